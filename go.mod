@@ -13,6 +13,7 @@ require (
 	github.com/trzsz/trzsz-ssh v0.1.18
 	golang.org/x/crypto v0.21.0
 	golang.org/x/lint v0.0.0-20210508222113-6edffad5e616
+	golang.org/x/net v0.21.0
 	libvirt.org/go/libvirtxml v1.8009.0
 )
 
@@ -103,7 +104,6 @@ require (
 	github.com/zclconf/go-cty v1.12.1 // indirect
 	golang.org/x/image v0.15.0 // indirect
 	golang.org/x/mod v0.14.0 // indirect
-	golang.org/x/net v0.21.0 // indirect
 	golang.org/x/sync v0.6.0 // indirect
 	golang.org/x/sys v0.18.0 // indirect
 	golang.org/x/term v0.18.0 // indirect
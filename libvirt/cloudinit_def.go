@@ -54,7 +54,7 @@ func removeTmpIsoDirectory(iso string) {
 
 }
 
-func (ci *defCloudInit) UploadIso(client *Client, iso string) (string, error) {
+func (ci *defCloudInit) UploadIso(ctx context.Context, client *Client, iso string) (string, error) {
 	virConn := client.libvirt
 	if virConn == nil {
 		return "", fmt.Errorf(LibVirtConIsNil)
@@ -103,13 +103,17 @@ func (ci *defCloudInit) UploadIso(client *Client, iso string) (string, error) {
 	}
 
 	// create the volume
-	volume, err := virConn.StorageVolCreateXML(pool, string(volumeDefXML), 0)
+	var volume libvirt.StorageVol
+	err = retryLibvirt(ctx, virConn, func() (err error) {
+		volume, err = virConn.StorageVolCreateXML(pool, string(volumeDefXML), 0)
+		return err
+	})
 	if err != nil {
 		return "", fmt.Errorf("error creating libvirt volume for cloudinit device %s: %w", ci.Name, err)
 	}
 
 	// upload ISO file
-	err = img.Import(newCopier(virConn, &volume, uint64(size)), volumeDef)
+	err = img.Import(newCopier(ctx, virConn, &volume, uint64(size)), volumeDef)
 	if err != nil {
 		return "", fmt.Errorf("error while uploading cloudinit %s: %w", img.String(), err)
 	}
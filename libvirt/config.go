@@ -12,16 +12,32 @@ import (
 
 // Config struct for the libvirt-provider.
 type Config struct {
-	URI string
+	URI        string
+	PrivateKey string
+	// MaxConcurrentOperations bounds the operations running at once on the
+	// host, 0 for no bound
+	MaxConcurrentOperations int
+	// Retry is how the libvirt calls failing with a transient error are
+	// retried
+	Retry retryPolicy
 }
 
 // Client libvirt.
 type Client struct {
+	// conn keeps libvirt connected, reconnecting when the connection is
+	// lost, e.g. on an ssh certificate renewal or a libvirtd restart
+	conn        *uri.ManagedLibvirt
 	libvirt     *libvirt.Libvirt
 	poolMutexKV *mutexkv.MutexKV
 	// define only one network at a time
 	// https://gitlab.com/libvirt/libvirt/-/issues/78
-	networkMutex sync.Mutex
+	networkMutex *sync.Mutex
+	// hosts are the hypervisors of the hosts blocks of the provider, nil
+	// with a single uri
+	hosts *hostPool
+	// throttle bounds the operations running at once on the host, and
+	// retries its failing calls
+	throttle *operationThrottle
 }
 
 // Client libvirt, returns a libvirt client for a config.
@@ -30,12 +46,15 @@ func (c *Config) Client() (*Client, error) {
 	if err != nil {
 		return nil, err
 	}
+	if c.PrivateKey != "" {
+		u.PrivateKey = []byte(c.PrivateKey)
+	}
 
-	l := libvirt.NewWithDialer(u)
-
-	if err := l.ConnectToURI(libvirt.ConnectURI(u.RemoteName())); err != nil {
-		return nil, fmt.Errorf("failed to connect: %w", err)
+	conn, err := u.ConnectManagedLibvirt()
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect: %w", u.ExplainOpenError(err))
 	}
+	l := conn.Libvirt()
 
 	v, err := l.ConnectGetLibVersion()
 	if err != nil {
@@ -44,9 +63,39 @@ func (c *Config) Client() (*Client, error) {
 	log.Printf("[INFO] libvirt client libvirt version: %v\n", v)
 
 	client := &Client{
-		libvirt:     l,
-		poolMutexKV: mutexkv.NewMutexKV(),
+		conn:         conn,
+		libvirt:      l,
+		poolMutexKV:  mutexkv.NewMutexKV(),
+		networkMutex: &sync.Mutex{},
+		throttle:     newOperationThrottle(c.MaxConcurrentOperations, c.Retry),
 	}
+	registerThrottle(l, client.throttle)
+	registerConnectionURI(l, u)
 
 	return client, nil
 }
+
+// connectionURIs are the URIs the libvirt connections were made with, which
+// the functions only given a connection cache the host capabilities by.
+var (
+	connectionURIsMutex sync.Mutex
+	connectionURIs      = make(map[*libvirt.Libvirt]*uri.ConnectionURI)
+)
+
+func registerConnectionURI(virConn *libvirt.Libvirt, u *uri.ConnectionURI) {
+	connectionURIsMutex.Lock()
+	defer connectionURIsMutex.Unlock()
+	connectionURIs[virConn] = u
+}
+
+// hostCapabilitiesXML returns the capabilities XML of the host of virConn,
+// fetched once per host and run when the provider made the connection.
+func hostCapabilitiesXML(virConn *libvirt.Libvirt) (string, error) {
+	connectionURIsMutex.Lock()
+	u := connectionURIs[virConn]
+	connectionURIsMutex.Unlock()
+	if u == nil {
+		return virConn.ConnectGetCapabilities()
+	}
+	return u.CachedCapabilitiesOf(virConn)
+}
@@ -1,6 +1,7 @@
 package libvirt
 
 import (
+	"context"
 	"encoding/json"
 	"encoding/xml"
 	"fmt"
@@ -30,7 +31,7 @@ func newIgnitionDef() defIgnition {
 // Create a ISO file based on the contents of the CloudInit instance and
 // uploads it to the libVirt pool
 // Returns a string holding terraform's internal ID of this resource.
-func (ign *defIgnition) CreateAndUpload(client *Client) (string, error) {
+func (ign *defIgnition) CreateAndUpload(ctx context.Context, client *Client) (string, error) {
 	virConn := client.libvirt
 	if virConn == nil {
 		return "", fmt.Errorf(LibVirtConIsNil)
@@ -85,13 +86,17 @@ func (ign *defIgnition) CreateAndUpload(client *Client) (string, error) {
 	}
 
 	// create the volume
-	volume, err := virConn.StorageVolCreateXML(pool, string(volumeDefXML), 0)
+	var volume libvirt.StorageVol
+	err = retryLibvirt(ctx, virConn, func() (err error) {
+		volume, err = virConn.StorageVolCreateXML(pool, string(volumeDefXML), 0)
+		return err
+	})
 	if err != nil {
 		return "", fmt.Errorf("error creating libvirt volume for Ignition %s: %w", ign.Name, err)
 	}
 
 	// upload ignition file
-	err = img.Import(newCopier(virConn, &volume, volumeDef.Capacity.Value), volumeDef)
+	err = img.Import(newCopier(ctx, virConn, &volume, volumeDef.Capacity.Value), volumeDef)
 	if err != nil {
 		return "", fmt.Errorf("error while uploading ignition file %s: %w", img.String(), err)
 	}
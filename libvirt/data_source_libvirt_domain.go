@@ -0,0 +1,196 @@
+package libvirt
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strings"
+
+	libvirt "github.com/digitalocean/go-libvirt"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"libvirt.org/go/libvirtxml"
+)
+
+// a libvirt domain datasource, looked up by name or by UUID
+//
+// Datasource example:
+//
+//	data "libvirt_domain" "router" {
+//	  name = "router"
+//	}
+//
+//	output "router_addresses" {
+//	  value = data.libvirt_domain.router.network_interface.0.addresses
+//	}
+func datasourceLibvirtDomain() *schema.Resource {
+	return &schema.Resource{
+		Read: resourceLibvirtDomainDataRead,
+		Schema: map[string]*schema.Schema{
+			"name": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				Computed:     true,
+				ExactlyOneOf: []string{"name", "uuid"},
+			},
+			"uuid": {
+				Type:     schema.TypeString,
+				Optional: true,
+				Computed: true,
+			},
+			"qemu_agent": {
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  false,
+			},
+			"description": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"state": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"running": {
+				Type:     schema.TypeBool,
+				Computed: true,
+			},
+			"autostart": {
+				Type:     schema.TypeBool,
+				Computed: true,
+			},
+			"vcpu": {
+				Type:     schema.TypeInt,
+				Computed: true,
+			},
+			"memory": {
+				Type:     schema.TypeInt,
+				Computed: true,
+			},
+			"network_interface": {
+				Type:     schema.TypeList,
+				Computed: true,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"network_name": {
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"bridge": {
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"mac": {
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"addresses": {
+							Type:     schema.TypeList,
+							Computed: true,
+							Elem: &schema.Schema{
+								Type: schema.TypeString,
+							},
+						},
+					},
+				},
+			},
+			"xml": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+		},
+	}
+}
+
+func resourceLibvirtDomainDataRead(d *schema.ResourceData, meta interface{}) error {
+	virConn := meta.(*Client).libvirt
+	if virConn == nil {
+		return fmt.Errorf(LibVirtConIsNil)
+	}
+
+	var domain libvirt.Domain
+	var err error
+	if name, ok := d.GetOk("name"); ok {
+		domain, err = virConn.DomainLookupByName(name.(string))
+	} else {
+		domain, err = virConn.DomainLookupByUUID(parseUUID(d.Get("uuid").(string)))
+	}
+	if err != nil {
+		return fmt.Errorf("error retrieving libvirt domain: %w", err)
+	}
+
+	domainXML, err := virConn.DomainGetXMLDesc(domain, 0)
+	if err != nil {
+		return fmt.Errorf("error retrieving libvirt domain XML description: %w", err)
+	}
+	var domainDef libvirtxml.Domain
+	if err := xml.Unmarshal([]byte(domainXML), &domainDef); err != nil {
+		return fmt.Errorf("error reading libvirt domain XML description: %w", err)
+	}
+
+	state, err := domainGetState(virConn, domain)
+	if err != nil {
+		return fmt.Errorf("error reading domain state: %w", err)
+	}
+	autostart, err := virConn.DomainGetAutostart(domain)
+	if err != nil {
+		return fmt.Errorf("error reading domain autostart setting: %w", err)
+	}
+
+	ifacesWithAddr, err := domainGetIfacesInfo(virConn, domain, d)
+	if err != nil {
+		return fmt.Errorf("error retrieving interface addresses: %w", err)
+	}
+	var netIfaces []map[string]interface{}
+	if domainDef.Devices != nil {
+		for _, ifaceDef := range domainDef.Devices.Interfaces {
+			netIface := map[string]interface{}{}
+			var mac string
+			if ifaceDef.MAC != nil {
+				mac = strings.ToUpper(ifaceDef.MAC.Address)
+			}
+			netIface["mac"] = mac
+			if ifaceDef.Source != nil {
+				if ifaceDef.Source.Network != nil {
+					netIface["network_name"] = ifaceDef.Source.Network.Network
+				} else if ifaceDef.Source.Bridge != nil {
+					netIface["bridge"] = ifaceDef.Source.Bridge.Bridge
+				}
+			}
+			var addrs []string
+			for _, ifaceWithAddr := range ifacesWithAddr {
+				if len(ifaceWithAddr.Hwaddr) > 0 && strings.ToUpper(ifaceWithAddr.Hwaddr[0]) == mac {
+					for _, addr := range ifaceWithAddr.Addrs {
+						addrs = append(addrs, addr.Addr)
+					}
+				}
+			}
+			netIface["addresses"] = addrs
+			netIfaces = append(netIfaces, netIface)
+		}
+	}
+
+	var memory uint
+	if domainDef.CurrentMemory != nil {
+		memory = domainDef.CurrentMemory.Value
+		if domainDef.CurrentMemory.Unit == "KiB" {
+			memory /= 1024
+		}
+	}
+	var vcpu uint
+	if domainDef.VCPU != nil {
+		vcpu = domainDef.VCPU.Value
+	}
+
+	d.Set("name", domain.Name)
+	d.Set("uuid", uuidString(domain.UUID))
+	d.Set("description", domainDef.Description)
+	d.Set("state", state)
+	d.Set("running", state == "running")
+	d.Set("autostart", autostart > 0)
+	d.Set("vcpu", vcpu)
+	d.Set("memory", memory)
+	d.Set("network_interface", netIfaces)
+	d.Set("xml", domainXML)
+	d.SetId(uuidString(domain.UUID))
+
+	return nil
+}
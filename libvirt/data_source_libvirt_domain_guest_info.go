@@ -0,0 +1,165 @@
+package libvirt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	libvirt "github.com/digitalocean/go-libvirt"
+	"github.com/dmacvicar/terraform-provider-libvirt/libvirt/helper/hashcode"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+// a datasource of what the qemu guest agent of a domain reports about its
+// guest, waiting for timeout for the guest to have addresses
+//
+// Datasource example:
+//
+//	data "libvirt_domain_guest_info" "web" {
+//	  domain_id     = libvirt_domain.web.id
+//	  prefer_family = "ipv4"
+//	  timeout       = "5m"
+//	}
+//
+//	output "web_address" {
+//	  value = data.libvirt_domain_guest_info.web.addresses.0
+//	}
+func datasourceLibvirtDomainGuestInfo() *schema.Resource {
+	return &schema.Resource{
+		Read: resourceLibvirtDomainGuestInfoRead,
+		Schema: map[string]*schema.Schema{
+			"domain_id": {
+				Type:     schema.TypeString,
+				Required: true,
+			},
+			"timeout": {
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+			"exclude_interfaces": {
+				Type:     schema.TypeList,
+				Optional: true,
+				Elem: &schema.Schema{
+					Type: schema.TypeString,
+				},
+			},
+			"prefer_family": {
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+			"hostname": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"interfaces": {
+				Type:     schema.TypeList,
+				Computed: true,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"name": {
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"mac": {
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"addresses": {
+							Type:     schema.TypeList,
+							Computed: true,
+							Elem: &schema.Schema{
+								Type: schema.TypeString,
+							},
+						},
+					},
+				},
+			},
+			"addresses": {
+				Type:     schema.TypeList,
+				Computed: true,
+				Elem: &schema.Schema{
+					Type: schema.TypeString,
+				},
+			},
+		},
+	}
+}
+
+func resourceLibvirtDomainGuestInfoRead(d *schema.ResourceData, meta interface{}) error {
+	virConn := meta.(*Client).libvirt
+	if virConn == nil {
+		return fmt.Errorf(LibVirtConIsNil)
+	}
+
+	filter, err := newGuestAddressFilter(d.Get, "")
+	if err != nil {
+		return err
+	}
+	timeout, err := guestWaitTimeout(d.Get, "", 0)
+	if err != nil {
+		return err
+	}
+
+	domainID := d.Get("domain_id").(string)
+	domain, err := virConn.DomainLookupByUUID(parseUUID(domainID))
+	if err != nil {
+		return fmt.Errorf("error retrieving libvirt domain %s: %w", domainID, err)
+	}
+
+	// without a timeout, the guest is read as it is now
+	var ifaces []libvirt.DomainInterface
+	if timeout == 0 {
+		ifaces, err = guestInterfaces(virConn, domain)
+	} else {
+		// waiting on the guest does not hold an operation slot
+		err = meta.(*Client).throttle.outside(func() error {
+			return resource.RetryContext(context.Background(), timeout, func() *resource.RetryError {
+				ifaces, err = guestInterfaces(virConn, domain)
+				if errors.Is(err, errGuestAgentNotReady) {
+					return resource.RetryableError(err)
+				}
+				if err != nil {
+					return resource.NonRetryableError(err)
+				}
+				if len(filter.addresses(ifaces, "")) == 0 {
+					return resource.RetryableError(fmt.Errorf("the guest of domain %s has no addresses yet", domainID))
+				}
+				return nil
+			})
+		})
+	}
+	if err != nil {
+		return fmt.Errorf("error reading the guest of domain %s: %w", domainID, err)
+	}
+
+	hostname, err := guestHostname(virConn, domain)
+	if err != nil {
+		return err
+	}
+
+	var interfaces []map[string]interface{}
+	for _, iface := range ifaces {
+		if filter.excludes(iface.Name) {
+			continue
+		}
+		var mac string
+		if len(iface.Hwaddr) > 0 {
+			mac = strings.ToUpper(iface.Hwaddr[0])
+		}
+		interfaces = append(interfaces, map[string]interface{}{
+			"name":      iface.Name,
+			"mac":       mac,
+			"addresses": filter.addresses([]libvirt.DomainInterface{iface}, ""),
+		})
+	}
+
+	d.Set("hostname", hostname)
+	d.Set("interfaces", interfaces)
+	d.Set("addresses", filter.addresses(ifaces, ""))
+	d.SetId(strconv.Itoa(hashcode.String(fmt.Sprintf("%v%v%v", domainID, hostname, interfaces))))
+
+	return nil
+}
@@ -0,0 +1,99 @@
+package libvirt
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+// a libvirt domain snapshot datasource, the current snapshot of the domain
+// unless name is given
+//
+// Datasource example:
+//
+//	data "libvirt_domain_snapshot" "current" {
+//	  domain_id = libvirt_domain.vm.id
+//	}
+//
+//	output "children" {
+//	  value = data.libvirt_domain_snapshot.current.children
+//	}
+func datasourceLibvirtDomainSnapshot() *schema.Resource {
+	return &schema.Resource{
+		Read: resourceLibvirtDomainSnapshotDataRead,
+		Schema: map[string]*schema.Schema{
+			"domain_id": {
+				Type:     schema.TypeString,
+				Required: true,
+			},
+			"name": {
+				Type:     schema.TypeString,
+				Optional: true,
+				Computed: true,
+			},
+			"description": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"parent": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"children": {
+				Type:     schema.TypeList,
+				Computed: true,
+				Elem: &schema.Schema{
+					Type: schema.TypeString,
+				},
+			},
+			"state": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"creation_time": {
+				Type:     schema.TypeInt,
+				Computed: true,
+			},
+			"current": {
+				Type:     schema.TypeBool,
+				Computed: true,
+			},
+			"xml": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+		},
+	}
+}
+
+func resourceLibvirtDomainSnapshotDataRead(d *schema.ResourceData, meta interface{}) error {
+	virConn := meta.(*Client).libvirt
+	if virConn == nil {
+		return fmt.Errorf(LibVirtConIsNil)
+	}
+
+	domainUUID := d.Get("domain_id").(string)
+	name := d.Get("name").(string)
+	if name == "" {
+		domain, err := virConn.DomainLookupByUUID(parseUUID(domainUUID))
+		if err != nil {
+			return fmt.Errorf("error retrieving libvirt domain %s: %w", domainUUID, err)
+		}
+		current, err := virConn.DomainSnapshotCurrent(domain, 0)
+		if err != nil {
+			return fmt.Errorf("error retrieving the current snapshot of libvirt domain %s: %w", domain.Name, err)
+		}
+		name = current.Name
+	}
+
+	snapshot, err := lookupDomainSnapshot(virConn, domainSnapshotID(domainUUID, name))
+	if err != nil {
+		return err
+	}
+	if _, err := setDomainSnapshotAttributes(virConn, d, snapshot); err != nil {
+		return err
+	}
+	d.SetId(domainSnapshotID(domainUUID, snapshot.Name))
+
+	return nil
+}
@@ -0,0 +1,48 @@
+package libvirt
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/acctest"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
+)
+
+func TestAccLibvirtDomainDataSource(t *testing.T) {
+	randomDomainName := acctest.RandStringFromCharSet(10, acctest.CharSetAlpha)
+	config := fmt.Sprintf(`
+	resource "libvirt_domain" "%s" {
+		name    = "%s"
+		memory  = 384
+		vcpu    = 2
+		running = false
+	}
+
+	data "libvirt_domain" "by_name" {
+		name = libvirt_domain.%s.name
+	}
+
+	data "libvirt_domain" "by_uuid" {
+		uuid = libvirt_domain.%s.id
+	}`, randomDomainName, randomDomainName, randomDomainName, randomDomainName)
+
+	resource.Test(t, resource.TestCase{
+		PreCheck:     func() { testAccPreCheck(t) },
+		Providers:    testAccProviders,
+		CheckDestroy: testAccCheckLibvirtDomainDestroy,
+		Steps: []resource.TestStep{
+			{
+				Config: config,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttrPair(
+						"data.libvirt_domain.by_name", "id", "libvirt_domain."+randomDomainName, "id"),
+					resource.TestCheckResourceAttr("data.libvirt_domain.by_name", "state", "shutoff"),
+					resource.TestCheckResourceAttr("data.libvirt_domain.by_name", "running", "false"),
+					resource.TestCheckResourceAttr("data.libvirt_domain.by_name", "memory", "384"),
+					resource.TestCheckResourceAttr("data.libvirt_domain.by_name", "vcpu", "2"),
+					resource.TestCheckResourceAttr("data.libvirt_domain.by_uuid", "name", randomDomainName),
+				),
+			},
+		},
+	})
+}
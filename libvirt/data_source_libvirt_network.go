@@ -5,10 +5,133 @@ import (
 	"net"
 	"strconv"
 
+	libvirt "github.com/digitalocean/go-libvirt"
 	"github.com/dmacvicar/terraform-provider-libvirt/libvirt/helper/hashcode"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
 )
 
+// a libvirt network datasource, looked up by name or by UUID
+//
+// Datasource example:
+//
+//	data "libvirt_network" "default" {
+//	  name = "default"
+//	}
+//
+//	resource "libvirt_domain" "domain" {
+//	  network_interface {
+//	    network_id = data.libvirt_network.default.id
+//	  }
+//	}
+func datasourceLibvirtNetwork() *schema.Resource {
+	return &schema.Resource{
+		Read: resourceLibvirtNetworkDataRead,
+		Schema: map[string]*schema.Schema{
+			"name": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				Computed:     true,
+				ExactlyOneOf: []string{"name", "uuid"},
+			},
+			"uuid": {
+				Type:     schema.TypeString,
+				Optional: true,
+				Computed: true,
+			},
+			"bridge": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"mode": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"domain": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"addresses": {
+				Type:     schema.TypeList,
+				Computed: true,
+				Elem: &schema.Schema{
+					Type: schema.TypeString,
+				},
+			},
+			"active": {
+				Type:     schema.TypeBool,
+				Computed: true,
+			},
+			"autostart": {
+				Type:     schema.TypeBool,
+				Computed: true,
+			},
+			"xml": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+		},
+	}
+}
+
+func resourceLibvirtNetworkDataRead(d *schema.ResourceData, meta interface{}) error {
+	virConn := meta.(*Client).libvirt
+	if virConn == nil {
+		return fmt.Errorf(LibVirtConIsNil)
+	}
+
+	var network libvirt.Network
+	var err error
+	if name, ok := d.GetOk("name"); ok {
+		network, err = virConn.NetworkLookupByName(name.(string))
+	} else {
+		network, err = virConn.NetworkLookupByUUID(parseUUID(d.Get("uuid").(string)))
+	}
+	if err != nil {
+		return fmt.Errorf("error retrieving libvirt network: %w", err)
+	}
+
+	networkXML, err := virConn.NetworkGetXMLDesc(network, 0)
+	if err != nil {
+		return fmt.Errorf("error retrieving libvirt network XML description: %w", err)
+	}
+	networkDef, err := newDefNetworkFromXML(networkXML)
+	if err != nil {
+		return fmt.Errorf("error reading libvirt network XML description: %w", err)
+	}
+
+	addresses, err := getNetworkAddresses(networkDef)
+	if err != nil {
+		return err
+	}
+	active, err := virConn.NetworkIsActive(network)
+	if err != nil {
+		return fmt.Errorf("error checking whether network %s is active: %w", network.Name, err)
+	}
+	autostart, err := virConn.NetworkGetAutostart(network)
+	if err != nil {
+		return fmt.Errorf("error reading network autostart setting: %w", err)
+	}
+
+	d.Set("name", network.Name)
+	d.Set("uuid", uuidString(network.UUID))
+	if networkDef.Bridge != nil {
+		d.Set("bridge", networkDef.Bridge.Name)
+	}
+	if networkDef.Forward != nil {
+		d.Set("mode", networkDef.Forward.Mode)
+	}
+	if networkDef.Domain != nil {
+		d.Set("domain", networkDef.Domain.Name)
+	}
+	d.Set("addresses", addresses)
+	d.Set("active", active == 1)
+	d.Set("autostart", autostart > 0)
+	d.Set("xml", networkXML)
+	d.SetId(uuidString(network.UUID))
+
+	return nil
+}
+
 // a libvirt network DNS host template datasource
 //
 // Datasource example:
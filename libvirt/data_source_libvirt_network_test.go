@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"testing"
 
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/acctest"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
 )
@@ -104,3 +105,44 @@ func TestAccLibvirtNetworkDataSource_DNSDnsmasqTemplate(t *testing.T) {
 		},
 	})
 }
+
+func TestAccLibvirtNetworkDataSource_Lookup(t *testing.T) {
+	randomNetworkName := acctest.RandStringFromCharSet(10, acctest.CharSetAlpha)
+	config := fmt.Sprintf(`
+	resource "libvirt_network" "%s" {
+		name      = "%s"
+		mode      = "nat"
+		domain    = "k8s.local"
+		addresses = ["10.17.3.0/24"]
+	}
+
+	data "libvirt_network" "by_name" {
+		name = libvirt_network.%s.name
+	}
+
+	data "libvirt_network" "by_uuid" {
+		uuid = libvirt_network.%s.id
+	}`, randomNetworkName, randomNetworkName, randomNetworkName, randomNetworkName)
+
+	resource.Test(t, resource.TestCase{
+		PreCheck:     func() { testAccPreCheck(t) },
+		Providers:    testAccProviders,
+		CheckDestroy: testAccCheckLibvirtNetworkDestroy,
+		Steps: []resource.TestStep{
+			{
+				Config: config,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttrPair(
+						"data.libvirt_network.by_name", "id", "libvirt_network."+randomNetworkName, "id"),
+					resource.TestCheckResourceAttrPair(
+						"data.libvirt_network.by_name", "bridge", "libvirt_network."+randomNetworkName, "bridge"),
+					resource.TestCheckResourceAttr("data.libvirt_network.by_name", "mode", "nat"),
+					resource.TestCheckResourceAttr("data.libvirt_network.by_name", "domain", "k8s.local"),
+					resource.TestCheckResourceAttr("data.libvirt_network.by_name", "addresses.0", "10.17.3.0/24"),
+					resource.TestCheckResourceAttr("data.libvirt_network.by_name", "active", "true"),
+					resource.TestCheckResourceAttr("data.libvirt_network.by_uuid", "name", randomNetworkName),
+				),
+			},
+		},
+	})
+}
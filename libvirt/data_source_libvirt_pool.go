@@ -0,0 +1,115 @@
+package libvirt
+
+import (
+	"fmt"
+
+	libvirt "github.com/digitalocean/go-libvirt"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"libvirt.org/go/libvirtxml"
+)
+
+// a libvirt storage pool datasource, looked up by name or by UUID
+//
+// Datasource example:
+//
+//	data "libvirt_pool" "images" {
+//	  name = "images"
+//	}
+//
+//	resource "libvirt_volume" "disk" {
+//	  name = "disk"
+//	  pool = data.libvirt_pool.images.name
+//	}
+func datasourceLibvirtPool() *schema.Resource {
+	return &schema.Resource{
+		Read: resourceLibvirtPoolDataRead,
+		Schema: map[string]*schema.Schema{
+			"name": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				Computed:     true,
+				ExactlyOneOf: []string{"name", "uuid"},
+			},
+			"uuid": {
+				Type:     schema.TypeString,
+				Optional: true,
+				Computed: true,
+			},
+			"type": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"path": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"capacity": {
+				Type:     schema.TypeInt,
+				Computed: true,
+			},
+			"allocation": {
+				Type:     schema.TypeInt,
+				Computed: true,
+			},
+			"available": {
+				Type:     schema.TypeInt,
+				Computed: true,
+			},
+			"active": {
+				Type:     schema.TypeBool,
+				Computed: true,
+			},
+			"xml": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+		},
+	}
+}
+
+func resourceLibvirtPoolDataRead(d *schema.ResourceData, meta interface{}) error {
+	virConn := meta.(*Client).libvirt
+	if virConn == nil {
+		return fmt.Errorf(LibVirtConIsNil)
+	}
+
+	var pool libvirt.StoragePool
+	var err error
+	if name, ok := d.GetOk("name"); ok {
+		pool, err = virConn.StoragePoolLookupByName(name.(string))
+	} else {
+		pool, err = virConn.StoragePoolLookupByUUID(parseUUID(d.Get("uuid").(string)))
+	}
+	if err != nil {
+		return fmt.Errorf("error retrieving libvirt pool: %w", err)
+	}
+
+	state, capacity, allocation, available, err := virConn.StoragePoolGetInfo(pool)
+	if err != nil {
+		return fmt.Errorf("error retrieving pool info: %w", err)
+	}
+
+	poolXML, err := virConn.StoragePoolGetXMLDesc(pool, 0)
+	if err != nil {
+		return fmt.Errorf("could not get XML description for pool %s: %w", pool.Name, err)
+	}
+	var poolDef libvirtxml.StoragePool
+	if err := poolDef.Unmarshal(poolXML); err != nil {
+		return fmt.Errorf("could not get a pool definition from XML for %s: %w", pool.Name, err)
+	}
+
+	d.Set("name", pool.Name)
+	d.Set("uuid", uuidString(pool.UUID))
+	d.Set("type", poolDef.Type)
+	if poolDef.Target != nil {
+		d.Set("path", poolDef.Target.Path)
+	}
+	d.Set("capacity", capacity)
+	d.Set("allocation", allocation)
+	d.Set("available", available)
+	d.Set("active", libvirt.StoragePoolState(state) == libvirt.StoragePoolRunning)
+	d.Set("xml", poolXML)
+	d.SetId(uuidString(pool.UUID))
+
+	return nil
+}
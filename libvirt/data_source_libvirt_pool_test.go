@@ -0,0 +1,49 @@
+package libvirt
+
+import (
+	"fmt"
+	"regexp"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/acctest"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
+)
+
+func TestAccLibvirtPoolDataSource(t *testing.T) {
+	randomPoolName := acctest.RandStringFromCharSet(10, acctest.CharSetAlpha)
+	poolPath := t.TempDir()
+	config := fmt.Sprintf(`
+	resource "libvirt_pool" "%s" {
+		name = "%s"
+		type = "dir"
+		path = "%s"
+	}
+
+	data "libvirt_pool" "by_name" {
+		name = libvirt_pool.%s.name
+	}
+
+	data "libvirt_pool" "by_uuid" {
+		uuid = libvirt_pool.%s.id
+	}`, randomPoolName, randomPoolName, poolPath, randomPoolName, randomPoolName)
+
+	resource.Test(t, resource.TestCase{
+		PreCheck:     func() { testAccPreCheck(t) },
+		Providers:    testAccProviders,
+		CheckDestroy: testAccCheckLibvirtPoolDestroy,
+		Steps: []resource.TestStep{
+			{
+				Config: config,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttrPair(
+						"data.libvirt_pool.by_name", "id", "libvirt_pool."+randomPoolName, "id"),
+					resource.TestCheckResourceAttr("data.libvirt_pool.by_name", "type", "dir"),
+					resource.TestCheckResourceAttr("data.libvirt_pool.by_name", "path", poolPath),
+					resource.TestCheckResourceAttr("data.libvirt_pool.by_name", "active", "true"),
+					resource.TestMatchResourceAttr("data.libvirt_pool.by_name", "capacity", regexp.MustCompile(`^\d+$`)),
+					resource.TestCheckResourceAttr("data.libvirt_pool.by_uuid", "name", randomPoolName),
+				),
+			},
+		},
+	})
+}
@@ -0,0 +1,127 @@
+package libvirt
+
+import (
+	"fmt"
+
+	libvirt "github.com/digitalocean/go-libvirt"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+// a libvirt volume datasource, looked up by name in its pool or by key
+//
+// Datasource example:
+//
+//	data "libvirt_volume" "base" {
+//	  name = "opensuse-leap.qcow2"
+//	  pool = "images"
+//	}
+//
+//	resource "libvirt_volume" "disk" {
+//	  name           = "disk"
+//	  base_volume_id = data.libvirt_volume.base.id
+//	}
+func datasourceLibvirtVolume() *schema.Resource {
+	return &schema.Resource{
+		Read: resourceLibvirtVolumeDataRead,
+		Schema: map[string]*schema.Schema{
+			"name": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				Computed:     true,
+				ExactlyOneOf: []string{"name", "key"},
+			},
+			"pool": {
+				Type:     schema.TypeString,
+				Optional: true,
+				Computed: true,
+			},
+			"key": {
+				Type:     schema.TypeString,
+				Optional: true,
+				Computed: true,
+			},
+			"path": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"format": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"size": {
+				Type:     schema.TypeInt,
+				Computed: true,
+			},
+			"allocation": {
+				Type:     schema.TypeInt,
+				Computed: true,
+			},
+			"xml": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+		},
+	}
+}
+
+func resourceLibvirtVolumeDataRead(d *schema.ResourceData, meta interface{}) error {
+	virConn := meta.(*Client).libvirt
+	if virConn == nil {
+		return fmt.Errorf(LibVirtConIsNil)
+	}
+
+	var volume libvirt.StorageVol
+	if name, ok := d.GetOk("name"); ok {
+		poolName := "default"
+		if pool, ok := d.GetOk("pool"); ok {
+			poolName = pool.(string)
+		}
+		pool, err := virConn.StoragePoolLookupByName(poolName)
+		if err != nil {
+			return fmt.Errorf("can't find storage pool '%s'", poolName)
+		}
+		volume, err = virConn.StorageVolLookupByName(pool, name.(string))
+		if err != nil {
+			return fmt.Errorf("error retrieving volume %s in pool %s: %w", name, poolName, err)
+		}
+	} else {
+		var err error
+		key := d.Get("key").(string)
+		volume, err = virConn.StorageVolLookupByKey(key)
+		if err != nil {
+			return fmt.Errorf("error retrieving volume %s: %w", key, err)
+		}
+	}
+
+	_, size, allocation, err := virConn.StorageVolGetInfo(volume)
+	if err != nil {
+		return fmt.Errorf("error retrieving volume info: %w", err)
+	}
+	path, err := virConn.StorageVolGetPath(volume)
+	if err != nil {
+		return fmt.Errorf("error retrieving path of volume %s: %w", volume.Name, err)
+	}
+
+	volumeXML, err := virConn.StorageVolGetXMLDesc(volume, 0)
+	if err != nil {
+		return fmt.Errorf("could not get XML description for volume %s: %w", volume.Name, err)
+	}
+	volumeDef, err := newDefVolumeFromXML(volumeXML)
+	if err != nil {
+		return fmt.Errorf("could not get a volume definition from XML for %s: %w", volume.Name, err)
+	}
+
+	d.Set("name", volume.Name)
+	d.Set("pool", volume.Pool)
+	d.Set("key", volume.Key)
+	d.Set("path", path)
+	if volumeDef.Target != nil && volumeDef.Target.Format != nil {
+		d.Set("format", volumeDef.Target.Format.Type)
+	}
+	d.Set("size", size)
+	d.Set("allocation", allocation)
+	d.Set("xml", volumeXML)
+	d.SetId(volume.Key)
+
+	return nil
+}
@@ -0,0 +1,58 @@
+package libvirt
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/acctest"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
+)
+
+func TestAccLibvirtVolumeDataSource(t *testing.T) {
+	randomVolumeName := acctest.RandStringFromCharSet(10, acctest.CharSetAlpha)
+	randomPoolName := acctest.RandStringFromCharSet(10, acctest.CharSetAlpha)
+	poolPath := t.TempDir()
+	config := fmt.Sprintf(`
+	resource "libvirt_pool" "%s" {
+		name = "%s"
+		type = "dir"
+		path = "%s"
+	}
+
+	resource "libvirt_volume" "%s" {
+		name   = "%s"
+		pool   = libvirt_pool.%s.name
+		format = "qcow2"
+		size   = 1073741824
+	}
+
+	data "libvirt_volume" "by_name" {
+		name = libvirt_volume.%s.name
+		pool = libvirt_pool.%s.name
+	}
+
+	data "libvirt_volume" "by_key" {
+		key = libvirt_volume.%s.id
+	}`, randomPoolName, randomPoolName, poolPath, randomVolumeName, randomVolumeName, randomPoolName,
+		randomVolumeName, randomPoolName, randomVolumeName)
+
+	resource.Test(t, resource.TestCase{
+		PreCheck:     func() { testAccPreCheck(t) },
+		Providers:    testAccProviders,
+		CheckDestroy: testAccCheckLibvirtVolumeDestroy,
+		Steps: []resource.TestStep{
+			{
+				Config: config,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttrPair(
+						"data.libvirt_volume.by_name", "id", "libvirt_volume."+randomVolumeName, "id"),
+					resource.TestCheckResourceAttr("data.libvirt_volume.by_name", "format", "qcow2"),
+					resource.TestCheckResourceAttr("data.libvirt_volume.by_name", "size", "1073741824"),
+					resource.TestCheckResourceAttr("data.libvirt_volume.by_name", "path", poolPath+"/"+randomVolumeName),
+					resource.TestCheckResourceAttr("data.libvirt_volume.by_key", "name", randomVolumeName),
+					resource.TestCheckResourceAttr("data.libvirt_volume.by_key", "pool", randomPoolName),
+				),
+			},
+		},
+	})
+}
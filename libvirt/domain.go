@@ -22,6 +22,10 @@ import (
 const domWaitLeaseStillWaiting = "waiting-addresses"
 const domWaitLeaseDone = "all-addresses-obtained"
 
+// firmwareAutoselectEFI is the firmware letting libvirt select the UEFI
+// firmware of the domain.
+const firmwareAutoselectEFI = "efi"
+
 var errDomainInvalidState = errors.New("invalid state for domain")
 
 func domainWaitForLeases(ctx context.Context, virConn *libvirt.Libvirt, domain libvirt.Domain, waitForLeases []*libvirtxml.DomainInterface,
@@ -166,7 +170,9 @@ func domainGetIfacesInfo(virConn *libvirt.Libvirt, domain libvirt.Domain, rd *sc
 
 	// setup source of interface address information
 	var addrsrc uint32
-	qemuAgentEnabled := rd.Get("qemu_agent").(bool)
+	// waiting for the guest needs its agent
+	_, waitForGuest := rd.GetOk("wait_for_guest")
+	qemuAgentEnabled := rd.Get("qemu_agent").(bool) || waitForGuest
 	if qemuAgentEnabled {
 		addrsrc = uint32(libvirt.DomainInterfaceAddressesSrcAgent)
 		log.Printf("[DEBUG] qemu-agent used to query interface info")
@@ -178,6 +184,10 @@ func domainGetIfacesInfo(virConn *libvirt.Libvirt, domain libvirt.Domain, rd *sc
 	// get all the interfaces attached to libvirt networks
 	var interfaces []libvirt.DomainInterface
 	interfaces, err = virConn.DomainInterfaceAddresses(domain, addrsrc, 0)
+	if qemuAgentEnabled && guestAgentNotReady(err) {
+		log.Print("[DEBUG] no interfaces could be obtained: the qemu guest agent is not ready")
+		return []libvirt.DomainInterface{}, nil
+	}
 	if err != nil {
 		return interfaces, fmt.Errorf("error retrieving interface addresses: %w", err)
 	}
@@ -308,7 +318,10 @@ func setGraphics(d *schema.ResourceData, domainDef *libvirtxml.Domain, arch stri
 			return fmt.Errorf("missing graphics type for domain")
 		}
 
-		autoport := d.Get(prefix + ".autoport").(bool)
+		// a fixed port is only used by libvirt without autoport
+		port := d.Get(prefix + ".port").(int)
+		tlsPort := d.Get(prefix + ".tls_port").(int)
+		autoport := d.Get(prefix+".autoport").(bool) && port == 0 && tlsPort == 0
 		listener := libvirtxml.DomainGraphicListener{}
 
 		if listenType, ok := d.GetOk(prefix + ".listen_type"); ok {
@@ -325,20 +338,39 @@ func setGraphics(d *schema.ResourceData, domainDef *libvirtxml.Domain, arch stri
 			}
 		}
 
+		password := d.Get(prefix + ".password").(string)
+		tls := d.Get(prefix + ".tls").(bool)
+
 		switch graphicsType {
 		case "spice":
 			domainDef.Devices.Graphics[0] = libvirtxml.DomainGraphic{
 				Spice: &libvirtxml.DomainGraphicSpice{},
 			}
 			domainDef.Devices.Graphics[0].Spice.AutoPort = formatBoolYesNo(autoport)
+			domainDef.Devices.Graphics[0].Spice.Port = port
+			domainDef.Devices.Graphics[0].Spice.TLSPort = tlsPort
+			domainDef.Devices.Graphics[0].Spice.Passwd = password
+			if tls {
+				domainDef.Devices.Graphics[0].Spice.DefaultMode = "secure"
+			}
 			domainDef.Devices.Graphics[0].Spice.Listeners = []libvirtxml.DomainGraphicListener{
 				listener,
 			}
 		case "vnc":
+			// libvirt enables TLS for all the VNC servers of a host at once,
+			// with vnc_tls in qemu.conf
+			if tls || tlsPort != 0 {
+				return fmt.Errorf("tls and tls_port are only supported by spice graphics, set vnc_tls in the qemu.conf of the host for vnc")
+			}
+			if len(password) > 8 {
+				return fmt.Errorf("vnc graphics passwords are limited to 8 characters")
+			}
 			domainDef.Devices.Graphics[0] = libvirtxml.DomainGraphic{
 				VNC: &libvirtxml.DomainGraphicVNC{},
 			}
 			domainDef.Devices.Graphics[0].VNC.AutoPort = formatBoolYesNo(autoport)
+			domainDef.Devices.Graphics[0].VNC.Port = port
+			domainDef.Devices.Graphics[0].VNC.Passwd = password
 			domainDef.Devices.Graphics[0].VNC.Listeners = []libvirtxml.DomainGraphicListener{
 				listener,
 			}
@@ -353,6 +385,40 @@ func setGraphics(d *schema.ResourceData, domainDef *libvirtxml.Domain, arch stri
 	return nil
 }
 
+// readGraphics returns the graphics of the domain, with the ports libvirt
+// assigned to them. The other attributes are kept as configured, the
+// password in particular not being part of the domain definition read.
+func readGraphics(d *schema.ResourceData, domainDef libvirtxml.Domain) []map[string]interface{} {
+	if d.Get("graphics.#").(int) == 0 || len(domainDef.Devices.Graphics) == 0 {
+		return nil
+	}
+	graphics, ok := d.Get("graphics.0").(map[string]interface{})
+	if !ok {
+		return nil
+	}
+
+	// the ports of a domain that is not running are -1
+	port := func(p int) int {
+		if p < 0 {
+			return 0
+		}
+		return p
+	}
+	// the graphics of the definition read are appended to the default
+	// ones of newDomainDef
+	switch def := domainDef.Devices.Graphics[len(domainDef.Devices.Graphics)-1]; {
+	case def.Spice != nil:
+		graphics["port"] = port(def.Spice.Port)
+		graphics["tls_port"] = port(def.Spice.TLSPort)
+	case def.VNC != nil:
+		graphics["port"] = port(def.VNC.Port)
+		graphics["websocket_port"] = port(def.VNC.WebSocket)
+	default:
+		return nil
+	}
+	return []map[string]interface{}{graphics}
+}
+
 func setCmdlineArgs(d *schema.ResourceData, domainDef *libvirtxml.Domain) {
 	var cmdlineArgs []string
 	for i := 0; i < d.Get("cmdline.#").(int); i++ {
@@ -371,28 +437,84 @@ func setCmdlineArgs(d *schema.ResourceData, domainDef *libvirtxml.Domain) {
 	domainDef.OS.Cmdline = strings.Join(cmdlineArgs, " ")
 }
 
-func setFirmware(d *schema.ResourceData, domainDef *libvirtxml.Domain) {
-	if firmware, ok := d.GetOk("firmware"); ok {
-		firmwareFile := firmware.(string)
+// setFirmware sets the firmware of the domain: given "efi", libvirt selects
+// the UEFI firmware and its NVRAM template for the architecture of the
+// domain itself, honoring the secure_boot settings; otherwise the firmware
+// is the path of the loader.
+func setFirmware(d *schema.ResourceData, domainDef *libvirtxml.Domain) error {
+	firmware, ok := d.GetOk("firmware")
+	if !ok {
+		if _, ok := d.GetOk("secure_boot"); ok {
+			return fmt.Errorf("secure_boot requires the firmware to be set")
+		}
+		return nil
+	}
+	_, secureBoot := d.GetOk("secure_boot")
+
+	if firmware.(string) == firmwareAutoselectEFI {
+		domainDef.OS.Firmware = firmwareAutoselectEFI
+		if secureBoot {
+			domainDef.OS.FirmwareInfo = &libvirtxml.DomainOSFirmwareInfo{
+				Features: []libvirtxml.DomainOSFirmwareFeature{
+					{Name: "secure-boot", Enabled: "yes"},
+					{Name: "enrolled-keys", Enabled: formatBoolYesNo(d.Get("secure_boot.0.enrolled_keys").(bool))},
+				},
+			}
+		}
+	} else {
 		domainDef.OS.Loader = &libvirtxml.DomainLoader{
-			Path:     firmwareFile,
+			Path:     firmware.(string),
 			Readonly: "yes",
 			Type:     "pflash",
-			Secure:   "no",
+			Secure:   formatBoolYesNo(secureBoot),
 		}
-
-		if _, ok := d.GetOk("nvram.0"); ok {
-			nvramFile := d.Get("nvram.0.file").(string)
-			nvramTemplateFile := ""
-			if nvramTemplate, ok := d.GetOk("nvram.0.template"); ok {
-				nvramTemplateFile = nvramTemplate.(string)
+		if secureBoot {
+			// secure boot firmwares only run with SMM
+			if domainDef.Features == nil {
+				domainDef.Features = &libvirtxml.DomainFeatureList{}
 			}
-			domainDef.OS.NVRam = &libvirtxml.DomainNVRam{
-				NVRam:    nvramFile,
-				Template: nvramTemplateFile,
+			domainDef.Features.SMM = &libvirtxml.DomainFeatureSMM{State: "on"}
+		}
+	}
+
+	if _, ok := d.GetOk("nvram.0"); ok {
+		nvramFile := d.Get("nvram.0.file").(string)
+		nvramTemplateFile := ""
+		if nvramTemplate, ok := d.GetOk("nvram.0.template"); ok {
+			nvramTemplateFile = nvramTemplate.(string)
+		}
+		domainDef.OS.NVRam = &libvirtxml.DomainNVRam{
+			NVRam:    nvramFile,
+			Template: nvramTemplateFile,
+		}
+	}
+
+	return nil
+}
+
+// readSecureBoot returns the secure_boot block of the firmware of
+// domainDef, nil when secure boot is disabled. The enrolled keys of a
+// loader given by path are unknown, so they are kept from the state.
+func readSecureBoot(d *schema.ResourceData, domainDef libvirtxml.Domain) []map[string]interface{} {
+	if domainDef.OS.FirmwareInfo != nil {
+		secureBoot, enrolledKeys := false, false
+		for _, feature := range domainDef.OS.FirmwareInfo.Features {
+			switch feature.Name {
+			case "secure-boot":
+				secureBoot = feature.Enabled == "yes"
+			case "enrolled-keys":
+				enrolledKeys = feature.Enabled == "yes"
 			}
 		}
+		if secureBoot {
+			return []map[string]interface{}{{"enrolled_keys": enrolledKeys}}
+		}
+		return nil
+	}
+	if domainDef.OS.Firmware == "" && domainDef.OS.Loader != nil && domainDef.OS.Loader.Secure == "yes" {
+		return []map[string]interface{}{{"enrolled_keys": d.Get("secure_boot.0.enrolled_keys").(bool)}}
 	}
+	return nil
 }
 
 func setBootDevices(d *schema.ResourceData, domainDef *libvirtxml.Domain) {
@@ -667,7 +789,7 @@ func setCloudinit(d *schema.ResourceData, domainDef *libvirtxml.Domain, virConn
 	return nil
 }
 
-func setNetworkInterfaces(d *schema.ResourceData, domainDef *libvirtxml.Domain,
+func setNetworkInterfaces(ctx context.Context, d *schema.ResourceData, domainDef *libvirtxml.Domain,
 	virConn *libvirt.Libvirt, partialNetIfaces map[string]*pendingMapping,
 	waitForLeases *[]*libvirtxml.DomainInterface) error {
 	for i := 0; i < d.Get("network_interface.#").(int); i++ {
@@ -769,7 +891,7 @@ func setNetworkInterfaces(d *schema.ResourceData, domainDef *libvirtxml.Domain,
 						}
 
 						log.Printf("[INFO] Adding IP/MAC/host=%s/%s/%s to %s", ip.String(), mac, hostname, network.Name)
-						if err := updateOrAddHost(virConn, network, ip.String(), mac, hostname); err != nil {
+						if err := updateOrAddHost(ctx, virConn, network, ip.String(), mac, hostname); err != nil {
 							return err
 						}
 					}
@@ -812,11 +934,48 @@ func setNetworkInterfaces(d *schema.ResourceData, domainDef *libvirtxml.Domain,
 	return nil
 }
 
+// addPendingHostMappings adds the ip/MAC/host mappings of the interfaces
+// that waited for a DHCP lease to learn their address, once d was read with
+// the addresses.
+func addPendingHostMappings(ctx context.Context, virConn *libvirt.Libvirt, d *schema.ResourceData, partialNetIfaces map[string]*pendingMapping) {
+	for i := 0; i < d.Get("network_interface.#").(int); i++ {
+		prefix := fmt.Sprintf("network_interface.%d", i)
+		mac := strings.ToUpper(d.Get(prefix + ".mac").(string))
+		log.Printf("[DEBUG] Reading network_interface.%d with MAC: %s\n", i, mac)
+
+		// if we were waiting for an IP address for this MAC, go ahead.
+		if pending, ok := partialNetIfaces[mac]; ok {
+			// we should have the address now
+			addressesI, ok := d.GetOk(prefix + ".addresses")
+			if !ok {
+				log.Printf("Did not obtain the IP address for MAC=%s", mac)
+				continue
+			}
+
+			network, err := virConn.NetworkLookupByName(pending.networkName)
+			if err != nil {
+				log.Printf("Can't retrieve network '%s'", pending.networkName)
+				continue
+			}
+
+			for _, addressI := range addressesI.([]interface{}) {
+				address := addressI.(string)
+				log.Printf("[INFO] Finally adding IP/MAC/host=%s/%s/%s", address, mac, pending.hostname)
+
+				err = updateOrAddHost(ctx, virConn, network, address, mac, pending.hostname)
+				if err != nil {
+					log.Printf("Could not add IP/MAC/host=%s/%s/%s: %s", address, mac, pending.hostname, err)
+				}
+			}
+		}
+	}
+}
+
 func setTPMs(d *schema.ResourceData, domainDef *libvirtxml.Domain) {
 	prefix := "tpm.0"
 	if _, ok := d.GetOk(prefix); ok {
 		tpm := libvirtxml.DomainTPM{}
-		if model, ok := d.GetOk(".model"); ok {
+		if model, ok := d.GetOk(prefix + ".model"); ok {
 			tpm.Model = model.(string)
 		}
 
@@ -854,7 +1013,7 @@ func setTPMs(d *schema.ResourceData, domainDef *libvirtxml.Domain) {
 	}
 }
 
-func destroyDomainByUserRequest(virConn *libvirt.Libvirt, d *schema.ResourceData, domain libvirt.Domain) error {
+func destroyDomainByUserRequest(ctx context.Context, virConn *libvirt.Libvirt, d *schema.ResourceData, domain libvirt.Domain) error {
 	if d.Get("running").(bool) {
 		return nil
 	}
@@ -866,7 +1025,9 @@ func destroyDomainByUserRequest(virConn *libvirt.Libvirt, d *schema.ResourceData
 	}
 
 	if libvirt.DomainState(state) == libvirt.DomainRunning || libvirt.DomainState(state) == libvirt.DomainPaused {
-		if err := virConn.DomainDestroy(domain); err != nil {
+		if err := retryLibvirt(ctx, virConn, func() error {
+			return virConn.DomainDestroy(domain)
+		}); err != nil {
 			return fmt.Errorf("couldn't destroy libvirt domain: %w", err)
 		}
 	}
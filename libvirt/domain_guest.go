@@ -0,0 +1,447 @@
+package libvirt
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	libvirt "github.com/digitalocean/go-libvirt"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"libvirt.org/go/libvirtxml"
+)
+
+const domWaitGuestStillWaiting = "waiting-guest"
+const domWaitGuestDone = "guest-ready"
+
+// guestExecPollInterval is how often the status of a command run in the
+// guest is polled.
+const guestExecPollInterval = time.Second
+
+// defaultGuestExcludedInterfaces are the guest interfaces the addresses of
+// are ignored by default: the loopback, and the bridges and veths of
+// container runtimes and of libvirt itself.
+var defaultGuestExcludedInterfaces = []string{"lo", "docker*", "br-*", "veth*", "virbr*", "cni*", "flannel*", "cali*"}
+
+// guestAddressFamilies are the values of prefer_family
+var guestAddressFamilies = []string{"ipv4", "ipv6"}
+
+// errGuestAgentNotReady is returned while the qemu guest agent of the domain
+// does not answer yet.
+var errGuestAgentNotReady = errors.New("the qemu guest agent is not ready")
+
+// guestAddressFilter selects the addresses of the guest interfaces.
+type guestAddressFilter struct {
+	// name patterns of the interfaces to ignore, as in path.Match
+	excludeInterfaces []string
+	// "ipv4", "ipv6", or empty for no preference
+	preferFamily string
+}
+
+// excludes returns whether the addresses of the guest interface name are
+// ignored.
+func (f *guestAddressFilter) excludes(name string) bool {
+	for _, pattern := range f.excludeInterfaces {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// addresses returns the addresses of the guest interfaces with the MAC
+// address mac, or of all of them if mac is empty. A nil filter returns them
+// all, otherwise the loopback and link-local addresses, and the ones of the
+// excluded interfaces, are left out, and the ones of the preferred family
+// come first.
+func (f *guestAddressFilter) addresses(ifaces []libvirt.DomainInterface, mac string) []string {
+	var addrs []libvirt.DomainIPAddr
+	for _, iface := range ifaces {
+		if mac != "" && (len(iface.Hwaddr) == 0 || strings.ToUpper(iface.Hwaddr[0]) != mac) {
+			continue
+		}
+		if f != nil && f.excludes(iface.Name) {
+			continue
+		}
+		for _, addr := range iface.Addrs {
+			if f != nil {
+				ip := net.ParseIP(addr.Addr)
+				if ip == nil || ip.IsLoopback() || ip.IsLinkLocalUnicast() {
+					continue
+				}
+			}
+			addrs = append(addrs, addr)
+		}
+	}
+
+	if f != nil && f.preferFamily != "" {
+		preferred := int32(libvirt.IPAddrTypeIpv4)
+		if f.preferFamily == "ipv6" {
+			preferred = int32(libvirt.IPAddrTypeIpv6)
+		}
+		sort.SliceStable(addrs, func(i, j int) bool {
+			return addrs[i].Type == preferred && addrs[j].Type != preferred
+		})
+	}
+
+	var result []string
+	for _, addr := range addrs {
+		result = append(result, addr.Addr)
+	}
+	return result
+}
+
+// hasPreferred returns whether one of addrs is of the preferred family, if
+// there is one.
+func (f *guestAddressFilter) hasPreferred(addrs []string) bool {
+	if len(addrs) == 0 {
+		return false
+	}
+	if f == nil || f.preferFamily == "" {
+		return true
+	}
+	for _, addr := range addrs {
+		isIPv4 := net.ParseIP(addr).To4() != nil
+		if isIPv4 == (f.preferFamily == "ipv4") {
+			return true
+		}
+	}
+	return false
+}
+
+// newGuestAddressFilter returns the filter of the guest addresses
+// configured in the block at prefix, get reading the configuration.
+func newGuestAddressFilter(get func(string) interface{}, prefix string) (*guestAddressFilter, error) {
+	filter := &guestAddressFilter{
+		excludeInterfaces: defaultGuestExcludedInterfaces,
+		preferFamily:      get(prefix + "prefer_family").(string),
+	}
+	if excluded := get(prefix + "exclude_interfaces").([]interface{}); len(excluded) > 0 {
+		filter.excludeInterfaces = nil
+		for _, pattern := range excluded {
+			pattern, _ := pattern.(string)
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("invalid exclude_interfaces pattern \"%s\": %w", pattern, err)
+			}
+			filter.excludeInterfaces = append(filter.excludeInterfaces, pattern)
+		}
+	}
+	switch filter.preferFamily {
+	case "", "ipv4", "ipv6":
+	default:
+		return nil, fmt.Errorf("unsupported prefer_family \"%s\", supported families are: %s",
+			filter.preferFamily, strings.Join(guestAddressFamilies, ", "))
+	}
+	return filter, nil
+}
+
+// guestWaitTimeout returns the timeout configured in the block at prefix,
+// or timeout if there is none.
+func guestWaitTimeout(get func(string) interface{}, prefix string, timeout time.Duration) (time.Duration, error) {
+	configured := get(prefix + "timeout").(string)
+	if configured == "" {
+		return timeout, nil
+	}
+	parsed, err := time.ParseDuration(configured)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %stimeout \"%s\": %w", prefix, configured, err)
+	}
+	return parsed, nil
+}
+
+// domainGuestAddressFilter returns the filter of the addresses of the domain
+// d, nil if it does not wait for its guest.
+func domainGuestAddressFilter(d *schema.ResourceData) (*guestAddressFilter, error) {
+	if len(d.Get("wait_for_guest").([]interface{})) == 0 {
+		return nil, nil
+	}
+	return newGuestAddressFilter(d.Get, "wait_for_guest.0.")
+}
+
+// validateWaitForGuest validates the address filter and the timeout of the
+// wait_for_guest block, get reading the configuration.
+func validateWaitForGuest(get func(string) interface{}) error {
+	if _, err := newGuestAddressFilter(get, "wait_for_guest.0."); err != nil {
+		return err
+	}
+	_, err := guestWaitTimeout(get, "wait_for_guest.0.", 0)
+	return err
+}
+
+// customizeDiffDomainWaitForGuest validates the wait_for_guest block of the
+// domain.
+func customizeDiffDomainWaitForGuest(ctx context.Context, d *schema.ResourceDiff, meta interface{}) error {
+	if len(d.Get("wait_for_guest").([]interface{})) == 0 {
+		return nil
+	}
+	if err := validateWaitForGuest(d.Get); err != nil {
+		return err
+	}
+	if command := d.Get("wait_for_guest.0.command").([]interface{}); len(command) > 0 && d.NewValueKnown("wait_for_guest.0.command") {
+		if path, _ := command[0].(string); path == "" {
+			return fmt.Errorf("the wait_for_guest command has no path to run")
+		}
+	}
+	return nil
+}
+
+// guestAgentNotReady returns whether err is the qemu guest agent not
+// answering, as it does until the guest boots it.
+func guestAgentNotReady(err error) bool {
+	return isError(err, libvirt.ErrAgentUnresponsive) || isError(err, libvirt.ErrAgentUnsynced)
+}
+
+// guestInterfaces returns the interfaces of the guest of the domain, as the
+// qemu guest agent reports them.
+func guestInterfaces(virConn *libvirt.Libvirt, domain libvirt.Domain) ([]libvirt.DomainInterface, error) {
+	ifaces, err := virConn.DomainInterfaceAddresses(domain, uint32(libvirt.DomainInterfaceAddressesSrcAgent), 0)
+	if guestAgentNotReady(err) {
+		return nil, errGuestAgentNotReady
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving the guest interfaces: %w", err)
+	}
+	return ifaces, nil
+}
+
+// guestHostname returns the hostname of the guest of the domain.
+func guestHostname(virConn *libvirt.Libvirt, domain libvirt.Domain) (string, error) {
+	hostname, err := virConn.DomainGetHostname(domain, libvirt.DomainGetHostnameAgent)
+	if guestAgentNotReady(err) {
+		return "", errGuestAgentNotReady
+	}
+	if err != nil {
+		return "", fmt.Errorf("error retrieving the guest hostname: %w", err)
+	}
+	return hostname, nil
+}
+
+// guestAgentCommand runs the qemu guest agent command execute with
+// arguments, if any, decoding what it returns into result.
+func guestAgentCommand(virConn *libvirt.Libvirt, domain libvirt.Domain, execute string, arguments interface{}, result interface{}) error {
+	request := map[string]interface{}{"execute": execute}
+	if arguments != nil {
+		request["arguments"] = arguments
+	}
+	command, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	response, err := virConn.QEMUDomainAgentCommand(domain, string(command), int32(libvirt.DomainAgentResponseTimeoutDefault), 0)
+	if guestAgentNotReady(err) {
+		return errGuestAgentNotReady
+	}
+	if err != nil {
+		return fmt.Errorf("error running the guest agent command %s: %w", execute, err)
+	}
+	if len(response) == 0 {
+		return fmt.Errorf("the guest agent command %s returned nothing", execute)
+	}
+
+	wrapped := struct {
+		Return interface{} `json:"return"`
+	}{result}
+	if err := json.Unmarshal([]byte(response[0]), &wrapped); err != nil {
+		return fmt.Errorf("error reading the result of the guest agent command %s: %w", execute, err)
+	}
+	return nil
+}
+
+// guestExecStatus is the result of the guest-exec-status agent command.
+type guestExecStatus struct {
+	Exited   bool   `json:"exited"`
+	ExitCode int    `json:"exitcode"`
+	OutData  string `json:"out-data"`
+	ErrData  string `json:"err-data"`
+}
+
+// output returns the output of the command, decoded.
+func (s guestExecStatus) output() string {
+	var output []string
+	for _, data := range []string{s.OutData, s.ErrData} {
+		decoded, err := base64.StdEncoding.DecodeString(data)
+		if err != nil || len(decoded) == 0 {
+			continue
+		}
+		output = append(output, strings.TrimSpace(string(decoded)))
+	}
+	return strings.Join(output, "\n")
+}
+
+// guestExec runs command, its path and arguments, in the guest of the
+// domain until it exits.
+func guestExec(ctx context.Context, virConn *libvirt.Libvirt, domain libvirt.Domain, command []string) (guestExecStatus, error) {
+	var status guestExecStatus
+
+	var started struct {
+		PID int `json:"pid"`
+	}
+	if err := guestAgentCommand(virConn, domain, "guest-exec", map[string]interface{}{
+		"path":           command[0],
+		"arg":            command[1:],
+		"capture-output": true,
+	}, &started); err != nil {
+		return status, err
+	}
+
+	for {
+		if err := guestAgentCommand(virConn, domain, "guest-exec-status", map[string]interface{}{
+			"pid": started.PID,
+		}, &status); err != nil {
+			return status, err
+		}
+		if status.Exited {
+			return status, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return status, ctx.Err()
+		case <-time.After(guestExecPollInterval):
+		}
+	}
+}
+
+// domainWaitForGuest waits for the guest of the domain d to be ready as its
+// wait_for_guest block sets: for the interfaces of the domain to have
+// addresses, for the guest to have the hostname, and for the readiness
+// command to succeed, as the qemu guest agent reports them.
+func domainWaitForGuest(ctx context.Context, virConn *libvirt.Libvirt, domain libvirt.Domain, d *schema.ResourceData, timeout time.Duration) error {
+	filter, err := domainGuestAddressFilter(d)
+	if err != nil || filter == nil {
+		return err
+	}
+
+	timeout, err = guestWaitTimeout(d.Get, "wait_for_guest.0.", timeout)
+	if err != nil {
+		return err
+	}
+	waitAddresses := d.Get("wait_for_guest.0.addresses").(bool)
+	hostname := d.Get("wait_for_guest.0.hostname").(string)
+	var command []string
+	for _, arg := range d.Get("wait_for_guest.0.command").([]interface{}) {
+		arg, _ := arg.(string)
+		command = append(command, arg)
+	}
+
+	var macs []string
+	if waitAddresses {
+		xmlDesc, err := virConn.DomainGetXMLDesc(domain, 0)
+		if err != nil {
+			return fmt.Errorf("error retrieving libvirt domain XML description: %w", err)
+		}
+		var domainDef libvirtxml.Domain
+		if err := xml.Unmarshal([]byte(xmlDesc), &domainDef); err != nil {
+			return fmt.Errorf("error reading libvirt domain XML description: %w", err)
+		}
+		for _, iface := range domainDef.Devices.Interfaces {
+			if iface.MAC != nil && iface.MAC.Address != "" {
+				macs = append(macs, strings.ToUpper(iface.MAC.Address))
+			}
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	waitFunc := func() (interface{}, string, error) {
+		state, err := domainGetState(virConn, domain)
+		if err != nil {
+			return false, "", err
+		}
+		for _, fatalState := range []string{"crashed", "shutoff", "shutdown", "pmsuspended"} {
+			if state == fatalState {
+				return false, "", errDomainInvalidState
+			}
+		}
+		if state != "running" {
+			return false, domWaitGuestStillWaiting, nil
+		}
+
+		ready, err := guestReady(ctx, virConn, domain, filter, macs, hostname, command)
+		if errors.Is(err, errGuestAgentNotReady) {
+			log.Printf("[DEBUG] the qemu guest agent of domain %s is not ready yet", d.Id())
+			return false, domWaitGuestStillWaiting, nil
+		}
+		if err != nil {
+			return false, "", err
+		}
+		if !ready {
+			return false, domWaitGuestStillWaiting, nil
+		}
+		return true, domWaitGuestDone, nil
+	}
+
+	stateConf := &resource.StateChangeConf{
+		Pending:    []string{domWaitGuestStillWaiting},
+		Target:     []string{domWaitGuestDone},
+		Refresh:    waitFunc,
+		Timeout:    timeout,
+		MinTimeout: resourceStateMinTimeout,
+		Delay:      resourceStateDelay,
+	}
+
+	if _, err := stateConf.WaitForStateContext(ctx); err != nil {
+		return fmt.Errorf("error waiting for the guest of domain %s: %w", d.Id(), err)
+	}
+
+	log.Printf("[DEBUG] the guest of domain %s is ready", d.Id())
+	return nil
+}
+
+// guestReady returns whether the guest of the domain has addresses for the
+// interfaces with the MAC addresses macs, has the hostname, if any, and runs
+// command, if any, successfully.
+func guestReady(ctx context.Context, virConn *libvirt.Libvirt, domain libvirt.Domain,
+	filter *guestAddressFilter, macs []string, hostname string, command []string) (bool, error) {
+	if err := guestAgentCommand(virConn, domain, "guest-ping", nil, &struct{}{}); err != nil {
+		return false, err
+	}
+
+	if len(macs) > 0 {
+		ifaces, err := guestInterfaces(virConn, domain)
+		if err != nil {
+			return false, err
+		}
+		for _, mac := range macs {
+			if !filter.hasPreferred(filter.addresses(ifaces, mac)) {
+				log.Printf("[DEBUG] the guest interface %s has no address yet", mac)
+				return false, nil
+			}
+		}
+	}
+
+	if hostname != "" {
+		current, err := guestHostname(virConn, domain)
+		if err != nil {
+			return false, err
+		}
+		if current != hostname {
+			log.Printf("[DEBUG] the guest hostname is %s, waiting for %s", current, hostname)
+			return false, nil
+		}
+	}
+
+	if len(command) > 0 {
+		status, err := guestExec(ctx, virConn, domain, command)
+		if err != nil {
+			return false, err
+		}
+		if status.ExitCode != 0 {
+			log.Printf("[DEBUG] the guest readiness command exited with %d: %s", status.ExitCode, status.output())
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
@@ -0,0 +1,124 @@
+package libvirt
+
+import (
+	"encoding/base64"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	libvirt "github.com/digitalocean/go-libvirt"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+func TestGuestAddressFilter(t *testing.T) {
+	ipv4 := int32(libvirt.IPAddrTypeIpv4)
+	ipv6 := int32(libvirt.IPAddrTypeIpv6)
+	ifaces := []libvirt.DomainInterface{
+		{Name: "lo", Hwaddr: []string{"00:00:00:00:00:00"}, Addrs: []libvirt.DomainIPAddr{{Type: ipv4, Addr: "127.0.0.1"}}},
+		{Name: "eth0", Hwaddr: []string{"52:54:00:aa:bb:cc"}, Addrs: []libvirt.DomainIPAddr{
+			{Type: ipv6, Addr: "fe80::5054:ff:feaa:bbcc"},
+			{Type: ipv6, Addr: "2001:db8::10"},
+			{Type: ipv4, Addr: "192.168.122.10"},
+		}},
+		{Name: "docker0", Hwaddr: []string{"02:42:0a:0b:0c:0d"}, Addrs: []libvirt.DomainIPAddr{{Type: ipv4, Addr: "172.17.0.1"}}},
+		{Name: "br0", Hwaddr: []string{"52:54:00:dd:ee:ff"}, Addrs: []libvirt.DomainIPAddr{{Type: ipv4, Addr: "10.0.0.5"}}},
+	}
+
+	tests := map[string]struct {
+		filter   *guestAddressFilter
+		mac      string
+		expected []string
+	}{
+		"no filter": {
+			mac:      "52:54:00:AA:BB:CC",
+			expected: []string{"fe80::5054:ff:feaa:bbcc", "2001:db8::10", "192.168.122.10"},
+		},
+		"default exclusions": {
+			filter:   &guestAddressFilter{excludeInterfaces: defaultGuestExcludedInterfaces},
+			expected: []string{"2001:db8::10", "192.168.122.10", "10.0.0.5"},
+		},
+		"prefer ipv4": {
+			filter:   &guestAddressFilter{preferFamily: "ipv4"},
+			mac:      "52:54:00:AA:BB:CC",
+			expected: []string{"192.168.122.10", "2001:db8::10"},
+		},
+		"excluded bridge": {
+			filter:   &guestAddressFilter{excludeInterfaces: []string{"br*"}, preferFamily: "ipv6"},
+			mac:      "52:54:00:DD:EE:FF",
+			expected: nil,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := test.filter.addresses(ifaces, test.mac); !reflect.DeepEqual(got, test.expected) {
+				t.Errorf("addresses = %v, expected %v", got, test.expected)
+			}
+		})
+	}
+
+	filter := &guestAddressFilter{preferFamily: "ipv4"}
+	if filter.hasPreferred([]string{"2001:db8::10"}) {
+		t.Errorf("an IPv6 address is preferred over IPv4")
+	}
+	if !filter.hasPreferred([]string{"2001:db8::10", "192.168.122.10"}) {
+		t.Errorf("an IPv4 address is not preferred")
+	}
+}
+
+func TestNewGuestAddressFilter(t *testing.T) {
+	block := func(settings map[string]interface{}) map[string]interface{} {
+		return map[string]interface{}{"wait_for_guest": []interface{}{settings}}
+	}
+
+	tests := map[string]struct {
+		raw   map[string]interface{}
+		error string
+	}{
+		"defaults":           {raw: block(map[string]interface{}{"addresses": true})},
+		"invalid pattern":    {raw: block(map[string]interface{}{"exclude_interfaces": []interface{}{"br[0"}}), error: "invalid exclude_interfaces pattern"},
+		"unsupported family": {raw: block(map[string]interface{}{"prefer_family": "ipx"}), error: `unsupported prefer_family "ipx"`},
+		"invalid timeout":    {raw: block(map[string]interface{}{"timeout": "5 minutes"}), error: "invalid wait_for_guest.0.timeout"},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			d := schema.TestResourceDataRaw(t, resourceLibvirtDomain().Schema, test.raw)
+			err := validateWaitForGuest(d.Get)
+			if test.error == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %s", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.error) {
+				t.Errorf("expected an error containing %q, got %v", test.error, err)
+			}
+		})
+	}
+
+	d := schema.TestResourceDataRaw(t, resourceLibvirtDomain().Schema, block(map[string]interface{}{"timeout": "90s"}))
+	if timeout, err := guestWaitTimeout(d.Get, "wait_for_guest.0.", time.Minute); err != nil || timeout != 90*time.Second {
+		t.Errorf("timeout = %s, %v, expected 1m30s", timeout, err)
+	}
+	filter, err := domainGuestAddressFilter(d)
+	if err != nil || !reflect.DeepEqual(filter.excludeInterfaces, defaultGuestExcludedInterfaces) {
+		t.Errorf("filter = %+v, %v, expected the default exclusions", filter, err)
+	}
+	if filter, err := domainGuestAddressFilter(resourceLibvirtDomain().Data(nil)); filter != nil || err != nil {
+		t.Errorf("a domain without wait_for_guest has the filter %+v, %v", filter, err)
+	}
+}
+
+func TestGuestExecStatusOutput(t *testing.T) {
+	status := guestExecStatus{
+		Exited:   true,
+		ExitCode: 1,
+		OutData:  base64.StdEncoding.EncodeToString([]byte("status: running\n")),
+		ErrData:  base64.StdEncoding.EncodeToString([]byte("not done yet\n")),
+	}
+	if output := status.output(); output != "status: running\nnot done yet" {
+		t.Errorf("unexpected output %q", output)
+	}
+}
@@ -0,0 +1,288 @@
+package libvirt
+
+import (
+	"encoding/xml"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	libvirt "github.com/digitalocean/go-libvirt"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"libvirt.org/go/libvirtxml"
+)
+
+// hostdevTypes are the kinds of host devices a domain can be given.
+var hostdevTypes = []string{"pci", "usb", "mdev"}
+
+// parsePCIAddress parses a PCI address written as domain:bus:slot.function,
+// as lspci -D does, the domain being optional.
+func parsePCIAddress(address string) (*libvirtxml.DomainAddressPCI, error) {
+	parts := strings.Split(address, ":")
+	if len(parts) == 2 {
+		parts = append([]string{"0"}, parts...)
+	}
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("invalid PCI address '%s', expected domain:bus:slot.function", address)
+	}
+	slotFunction := strings.Split(parts[2], ".")
+	if len(slotFunction) != 2 {
+		return nil, fmt.Errorf("invalid PCI address '%s', expected domain:bus:slot.function", address)
+	}
+
+	var values [4]uint
+	for i, part := range []string{parts[0], parts[1], slotFunction[0], slotFunction[1]} {
+		value, err := strconv.ParseUint(part, 16, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid PCI address '%s': %w", address, err)
+		}
+		values[i] = uint(value)
+	}
+
+	return &libvirtxml.DomainAddressPCI{
+		Domain:   &values[0],
+		Bus:      &values[1],
+		Slot:     &values[2],
+		Function: &values[3],
+	}, nil
+}
+
+// pciAddressString is the inverse of parsePCIAddress.
+func pciAddressString(domain, bus, slot, function *uint) string {
+	value := func(v *uint) uint {
+		if v == nil {
+			return 0
+		}
+		return *v
+	}
+	return fmt.Sprintf("%04x:%02x:%02x.%x", value(domain), value(bus), value(slot), value(function))
+}
+
+// parseUSBID parses a USB vendor or product ID, given in hexadecimal with an
+// optional 0x prefix, as lsusb does.
+func parseUSBID(id string) (uint64, error) {
+	return strconv.ParseUint(strings.TrimPrefix(strings.ToLower(id), "0x"), 16, 16)
+}
+
+// getNodeDeviceDef returns the definition of the host device name.
+func getNodeDeviceDef(virConn *libvirt.Libvirt, name string) (*libvirtxml.NodeDevice, error) {
+	xmlDesc, err := virConn.NodeDeviceGetXMLDesc(name, 0)
+	if err != nil {
+		return nil, err
+	}
+	nodeDeviceDef := &libvirtxml.NodeDevice{}
+	if err := xml.Unmarshal([]byte(xmlDesc), nodeDeviceDef); err != nil {
+		return nil, fmt.Errorf("error reading node device %s XML description: %w", name, err)
+	}
+	return nodeDeviceDef, nil
+}
+
+// validatePCIHostdev checks the PCI device at address can be passed through:
+// an unmanaged device has to be bound to vfio-pci already, and the other
+// devices of its IOMMU group have to be passed through as well, or leave
+// the group usable by not being bound to a host driver. PCI bridges do not
+// matter.
+func validatePCIHostdev(virConn *libvirt.Libvirt, address string, managed bool, passedThrough map[string]bool) error {
+	pciAddress, err := parsePCIAddress(address)
+	if err != nil {
+		return err
+	}
+	nodeDeviceName := func(address string) string {
+		return "pci_" + strings.NewReplacer(":", "_", ".", "_").Replace(address)
+	}
+
+	name := nodeDeviceName(pciAddressString(pciAddress.Domain, pciAddress.Bus, pciAddress.Slot, pciAddress.Function))
+	nodeDeviceDef, err := getNodeDeviceDef(virConn, name)
+	if err != nil {
+		return fmt.Errorf("error retrieving PCI device %s: %w", address, err)
+	}
+	pci := nodeDeviceDef.Capability.PCI
+	if pci == nil {
+		return fmt.Errorf("node device %s is not a PCI device", name)
+	}
+
+	if !managed && (nodeDeviceDef.Driver == nil || nodeDeviceDef.Driver.Name != "vfio-pci") {
+		return fmt.Errorf("PCI device %s is not managed, it has to be bound to the vfio-pci driver", address)
+	}
+
+	if pci.IOMMUGroup == nil {
+		return fmt.Errorf("PCI device %s is in no IOMMU group, check the IOMMU is enabled on the host", address)
+	}
+	for _, groupAddress := range pci.IOMMUGroup.Address {
+		other := pciAddressString(groupAddress.Domain, groupAddress.Bus, groupAddress.Slot, groupAddress.Function)
+		if passedThrough[other] {
+			continue
+		}
+		otherDef, err := getNodeDeviceDef(virConn, nodeDeviceName(other))
+		if err != nil {
+			return fmt.Errorf("error retrieving PCI device %s: %w", other, err)
+		}
+		if otherDef.Capability.PCI != nil && strings.HasPrefix(otherDef.Capability.PCI.Class, "0x0604") {
+			continue
+		}
+		if otherDef.Driver != nil && otherDef.Driver.Name != "vfio-pci" && otherDef.Driver.Name != "pci-stub" {
+			return fmt.Errorf("PCI device %s shares IOMMU group %d with %s, bound to the %s driver: pass it through too, or bind it to vfio-pci",
+				address, pci.IOMMUGroup.Number, other, otherDef.Driver.Name)
+		}
+	}
+
+	return nil
+}
+
+// usbHostdevAddress returns the address of the host USB device with the
+// vendor and product IDs given.
+func usbHostdevAddress(virConn *libvirt.Libvirt, vendor string, product string) (*libvirtxml.DomainAddressUSB, error) {
+	vendorID, err := parseUSBID(vendor)
+	if err != nil {
+		return nil, fmt.Errorf("invalid USB vendor ID '%s'", vendor)
+	}
+	productID, err := parseUSBID(product)
+	if err != nil {
+		return nil, fmt.Errorf("invalid USB product ID '%s'", product)
+	}
+
+	devices, _, err := virConn.ConnectListAllNodeDevices(1, uint32(libvirt.ConnectListNodeDevicesCapUsbDev))
+	if err != nil {
+		return nil, fmt.Errorf("error listing the host USB devices: %w", err)
+	}
+	for _, device := range devices {
+		nodeDeviceDef, err := getNodeDeviceDef(virConn, device.Name)
+		if err != nil {
+			return nil, err
+		}
+		usb := nodeDeviceDef.Capability.USBDevice
+		if usb == nil {
+			continue
+		}
+		if v, err := parseUSBID(usb.Vendor.ID); err != nil || v != vendorID {
+			continue
+		}
+		if p, err := parseUSBID(usb.Product.ID); err != nil || p != productID {
+			continue
+		}
+		bus, dev := uint(usb.Bus), uint(usb.Device)
+		return &libvirtxml.DomainAddressUSB{Bus: &bus, Device: &dev}, nil
+	}
+
+	return nil, fmt.Errorf("no USB device %s:%s found on the host", vendor, product)
+}
+
+func setHostdevs(d *schema.ResourceData, domainDef *libvirtxml.Domain, virConn *libvirt.Libvirt) error {
+	// the PCI devices passed through, for the IOMMU groups validation
+	passedThrough := map[string]bool{}
+	for i := 0; i < d.Get("hostdev.#").(int); i++ {
+		prefix := fmt.Sprintf("hostdev.%d", i)
+		if d.Get(prefix+".type").(string) != "pci" {
+			continue
+		}
+		pciAddress, err := parsePCIAddress(d.Get(prefix + ".address").(string))
+		if err != nil {
+			return fmt.Errorf("hostdev entry %d: %w", i, err)
+		}
+		passedThrough[pciAddressString(pciAddress.Domain, pciAddress.Bus, pciAddress.Slot, pciAddress.Function)] = true
+	}
+
+	for i := 0; i < d.Get("hostdev.#").(int); i++ {
+		prefix := fmt.Sprintf("hostdev.%d", i)
+		hostdevType := d.Get(prefix + ".type").(string)
+		hostdev := libvirtxml.DomainHostdev{}
+
+		switch hostdevType {
+		case "pci":
+			address := d.Get(prefix + ".address").(string)
+			pciAddress, err := parsePCIAddress(address)
+			if err != nil {
+				return fmt.Errorf("hostdev entry %d: %w", i, err)
+			}
+			managed := d.Get(prefix + ".managed").(bool)
+			if err := validatePCIHostdev(virConn, address, managed, passedThrough); err != nil {
+				return err
+			}
+			hostdev.Managed = formatBoolYesNo(managed)
+			hostdev.SubsysPCI = &libvirtxml.DomainHostdevSubsysPCI{
+				Source: &libvirtxml.DomainHostdevSubsysPCISource{Address: pciAddress},
+			}
+
+		case "usb":
+			vendor, product := d.Get(prefix+".vendor").(string), d.Get(prefix+".product").(string)
+			if vendor == "" || product == "" {
+				return fmt.Errorf("hostdev entry %d of type \"usb\" must have a 'vendor' and a 'product' set", i)
+			}
+			usbAddress, err := usbHostdevAddress(virConn, vendor, product)
+			if err != nil {
+				return err
+			}
+			hostdev.SubsysUSB = &libvirtxml.DomainHostdevSubsysUSB{
+				Source: &libvirtxml.DomainHostdevSubsysUSBSource{Address: usbAddress},
+			}
+
+		case "mdev":
+			mdevUUID := d.Get(prefix + ".uuid").(string)
+			if mdevUUID == "" {
+				return fmt.Errorf("hostdev entry %d of type \"mdev\" must have a 'uuid' set", i)
+			}
+			hostdev.SubsysMDev = &libvirtxml.DomainHostdevSubsysMDev{
+				Model: d.Get(prefix + ".model").(string),
+				Source: &libvirtxml.DomainHostdevSubsysMDevSource{
+					Address: &libvirtxml.DomainAddressMDev{UUID: mdevUUID},
+				},
+			}
+			if d.Get(prefix + ".display").(bool) {
+				hostdev.SubsysMDev.Display = "on"
+			}
+
+		default:
+			return fmt.Errorf("hostdev entry %d has an unsupported type \"%s\", supported types are: %s", i, hostdevType, strings.Join(hostdevTypes, ", "))
+		}
+
+		domainDef.Devices.Hostdevs = append(domainDef.Devices.Hostdevs, hostdev)
+	}
+	log.Printf("hostdevs: %+v\n", domainDef.Devices.Hostdevs)
+	return nil
+}
+
+// readHostdevs returns the hostdev entries of the host devices in
+// domainDef. USB devices are given by vendor and product but defined by
+// their address on the host, so these are kept from the state, as are the
+// settings the type of a device does not use, or their defaults when the
+// device is not in the state yet, as after an import.
+func readHostdevs(d *schema.ResourceData, domainDef libvirtxml.Domain) []map[string]interface{} {
+	var hostdevs []map[string]interface{}
+	inState := d.Get("hostdev.#").(int)
+	for i, hostdevDef := range domainDef.Devices.Hostdevs {
+		fromState := func(key string, defaultValue interface{}) interface{} {
+			if i >= inState {
+				return defaultValue
+			}
+			return d.Get(fmt.Sprintf("hostdev.%d.%s", i, key))
+		}
+
+		hostdev := map[string]interface{}{}
+		switch {
+		case hostdevDef.SubsysPCI != nil && hostdevDef.SubsysPCI.Source != nil && hostdevDef.SubsysPCI.Source.Address != nil:
+			address := hostdevDef.SubsysPCI.Source.Address
+			hostdev["type"] = "pci"
+			hostdev["address"] = pciAddressString(address.Domain, address.Bus, address.Slot, address.Function)
+			hostdev["managed"] = hostdevDef.Managed == "yes"
+			hostdev["model"] = fromState("model", "vfio-pci")
+			hostdev["display"] = fromState("display", false)
+		case hostdevDef.SubsysUSB != nil:
+			hostdev["type"] = "usb"
+			hostdev["vendor"] = fromState("vendor", "")
+			hostdev["product"] = fromState("product", "")
+			hostdev["managed"] = fromState("managed", true)
+			hostdev["model"] = fromState("model", "vfio-pci")
+			hostdev["display"] = fromState("display", false)
+		case hostdevDef.SubsysMDev != nil && hostdevDef.SubsysMDev.Source != nil && hostdevDef.SubsysMDev.Source.Address != nil:
+			hostdev["type"] = "mdev"
+			hostdev["uuid"] = hostdevDef.SubsysMDev.Source.Address.UUID
+			hostdev["model"] = hostdevDef.SubsysMDev.Model
+			hostdev["display"] = hostdevDef.SubsysMDev.Display == "on"
+			hostdev["managed"] = fromState("managed", true)
+		default:
+			continue
+		}
+		hostdevs = append(hostdevs, hostdev)
+	}
+	return hostdevs
+}
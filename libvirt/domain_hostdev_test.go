@@ -0,0 +1,178 @@
+package libvirt
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+	"libvirt.org/go/libvirtxml"
+)
+
+func TestParsePCIAddress(t *testing.T) {
+	tests := map[string]string{
+		"0000:01:00.0": "0000:01:00.0",
+		"01:00.1":      "0000:01:00.1",
+		"0001:3b:1f.7": "0001:3b:1f.7",
+		"0000:0A:00.0": "0000:0a:00.0",
+	}
+	for address, expected := range tests {
+		pciAddress, err := parsePCIAddress(address)
+		if err != nil {
+			t.Errorf("unexpected error parsing %s: %s", address, err)
+			continue
+		}
+		if s := pciAddressString(pciAddress.Domain, pciAddress.Bus, pciAddress.Slot, pciAddress.Function); s != expected {
+			t.Errorf("parsePCIAddress(%s) = %s, expected %s", address, s, expected)
+		}
+	}
+
+	for _, address := range []string{"", "01:00", "0000:01:00:0", "0000:01:zz.0"} {
+		if _, err := parsePCIAddress(address); err == nil {
+			t.Errorf("expected an error parsing '%s'", address)
+		}
+	}
+}
+
+func TestParseUSBID(t *testing.T) {
+	for _, id := range []string{"046d", "0x046d", "0x046D"} {
+		if v, err := parseUSBID(id); err != nil || v != 0x046d {
+			t.Errorf("parseUSBID(%s) = %x, %v, expected 46d", id, v, err)
+		}
+	}
+	if _, err := parseUSBID("0x10000"); err == nil {
+		t.Errorf("expected an error parsing an ID out of range")
+	}
+}
+
+func TestSetHostdevsMDev(t *testing.T) {
+	d := schema.TestResourceDataRaw(t, resourceLibvirtDomain().Schema, map[string]interface{}{
+		"name": "domain",
+		"hostdev": []interface{}{map[string]interface{}{
+			"type":    "mdev",
+			"uuid":    "c2177883-f1bb-47f0-914d-32a22e3a8804",
+			"display": true,
+		}},
+	})
+
+	domainDef := libvirtxml.Domain{Devices: &libvirtxml.DomainDeviceList{}}
+	if err := setHostdevs(d, &domainDef, nil); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	data, err := xmlMarshallIndented(domainDef)
+	if err != nil {
+		t.Fatalf("could not marshall the domain: %s", err)
+	}
+	for _, expected := range []string{
+		`<hostdev mode="subsystem" type="mdev" model="vfio-pci" display="on">`,
+		`<address uuid="c2177883-f1bb-47f0-914d-32a22e3a8804"></address>`,
+	} {
+		if !strings.Contains(data, expected) {
+			t.Errorf("expected %s in:\n%s", expected, data)
+		}
+	}
+
+	hostdevs := readHostdevs(d, domainDef)
+	if len(hostdevs) != 1 || hostdevs[0]["uuid"] != "c2177883-f1bb-47f0-914d-32a22e3a8804" || hostdevs[0]["display"] != true {
+		t.Errorf("unexpected hostdevs read: %v", hostdevs)
+	}
+}
+
+func TestSetHostdevsErrors(t *testing.T) {
+	tests := map[string]struct {
+		hostdev map[string]interface{}
+		error   string
+	}{
+		"unsupported type": {
+			hostdev: map[string]interface{}{"type": "scsi"},
+			error:   `unsupported type "scsi"`,
+		},
+		"invalid PCI address": {
+			hostdev: map[string]interface{}{"type": "pci", "address": "01:00"},
+			error:   "invalid PCI address '01:00'",
+		},
+		"usb without product": {
+			hostdev: map[string]interface{}{"type": "usb", "vendor": "0x046d"},
+			error:   `must have a 'vendor' and a 'product' set`,
+		},
+		"mdev without uuid": {
+			hostdev: map[string]interface{}{"type": "mdev"},
+			error:   `must have a 'uuid' set`,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			d := schema.TestResourceDataRaw(t, resourceLibvirtDomain().Schema, map[string]interface{}{
+				"name":    "domain",
+				"hostdev": []interface{}{test.hostdev},
+			})
+			domainDef := libvirtxml.Domain{Devices: &libvirtxml.DomainDeviceList{}}
+			err := setHostdevs(d, &domainDef, nil)
+			if err == nil || !strings.Contains(err.Error(), test.error) {
+				t.Errorf("expected an error containing %q, got %v", test.error, err)
+			}
+		})
+	}
+}
+
+func TestReadHostdevsNoDiff(t *testing.T) {
+	unit := func(v uint) *uint { return &v }
+	tests := map[string]struct {
+		hostdev map[string]interface{}
+		def     libvirtxml.DomainHostdev
+	}{
+		"pci": {
+			hostdev: map[string]interface{}{"type": "pci", "address": "0000:01:00.0"},
+			def: libvirtxml.DomainHostdev{
+				Managed: "yes",
+				SubsysPCI: &libvirtxml.DomainHostdevSubsysPCI{Source: &libvirtxml.DomainHostdevSubsysPCISource{
+					Address: &libvirtxml.DomainAddressPCI{Domain: unit(0), Bus: unit(1), Slot: unit(0), Function: unit(0)},
+				}},
+			},
+		},
+		"usb": {
+			hostdev: map[string]interface{}{"type": "usb", "vendor": "0x046d", "product": "0xc52b"},
+			def: libvirtxml.DomainHostdev{
+				SubsysUSB: &libvirtxml.DomainHostdevSubsysUSB{Source: &libvirtxml.DomainHostdevSubsysUSBSource{
+					Address: &libvirtxml.DomainAddressUSB{Bus: unit(1), Device: unit(4)},
+				}},
+			},
+		},
+		"mdev": {
+			hostdev: map[string]interface{}{"type": "mdev", "uuid": "c2177883-f1bb-47f0-914d-32a22e3a8804", "managed": false},
+			def: libvirtxml.DomainHostdev{
+				SubsysMDev: &libvirtxml.DomainHostdevSubsysMDev{
+					Model:  "vfio-pci",
+					Source: &libvirtxml.DomainHostdevSubsysMDevSource{Address: &libvirtxml.DomainAddressMDev{UUID: "c2177883-f1bb-47f0-914d-32a22e3a8804"}},
+				},
+			},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			raw := map[string]interface{}{"name": "domain", "hostdev": []interface{}{test.hostdev}}
+			d := schema.TestResourceDataRaw(t, resourceLibvirtDomain().Schema, raw)
+			domainDef := libvirtxml.Domain{Devices: &libvirtxml.DomainDeviceList{Hostdevs: []libvirtxml.DomainHostdev{test.def}}}
+
+			read := resourceLibvirtDomain().Data(nil)
+			read.SetId("domain")
+			read.Set("name", "domain")
+			read.Set("hostdev", readHostdevs(d, domainDef))
+
+			diff, err := schema.InternalMap(resourceLibvirtDomain().Schema).Diff(context.Background(), read.State(), terraform.NewResourceConfigRaw(raw), nil, nil, true)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if diff != nil {
+				for key, attr := range diff.Attributes {
+					if strings.HasPrefix(key, "hostdev") && (attr.Old != attr.New || attr.NewComputed) {
+						t.Errorf("the hostdev read back differs from the config: %s: %q => %q", key, attr.Old, attr.New)
+					}
+				}
+			}
+		})
+	}
+}
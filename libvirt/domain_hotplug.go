@@ -0,0 +1,226 @@
+package libvirt
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"strings"
+
+	libvirt "github.com/digitalocean/go-libvirt"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"libvirt.org/go/libvirtxml"
+)
+
+// diskKeys are the attributes of a disk entry, changing any of them on an
+// existing disk requires a new domain.
+var diskKeys = []string{"volume_id", "url", "file", "scsi", "wwn", "block_device", "cache"}
+
+// networkInterfaceSourceKeys are the attributes selecting what an existing
+// network interface is connected to, changing them requires a new domain.
+var networkInterfaceSourceKeys = []string{"network_id", "network_name", "bridge", "vepa", "macvtap", "passthrough"}
+
+// diskHotpluggable reports whether a disk entry can be attached to and
+// detached from a running domain: IDE cdroms cannot, and SCSI disks need a
+// controller the domain might not have.
+func diskHotpluggable(disk interface{}) bool {
+	attrs, ok := disk.(map[string]interface{})
+	if !ok {
+		return false
+	}
+	if scsi, _ := attrs["scsi"].(bool); scsi {
+		return false
+	}
+	for _, key := range []string{"file", "url"} {
+		if path, _ := attrs[key].(string); strings.HasSuffix(path, ".iso") {
+			return false
+		}
+	}
+	return true
+}
+
+// customizeDiffDomainDevices forces a new domain for the changes of the disk
+// and network_interface entries that updateDomainDevices cannot apply by
+// attaching and detaching devices: entries are only added or removed at the
+// end, as the disk targets follow the position of the disks, and entries
+// that stay have to keep what they are connected to.
+func customizeDiffDomainDevices(ctx context.Context, d *schema.ResourceDiff, meta interface{}) error {
+	if d.Id() == "" {
+		return nil
+	}
+
+	if d.HasChange("disk") {
+		o, n := d.GetChange("disk")
+		oldDisks, newDisks := o.([]interface{}), n.([]interface{})
+		for i := 0; i < len(oldDisks) && i < len(newDisks); i++ {
+			for _, key := range diskKeys {
+				if d.HasChange(fmt.Sprintf("disk.%d.%s", i, key)) {
+					log.Printf("[DEBUG] disk %d changed, the domain has to be recreated", i)
+					return d.ForceNew("disk")
+				}
+			}
+		}
+		for i := len(newDisks); i < len(oldDisks); i++ {
+			if !diskHotpluggable(oldDisks[i]) {
+				log.Printf("[DEBUG] disk %d cannot be detached, the domain has to be recreated", i)
+				return d.ForceNew("disk")
+			}
+		}
+		for i := len(oldDisks); i < len(newDisks); i++ {
+			if !diskHotpluggable(newDisks[i]) {
+				log.Printf("[DEBUG] disk %d cannot be attached, the domain has to be recreated", i)
+				return d.ForceNew("disk")
+			}
+		}
+	}
+
+	if d.HasChange("network_interface") {
+		o, n := d.GetChange("network_interface")
+		count := len(o.([]interface{}))
+		if newCount := len(n.([]interface{})); newCount < count {
+			count = newCount
+		}
+		for i := 0; i < count; i++ {
+			for _, key := range networkInterfaceSourceKeys {
+				key = fmt.Sprintf("network_interface.%d.%s", i, key)
+				if d.HasChange(key) {
+					log.Printf("[DEBUG] %s changed, the domain has to be recreated", key)
+					return d.ForceNew(key)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// domainDeviceModifyFlags returns the flags to change the devices of the
+// persistent definition of domain, and of the running domain when it runs.
+func domainDeviceModifyFlags(virConn *libvirt.Libvirt, domain libvirt.Domain) (uint32, error) {
+	running, err := domainIsRunning(virConn, domain)
+	if err != nil {
+		return 0, err
+	}
+	flags := libvirt.DomainDeviceModifyConfig
+	if running {
+		flags |= libvirt.DomainDeviceModifyLive
+	}
+	return uint32(flags), nil
+}
+
+// updateDomainDevices attaches the disks and network interfaces added at
+// the end of their lists to domain, and detaches the ones removed from
+// there, both from the running domain and from its persistent definition.
+// customizeDiffDomainDevices makes sure the other changes recreate the
+// domain instead. It returns the interfaces to wait for a lease of, and the
+// host mappings that are pending until then.
+func updateDomainDevices(ctx context.Context, virConn *libvirt.Libvirt, d *schema.ResourceData, domain libvirt.Domain) ([]*libvirtxml.DomainInterface, map[string]*pendingMapping, error) {
+	partialNetIfaces := make(map[string]*pendingMapping)
+	if !d.HasChange("disk") && !d.HasChange("network_interface") {
+		return nil, partialNetIfaces, nil
+	}
+
+	flags, err := domainDeviceModifyFlags(virConn, domain)
+	if err != nil {
+		return nil, nil, err
+	}
+	domainDef, err := getXMLDomainDefFromLibvirt(virConn, domain)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	o, n := d.GetChange("disk")
+	oldDisks, newDisks := len(o.([]interface{})), len(n.([]interface{}))
+	for i := oldDisks - 1; i >= newDisks; i-- {
+		dev := newDefDisk(i).Target.Dev
+		var disk *libvirtxml.DomainDisk
+		for j := range domainDef.Devices.Disks {
+			if target := domainDef.Devices.Disks[j].Target; target != nil && target.Dev == dev {
+				disk = &domainDef.Devices.Disks[j]
+				break
+			}
+		}
+		if disk == nil {
+			log.Printf("[WARN] disk %s of domain %s is already gone", dev, domain.Name)
+			continue
+		}
+		if err := detachDomainDevice(ctx, virConn, domain, disk, flags); err != nil {
+			return nil, nil, fmt.Errorf("error detaching disk %s: %w", dev, err)
+		}
+	}
+	if newDisks > oldDisks {
+		wanted := libvirtxml.Domain{Devices: &libvirtxml.DomainDeviceList{}}
+		if err := setDisks(d, &wanted, virConn); err != nil {
+			return nil, nil, err
+		}
+		for i := oldDisks; i < newDisks; i++ {
+			disk := wanted.Devices.Disks[i]
+			if err := attachDomainDevice(ctx, virConn, domain, disk, flags); err != nil {
+				return nil, nil, fmt.Errorf("error attaching disk %s: %w", disk.Target.Dev, err)
+			}
+		}
+	}
+
+	o, n = d.GetChange("network_interface")
+	oldIfaces, newIfaces := o.([]interface{}), len(n.([]interface{}))
+	for i := len(oldIfaces) - 1; i >= newIfaces; i-- {
+		mac, _ := oldIfaces[i].(map[string]interface{})["mac"].(string)
+		var iface *libvirtxml.DomainInterface
+		for j := range domainDef.Devices.Interfaces {
+			if ifaceMAC := domainDef.Devices.Interfaces[j].MAC; ifaceMAC != nil && strings.EqualFold(ifaceMAC.Address, mac) {
+				iface = &domainDef.Devices.Interfaces[j]
+				break
+			}
+		}
+		if iface == nil {
+			log.Printf("[WARN] network interface %s of domain %s is already gone", mac, domain.Name)
+			continue
+		}
+		if err := detachDomainDevice(ctx, virConn, domain, iface, flags); err != nil {
+			return nil, nil, fmt.Errorf("error detaching network interface %s: %w", mac, err)
+		}
+	}
+	var waitForLeases []*libvirtxml.DomainInterface
+	if newIfaces > len(oldIfaces) {
+		wanted := libvirtxml.Domain{Name: d.Get("name").(string), Devices: &libvirtxml.DomainDeviceList{}}
+		var wanting []*libvirtxml.DomainInterface
+		if err := setNetworkInterfaces(ctx, d, &wanted, virConn, partialNetIfaces, &wanting); err != nil {
+			return nil, nil, err
+		}
+		for i := len(oldIfaces); i < newIfaces; i++ {
+			iface := wanted.Devices.Interfaces[i]
+			if err := attachDomainDevice(ctx, virConn, domain, iface, flags); err != nil {
+				return nil, nil, fmt.Errorf("error attaching network interface %s: %w", iface.MAC.Address, err)
+			}
+			for _, w := range wanting {
+				if w.MAC.Address == iface.MAC.Address {
+					waitForLeases = append(waitForLeases, w)
+				}
+			}
+		}
+	}
+
+	return waitForLeases, partialNetIfaces, nil
+}
+
+func attachDomainDevice(ctx context.Context, virConn *libvirt.Libvirt, domain libvirt.Domain, device interface{}, flags uint32) error {
+	data, err := xml.Marshal(device)
+	if err != nil {
+		return fmt.Errorf("error serializing device: %w", err)
+	}
+	log.Printf("[INFO] Attaching device to domain %s:\n%s", domain.Name, data)
+	return retryLibvirt(ctx, virConn, func() error {
+		return virConn.DomainAttachDeviceFlags(domain, string(data), flags)
+	})
+}
+
+func detachDomainDevice(ctx context.Context, virConn *libvirt.Libvirt, domain libvirt.Domain, device interface{}, flags uint32) error {
+	data, err := xml.Marshal(device)
+	if err != nil {
+		return fmt.Errorf("error serializing device: %w", err)
+	}
+	log.Printf("[INFO] Detaching device from domain %s:\n%s", domain.Name, data)
+	return retryLibvirt(ctx, virConn, func() error {
+		return virConn.DomainDetachDeviceFlags(domain, string(data), flags)
+	})
+}
@@ -0,0 +1,26 @@
+package libvirt
+
+import (
+	"testing"
+)
+
+func TestDiskHotpluggable(t *testing.T) {
+	tests := []struct {
+		disk         interface{}
+		hotpluggable bool
+	}{
+		{map[string]interface{}{"volume_id": "/pool/volume"}, true},
+		{map[string]interface{}{"file": "/var/lib/libvirt/images/data.qcow2"}, true},
+		{map[string]interface{}{"block_device": "/dev/sdb"}, true},
+		{map[string]interface{}{"volume_id": "/pool/volume", "scsi": true}, false},
+		{map[string]interface{}{"file": "/var/lib/libvirt/images/install.iso"}, false},
+		{map[string]interface{}{"url": "http://example.com/install.iso"}, false},
+		{nil, false},
+	}
+
+	for _, test := range tests {
+		if hotpluggable := diskHotpluggable(test.disk); hotpluggable != test.hotpluggable {
+			t.Errorf("diskHotpluggable(%v) = %t, expected %t", test.disk, hotpluggable, test.hotpluggable)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package libvirt
+
+import (
+	"fmt"
+	"log"
+
+	libvirt "github.com/digitalocean/go-libvirt"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+// migrateDomain moves the domain d from the source host to the target one,
+// keeping its definition. A running domain is migrated live, unless the
+// migration block says otherwise, and a stopped one offline. The migration
+// is driven by the provider over its connections to both hosts, unless it is
+// tunnelled, in which case the source host connects to the target uri.
+func migrateDomain(d *schema.ResourceData, source *Client, target *Client, targetURI string) error {
+	uuid := parseUUID(d.Id())
+
+	if _, err := target.libvirt.DomainLookupByUUID(uuid); err == nil {
+		log.Printf("[INFO] Domain %s is on the target host already, not migrating it", d.Id())
+		return nil
+	}
+
+	domain, err := source.libvirt.DomainLookupByUUID(uuid)
+	if err != nil {
+		return fmt.Errorf("error retrieving libvirt domain to migrate: %w", err)
+	}
+
+	running, err := domainIsRunning(source.libvirt, domain)
+	if err != nil {
+		return err
+	}
+
+	flags := migrationFlags(d, running)
+	params := []libvirt.TypedParam{
+		{Field: libvirt.MigrateParamDestName, Value: *libvirt.NewTypedParamValueString(domain.Name)},
+	}
+	if bandwidth := d.Get("migration.0.bandwidth").(int); bandwidth > 0 {
+		params = append(params, libvirt.TypedParam{
+			Field: libvirt.MigrateParamBandwidth,
+			Value: *libvirt.NewTypedParamValueUllong(uint64(bandwidth)),
+		})
+	}
+
+	log.Printf("[INFO] Migrating domain %s (flags %d)", domain.Name, flags)
+
+	if flags&libvirt.MigratePeer2peer != 0 {
+		_, err := source.libvirt.DomainMigratePerform3Params(domain, libvirt.OptString{targetURI}, params, nil, flags)
+		if err != nil {
+			return fmt.Errorf("error migrating domain %s: %w", domain.Name, err)
+		}
+		return nil
+	}
+	return migrateDomainManaged(source.libvirt, target.libvirt, domain, params, flags)
+}
+
+// migrationFlags returns the flags to migrate the domain d with, running or
+// not.
+func migrationFlags(d *schema.ResourceData, running bool) libvirt.DomainMigrateFlags {
+	flags := libvirt.MigratePersistDest | libvirt.MigrateUndefineSource
+	if !running {
+		return flags | libvirt.MigrateOffline
+	}
+
+	if d.Get("migration.#").(int) == 0 || d.Get("migration.0.live").(bool) {
+		flags |= libvirt.MigrateLive
+	}
+	if d.Get("migration.0.tunnelled").(bool) {
+		flags |= libvirt.MigratePeer2peer | libvirt.MigrateTunnelled
+	}
+	if d.Get("migration.0.copy_storage").(bool) {
+		flags |= libvirt.MigrateNonSharedDisk
+	}
+	return flags
+}
+
+// migrateDomainManaged runs the phases of a version 3 migration of domain
+// from source to target, the way libvirt does for a client that is not
+// peer to peer: the guest memory goes straight from one host to the other.
+func migrateDomainManaged(source, target *libvirt.Libvirt, domain libvirt.Domain, params []libvirt.TypedParam, flags libvirt.DomainMigrateFlags) error {
+	cookie, domainXML, err := source.DomainMigrateBegin3Params(domain, params, uint32(flags))
+	if err != nil {
+		return fmt.Errorf("error beginning the migration of domain %s: %w", domain.Name, err)
+	}
+
+	params = append(params, libvirt.TypedParam{
+		Field: libvirt.MigrateParamDestXML,
+		Value: *libvirt.NewTypedParamValueString(domainXML),
+	})
+	cookie, uriOut, err := target.DomainMigratePrepare3Params(params, cookie, uint32(flags))
+	if err != nil {
+		return fmt.Errorf("error preparing the migration of domain %s: %w", domain.Name, err)
+	}
+	if len(uriOut) > 0 {
+		params = append(params, libvirt.TypedParam{
+			Field: libvirt.MigrateParamURI,
+			Value: *libvirt.NewTypedParamValueString(uriOut[0]),
+		})
+	}
+
+	// offline migrations only define the domain on the target
+	var performErr error
+	if flags&libvirt.MigrateOffline == 0 {
+		cookie, performErr = source.DomainMigratePerform3Params(domain, nil, params, cookie, flags)
+	}
+	cancelled := bool2int(performErr != nil)
+
+	_, cookie, err = target.DomainMigrateFinish3Params(params, cookie, uint32(flags), cancelled)
+	if err != nil && performErr == nil {
+		performErr = err
+		cancelled = 1
+	}
+
+	if err := source.DomainMigrateConfirm3Params(domain, params, cookie, uint32(flags), cancelled); err != nil {
+		log.Printf("[WARN] Error confirming the migration of domain %s: %s", domain.Name, err)
+	}
+
+	if performErr != nil {
+		return fmt.Errorf("error migrating domain %s: %w", domain.Name, performErr)
+	}
+	return nil
+}
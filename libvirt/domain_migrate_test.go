@@ -0,0 +1,54 @@
+package libvirt
+
+import (
+	"testing"
+
+	libvirt "github.com/digitalocean/go-libvirt"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+func TestMigrationFlags(t *testing.T) {
+	const moved = libvirt.MigratePersistDest | libvirt.MigrateUndefineSource
+
+	tests := map[string]struct {
+		migration []interface{}
+		running   bool
+		expected  libvirt.DomainMigrateFlags
+	}{
+		"stopped": {
+			expected: moved | libvirt.MigrateOffline,
+		},
+		"stopped with copy_storage": {
+			migration: []interface{}{map[string]interface{}{"copy_storage": true}},
+			expected:  moved | libvirt.MigrateOffline,
+		},
+		"running": {
+			running:  true,
+			expected: moved | libvirt.MigrateLive,
+		},
+		"running not live": {
+			migration: []interface{}{map[string]interface{}{"live": false}},
+			running:   true,
+			expected:  moved,
+		},
+		"tunnelled with storage": {
+			migration: []interface{}{map[string]interface{}{"tunnelled": true, "copy_storage": true}},
+			running:   true,
+			expected: moved | libvirt.MigrateLive | libvirt.MigratePeer2peer |
+				libvirt.MigrateTunnelled | libvirt.MigrateNonSharedDisk,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			raw := map[string]interface{}{}
+			if test.migration != nil {
+				raw["migration"] = test.migration
+			}
+			d := schema.TestResourceDataRaw(t, resourceLibvirtDomain().Schema, raw)
+			if flags := migrationFlags(d, test.running); flags != test.expected {
+				t.Errorf("expected flags %d, got %d", test.expected, flags)
+			}
+		})
+	}
+}
@@ -0,0 +1,225 @@
+package libvirt
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"libvirt.org/go/libvirtxml"
+)
+
+func TestSetFirmware(t *testing.T) {
+	tests := map[string]struct {
+		raw        map[string]interface{}
+		expected   []string
+		unexpected []string
+	}{
+		"efi": {
+			raw: map[string]interface{}{
+				"firmware": "efi",
+			},
+			expected:   []string{`<os firmware="efi">`},
+			unexpected: []string{`<loader`, `<firmware>`, `<nvram`},
+		},
+		"efi with secure boot": {
+			raw: map[string]interface{}{
+				"firmware":    "efi",
+				"secure_boot": []interface{}{map[string]interface{}{}},
+			},
+			expected: []string{
+				`<os firmware="efi">`,
+				`<feature enabled="yes" name="secure-boot"></feature>`,
+				`<feature enabled="yes" name="enrolled-keys"></feature>`,
+			},
+		},
+		"efi with secure boot without enrolled keys": {
+			raw: map[string]interface{}{
+				"firmware":    "efi",
+				"secure_boot": []interface{}{map[string]interface{}{"enrolled_keys": false}},
+			},
+			expected: []string{`<feature enabled="no" name="enrolled-keys"></feature>`},
+		},
+		"loader with secure boot": {
+			raw: map[string]interface{}{
+				"firmware":    "/usr/share/OVMF/OVMF_CODE.secboot.fd",
+				"secure_boot": []interface{}{map[string]interface{}{}},
+				"nvram": []interface{}{map[string]interface{}{
+					"file":     "/var/lib/libvirt/qemu/nvram/vm_VARS.fd",
+					"template": "/usr/share/OVMF/OVMF_VARS.secboot.fd",
+				}},
+			},
+			expected: []string{
+				`<loader readonly="yes" secure="yes" type="pflash">/usr/share/OVMF/OVMF_CODE.secboot.fd</loader>`,
+				`<nvram template="/usr/share/OVMF/OVMF_VARS.secboot.fd">/var/lib/libvirt/qemu/nvram/vm_VARS.fd</nvram>`,
+				`<smm state="on"></smm>`,
+			},
+			unexpected: []string{`firmware="efi"`},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			test.raw["name"] = "domain"
+			d := schema.TestResourceDataRaw(t, resourceLibvirtDomain().Schema, test.raw)
+
+			domainDef := newDomainDef()
+			if err := setFirmware(d, &domainDef); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			data, err := xmlMarshallIndented(domainDef)
+			if err != nil {
+				t.Fatalf("could not marshall the domain: %s", err)
+			}
+			for _, expected := range test.expected {
+				if !strings.Contains(data, expected) {
+					t.Errorf("expected %s in:\n%s", expected, data)
+				}
+			}
+			for _, unexpected := range test.unexpected {
+				if strings.Contains(data, unexpected) {
+					t.Errorf("unexpected %s in:\n%s", unexpected, data)
+				}
+			}
+
+			_, secureBoot := test.raw["secure_boot"]
+			if read := readSecureBoot(d, domainDef); (len(read) == 1) != secureBoot {
+				t.Errorf("readSecureBoot() = %v, expected secure boot %t", read, secureBoot)
+			}
+		})
+	}
+}
+
+func TestSetFirmwareSecureBootWithoutFirmware(t *testing.T) {
+	d := schema.TestResourceDataRaw(t, resourceLibvirtDomain().Schema, map[string]interface{}{
+		"name":        "domain",
+		"secure_boot": []interface{}{map[string]interface{}{}},
+	})
+
+	domainDef := newDomainDef()
+	if err := setFirmware(d, &domainDef); err == nil {
+		t.Errorf("expected an error for secure_boot without firmware")
+	}
+}
+
+func TestSetTPMs(t *testing.T) {
+	d := schema.TestResourceDataRaw(t, resourceLibvirtDomain().Schema, map[string]interface{}{
+		"name": "domain",
+		"tpm": []interface{}{map[string]interface{}{
+			"model":                    "tpm-crb",
+			"backend_version":          "2.0",
+			"backend_persistent_state": true,
+		}},
+	})
+
+	domainDef := newDomainDef()
+	setTPMs(d, &domainDef)
+	data, err := xmlMarshallIndented(domainDef)
+	if err != nil {
+		t.Fatalf("could not marshall the domain: %s", err)
+	}
+	for _, expected := range []string{
+		`<tpm model="tpm-crb">`,
+		`<backend type="emulator" version="2.0" persistent_state="yes">`,
+	} {
+		if !strings.Contains(data, expected) {
+			t.Errorf("expected %s in:\n%s", expected, data)
+		}
+	}
+}
+
+func TestSetGraphics(t *testing.T) {
+	tests := map[string]struct {
+		graphics   map[string]interface{}
+		expected   []string
+		unexpected []string
+		error      string
+	}{
+		"spice with tls": {
+			graphics: map[string]interface{}{
+				"type":           "spice",
+				"listen_type":    "address",
+				"listen_address": "0.0.0.0",
+				"tls":            true,
+				"password":       "secret",
+			},
+			expected: []string{
+				`<graphics type="spice" autoport="yes" defaultMode="secure" passwd="secret">`,
+				`<listen type="address" address="0.0.0.0"></listen>`,
+			},
+		},
+		"spice with fixed ports": {
+			graphics: map[string]interface{}{"type": "spice", "port": 5910, "tls_port": 5911},
+			expected: []string{`<graphics type="spice" port="5910" tlsPort="5911" autoport="no">`},
+		},
+		"vnc with websocket": {
+			graphics: map[string]interface{}{"type": "vnc", "websocket": -1, "password": "vdi"},
+			expected: []string{`<graphics type="vnc" autoport="yes" websocket="-1" passwd="vdi">`},
+		},
+		"vnc with tls": {
+			graphics: map[string]interface{}{"type": "vnc", "tls": true},
+			error:    "only supported by spice",
+		},
+		"vnc with a long password": {
+			graphics: map[string]interface{}{"type": "vnc", "password": "longer than 8"},
+			error:    "limited to 8 characters",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			d := schema.TestResourceDataRaw(t, resourceLibvirtDomain().Schema, map[string]interface{}{
+				"name":     "domain",
+				"graphics": []interface{}{test.graphics},
+			})
+
+			domainDef := newDomainDef()
+			err := setGraphics(d, &domainDef, "x86_64")
+			if test.error != "" {
+				if err == nil || !strings.Contains(err.Error(), test.error) {
+					t.Errorf("expected an error containing %q, got %v", test.error, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			data, err := xmlMarshallIndented(domainDef)
+			if err != nil {
+				t.Fatalf("could not marshall the domain: %s", err)
+			}
+			for _, expected := range test.expected {
+				if !strings.Contains(data, expected) {
+					t.Errorf("expected %s in:\n%s", expected, data)
+				}
+			}
+		})
+	}
+}
+
+func TestReadGraphics(t *testing.T) {
+	d := schema.TestResourceDataRaw(t, resourceLibvirtDomain().Schema, map[string]interface{}{
+		"name":     "domain",
+		"graphics": []interface{}{map[string]interface{}{"type": "vnc", "websocket": -1, "password": "vdi"}},
+	})
+
+	domainDef := newDomainDef()
+	domainDef.Devices.Graphics = append(domainDef.Devices.Graphics, libvirtxml.DomainGraphic{
+		VNC: &libvirtxml.DomainGraphicVNC{Port: 5903, WebSocket: 5703, AutoPort: "yes"},
+	})
+	graphics := readGraphics(d, domainDef)
+	if len(graphics) != 1 {
+		t.Fatalf("expected one graphics, got %v", graphics)
+	}
+	if graphics[0]["port"] != 5903 || graphics[0]["websocket_port"] != 5703 {
+		t.Errorf("unexpected ports in %v", graphics[0])
+	}
+	if graphics[0]["password"] != "vdi" || graphics[0]["websocket"] != -1 {
+		t.Errorf("the configured attributes were not kept in %v", graphics[0])
+	}
+
+	// libvirt has not assigned ports to a domain that is not running
+	domainDef.Devices.Graphics[1].VNC.Port = -1
+	if graphics := readGraphics(d, domainDef); graphics[0]["port"] != 0 {
+		t.Errorf("expected port 0, got %v", graphics[0]["port"])
+	}
+}
@@ -0,0 +1,394 @@
+package libvirt
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	libvirt "github.com/digitalocean/go-libvirt"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"libvirt.org/go/libvirtxml"
+)
+
+// numatuneModes are the ways the memory of a domain can be bound to the host
+// NUMA nodes of numatune.
+var numatuneModes = []string{"strict", "preferred", "interleave", "restrictive"}
+
+// tuningKeys are the attributes of a domain with CPU and memory tuning.
+var tuningKeys = []string{"cputune", "numatune", "numa_cell", "memory_backing"}
+
+// parseCPUSet parses a libvirt cpuset, like 0-3,^2,8, into the CPU or node
+// numbers it is made of.
+func parseCPUSet(cpuset string) (map[uint]bool, error) {
+	ids := map[uint]bool{}
+	var excluded []uint
+	for _, part := range strings.Split(cpuset, ",") {
+		part = strings.TrimSpace(part)
+		exclude := strings.HasPrefix(part, "^")
+		part = strings.TrimPrefix(part, "^")
+
+		first, last, isRange := strings.Cut(part, "-")
+		if exclude && isRange {
+			return nil, fmt.Errorf("invalid cpuset '%s': only single numbers can be excluded", cpuset)
+		}
+		start, err := strconv.ParseUint(first, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cpuset '%s'", cpuset)
+		}
+		end := start
+		if isRange {
+			end, err = strconv.ParseUint(last, 10, 32)
+			if err != nil || end < start {
+				return nil, fmt.Errorf("invalid cpuset '%s'", cpuset)
+			}
+		}
+
+		for id := uint(start); id <= uint(end); id++ {
+			if exclude {
+				excluded = append(excluded, id)
+			} else {
+				ids[id] = true
+			}
+		}
+	}
+	for _, id := range excluded {
+		delete(ids, id)
+	}
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("invalid cpuset '%s': it is empty", cpuset)
+	}
+	return ids, nil
+}
+
+// validateDomainTuning checks the cputune, numatune, numa_cell and
+// memory_backing settings of the domain get, a schema.ResourceData or
+// schema.ResourceDiff Get, against each other and against the capabilities
+// of the host, when it is known.
+func validateDomainTuning(get func(key string) interface{}, caps *libvirtxml.Caps) error {
+	vcpus := uint(get("vcpu").(int))
+
+	var hostCPUs, hostNodes map[uint]bool
+	hostPages := map[uint]uint64{}
+	if caps != nil && caps.Host.NUMA != nil && caps.Host.NUMA.Cells != nil {
+		hostCPUs, hostNodes = map[uint]bool{}, map[uint]bool{}
+		for _, cell := range caps.Host.NUMA.Cells.Cells {
+			hostNodes[uint(cell.ID)] = true
+			if cell.CPUS != nil {
+				for _, cpu := range cell.CPUS.CPUs {
+					hostCPUs[uint(cpu.ID)] = true
+				}
+			}
+			for _, pages := range cell.PageInfo {
+				hostPages[uint(pages.Size)] += pages.Count
+			}
+		}
+	}
+
+	checkHostCPUs := func(what string, cpuset string) error {
+		ids, err := parseCPUSet(cpuset)
+		if err != nil {
+			return fmt.Errorf("%s: %w", what, err)
+		}
+		for id := range ids {
+			if hostCPUs != nil && !hostCPUs[id] {
+				return fmt.Errorf("%s: the host has no CPU %d", what, id)
+			}
+		}
+		return nil
+	}
+
+	for i, pin := range get("cputune.0.vcpupin").([]interface{}) {
+		pin := pin.(map[string]interface{})
+		if vcpu := uint(pin["vcpu"].(int)); vcpu >= vcpus {
+			return fmt.Errorf("cputune vcpupin %d: the domain has no vcpu %d, it has %d", i, vcpu, vcpus)
+		}
+		if err := checkHostCPUs(fmt.Sprintf("cputune vcpupin %d", i), pin["cpuset"].(string)); err != nil {
+			return err
+		}
+	}
+	if emulatorPin := get("cputune.0.emulatorpin").(string); emulatorPin != "" {
+		if err := checkHostCPUs("cputune emulatorpin", emulatorPin); err != nil {
+			return err
+		}
+	}
+
+	if get("numatune.#").(int) > 0 {
+		mode := get("numatune.0.mode").(string)
+		validMode := false
+		for _, m := range numatuneModes {
+			validMode = validMode || m == mode
+		}
+		if !validMode {
+			return fmt.Errorf("numatune has an unsupported mode \"%s\", supported modes are: %s", mode, strings.Join(numatuneModes, ", "))
+		}
+	}
+	if nodeset := get("numatune.0.nodeset").(string); nodeset != "" {
+		ids, err := parseCPUSet(nodeset)
+		if err != nil {
+			return fmt.Errorf("numatune: %w", err)
+		}
+		for id := range ids {
+			if hostNodes != nil && !hostNodes[id] {
+				return fmt.Errorf("numatune: the host has no NUMA node %d", id)
+			}
+		}
+	}
+
+	// libvirt sizes the memory of a domain with NUMA cells after them
+	domainMemory := get("memory").(int)
+	cellsMemory := 0
+	guestNodes := map[uint]bool{}
+	guestCPUs := map[uint]bool{}
+	for i, cell := range get("numa_cell").([]interface{}) {
+		cell := cell.(map[string]interface{})
+		cellsMemory += cell["memory"].(int)
+		id := uint(cell["id"].(int))
+		if guestNodes[id] {
+			return fmt.Errorf("numa_cell %d: there is another cell with id %d", i, id)
+		}
+		guestNodes[id] = true
+
+		ids, err := parseCPUSet(cell["cpus"].(string))
+		if err != nil {
+			return fmt.Errorf("numa_cell %d: %w", i, err)
+		}
+		for cpu := range ids {
+			if cpu >= vcpus {
+				return fmt.Errorf("numa_cell %d: the domain has no vcpu %d, it has %d", i, cpu, vcpus)
+			}
+			if guestCPUs[cpu] {
+				return fmt.Errorf("numa_cell %d: vcpu %d is in another cell", i, cpu)
+			}
+			guestCPUs[cpu] = true
+		}
+	}
+	if len(guestNodes) > 0 && cellsMemory < domainMemory {
+		return fmt.Errorf("the memory of the numa_cell entries adds up to %d MiB, less than the %d MiB of the domain", cellsMemory, domainMemory)
+	}
+
+	memory := uint64(domainMemory) * 1024
+	for i, page := range get("memory_backing.0.hugepages").([]interface{}) {
+		page := page.(map[string]interface{})
+		size := uint(page["size"].(int))
+		if caps != nil && len(caps.Host.CPU.PageSizes) > 0 {
+			supported := false
+			for _, pageSize := range caps.Host.CPU.PageSizes {
+				supported = supported || uint(pageSize.Size) == size
+			}
+			if !supported {
+				return fmt.Errorf("memory_backing hugepages %d: the host does not support pages of %d KiB", i, size)
+			}
+		}
+		if count, ok := hostPages[size]; ok && count*uint64(size) < memory {
+			return fmt.Errorf("memory_backing hugepages %d: the host has %d pages of %d KiB, not enough for %d MiB of memory",
+				i, count, size, memory/1024)
+		}
+
+		if nodeset := page["nodeset"].(string); nodeset != "" {
+			ids, err := parseCPUSet(nodeset)
+			if err != nil {
+				return fmt.Errorf("memory_backing hugepages %d: %w", i, err)
+			}
+			for id := range ids {
+				if !guestNodes[id] {
+					return fmt.Errorf("memory_backing hugepages %d: the domain has no numa_cell with id %d", i, id)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// customizeDiffDomainTuning validates the tuning settings of the domain at
+// plan time, against the capabilities of the host it is going to be on.
+func customizeDiffDomainTuning(ctx context.Context, d *schema.ResourceDiff, meta interface{}) error {
+	for _, key := range append([]string{"vcpu", "memory"}, tuningKeys...) {
+		if !d.NewValueKnown(key) {
+			log.Printf("[DEBUG] %s is not known yet, not validating the domain tuning", key)
+			return nil
+		}
+	}
+
+	var caps *libvirtxml.Caps
+	if virConn := tuningHostConn(d, meta); virConn != nil {
+		hostCaps, err := getHostCapabilities(virConn)
+		if err != nil {
+			return fmt.Errorf("error retrieving the host capabilities: %w", err)
+		}
+		caps = &hostCaps
+	}
+	return validateDomainTuning(d.Get, caps)
+}
+
+// tuningHostConn returns the connection to the host of the domain d, or nil
+// when it is not known yet, as before a domain is placed, or when the domain
+// has no tuning settings to validate.
+func tuningHostConn(d *schema.ResourceDiff, meta interface{}) *libvirt.Libvirt {
+	tuned := false
+	for _, key := range tuningKeys {
+		tuned = tuned || d.Get(key+".#").(int) > 0
+	}
+	client, ok := meta.(*Client)
+	if !tuned || !ok {
+		return nil
+	}
+	if client.hosts == nil {
+		return client.libvirt
+	}
+
+	name, ok := d.Get("host").(string)
+	if !ok || name == "" || !d.NewValueKnown("host") {
+		log.Printf("[DEBUG] The host of the domain is not known yet, not validating its tuning against it")
+		return nil
+	}
+	hostClient, err := client.hosts.client(name)
+	if err != nil {
+		log.Printf("[WARN] Not validating the domain tuning against host %s: %v", name, err)
+		return nil
+	}
+	return hostClient.libvirt
+}
+
+// setTuning sets the CPU and memory tuning of the domain definition.
+func setTuning(d *schema.ResourceData, domainDef *libvirtxml.Domain) {
+	if d.Get("cputune.#").(int) > 0 {
+		domainDef.CPUTune = &libvirtxml.DomainCPUTune{}
+		for _, pin := range d.Get("cputune.0.vcpupin").([]interface{}) {
+			pin := pin.(map[string]interface{})
+			domainDef.CPUTune.VCPUPin = append(domainDef.CPUTune.VCPUPin, libvirtxml.DomainCPUTuneVCPUPin{
+				VCPU:   uint(pin["vcpu"].(int)),
+				CPUSet: pin["cpuset"].(string),
+			})
+		}
+		if emulatorPin := d.Get("cputune.0.emulatorpin").(string); emulatorPin != "" {
+			domainDef.CPUTune.EmulatorPin = &libvirtxml.DomainCPUTuneEmulatorPin{CPUSet: emulatorPin}
+		}
+	}
+
+	if d.Get("numatune.#").(int) > 0 {
+		domainDef.NUMATune = &libvirtxml.DomainNUMATune{
+			Memory: &libvirtxml.DomainNUMATuneMemory{
+				Mode:    d.Get("numatune.0.mode").(string),
+				Nodeset: d.Get("numatune.0.nodeset").(string),
+			},
+		}
+	}
+
+	if cells := d.Get("numa_cell").([]interface{}); len(cells) > 0 {
+		if domainDef.CPU == nil {
+			domainDef.CPU = &libvirtxml.DomainCPU{}
+		}
+		domainDef.CPU.Numa = &libvirtxml.DomainNuma{}
+		for _, cell := range cells {
+			cell := cell.(map[string]interface{})
+			id := uint(cell["id"].(int))
+			domainDef.CPU.Numa.Cell = append(domainDef.CPU.Numa.Cell, libvirtxml.DomainCell{
+				ID:     &id,
+				CPUs:   cell["cpus"].(string),
+				Memory: uint(cell["memory"].(int)),
+				Unit:   "MiB",
+			})
+		}
+	}
+
+	if d.Get("memory_backing.#").(int) > 0 {
+		domainDef.MemoryBacking = &libvirtxml.DomainMemoryBacking{}
+		if pages := d.Get("memory_backing.0.hugepages").([]interface{}); len(pages) > 0 {
+			domainDef.MemoryBacking.MemoryHugePages = &libvirtxml.DomainMemoryHugepages{}
+			for _, page := range pages {
+				page := page.(map[string]interface{})
+				domainDef.MemoryBacking.MemoryHugePages.Hugepages = append(domainDef.MemoryBacking.MemoryHugePages.Hugepages,
+					libvirtxml.DomainMemoryHugepage{
+						Size:    uint(page["size"].(int)),
+						Unit:    "KiB",
+						Nodeset: page["nodeset"].(string),
+					})
+			}
+		}
+		if d.Get("memory_backing.0.locked").(bool) {
+			domainDef.MemoryBacking.MemoryLocked = &libvirtxml.DomainMemoryLocked{}
+		}
+	}
+}
+
+// readTuning sets the CPU and memory tuning of the domain from its
+// definition.
+func readTuning(d *schema.ResourceData, domainDef libvirtxml.Domain) {
+	var cputune []map[string]interface{}
+	if tune := domainDef.CPUTune; tune != nil && (len(tune.VCPUPin) > 0 || tune.EmulatorPin != nil) {
+		var pins []map[string]interface{}
+		for _, pin := range tune.VCPUPin {
+			pins = append(pins, map[string]interface{}{
+				"vcpu":   int(pin.VCPU),
+				"cpuset": pin.CPUSet,
+			})
+		}
+		cputune = append(cputune, map[string]interface{}{"vcpupin": pins})
+		if tune.EmulatorPin != nil {
+			cputune[0]["emulatorpin"] = tune.EmulatorPin.CPUSet
+		}
+	}
+	d.Set("cputune", cputune)
+
+	var numatune []map[string]interface{}
+	if tune := domainDef.NUMATune; tune != nil && tune.Memory != nil && tune.Memory.Nodeset != "" {
+		numatune = append(numatune, map[string]interface{}{
+			"mode":    tune.Memory.Mode,
+			"nodeset": tune.Memory.Nodeset,
+		})
+	}
+	d.Set("numatune", numatune)
+
+	var cells []map[string]interface{}
+	if domainDef.CPU != nil && domainDef.CPU.Numa != nil {
+		for _, cell := range domainDef.CPU.Numa.Cell {
+			var id uint
+			if cell.ID != nil {
+				id = *cell.ID
+			}
+			cells = append(cells, map[string]interface{}{
+				"id":     int(id),
+				"cpus":   cell.CPUs,
+				"memory": memoryKiB(cell.Memory, cell.Unit) / 1024,
+			})
+		}
+	}
+	d.Set("numa_cell", cells)
+
+	var memoryBacking []map[string]interface{}
+	if backing := domainDef.MemoryBacking; backing != nil && (backing.MemoryHugePages != nil || backing.MemoryLocked != nil) {
+		var pages []map[string]interface{}
+		if backing.MemoryHugePages != nil {
+			for _, page := range backing.MemoryHugePages.Hugepages {
+				pages = append(pages, map[string]interface{}{
+					"size":    memoryKiB(page.Size, page.Unit),
+					"nodeset": page.Nodeset,
+				})
+			}
+		}
+		memoryBacking = append(memoryBacking, map[string]interface{}{
+			"hugepages": pages,
+			"locked":    backing.MemoryLocked != nil,
+		})
+	}
+	d.Set("memory_backing", memoryBacking)
+}
+
+// memoryKiB converts an amount of memory in unit, as libvirt writes it, to
+// KiB, rounding down.
+func memoryKiB(value uint, unit string) int {
+	switch strings.ToLower(unit) {
+	case "b", "bytes":
+		return int(value / 1024)
+	case "m", "mib":
+		return int(value * 1024)
+	case "g", "gib":
+		return int(value * 1024 * 1024)
+	case "t", "tib":
+		return int(value * 1024 * 1024 * 1024)
+	}
+	return int(value)
+}
@@ -0,0 +1,211 @@
+package libvirt
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"libvirt.org/go/libvirtxml"
+)
+
+func TestParseCPUSet(t *testing.T) {
+	tests := map[string][]uint{
+		"3":         {3},
+		"0-3":       {0, 1, 2, 3},
+		"0-3,^2,8":  {0, 1, 3, 8},
+		" 4-5 , 7 ": {4, 5, 7},
+	}
+	for cpuset, expected := range tests {
+		ids, err := parseCPUSet(cpuset)
+		if err != nil {
+			t.Errorf("unexpected error parsing %s: %s", cpuset, err)
+			continue
+		}
+		if len(ids) != len(expected) {
+			t.Errorf("parseCPUSet(%s) = %v, expected %v", cpuset, ids, expected)
+		}
+		for _, id := range expected {
+			if !ids[id] {
+				t.Errorf("parseCPUSet(%s) = %v, expected %v", cpuset, ids, expected)
+			}
+		}
+	}
+
+	for _, cpuset := range []string{"", "a", "3-1", "0-3,^0-3", "^1-2"} {
+		if _, err := parseCPUSet(cpuset); err == nil {
+			t.Errorf("expected an error parsing '%s'", cpuset)
+		}
+	}
+}
+
+func testTuningCaps() *libvirtxml.Caps {
+	cell := func(id int, cpus ...int) libvirtxml.CapsHostNUMACell {
+		cell := libvirtxml.CapsHostNUMACell{
+			ID:       id,
+			CPUS:     &libvirtxml.CapsHostNUMACPUs{},
+			PageInfo: []libvirtxml.CapsHostNUMAPageInfo{{Size: 2048, Unit: "KiB", Count: 1024}},
+		}
+		for _, cpu := range cpus {
+			cell.CPUS.CPUs = append(cell.CPUS.CPUs, libvirtxml.CapsHostNUMACPU{ID: cpu})
+		}
+		return cell
+	}
+	caps := &libvirtxml.Caps{}
+	caps.Host.CPU = &libvirtxml.CapsHostCPU{
+		PageSizes: []libvirtxml.CapsHostCPUPageSize{{Size: 4, Unit: "KiB"}, {Size: 2048, Unit: "KiB"}},
+	}
+	caps.Host.NUMA = &libvirtxml.CapsHostNUMATopology{
+		Cells: &libvirtxml.CapsHostNUMACells{Cells: []libvirtxml.CapsHostNUMACell{cell(0, 0, 1, 2, 3), cell(1, 4, 5, 6, 7)}},
+	}
+	return caps
+}
+
+func TestValidateDomainTuning(t *testing.T) {
+	valid := map[string]interface{}{
+		"vcpu":   4,
+		"memory": 2048,
+		"cputune": []interface{}{map[string]interface{}{
+			"vcpupin": []interface{}{
+				map[string]interface{}{"vcpu": 0, "cpuset": "2"},
+				map[string]interface{}{"vcpu": 1, "cpuset": "3"},
+			},
+			"emulatorpin": "0-1",
+		}},
+		"numatune": []interface{}{map[string]interface{}{"nodeset": "0"}},
+		"numa_cell": []interface{}{
+			map[string]interface{}{"id": 0, "cpus": "0-1", "memory": 1024},
+			map[string]interface{}{"id": 1, "cpus": "2-3", "memory": 1024},
+		},
+		"memory_backing": []interface{}{map[string]interface{}{
+			"hugepages": []interface{}{map[string]interface{}{"size": 2048, "nodeset": "0-1"}},
+			"locked":    true,
+		}},
+	}
+
+	tests := map[string]struct {
+		change map[string]interface{}
+		error  string
+	}{
+		"valid": {},
+		"pin of a missing vcpu": {
+			change: map[string]interface{}{"vcpu": 1},
+			error:  "the domain has no vcpu 1",
+		},
+		"pin to a missing host CPU": {
+			change: map[string]interface{}{"cputune": []interface{}{map[string]interface{}{"emulatorpin": "8"}}},
+			error:  "cputune emulatorpin: the host has no CPU 8",
+		},
+		"missing host node": {
+			change: map[string]interface{}{"numatune": []interface{}{map[string]interface{}{"nodeset": "0,2"}}},
+			error:  "numatune: the host has no NUMA node 2",
+		},
+		"unsupported numatune mode": {
+			change: map[string]interface{}{"numatune": []interface{}{map[string]interface{}{"mode": "loose", "nodeset": "0"}}},
+			error:  `unsupported mode "loose"`,
+		},
+		"vcpu in two cells": {
+			change: map[string]interface{}{"numa_cell": []interface{}{
+				map[string]interface{}{"id": 0, "cpus": "0-2", "memory": 1024},
+				map[string]interface{}{"id": 1, "cpus": "2-3", "memory": 1024},
+			}},
+			error: "numa_cell 1: vcpu 2 is in another cell",
+		},
+		"cells without enough memory": {
+			change: map[string]interface{}{"memory": 4096},
+			error:  "adds up to 2048 MiB, less than the 4096 MiB",
+		},
+		"unsupported page size": {
+			change: map[string]interface{}{"memory_backing": []interface{}{map[string]interface{}{
+				"hugepages": []interface{}{map[string]interface{}{"size": 1048576}},
+			}}},
+			error: "the host does not support pages of 1048576 KiB",
+		},
+		"pages on a missing guest node": {
+			change: map[string]interface{}{"memory_backing": []interface{}{map[string]interface{}{
+				"hugepages": []interface{}{map[string]interface{}{"size": 2048, "nodeset": "2"}},
+			}}},
+			error: "the domain has no numa_cell with id 2",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			raw := map[string]interface{}{}
+			for k, v := range valid {
+				raw[k] = v
+			}
+			for k, v := range test.change {
+				raw[k] = v
+			}
+			d := schema.TestResourceDataRaw(t, resourceLibvirtDomain().Schema, raw)
+			err := validateDomainTuning(d.Get, testTuningCaps())
+			if test.error == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %s", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.error) {
+				t.Errorf("expected an error containing %q, got %v", test.error, err)
+			}
+		})
+	}
+
+	// the hugepages reserved on the host are not enough for 6 GiB
+	d := schema.TestResourceDataRaw(t, resourceLibvirtDomain().Schema, map[string]interface{}{
+		"memory": 6144,
+		"memory_backing": []interface{}{map[string]interface{}{
+			"hugepages": []interface{}{map[string]interface{}{"size": 2048}},
+		}},
+	})
+	if err := validateDomainTuning(d.Get, testTuningCaps()); err == nil || !strings.Contains(err.Error(), "has 2048 pages of 2048 KiB") {
+		t.Errorf("expected an error about the host hugepages, got %v", err)
+	}
+	// without the host capabilities, only the domain is validated
+	if err := validateDomainTuning(d.Get, nil); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+}
+
+func TestSetReadTuning(t *testing.T) {
+	r := resourceLibvirtDomain()
+	d := schema.TestResourceDataRaw(t, r.Schema, map[string]interface{}{
+		"vcpu":     2,
+		"cputune":  []interface{}{map[string]interface{}{"vcpupin": []interface{}{map[string]interface{}{"vcpu": 1, "cpuset": "4-5"}}}},
+		"numatune": []interface{}{map[string]interface{}{"mode": "interleave", "nodeset": "0-1"}},
+		"numa_cell": []interface{}{
+			map[string]interface{}{"id": 0, "cpus": "0", "memory": 256},
+			map[string]interface{}{"id": 1, "cpus": "1", "memory": 256},
+		},
+		"memory_backing": []interface{}{map[string]interface{}{
+			"hugepages": []interface{}{map[string]interface{}{"size": 2048, "nodeset": "1"}},
+		}},
+	})
+
+	domainDef := newDomainDef()
+	setTuning(d, &domainDef)
+
+	if pins := domainDef.CPUTune.VCPUPin; len(pins) != 1 || pins[0].VCPU != 1 || pins[0].CPUSet != "4-5" {
+		t.Errorf("unexpected vcpupin %+v", pins)
+	}
+	if memory := domainDef.NUMATune.Memory; memory.Mode != "interleave" || memory.Nodeset != "0-1" {
+		t.Errorf("unexpected numatune %+v", memory)
+	}
+	if cells := domainDef.CPU.Numa.Cell; len(cells) != 2 || *cells[1].ID != 1 || cells[1].Memory != 256 || cells[1].Unit != "MiB" {
+		t.Errorf("unexpected numa cells %+v", cells)
+	}
+	if domainDef.MemoryBacking.MemoryLocked != nil {
+		t.Errorf("memory locked without locked")
+	}
+
+	// libvirt writes the memory in KiB
+	domainDef.CPU.Numa.Cell[0].Memory, domainDef.CPU.Numa.Cell[0].Unit = 262144, "KiB"
+	read := r.Data(nil)
+	readTuning(read, domainDef)
+	for _, key := range []string{"cputune", "numatune", "numa_cell", "memory_backing"} {
+		if got, expected := read.Get(key), d.Get(key); !reflect.DeepEqual(got, expected) {
+			t.Errorf("read %s %v, expected %v", key, got, expected)
+		}
+	}
+}
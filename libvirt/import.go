@@ -0,0 +1,332 @@
+package libvirt
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	libvirt "github.com/digitalocean/go-libvirt"
+	"github.com/google/uuid"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"libvirt.org/go/libvirtxml"
+)
+
+// The importers below set the attributes the Read of their resource keeps
+// as configured, from the live definition, so that an imported resource
+// gets a complete state. Read runs after them, and sets the others.
+
+// cloudInitImportID returns the ID of the libvirt_cloudinit_disk of the
+// volume key when it is imported. It is the same every time, so that the
+// cloudinit of an imported domain matches the imported disk.
+func cloudInitImportID(volumeKey string) string {
+	return fmt.Sprintf("%s;%s", volumeKey, uuid.NewSHA1(uuid.NameSpaceURL, []byte(volumeKey)))
+}
+
+// lookupVolumeForImport returns the volume id is the key or the path of.
+func lookupVolumeForImport(virConn *libvirt.Libvirt, id string) (libvirt.StorageVol, error) {
+	volume, err := virConn.StorageVolLookupByKey(id)
+	if err == nil {
+		return volume, nil
+	}
+	if byPath, pathErr := virConn.StorageVolLookupByPath(id); pathErr == nil {
+		return byPath, nil
+	}
+	return volume, fmt.Errorf("error retrieving volume %s: %w", id, err)
+}
+
+func resourceLibvirtCloudInitDiskImport(ctx context.Context, d *schema.ResourceData, meta interface{}) ([]*schema.ResourceData, error) {
+	virConn := meta.(*Client).libvirt
+	if virConn == nil {
+		return nil, fmt.Errorf(LibVirtConIsNil)
+	}
+
+	if _, err := getCloudInitVolumeKeyFromTerraformID(d.Id()); err == nil {
+		return []*schema.ResourceData{d}, nil
+	}
+	volume, err := lookupVolumeForImport(virConn, d.Id())
+	if err != nil {
+		return nil, err
+	}
+	d.SetId(cloudInitImportID(volume.Key))
+	return []*schema.ResourceData{d}, nil
+}
+
+func resourceLibvirtVolumeImport(ctx context.Context, d *schema.ResourceData, meta interface{}) ([]*schema.ResourceData, error) {
+	virConn := meta.(*Client).libvirt
+	if virConn == nil {
+		return nil, fmt.Errorf(LibVirtConIsNil)
+	}
+
+	volume, err := lookupVolumeForImport(virConn, d.Id())
+	if err != nil {
+		return nil, err
+	}
+	d.SetId(volume.Key)
+
+	volumeDef, err := newDefVolumeFromLibvirt(virConn, volume)
+	if err != nil {
+		return nil, err
+	}
+	if volumeDef.BackingStore != nil && volumeDef.BackingStore.Path != "" {
+		baseVolume, err := virConn.StorageVolLookupByPath(volumeDef.BackingStore.Path)
+		if err != nil {
+			// the backing file of the volume is not in a pool
+			log.Printf("[WARN] Backing store %s of volume %s is not a volume: %s", volumeDef.BackingStore.Path, volume.Name, err)
+		} else {
+			d.Set("base_volume_id", baseVolume.Key)
+		}
+	}
+	return []*schema.ResourceData{d}, nil
+}
+
+func resourceLibvirtPoolImport(ctx context.Context, d *schema.ResourceData, meta interface{}) ([]*schema.ResourceData, error) {
+	virConn := meta.(*Client).libvirt
+	if virConn == nil {
+		return nil, fmt.Errorf(LibVirtConIsNil)
+	}
+
+	if _, err := uuid.Parse(d.Id()); err != nil {
+		pool, err := virConn.StoragePoolLookupByName(d.Id())
+		if err != nil {
+			return nil, fmt.Errorf("error retrieving pool %s: %w", d.Id(), err)
+		}
+		d.SetId(uuidString(pool.UUID))
+	}
+	return []*schema.ResourceData{d}, nil
+}
+
+func resourceLibvirtNetworkImport(ctx context.Context, d *schema.ResourceData, meta interface{}) ([]*schema.ResourceData, error) {
+	virConn := meta.(*Client).libvirt
+	if virConn == nil {
+		return nil, fmt.Errorf(LibVirtConIsNil)
+	}
+
+	var network libvirt.Network
+	var err error
+	if id, parseErr := uuid.Parse(d.Id()); parseErr == nil {
+		network, err = virConn.NetworkLookupByUUID(libvirt.UUID(id))
+	} else {
+		network, err = virConn.NetworkLookupByName(d.Id())
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving network %s: %w", d.Id(), err)
+	}
+	d.SetId(uuidString(network.UUID))
+
+	networkDef, err := getXMLNetworkDefFromLibvirt(virConn, network)
+	if err != nil {
+		return nil, err
+	}
+
+	// Read only refreshes the DHCP hosts of the configuration, all of them
+	// are taken here
+	var hosts []interface{}
+	for _, ip := range networkDef.IPs {
+		if ip.DHCP == nil {
+			continue
+		}
+		for _, host := range ip.DHCP.Hosts {
+			if host.MAC == "" {
+				continue
+			}
+			hosts = append(hosts, map[string]interface{}{
+				"mac":      host.MAC,
+				"ip":       host.IP,
+				"hostname": host.Name,
+			})
+		}
+	}
+	d.Set("dhcp_host", hosts)
+
+	if networkDef.DnsmasqOptions != nil && len(networkDef.DnsmasqOptions.Option) > 0 {
+		var options []map[string]interface{}
+		for _, option := range networkDef.DnsmasqOptions.Option {
+			name, value, _ := strings.Cut(option.Value, "=")
+			options = append(options, map[string]interface{}{
+				"option_name":  name,
+				"option_value": value,
+			})
+		}
+		d.Set("dnsmasq_options", []map[string]interface{}{{"options": options}})
+	}
+
+	return []*schema.ResourceData{d}, nil
+}
+
+func resourceLibvirtDomainImport(ctx context.Context, d *schema.ResourceData, meta interface{}) ([]*schema.ResourceData, error) {
+	virConn := meta.(*Client).libvirt
+	if virConn == nil {
+		return nil, fmt.Errorf(LibVirtConIsNil)
+	}
+
+	var domain libvirt.Domain
+	var err error
+	if id, parseErr := uuid.Parse(d.Id()); parseErr == nil {
+		domain, err = virConn.DomainLookupByUUID(libvirt.UUID(id))
+	} else {
+		domain, err = virConn.DomainLookupByName(d.Id())
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving domain %s: %w", d.Id(), err)
+	}
+	d.SetId(uuidString(domain.UUID))
+
+	xmlDesc, err := virConn.DomainGetXMLDesc(domain, 0)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving libvirt domain XML description: %w", err)
+	}
+	// not over newDomainDef, which has devices of its own
+	var domainDef libvirtxml.Domain
+	if err := xml.Unmarshal([]byte(xmlDesc), &domainDef); err != nil {
+		return nil, fmt.Errorf("error reading libvirt domain XML description: %w", err)
+	}
+
+	if err := readImportedDomain(virConn, d, domainDef); err != nil {
+		return nil, err
+	}
+	return []*schema.ResourceData{d}, nil
+}
+
+// readImportedDomain sets the attributes of the domain d that Read keeps as
+// configured from its definition.
+func readImportedDomain(virConn *libvirt.Libvirt, d *schema.ResourceData, domainDef libvirtxml.Domain) error {
+	d.Set("type", domainDef.Type)
+
+	if domainDef.Devices == nil {
+		return nil
+	}
+
+	if len(domainDef.Devices.Videos) > 0 && domainDef.Devices.Videos[0].Model.Type != "" {
+		d.Set("video", []map[string]interface{}{{"type": domainDef.Devices.Videos[0].Model.Type}})
+	}
+
+	if domainDef.OS != nil && len(domainDef.OS.BootDevices) > 0 {
+		var devs []string
+		for _, dev := range domainDef.OS.BootDevices {
+			devs = append(devs, dev.Dev)
+		}
+		d.Set("boot_device", []map[string]interface{}{{"dev": devs}})
+	}
+
+	d.Set("console", readImportedConsoles(domainDef))
+	d.Set("graphics", readImportedGraphics(domainDef))
+	d.Set("tpm", readImportedTPMs(domainDef))
+
+	for _, diskDef := range domainDef.Devices.Disks {
+		if diskDef.Serial != "cloudinit" || diskDef.Source == nil || diskDef.Source.File == nil {
+			continue
+		}
+		volume, err := virConn.StorageVolLookupByPath(diskDef.Source.File.File)
+		if err != nil {
+			return fmt.Errorf("error retrieving the cloudinit volume %s: %w", diskDef.Source.File.File, err)
+		}
+		d.Set("cloudinit", cloudInitImportID(volume.Key))
+	}
+
+	return nil
+}
+
+func readImportedConsoles(domainDef libvirtxml.Domain) []map[string]interface{} {
+	var consoles []map[string]interface{}
+	for _, consoleDef := range domainDef.Devices.Consoles {
+		console := map[string]interface{}{}
+		if target := consoleDef.Target; target != nil {
+			if target.Port != nil {
+				console["target_port"] = strconv.Itoa(int(*target.Port))
+			}
+			console["target_type"] = target.Type
+		}
+		switch source := consoleDef.Source; {
+		case source == nil:
+			console["type"] = "pty"
+		case source.TCP != nil:
+			console["type"] = "tcp"
+			console["source_host"] = source.TCP.Host
+			console["source_service"] = source.TCP.Service
+		case source.Pty != nil:
+			console["type"] = "pty"
+			console["source_path"] = source.Pty.Path
+		case source.Dev != nil:
+			console["type"] = "dev"
+			console["source_path"] = source.Dev.Path
+		default:
+			continue
+		}
+		consoles = append(consoles, console)
+	}
+	return consoles
+}
+
+func readImportedGraphics(domainDef libvirtxml.Domain) []map[string]interface{} {
+	if len(domainDef.Devices.Graphics) == 0 {
+		return nil
+	}
+
+	graphics := map[string]interface{}{"listen_type": "none"}
+	var listeners []libvirtxml.DomainGraphicListener
+	switch def := domainDef.Devices.Graphics[0]; {
+	case def.Spice != nil:
+		graphics["type"] = "spice"
+		graphics["autoport"] = def.Spice.AutoPort != "no"
+		graphics["tls"] = def.Spice.DefaultMode == "secure"
+		if def.Spice.AutoPort == "no" {
+			graphics["port"] = def.Spice.Port
+			graphics["tls_port"] = def.Spice.TLSPort
+		}
+		listeners = def.Spice.Listeners
+	case def.VNC != nil:
+		graphics["type"] = "vnc"
+		graphics["autoport"] = def.VNC.AutoPort != "no"
+		if def.VNC.AutoPort == "no" {
+			graphics["port"] = def.VNC.Port
+		}
+		if def.VNC.WebSocket != 0 {
+			graphics["websocket"] = def.VNC.WebSocket
+		}
+		listeners = def.VNC.Listeners
+	default:
+		return nil
+	}
+
+	if len(listeners) > 0 {
+		switch listener := listeners[0]; {
+		case listener.Address != nil:
+			graphics["listen_type"] = "address"
+			graphics["listen_address"] = listener.Address.Address
+		case listener.Network != nil:
+			graphics["listen_type"] = "network"
+		case listener.Socket != nil:
+			graphics["listen_type"] = "socket"
+		}
+	}
+	return []map[string]interface{}{graphics}
+}
+
+func readImportedTPMs(domainDef libvirtxml.Domain) []map[string]interface{} {
+	if len(domainDef.Devices.TPMs) == 0 {
+		return nil
+	}
+
+	tpmDef := domainDef.Devices.TPMs[0]
+	tpm := map[string]interface{}{"model": tpmDef.Model}
+	if backend := tpmDef.Backend; backend != nil {
+		switch {
+		case backend.Passthrough != nil:
+			tpm["backend_type"] = "passthrough"
+			if backend.Passthrough.Device != nil {
+				tpm["backend_device_path"] = backend.Passthrough.Device.Path
+			}
+		case backend.Emulator != nil:
+			tpm["backend_type"] = "emulator"
+			tpm["backend_version"] = backend.Emulator.Version
+			tpm["backend_persistent_state"] = backend.Emulator.PersistentState == "yes"
+			if backend.Emulator.Encryption != nil {
+				tpm["backend_encryption_secret"] = backend.Emulator.Encryption.Secret
+			}
+		}
+	}
+	return []map[string]interface{}{tpm}
+}
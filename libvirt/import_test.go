@@ -0,0 +1,109 @@
+package libvirt
+
+import (
+	"encoding/xml"
+	"strings"
+	"testing"
+
+	"libvirt.org/go/libvirtxml"
+)
+
+const testImportedDomainXML = `<domain type="kvm">
+  <name>brownfield</name>
+  <os>
+    <type arch="x86_64" machine="pc-q35-8.2">hvm</type>
+    <boot dev="network"/>
+    <boot dev="hd"/>
+  </os>
+  <devices>
+    <disk type="file" device="cdrom">
+      <target dev="sda" bus="sata"/>
+    </disk>
+    <console type="pty">
+      <source path="/dev/pts/3"/>
+      <target type="serial" port="0"/>
+    </console>
+    <graphics type="vnc" port="5901" autoport="no" websocket="5701">
+      <listen type="address" address="0.0.0.0"/>
+    </graphics>
+    <video>
+      <model type="virtio"/>
+    </video>
+    <tpm model="tpm-crb">
+      <backend type="emulator" version="2.0"/>
+    </tpm>
+  </devices>
+</domain>`
+
+func TestReadImportedDomain(t *testing.T) {
+	var domainDef libvirtxml.Domain
+	if err := xml.Unmarshal([]byte(testImportedDomainXML), &domainDef); err != nil {
+		t.Fatalf("could not unmarshal the domain: %s", err)
+	}
+
+	d := resourceLibvirtDomain().Data(nil)
+	if err := readImportedDomain(nil, d, domainDef); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	for key, expected := range map[string]interface{}{
+		"type":                      "kvm",
+		"video.0.type":              "virtio",
+		"boot_device.0.dev.0":       "network",
+		"boot_device.0.dev.1":       "hd",
+		"console.0.type":            "pty",
+		"console.0.source_path":     "/dev/pts/3",
+		"console.0.target_port":     "0",
+		"console.0.target_type":     "serial",
+		"graphics.0.type":           "vnc",
+		"graphics.0.autoport":       false,
+		"graphics.0.port":           5901,
+		"graphics.0.websocket":      5701,
+		"graphics.0.listen_type":    "address",
+		"graphics.0.listen_address": "0.0.0.0",
+		"tpm.0.model":               "tpm-crb",
+		"tpm.0.backend_type":        "emulator",
+		"tpm.0.backend_version":     "2.0",
+	} {
+		if got := d.Get(key); got != expected {
+			t.Errorf("%s = %v, expected %v", key, got, expected)
+		}
+	}
+
+	// the imported graphics define the same graphics again
+	domainDef.Devices.Graphics = nil
+	if err := setGraphics(d, &domainDef, "x86_64"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	data, err := xmlMarshallIndented(domainDef)
+	if err != nil {
+		t.Fatalf("could not marshall the domain: %s", err)
+	}
+	if expected := `<graphics type="vnc" port="5901" autoport="no" websocket="5701">`; !strings.Contains(data, expected) {
+		t.Errorf("expected %s in:\n%s", expected, data)
+	}
+}
+
+func TestCloudInitImportID(t *testing.T) {
+	key := "/var/lib/libvirt/images/commoninit.iso"
+	id := cloudInitImportID(key)
+	if id != cloudInitImportID(key) {
+		t.Errorf("the import ID of %s changes", key)
+	}
+	if volumeKey, err := getCloudInitVolumeKeyFromTerraformID(id); err != nil || volumeKey != key {
+		t.Errorf("the import ID %s is not a cloudinit disk ID of %s", id, key)
+	}
+	if id == cloudInitImportID(key+".2") {
+		t.Errorf("the import IDs of different volumes are the same")
+	}
+}
+
+func TestResourceImporters(t *testing.T) {
+	p := Provider()
+	for _, name := range []string{"libvirt_domain", "libvirt_volume", "libvirt_network", "libvirt_pool", "libvirt_cloudinit_disk"} {
+		r := p.ResourcesMap[name]
+		if r.Importer == nil || r.Importer.StateContext == nil {
+			t.Errorf("%s cannot be imported", name)
+		}
+	}
+}
@@ -225,3 +225,27 @@ func getDNSMasqOptionFromResource(d *schema.ResourceData) []libvirtxml.NetworkDn
 
 	return dnsmasqOption
 }
+
+// getNetworkAddresses returns the CIDRs of the networks of the IP addresses
+// of networkDef.
+func getNetworkAddresses(networkDef libvirtxml.Network) ([]string, error) {
+	addresses := []string{}
+	//nolint:gomnd
+	for _, address := range networkDef.IPs {
+		// we get the host interface IP (ie, 10.10.8.1) but we want the network CIDR (ie, 10.10.8.0/24)
+		// so we need some transformations...
+		addr := net.ParseIP(address.Address)
+		if addr == nil {
+			return nil, fmt.Errorf("error parsing IP '%s'", address.Address)
+		}
+		bits := net.IPv6len * 8
+		if addr.To4() != nil {
+			bits = net.IPv4len * 8
+		}
+
+		mask := net.CIDRMask(int(address.Prefix), bits)
+		network := addr.Mask(mask)
+		addresses = append(addresses, fmt.Sprintf("%s/%d", network, address.Prefix))
+	}
+	return addresses, nil
+}
@@ -1,6 +1,7 @@
 package libvirt
 
 import (
+	"context"
 	"encoding/xml"
 	"fmt"
 	"log"
@@ -82,25 +83,29 @@ func getHostXMLDesc(ip, mac, name string) string {
 }
 
 // Adds a new static host to the network.
-func addHost(virConn *libvirt.Libvirt, n libvirt.Network, ip, mac, name string, xmlIdx int) error {
+func addHost(ctx context.Context, virConn *libvirt.Libvirt, n libvirt.Network, ip, mac, name string, xmlIdx int) error {
 	xmlDesc := getHostXMLDesc(ip, mac, name)
 	log.Printf("Adding host with XML:\n%s", xmlDesc)
 	// From https://libvirt.org/html/libvirt-libvirt-network.html#virNetworkUpdateFlags
 	// Update live and config for hosts to make update permanent across reboots
-	return virConn.NetworkUpdateCompat(n, libvirt.NetworkUpdateCommandAddLast,
-		libvirt.NetworkSectionIPDhcpHost, int32(xmlIdx), xmlDesc,
-		libvirt.NetworkUpdateAffectConfig|libvirt.NetworkUpdateAffectLive)
+	return retryLibvirt(ctx, virConn, func() error {
+		return virConn.NetworkUpdateCompat(n, libvirt.NetworkUpdateCommandAddLast,
+			libvirt.NetworkSectionIPDhcpHost, int32(xmlIdx), xmlDesc,
+			libvirt.NetworkUpdateAffectConfig|libvirt.NetworkUpdateAffectLive)
+	})
 }
 
 // Update a static host from the network.
-func updateHost(virConn *libvirt.Libvirt, n libvirt.Network, ip, mac, name string, xmlIdx int) error {
+func updateHost(ctx context.Context, virConn *libvirt.Libvirt, n libvirt.Network, ip, mac, name string, xmlIdx int) error {
 	xmlDesc := getHostXMLDesc(ip, mac, name)
 	log.Printf("Updating host with XML:\n%s", xmlDesc)
 	// From https://libvirt.org/html/libvirt-libvirt-network.html#virNetworkUpdateFlags
 	// Update live and config for hosts to make update permanent across reboots
-	return virConn.NetworkUpdateCompat(n, libvirt.NetworkUpdateCommandModify,
-		libvirt.NetworkSectionIPDhcpHost, int32(xmlIdx), xmlDesc,
-		libvirt.NetworkUpdateAffectConfig|libvirt.NetworkUpdateAffectLive)
+	return retryLibvirt(ctx, virConn, func() error {
+		return virConn.NetworkUpdateCompat(n, libvirt.NetworkUpdateCommandModify,
+			libvirt.NetworkSectionIPDhcpHost, int32(xmlIdx), xmlDesc,
+			libvirt.NetworkUpdateAffectConfig|libvirt.NetworkUpdateAffectLive)
+	})
 }
 
 // Get the network index of the target network.
@@ -127,7 +132,7 @@ func getNetworkIdx(n *libvirtxml.Network, ip string) (int, error) {
 }
 
 // Tries to update first, if that fails, it will add it.
-func updateOrAddHost(virConn *libvirt.Libvirt, n libvirt.Network, ip, mac, name string) error {
+func updateOrAddHost(ctx context.Context, virConn *libvirt.Libvirt, n libvirt.Network, ip, mac, name string) error {
 	xmlNet, _ := getXMLNetworkDefFromLibvirt(virConn, n)
 	// We don't check the error above
 	// if we can't parse the network to xml for some reason
@@ -137,12 +142,12 @@ func updateOrAddHost(virConn *libvirt.Libvirt, n libvirt.Network, ip, mac, name
 		log.Printf("Error during detecting network index: %s\nUsing default value: %d", err, xmlIdx)
 	}
 
-	err = updateHost(virConn, n, ip, mac, name, xmlIdx)
+	err = updateHost(ctx, virConn, n, ip, mac, name, xmlIdx)
 	// FIXME: libvirt.Error.DomainID is not available from library. Is it still required here?
 	//  && virErr.Error.DomainID == uint32(.....FromNetwork) {
 	if isError(err, libvirt.ErrOperationInvalid) {
 		log.Printf("[DEBUG]: karl: updateOrAddHost before addHost()\n")
-		return addHost(virConn, n, ip, mac, name, xmlIdx)
+		return addHost(ctx, virConn, n, ip, mac, name, xmlIdx)
 	}
 	return err
 }
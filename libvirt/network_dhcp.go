@@ -0,0 +1,168 @@
+package libvirt
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+
+	libvirt "github.com/digitalocean/go-libvirt"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"libvirt.org/go/libvirtxml"
+)
+
+// parseNetworkDHCPHosts returns the DHCP hosts of the dhcp_host entries in
+// change.
+func parseNetworkDHCPHosts(change interface{}) ([]libvirtxml.NetworkDHCPHost, error) {
+	set, ok := change.(*schema.Set)
+	if !ok {
+		return nil, fmt.Errorf("not set %v", change)
+	}
+
+	hosts := make([]libvirtxml.NetworkDHCPHost, 0, set.Len())
+	for _, entry := range set.List() {
+		entryMap := entry.(map[string]interface{})
+		mac, err := net.ParseMAC(entryMap["mac"].(string))
+		if err != nil {
+			return nil, fmt.Errorf("could not parse MAC address '%s'", entryMap["mac"])
+		}
+		ip := net.ParseIP(entryMap["ip"].(string))
+		if ip == nil || ip.To4() == nil {
+			return nil, fmt.Errorf("could not parse IPv4 address '%s'", entryMap["ip"])
+		}
+		hosts = append(hosts, libvirtxml.NetworkDHCPHost{
+			MAC:  strings.ToUpper(mac.String()),
+			IP:   ip.String(),
+			Name: entryMap["hostname"].(string),
+		})
+	}
+
+	return hosts, nil
+}
+
+// getDHCPHostsFromResource adds the DHCP hosts of the resource to the IPs
+// of the network containing their addresses.
+func getDHCPHostsFromResource(d *schema.ResourceData, ips []libvirtxml.NetworkIP) error {
+	hosts, err := parseNetworkDHCPHosts(d.Get("dhcp_host"))
+	if err != nil {
+		return err
+	}
+
+	for _, host := range hosts {
+		idx, err := getNetworkIdx(&libvirtxml.Network{IPs: ips}, host.IP)
+		if err != nil {
+			return err
+		}
+		if idx < 0 || ips[idx].DHCP == nil {
+			return fmt.Errorf("no address of the network with DHCP enabled contains the DHCP host %s", host.IP)
+		}
+		ips[idx].DHCP.Hosts = append(ips[idx].DHCP.Hosts, host)
+	}
+
+	return nil
+}
+
+// updateDHCPHosts detects changes in the DHCP hosts entries, removing and
+// adding them from the running network and its definition accordingly.
+func updateDHCPHosts(ctx context.Context, d *schema.ResourceData, meta interface{}, network libvirt.Network) error {
+	virConn := meta.(*Client).libvirt
+
+	if !d.HasChange("dhcp_host") {
+		return nil
+	}
+	oldInterface, newInterface := d.GetChange("dhcp_host")
+
+	oldHosts, err := parseNetworkDHCPHosts(oldInterface)
+	if err != nil {
+		return fmt.Errorf("parse old dhcp_host: %w", err)
+	}
+	newHosts, err := parseNetworkDHCPHosts(newInterface)
+	if err != nil {
+		return fmt.Errorf("parse new dhcp_host: %w", err)
+	}
+
+	networkDef, err := getXMLNetworkDefFromLibvirt(virConn, network)
+	if err != nil {
+		return err
+	}
+
+	// libvirt finds the hosts to remove by their MAC address, so all the
+	// removals have to happen before the additions
+	for _, oldHost := range oldHosts {
+		if containsDHCPHost(newHosts, oldHost) {
+			continue
+		}
+		idx, err := getNetworkIdx(&networkDef, oldHost.IP)
+		if err != nil {
+			return err
+		}
+		log.Printf("[INFO] Removing DHCP host %s/%s from network %s", oldHost.IP, oldHost.MAC, network.Name)
+		err = retryLibvirt(ctx, virConn, func() error {
+			return virConn.NetworkUpdateCompat(network, libvirt.NetworkUpdateCommandDelete,
+				libvirt.NetworkSectionIPDhcpHost, int32(idx), getHostXMLDesc(oldHost.IP, oldHost.MAC, oldHost.Name),
+				libvirt.NetworkUpdateAffectLive|libvirt.NetworkUpdateAffectConfig)
+		})
+		if err != nil {
+			return fmt.Errorf("delete %s: %w", oldHost.IP, err)
+		}
+	}
+
+	for _, newHost := range newHosts {
+		if containsDHCPHost(oldHosts, newHost) {
+			continue
+		}
+		idx, err := getNetworkIdx(&networkDef, newHost.IP)
+		if err != nil {
+			return err
+		}
+		if idx < 0 {
+			return fmt.Errorf("no address of the network contains the DHCP host %s", newHost.IP)
+		}
+		log.Printf("[INFO] Adding DHCP host %s/%s to network %s", newHost.IP, newHost.MAC, network.Name)
+		if err := addHost(ctx, virConn, network, newHost.IP, newHost.MAC, newHost.Name, idx); err != nil {
+			return fmt.Errorf("add %s: %w", newHost.IP, err)
+		}
+	}
+
+	return nil
+}
+
+func containsDHCPHost(hosts []libvirtxml.NetworkDHCPHost, host libvirtxml.NetworkDHCPHost) bool {
+	for _, h := range hosts {
+		if h.MAC == host.MAC && h.IP == host.IP && h.Name == host.Name {
+			return true
+		}
+	}
+	return false
+}
+
+// readDHCPHosts sets the dhcp_host entries from the DHCP hosts of
+// networkDef. Only the hosts with the MAC address of an entry are read, as
+// the domains add their own hosts to the network.
+func readDHCPHosts(d *schema.ResourceData, networkDef libvirtxml.Network) error {
+	known := d.Get("dhcp_host").(*schema.Set).List()
+
+	var hostsBlock []map[string]interface{}
+	for _, ip := range networkDef.IPs {
+		if ip.DHCP == nil {
+			continue
+		}
+		for _, host := range ip.DHCP.Hosts {
+			for _, entry := range known {
+				// keep the MAC address as written in the configuration
+				mac := entry.(map[string]interface{})["mac"].(string)
+				if strings.EqualFold(mac, host.MAC) {
+					hostsBlock = append(hostsBlock, map[string]interface{}{
+						"mac":      mac,
+						"ip":       host.IP,
+						"hostname": host.Name,
+					})
+					break
+				}
+			}
+		}
+	}
+
+	return d.Set("dhcp_host", hostsBlock)
+}
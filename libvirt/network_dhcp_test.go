@@ -0,0 +1,121 @@
+package libvirt
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"libvirt.org/go/libvirtxml"
+)
+
+func TestGetDHCPHostsFromResource(t *testing.T) {
+	d := schema.TestResourceDataRaw(t, resourceLibvirtNetwork().Schema, map[string]interface{}{
+		"name":      "network",
+		"addresses": []interface{}{"10.17.3.0/24", "10.17.4.0/24"},
+		"dhcp_host": []interface{}{
+			map[string]interface{}{"mac": "52:54:00:6c:3c:01", "ip": "10.17.4.10", "hostname": "node1"},
+		},
+	})
+
+	ips, err := getIPsFromResource(d)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := getDHCPHostsFromResource(d, ips); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if len(ips[0].DHCP.Hosts) != 0 {
+		t.Errorf("expected no DHCP hosts in %s, got %v", ips[0].Address, ips[0].DHCP.Hosts)
+	}
+	expected := libvirtxml.NetworkDHCPHost{MAC: "52:54:00:6C:3C:01", IP: "10.17.4.10", Name: "node1"}
+	if len(ips[1].DHCP.Hosts) != 1 || ips[1].DHCP.Hosts[0] != expected {
+		t.Errorf("expected %v in %s, got %v", expected, ips[1].Address, ips[1].DHCP.Hosts)
+	}
+}
+
+func TestGetDHCPHostsFromResourceErrors(t *testing.T) {
+	tests := map[string]struct {
+		raw   map[string]interface{}
+		error string
+	}{
+		"invalid MAC address": {
+			raw: map[string]interface{}{
+				"dhcp_host": []interface{}{map[string]interface{}{"mac": "52:54:00", "ip": "10.17.3.10"}},
+			},
+			error: "could not parse MAC address '52:54:00'",
+		},
+		"IPv6 address": {
+			raw: map[string]interface{}{
+				"dhcp_host": []interface{}{map[string]interface{}{"mac": "52:54:00:6c:3c:01", "ip": "2001:db8::10"}},
+			},
+			error: "could not parse IPv4 address '2001:db8::10'",
+		},
+		"address outside of the network": {
+			raw: map[string]interface{}{
+				"dhcp_host": []interface{}{map[string]interface{}{"mac": "52:54:00:6c:3c:01", "ip": "10.17.5.10"}},
+			},
+			error: "no address of the network with DHCP enabled contains the DHCP host 10.17.5.10",
+		},
+		"DHCP disabled": {
+			raw: map[string]interface{}{
+				"dhcp":      []interface{}{map[string]interface{}{"enabled": false}},
+				"dhcp_host": []interface{}{map[string]interface{}{"mac": "52:54:00:6c:3c:01", "ip": "10.17.3.10"}},
+			},
+			error: "no address of the network with DHCP enabled contains the DHCP host 10.17.3.10",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			test.raw["name"] = "network"
+			test.raw["addresses"] = []interface{}{"10.17.3.0/24"}
+			d := schema.TestResourceDataRaw(t, resourceLibvirtNetwork().Schema, test.raw)
+
+			ips, err := getIPsFromResource(d)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			err = getDHCPHostsFromResource(d, ips)
+			if err == nil || !strings.Contains(err.Error(), test.error) {
+				t.Errorf("expected an error containing %q, got %v", test.error, err)
+			}
+		})
+	}
+}
+
+func TestParseNetworkDNSRecords(t *testing.T) {
+	d := schema.TestResourceDataRaw(t, resourceLibvirtNetwork().Schema, map[string]interface{}{
+		"name": "network",
+		"dns": []interface{}{map[string]interface{}{
+			"srvs": []interface{}{
+				map[string]interface{}{"service": "etcd", "protocol": "tcp", "target": "node1", "port": "2379"},
+			},
+			"txts": []interface{}{
+				map[string]interface{}{"name": "cluster", "value": "k8s"},
+			},
+		}},
+	})
+
+	srvs, err := getDNSSRVFromResource(d)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expectedSRV := libvirtxml.NetworkDNSSRV{Service: "etcd", Protocol: "tcp", Target: "node1", Port: 2379}
+	if len(srvs) != 1 || srvs[0] != expectedSRV {
+		t.Errorf("expected %v, got %v", expectedSRV, srvs)
+	}
+
+	txts, err := getDNSTXTsFromResource(d)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expectedTXT := libvirtxml.NetworkDNSTXT{Name: "cluster", Value: "k8s"}
+	if len(txts) != 1 || txts[0] != expectedTXT {
+		t.Errorf("expected %v, got %v", expectedTXT, txts)
+	}
+
+	if _, err := parseNetworkDNSSRVs([]interface{}{map[string]interface{}{"port": "http"}}); err == nil {
+		t.Errorf("expected an error for an invalid port")
+	}
+}
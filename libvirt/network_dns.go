@@ -1,6 +1,7 @@
 package libvirt
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"reflect"
@@ -14,7 +15,7 @@ import (
 
 // updateDNSHosts detects changes in the DNS hosts entries
 // updating the network definition accordingly.
-func updateDNSHosts(d *schema.ResourceData, meta interface{}, network libvirt.Network) error {
+func updateDNSHosts(ctx context.Context, d *schema.ResourceData, meta interface{}, network libvirt.Network) error {
 	virConn := meta.(*Client).libvirt
 
 	hostsKey := dnsPrefix + ".hosts"
@@ -49,8 +50,10 @@ func updateDNSHosts(d *schema.ResourceData, meta interface{}, network libvirt.Ne
 				return fmt.Errorf("serialize update: %w", err)
 			}
 
-			err = virConn.NetworkUpdateCompat(network, libvirt.NetworkUpdateCommandDelete,
-				libvirt.NetworkSectionDNSHost, -1, data, libvirt.NetworkUpdateAffectLive|libvirt.NetworkUpdateAffectConfig)
+			err = retryLibvirt(ctx, virConn, func() error {
+				return virConn.NetworkUpdateCompat(network, libvirt.NetworkUpdateCommandDelete,
+					libvirt.NetworkSectionDNSHost, -1, data, libvirt.NetworkUpdateAffectLive|libvirt.NetworkUpdateAffectConfig)
+			})
 			if err != nil {
 				return fmt.Errorf("delete %s: %w", oldEntry.IP, err)
 			}
@@ -74,8 +77,10 @@ func updateDNSHosts(d *schema.ResourceData, meta interface{}, network libvirt.Ne
 				return fmt.Errorf("serialize update: %w", err)
 			}
 
-			err = virConn.NetworkUpdateCompat(network, libvirt.NetworkUpdateCommandAddLast,
-				libvirt.NetworkSectionDNSHost, -1, data, libvirt.NetworkUpdateAffectLive|libvirt.NetworkUpdateAffectConfig)
+			err = retryLibvirt(ctx, virConn, func() error {
+				return virConn.NetworkUpdateCompat(network, libvirt.NetworkUpdateCommandAddLast,
+					libvirt.NetworkSectionDNSHost, -1, data, libvirt.NetworkUpdateAffectLive|libvirt.NetworkUpdateAffectConfig)
+			})
 			if err != nil {
 				return fmt.Errorf("add %v: %w", newEntry, err)
 			}
@@ -215,48 +220,180 @@ func getDNSEnableFromResource(d *schema.ResourceData) string {
 // getDNSSRVFromResource returns a list of libvirt's DNS SRVs
 // in the network definition.
 func getDNSSRVFromResource(d *schema.ResourceData) ([]libvirtxml.NetworkDNSSRV, error) {
-	var dnsSRVs []libvirtxml.NetworkDNSSRV
+	return parseNetworkDNSSRVs(d.Get(dnsPrefix + ".srvs"))
+}
 
-	if dnsSRVCount, ok := d.GetOk(dnsPrefix + ".srvs.#"); ok {
-		for i := 0; i < dnsSRVCount.(int); i++ {
-			srv := libvirtxml.NetworkDNSSRV{}
-			srvPrefix := fmt.Sprintf(dnsPrefix+".srvs.%d", i)
-			if service, ok := d.GetOk(srvPrefix + ".service"); ok {
-				srv.Service = service.(string)
-			}
-			if protocol, ok := d.GetOk(srvPrefix + ".protocol"); ok {
-				srv.Protocol = protocol.(string)
-			}
-			if domain, ok := d.GetOk(srvPrefix + ".domain"); ok {
-				srv.Domain = domain.(string)
-			}
-			if target, ok := d.GetOk(srvPrefix + ".target"); ok {
-				srv.Target = target.(string)
-			}
-			if port, ok := d.GetOk(srvPrefix + ".port"); ok {
-				p, err := strconv.Atoi(port.(string))
-				if err != nil {
-					return nil, fmt.Errorf("could not convert port '%s' to int", port)
-				}
-				srv.Port = uint(p)
-			}
-			if weight, ok := d.GetOk(srvPrefix + ".weight"); ok {
-				w, err := strconv.Atoi(weight.(string))
-				if err != nil {
-					return nil, fmt.Errorf("could not convert weight '%s' to int", weight)
-				}
-				srv.Weight = uint(w)
+func parseNetworkDNSSRVs(change interface{}) ([]libvirtxml.NetworkDNSSRV, error) {
+	list, ok := change.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("not list %v", change)
+	}
+
+	var dnsSRVs []libvirtxml.NetworkDNSSRV
+	for _, srvInterface := range list {
+		srvMap, _ := srvInterface.(map[string]interface{})
+		// ints are given as strings, so that they can be left unset
+		atoi := func(key string) (uint, error) {
+			value, _ := srvMap[key].(string)
+			if value == "" {
+				return 0, nil
 			}
-			if priority, ok := d.GetOk(srvPrefix + ".priority"); ok {
-				w, err := strconv.Atoi(priority.(string))
-				if err != nil {
-					return nil, fmt.Errorf("could not convert priority '%s' to int", priority)
-				}
-				srv.Priority = uint(w)
+			i, err := strconv.Atoi(value)
+			if err != nil {
+				return 0, fmt.Errorf("could not convert %s '%s' to int", key, value)
 			}
-			dnsSRVs = append(dnsSRVs, srv)
+			return uint(i), nil
+		}
+
+		srv := libvirtxml.NetworkDNSSRV{}
+		srv.Service, _ = srvMap["service"].(string)
+		srv.Protocol, _ = srvMap["protocol"].(string)
+		srv.Domain, _ = srvMap["domain"].(string)
+		srv.Target, _ = srvMap["target"].(string)
+		var err error
+		if srv.Port, err = atoi("port"); err != nil {
+			return nil, err
+		}
+		if srv.Weight, err = atoi("weight"); err != nil {
+			return nil, err
+		}
+		if srv.Priority, err = atoi("priority"); err != nil {
+			return nil, err
 		}
+		dnsSRVs = append(dnsSRVs, srv)
 	}
 
 	return dnsSRVs, nil
 }
+
+// getDNSTXTsFromResource returns a list of libvirt's DNS TXT records
+// in the network definition.
+func getDNSTXTsFromResource(d *schema.ResourceData) ([]libvirtxml.NetworkDNSTXT, error) {
+	return parseNetworkDNSTXTs(d.Get(dnsPrefix + ".txts"))
+}
+
+func parseNetworkDNSTXTs(change interface{}) ([]libvirtxml.NetworkDNSTXT, error) {
+	list, ok := change.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("not list %v", change)
+	}
+
+	var dnsTXTs []libvirtxml.NetworkDNSTXT
+	for _, txtInterface := range list {
+		txtMap, _ := txtInterface.(map[string]interface{})
+		txt := libvirtxml.NetworkDNSTXT{}
+		txt.Name, _ = txtMap["name"].(string)
+		txt.Value, _ = txtMap["value"].(string)
+		if txt.Name == "" {
+			return nil, fmt.Errorf("DNS TXT records require a name")
+		}
+		dnsTXTs = append(dnsTXTs, txt)
+	}
+
+	return dnsTXTs, nil
+}
+
+// updateDNSSRVsAndTXTs detects changes in the DNS SRV and TXT records,
+// updating the network definition accordingly.
+func updateDNSSRVsAndTXTs(ctx context.Context, d *schema.ResourceData, meta interface{}, network libvirt.Network) error {
+	virConn := meta.(*Client).libvirt
+
+	srvsKey := dnsPrefix + ".srvs"
+	if d.HasChange(srvsKey) {
+		oldInterface, newInterface := d.GetChange(srvsKey)
+		oldSRVs, err := parseNetworkDNSSRVs(oldInterface)
+		if err != nil {
+			return fmt.Errorf("parse old %s: %w", srvsKey, err)
+		}
+		newSRVs, err := parseNetworkDNSSRVs(newInterface)
+		if err != nil {
+			return fmt.Errorf("parse new %s: %w", srvsKey, err)
+		}
+
+		var oldEntries, newEntries []interface{}
+		for _, srv := range oldSRVs {
+			oldEntries = append(oldEntries, srv)
+		}
+		for _, srv := range newSRVs {
+			newEntries = append(newEntries, srv)
+		}
+		if err := updateDNSEntries(ctx, virConn, network, libvirt.NetworkSectionDNSSrv, oldEntries, newEntries); err != nil {
+			return err
+		}
+	}
+
+	txtsKey := dnsPrefix + ".txts"
+	if d.HasChange(txtsKey) {
+		oldInterface, newInterface := d.GetChange(txtsKey)
+		oldTXTs, err := parseNetworkDNSTXTs(oldInterface)
+		if err != nil {
+			return fmt.Errorf("parse old %s: %w", txtsKey, err)
+		}
+		newTXTs, err := parseNetworkDNSTXTs(newInterface)
+		if err != nil {
+			return fmt.Errorf("parse new %s: %w", txtsKey, err)
+		}
+
+		var oldEntries, newEntries []interface{}
+		for _, txt := range oldTXTs {
+			oldEntries = append(oldEntries, txt)
+		}
+		for _, txt := range newTXTs {
+			newEntries = append(newEntries, txt)
+		}
+		if err := updateDNSEntries(ctx, virConn, network, libvirt.NetworkSectionDNSTxt, oldEntries, newEntries); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// updateDNSEntries removes the entries of section in oldEntries but not in
+// newEntries from the network, then adds the ones only in newEntries.
+func updateDNSEntries(ctx context.Context, virConn *libvirt.Libvirt, network libvirt.Network, section libvirt.NetworkUpdateSection, oldEntries, newEntries []interface{}) error {
+	contains := func(entries []interface{}, entry interface{}) bool {
+		for _, e := range entries {
+			if reflect.DeepEqual(e, entry) {
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, oldEntry := range oldEntries {
+		if contains(newEntries, oldEntry) {
+			continue
+		}
+		data, err := xmlMarshallIndented(oldEntry)
+		if err != nil {
+			return fmt.Errorf("serialize update: %w", err)
+		}
+		err = retryLibvirt(ctx, virConn, func() error {
+			return virConn.NetworkUpdateCompat(network, libvirt.NetworkUpdateCommandDelete,
+				section, -1, data, libvirt.NetworkUpdateAffectLive|libvirt.NetworkUpdateAffectConfig)
+		})
+		if err != nil {
+			return fmt.Errorf("delete %v: %w", oldEntry, err)
+		}
+	}
+
+	for _, newEntry := range newEntries {
+		if contains(oldEntries, newEntry) {
+			continue
+		}
+		data, err := xmlMarshallIndented(newEntry)
+		if err != nil {
+			return fmt.Errorf("serialize update: %w", err)
+		}
+		err = retryLibvirt(ctx, virConn, func() error {
+			return virConn.NetworkUpdateCompat(network, libvirt.NetworkUpdateCommandAddLast,
+				section, -1, data, libvirt.NetworkUpdateAffectLive|libvirt.NetworkUpdateAffectConfig)
+		})
+		if err != nil {
+			return fmt.Errorf("add %v: %w", newEntry, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,256 @@
+package libvirt
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+// placementPolicies are the ways of choosing the host of a domain or volume
+// without a host attribute: round_robin takes the hosts in turn, and
+// least_memory the one with the most free memory.
+var placementPolicies = []string{"round_robin", "least_memory"}
+
+// hostPool are the hypervisors of the hosts blocks of the provider, which
+// domains and volumes are placed on. Hosts are connected to on first use.
+type hostPool struct {
+	names     []string
+	configs   map[string]Config
+	placement string
+	// freeMemory returns the free memory of a host, in bytes
+	freeMemory func(name string) (uint64, error)
+
+	mu   sync.Mutex
+	next int
+	// reserved is the memory of the domains placed on each host by this
+	// run, which the free memory of a host reflects once they run only
+	reserved map[string]uint64
+}
+
+// newHostPool returns the pool of the hosts, connecting to each of them with
+// base and its uri.
+func newHostPool(hosts []interface{}, placement string, base Config) (*hostPool, error) {
+	validPlacement := false
+	for _, p := range placementPolicies {
+		validPlacement = validPlacement || p == placement
+	}
+	if !validPlacement {
+		return nil, fmt.Errorf("unsupported placement \"%s\", supported placements are: %s", placement, strings.Join(placementPolicies, ", "))
+	}
+
+	pool := &hostPool{
+		configs:   make(map[string]Config),
+		placement: placement,
+		reserved:  make(map[string]uint64),
+	}
+	for i, h := range hosts {
+		host, _ := h.(map[string]interface{})
+		name, _ := host["name"].(string)
+		hostURI, _ := host["uri"].(string)
+		if name == "" || hostURI == "" {
+			return nil, fmt.Errorf("hosts entry %d must have a 'name' and a 'uri' set", i)
+		}
+		if _, ok := pool.configs[name]; ok {
+			return nil, fmt.Errorf("hosts entry %d: there is another host named \"%s\"", i, name)
+		}
+		pool.names = append(pool.names, name)
+		config := base
+		config.URI = hostURI
+		pool.configs[name] = config
+	}
+	pool.freeMemory = func(name string) (uint64, error) {
+		client, err := pool.client(name)
+		if err != nil {
+			return 0, err
+		}
+		return client.libvirt.NodeGetFreeMemory()
+	}
+	return pool, nil
+}
+
+// client returns the client of the host name, connecting to it if needed.
+func (p *hostPool) client(name string) (*Client, error) {
+	config, ok := p.configs[name]
+	if !ok {
+		return nil, fmt.Errorf("host \"%s\" is not one of the hosts of the provider: %s", name, strings.Join(p.names, ", "))
+	}
+	client, err := configureClient(config)
+	if err != nil {
+		return nil, fmt.Errorf("host %s: %w", name, err)
+	}
+	return client, nil
+}
+
+// place returns the host to put a domain or volume needing memory bytes on,
+// according to the placement policy. With least_memory, hosts that cannot
+// be reached are left out, and the memory is reserved on the chosen host so
+// that the domains created at the same time are spread as well.
+func (p *hostPool) place(memory uint64) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.placement != "least_memory" {
+		name := p.names[p.next%len(p.names)]
+		p.next++
+		return name, nil
+	}
+
+	var best string
+	var bestFree uint64
+	for _, name := range p.names {
+		free, err := p.freeMemory(name)
+		if err != nil {
+			log.Printf("[WARN] Not placing on host %s, its free memory is unknown: %v", name, err)
+			continue
+		}
+		if reserved := p.reserved[name]; reserved < free {
+			free -= reserved
+		} else {
+			free = 0
+		}
+		if best == "" || free > bestFree {
+			best, bestFree = name, free
+		}
+	}
+	if best == "" {
+		return "", fmt.Errorf("none of the hosts %s can be placed on", strings.Join(p.names, ", "))
+	}
+	p.reserved[best] += memory
+	log.Printf("[DEBUG] Placing on host %s, with %d bytes of free memory", best, bestFree)
+	return best, nil
+}
+
+// domainMemoryDemand returns the memory the domain d needs, in bytes.
+func domainMemoryDemand(d *schema.ResourceData) uint64 {
+	return uint64(d.Get("memory").(int)) * 1024 * 1024
+}
+
+// hostMeta returns the client of the host the resource d is on, with the
+// host attribute set to the first host when it was not set yet, or meta
+// itself when the provider has no hosts.
+func hostMeta(d *schema.ResourceData, meta interface{}) (interface{}, error) {
+	client, ok := meta.(*Client)
+	if !ok || client.hosts == nil {
+		return meta, nil
+	}
+	name := d.Get("host").(string)
+	if name == "" {
+		name = client.hosts.names[0]
+		d.Set("host", name)
+	}
+	return client.hosts.client(name)
+}
+
+// migrate moves the resource d from the source host to the target one,
+// targetURI being the uri of the target host.
+type hostMigrateFunc func(d *schema.ResourceData, source *Client, target *Client, targetURI string) error
+
+// migrate moves the resource d to the host it is set to now, from the one it
+// was on, with move.
+func (p *hostPool) migrate(d *schema.ResourceData, move hostMigrateFunc) error {
+	from, to := d.GetChange("host")
+	if from.(string) == "" {
+		return nil
+	}
+	source, err := p.client(from.(string))
+	if err != nil {
+		return err
+	}
+	target, err := p.client(to.(string))
+	if err != nil {
+		return err
+	}
+	log.Printf("[INFO] Moving %s from host %s to host %s", d.Id(), from, to)
+	return move(d, source, target, p.configs[to.(string)].URI)
+}
+
+// placeOnHosts makes r, a resource with a host attribute, operate on the
+// host it is placed on when the provider has hosts, instead of the first
+// one. A new resource without a host is placed according to the placement
+// policy, demand returning the memory it needs, if any. When the host of a
+// resource changes, it is moved there with move before being updated, or
+// nil if the host cannot change. An imported resource is on the host its ID
+// is prefixed with, as host:id, or else on the first host.
+func placeOnHosts(r *schema.Resource, demand func(d *schema.ResourceData) uint64, move hostMigrateFunc) {
+	if create := r.CreateContext; create != nil {
+		r.CreateContext = func(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+			client, ok := meta.(*Client)
+			if !ok || client.hosts == nil {
+				if host := d.Get("host").(string); host != "" {
+					return diag.Errorf("host \"%s\" is set, but the provider has no hosts", host)
+				}
+				return create(ctx, d, meta)
+			}
+			if d.Get("host").(string) == "" {
+				var memory uint64
+				if demand != nil {
+					memory = demand(d)
+				}
+				name, err := client.hosts.place(memory)
+				if err != nil {
+					return diag.FromErr(err)
+				}
+				d.Set("host", name)
+			}
+			hostClient, err := hostMeta(d, meta)
+			if err != nil {
+				return diag.FromErr(err)
+			}
+			return create(ctx, d, hostClient)
+		}
+	}
+
+	type contextFunc = func(context.Context, *schema.ResourceData, interface{}) diag.Diagnostics
+	wrap := func(f contextFunc) contextFunc {
+		if f == nil {
+			return nil
+		}
+		return func(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+			hostClient, err := hostMeta(d, meta)
+			if err != nil {
+				return diag.FromErr(err)
+			}
+			return f(ctx, d, hostClient)
+		}
+	}
+	r.ReadContext = wrap(r.ReadContext)
+	r.UpdateContext = wrap(r.UpdateContext)
+	r.DeleteContext = wrap(r.DeleteContext)
+
+	if update := r.UpdateContext; update != nil && move != nil {
+		r.UpdateContext = func(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+			if client, ok := meta.(*Client); ok && client.hosts != nil && d.HasChange("host") {
+				if err := client.hosts.migrate(d, move); err != nil {
+					// the resource is still on the host it was on
+					d.Partial(true)
+					return diag.FromErr(err)
+				}
+			}
+			return update(ctx, d, meta)
+		}
+	}
+
+	if r.Importer != nil && r.Importer.StateContext != nil {
+		importState := r.Importer.StateContext
+		r.Importer.StateContext = func(ctx context.Context, d *schema.ResourceData, meta interface{}) ([]*schema.ResourceData, error) {
+			if client, ok := meta.(*Client); ok && client.hosts != nil {
+				if name, id, ok := strings.Cut(d.Id(), ":"); ok {
+					if _, known := client.hosts.configs[name]; known {
+						d.Set("host", name)
+						d.SetId(id)
+					}
+				}
+			}
+			hostClient, err := hostMeta(d, meta)
+			if err != nil {
+				return nil, err
+			}
+			return importState(ctx, d, hostClient)
+		}
+	}
+}
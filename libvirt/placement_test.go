@@ -0,0 +1,191 @@
+package libvirt
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+func testHostPool(t *testing.T, placement string, names ...string) *hostPool {
+	var hosts []interface{}
+	for _, name := range names {
+		uri := "test:///placement/" + name
+		hosts = append(hosts, map[string]interface{}{"name": name, "uri": uri})
+
+		// hosts are connected to on first use, have them connected already
+		globalClientMutex.Lock()
+		globalClientMap[clientKeyOf(Config{URI: uri})] = &Client{}
+		globalClientMutex.Unlock()
+		t.Cleanup(func() {
+			globalClientMutex.Lock()
+			delete(globalClientMap, clientKeyOf(Config{URI: uri}))
+			globalClientMutex.Unlock()
+		})
+	}
+	pool, err := newHostPool(hosts, placement, Config{})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	return pool
+}
+
+func TestHostPoolPlace(t *testing.T) {
+	pool := testHostPool(t, "round_robin", "hv1", "hv2", "hv3")
+	var placed []string
+	for i := 0; i < 4; i++ {
+		name, err := pool.place(0)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		placed = append(placed, name)
+	}
+	if got := strings.Join(placed, ","); got != "hv1,hv2,hv3,hv1" {
+		t.Errorf("round_robin placed on %s", got)
+	}
+
+	const gib = 1024 * 1024 * 1024
+	pool = testHostPool(t, "least_memory", "hv1", "hv2", "hv3")
+	free := map[string]uint64{"hv1": 8 * gib, "hv2": 12 * gib}
+	pool.freeMemory = func(name string) (uint64, error) {
+		if memory, ok := free[name]; ok {
+			return memory, nil
+		}
+		return 0, fmt.Errorf("connection refused")
+	}
+	placed = nil
+	for _, memory := range []uint64{6 * gib, 4 * gib, 4 * gib} {
+		name, err := pool.place(memory)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		placed = append(placed, name)
+	}
+	// the memory of the domains placed already counts, and the host that
+	// cannot be reached is left out
+	if got := strings.Join(placed, ","); got != "hv2,hv1,hv2" {
+		t.Errorf("least_memory placed on %s", got)
+	}
+
+	free = nil
+	if _, err := pool.place(gib); err == nil {
+		t.Errorf("expected an error without reachable hosts")
+	}
+}
+
+func TestNewHostPoolErrors(t *testing.T) {
+	tests := map[string]struct {
+		hosts     []interface{}
+		placement string
+		error     string
+	}{
+		"unsupported placement": {
+			hosts:     []interface{}{map[string]interface{}{"name": "hv1", "uri": "qemu:///system"}},
+			placement: "random",
+			error:     `unsupported placement "random"`,
+		},
+		"duplicate name": {
+			hosts: []interface{}{
+				map[string]interface{}{"name": "hv1", "uri": "qemu+ssh://hv1/system"},
+				map[string]interface{}{"name": "hv1", "uri": "qemu+ssh://hv2/system"},
+			},
+			placement: "round_robin",
+			error:     `there is another host named "hv1"`,
+		},
+		"missing uri": {
+			hosts:     []interface{}{map[string]interface{}{"name": "hv1"}},
+			placement: "round_robin",
+			error:     `hosts entry 0 must have a 'name' and a 'uri' set`,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := newHostPool(test.hosts, test.placement, Config{})
+			if err == nil || !strings.Contains(err.Error(), test.error) {
+				t.Errorf("expected an error containing %q, got %v", test.error, err)
+			}
+		})
+	}
+}
+
+func TestPlaceOnHosts(t *testing.T) {
+	pool := testHostPool(t, "round_robin", "hv1", "hv2")
+	provider := &Client{hosts: pool}
+
+	var used interface{}
+	r := &schema.Resource{
+		CreateContext: func(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+			used = meta
+			d.SetId("id")
+			return nil
+		},
+		ReadContext: func(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+			used = meta
+			return nil
+		},
+		Importer: &schema.ResourceImporter{
+			StateContext: schema.ImportStatePassthroughContext,
+		},
+		Schema: map[string]*schema.Schema{
+			"host": {
+				Type:     schema.TypeString,
+				Optional: true,
+				Computed: true,
+				ForceNew: true,
+			},
+		},
+	}
+	placeOnHosts(r, nil, nil)
+	hostClient := func(name string) *Client {
+		client, err := pool.client(name)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		return client
+	}
+
+	d := schema.TestResourceDataRaw(t, r.Schema, map[string]interface{}{})
+	if diags := r.CreateContext(context.Background(), d, provider); diags.HasError() {
+		t.Fatalf("unexpected error: %v", diags)
+	}
+	if d.Get("host") != "hv1" || used != hostClient("hv1") {
+		t.Errorf("created on %s instead of hv1", d.Get("host"))
+	}
+
+	d = schema.TestResourceDataRaw(t, r.Schema, map[string]interface{}{"host": "hv2"})
+	if diags := r.ReadContext(context.Background(), d, provider); diags.HasError() {
+		t.Fatalf("unexpected error: %v", diags)
+	}
+	if used != hostClient("hv2") {
+		t.Errorf("read from another host than hv2")
+	}
+
+	d = schema.TestResourceDataRaw(t, r.Schema, map[string]interface{}{"host": "hv4"})
+	if diags := r.ReadContext(context.Background(), d, provider); !diags.HasError() {
+		t.Errorf("expected an error for an unknown host")
+	}
+
+	// without hosts, resources are on the provider connection
+	d = schema.TestResourceDataRaw(t, r.Schema, map[string]interface{}{"host": "hv2"})
+	if diags := r.CreateContext(context.Background(), d, &Client{}); !diags.HasError() {
+		t.Errorf("expected an error for a host without hosts in the provider")
+	}
+
+	for id, expected := range map[string]string{
+		"hv2:5e0b1e5f-0c5b-4b8c-a1a4-1f7a3c2b9d10": "hv2",
+		"/var/lib/libvirt/images/disk.qcow2":       "hv1",
+	} {
+		d = r.Data(nil)
+		d.SetId(id)
+		if _, err := r.Importer.StateContext(context.Background(), d, provider); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if d.Get("host") != expected || strings.HasPrefix(d.Id(), expected+":") {
+			t.Errorf("imported %s as %s on %s, expected host %s", id, d.Id(), d.Get("host"), expected)
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package libvirt
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"libvirt.org/go/libvirtxml"
+)
+
+// poolTypes are the pool types the pool resource knows how to define, each
+// configured by the block named after it, except for "dir".
+var poolTypes = []string{"dir", "logical", "iscsi", "netfs", "rbd"}
+
+// newPoolDef returns the definition of the pool described by d.
+func newPoolDef(d *schema.ResourceData) (libvirtxml.StoragePool, error) {
+	poolType := d.Get("type").(string)
+	poolDef := libvirtxml.StoragePool{
+		Type: poolType,
+		Name: d.Get("name").(string),
+	}
+	poolPath := d.Get("path").(string)
+
+	for _, blockType := range poolTypes[1:] {
+		if _, ok := d.GetOk(blockType); ok && blockType != poolType {
+			return poolDef, fmt.Errorf("the \"%s\" block is only relevant to storage pools of type \"%s\"", blockType, blockType)
+		}
+	}
+
+	switch poolType {
+	case "dir":
+		if poolPath == "" {
+			return poolDef, fmt.Errorf("\"path\" attribute is requires for storage pools of type \"dir\"")
+		}
+		poolDef.Target = &libvirtxml.StoragePoolTarget{Path: poolPath}
+
+	case "logical":
+		volumeGroup := poolDef.Name
+		if name, ok := d.GetOk("logical.0.volume_group"); ok {
+			volumeGroup = name.(string)
+		}
+		poolDef.Source = &libvirtxml.StoragePoolSource{
+			Name:   volumeGroup,
+			Format: &libvirtxml.StoragePoolSourceFormat{Type: "lvm2"},
+		}
+		for _, device := range d.Get("logical.0.devices").([]interface{}) {
+			poolDef.Source.Device = append(poolDef.Source.Device, libvirtxml.StoragePoolSourceDevice{Path: device.(string)})
+		}
+		if poolPath == "" {
+			poolPath = "/dev/" + volumeGroup
+		}
+		poolDef.Target = &libvirtxml.StoragePoolTarget{Path: poolPath}
+
+	case "iscsi":
+		if _, ok := d.GetOk("iscsi"); !ok {
+			return poolDef, fmt.Errorf("the \"iscsi\" block is required for storage pools of type \"iscsi\"")
+		}
+		host, err := newPoolSourceHost(d.Get("iscsi.0.portal").(string))
+		if err != nil {
+			return poolDef, err
+		}
+		poolDef.Source = &libvirtxml.StoragePoolSource{
+			Host:   []libvirtxml.StoragePoolSourceHost{host},
+			Device: []libvirtxml.StoragePoolSourceDevice{{Path: d.Get("iscsi.0.target").(string)}},
+		}
+		if initiator, ok := d.GetOk("iscsi.0.initiator"); ok {
+			poolDef.Source.Initiator = &libvirtxml.StoragePoolSourceInitiator{
+				IQN: libvirtxml.StoragePoolSourceInitiatorIQN{Name: initiator.(string)},
+			}
+		}
+		poolDef.Source.Auth = newPoolSourceAuth("chap", d.Get("iscsi.0.username").(string), d.Get("iscsi.0.secret_uuid").(string))
+		if poolPath == "" {
+			poolPath = "/dev/disk/by-path"
+		}
+		poolDef.Target = &libvirtxml.StoragePoolTarget{Path: poolPath}
+
+	case "netfs":
+		if _, ok := d.GetOk("netfs"); !ok {
+			return poolDef, fmt.Errorf("the \"netfs\" block is required for storage pools of type \"netfs\"")
+		}
+		if poolPath == "" {
+			return poolDef, fmt.Errorf("\"path\" attribute is requires for storage pools of type \"netfs\"")
+		}
+		poolDef.Source = &libvirtxml.StoragePoolSource{
+			Host:   []libvirtxml.StoragePoolSourceHost{{Name: d.Get("netfs.0.host").(string)}},
+			Dir:    &libvirtxml.StoragePoolSourceDir{Path: d.Get("netfs.0.export").(string)},
+			Format: &libvirtxml.StoragePoolSourceFormat{Type: d.Get("netfs.0.format").(string)},
+		}
+		poolDef.Target = &libvirtxml.StoragePoolTarget{Path: poolPath}
+
+	case "rbd":
+		if _, ok := d.GetOk("rbd"); !ok {
+			return poolDef, fmt.Errorf("the \"rbd\" block is required for storage pools of type \"rbd\"")
+		}
+		if poolPath != "" {
+			return poolDef, fmt.Errorf("\"path\" attribute is not supported by storage pools of type \"rbd\"")
+		}
+		poolDef.Source = &libvirtxml.StoragePoolSource{
+			Name: d.Get("rbd.0.pool").(string),
+		}
+		for _, monitor := range d.Get("rbd.0.monitors").([]interface{}) {
+			host, err := newPoolSourceHost(monitor.(string))
+			if err != nil {
+				return poolDef, err
+			}
+			poolDef.Source.Host = append(poolDef.Source.Host, host)
+		}
+		poolDef.Source.Auth = newPoolSourceAuth("ceph", d.Get("rbd.0.username").(string), d.Get("rbd.0.secret_uuid").(string))
+
+	default:
+		return poolDef, fmt.Errorf("storage pools of type \"%s\" are not supported, supported types are: %s", poolType, strings.Join(poolTypes, ", "))
+	}
+
+	return poolDef, nil
+}
+
+// newPoolSourceHost parses address, a host with an optional port.
+func newPoolSourceHost(address string) (libvirtxml.StoragePoolSourceHost, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		// without a port, the address is a host name or an IP address
+		name := strings.TrimSuffix(strings.TrimPrefix(address, "["), "]")
+		if strings.Contains(name, ":") && net.ParseIP(name) == nil {
+			return libvirtxml.StoragePoolSourceHost{}, fmt.Errorf("invalid address '%s': %w", address, err)
+		}
+		return libvirtxml.StoragePoolSourceHost{Name: name}, nil
+	}
+	return libvirtxml.StoragePoolSourceHost{Name: host, Port: port}, nil
+}
+
+// poolSourceHostAddress is the inverse of newPoolSourceHost.
+func poolSourceHostAddress(host libvirtxml.StoragePoolSourceHost) string {
+	if host.Port == "" {
+		return host.Name
+	}
+	return net.JoinHostPort(host.Name, host.Port)
+}
+
+// newPoolSourceAuth returns the authentication of a pool source with the
+// libvirt secret secretUUID, if a username is given.
+func newPoolSourceAuth(authType string, username string, secretUUID string) *libvirtxml.StoragePoolSourceAuth {
+	if username == "" {
+		return nil
+	}
+	auth := &libvirtxml.StoragePoolSourceAuth{
+		Type:     authType,
+		Username: username,
+	}
+	if secretUUID != "" {
+		auth.Secret = &libvirtxml.StoragePoolSourceAuthSecret{UUID: secretUUID}
+	}
+	return auth
+}
+
+// poolBuilt reports whether creating the pool described by d builds it, and
+// so whether deleting it has to delete what was built: dir and netfs pools
+// create their directory, and logical pools create their volume group when
+// given its devices. The other pools use storage that exists already.
+func poolBuilt(d *schema.ResourceData) bool {
+	switch d.Get("type").(string) {
+	case "dir", "netfs":
+		return true
+	case "logical":
+		return len(d.Get("logical.0.devices").([]interface{})) > 0
+	}
+	return false
+}
+
+// setPoolSourceAttributes sets the attributes of the type specific block of
+// the pool resource from the source in poolDef.
+func setPoolSourceAttributes(d *schema.ResourceData, poolDef libvirtxml.StoragePool) error {
+	source := poolDef.Source
+	if source == nil {
+		return nil
+	}
+
+	auth := func(attrs map[string]interface{}) {
+		if source.Auth != nil {
+			attrs["username"] = source.Auth.Username
+			if source.Auth.Secret != nil {
+				attrs["secret_uuid"] = source.Auth.Secret.UUID
+			}
+		}
+	}
+
+	switch poolDef.Type {
+	case "logical":
+		// the devices are kept from the state, as they tell whether the
+		// volume group is deleted along with the pool, unless importing
+		devices := d.Get("logical.0.devices").([]interface{})
+		if _, ok := d.GetOk("logical"); !ok {
+			for _, device := range source.Device {
+				devices = append(devices, device.Path)
+			}
+		}
+		return d.Set("logical", []map[string]interface{}{{
+			"volume_group": source.Name,
+			"devices":      devices,
+		}})
+
+	case "iscsi":
+		attrs := map[string]interface{}{}
+		if len(source.Host) > 0 {
+			attrs["portal"] = poolSourceHostAddress(source.Host[0])
+		}
+		if len(source.Device) > 0 {
+			attrs["target"] = source.Device[0].Path
+		}
+		if source.Initiator != nil {
+			attrs["initiator"] = source.Initiator.IQN.Name
+		}
+		auth(attrs)
+		return d.Set("iscsi", []map[string]interface{}{attrs})
+
+	case "netfs":
+		attrs := map[string]interface{}{}
+		if len(source.Host) > 0 {
+			attrs["host"] = source.Host[0].Name
+		}
+		if source.Dir != nil {
+			attrs["export"] = source.Dir.Path
+		}
+		if source.Format != nil {
+			attrs["format"] = source.Format.Type
+		}
+		return d.Set("netfs", []map[string]interface{}{attrs})
+
+	case "rbd":
+		monitors := make([]string, 0, len(source.Host))
+		for _, host := range source.Host {
+			monitors = append(monitors, poolSourceHostAddress(host))
+		}
+		attrs := map[string]interface{}{
+			"pool":     source.Name,
+			"monitors": monitors,
+		}
+		auth(attrs)
+		return d.Set("rbd", []map[string]interface{}{attrs})
+	}
+
+	return nil
+}
@@ -0,0 +1,189 @@
+package libvirt
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+func TestNewPoolDef(t *testing.T) {
+	tests := map[string]struct {
+		raw      map[string]interface{}
+		expected []string
+		built    bool
+	}{
+		"dir": {
+			raw: map[string]interface{}{
+				"type": "dir",
+				"path": "/var/lib/pool",
+			},
+			expected: []string{`<pool type="dir">`, `<path>/var/lib/pool</path>`},
+			built:    true,
+		},
+		"logical": {
+			raw: map[string]interface{}{
+				"type": "logical",
+				"logical": []interface{}{map[string]interface{}{
+					"volume_group": "vg0",
+					"devices":      []interface{}{"/dev/sdb", "/dev/sdc"},
+				}},
+			},
+			expected: []string{
+				`<pool type="logical">`, `<name>vg0</name>`, `<format type="lvm2"></format>`,
+				`<device path="/dev/sdb"></device>`, `<device path="/dev/sdc"></device>`, `<path>/dev/vg0</path>`,
+			},
+			built: true,
+		},
+		"logical on an existing volume group": {
+			raw: map[string]interface{}{
+				"type": "logical",
+			},
+			expected: []string{`<name>pool</name>`, `<path>/dev/pool</path>`},
+			built:    false,
+		},
+		"iscsi": {
+			raw: map[string]interface{}{
+				"type": "iscsi",
+				"iscsi": []interface{}{map[string]interface{}{
+					"portal":      "192.168.1.10:3260",
+					"target":      "iqn.2013-06.com.example:iscsi-pool",
+					"initiator":   "iqn.2013-06.com.example:initiator",
+					"username":    "admin",
+					"secret_uuid": "2ec115d7-3a88-3ceb-bc12-0ac909a6fd87",
+				}},
+			},
+			expected: []string{
+				`<host name="192.168.1.10" port="3260"></host>`,
+				`<device path="iqn.2013-06.com.example:iscsi-pool"></device>`,
+				`<auth type="chap" username="admin">`,
+				`<secret uuid="2ec115d7-3a88-3ceb-bc12-0ac909a6fd87"></secret>`,
+				`<iqn name="iqn.2013-06.com.example:initiator"></iqn>`,
+				`<path>/dev/disk/by-path</path>`,
+			},
+			built: false,
+		},
+		"netfs": {
+			raw: map[string]interface{}{
+				"type": "netfs",
+				"path": "/var/lib/pool",
+				"netfs": []interface{}{map[string]interface{}{
+					"host":   "nfs.example.com",
+					"export": "/exports/pool",
+				}},
+			},
+			expected: []string{
+				`<host name="nfs.example.com"></host>`, `<dir path="/exports/pool"></dir>`,
+				`<format type="nfs"></format>`, `<path>/var/lib/pool</path>`,
+			},
+			built: true,
+		},
+		"rbd": {
+			raw: map[string]interface{}{
+				"type": "rbd",
+				"rbd": []interface{}{map[string]interface{}{
+					"monitors":    []interface{}{"mon1.example.com:6789", "[2001:db8::1]", "mon3.example.com"},
+					"pool":        "libvirt",
+					"username":    "libvirt",
+					"secret_uuid": "2ec115d7-3a88-3ceb-bc12-0ac909a6fd87",
+				}},
+			},
+			expected: []string{
+				`<name>libvirt</name>`,
+				`<host name="mon1.example.com" port="6789"></host>`,
+				`<host name="2001:db8::1"></host>`,
+				`<host name="mon3.example.com"></host>`,
+				`<auth type="ceph" username="libvirt">`,
+			},
+			built: false,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			test.raw["name"] = "pool"
+			d := schema.TestResourceDataRaw(t, resourceLibvirtPool().Schema, test.raw)
+
+			poolDef, err := newPoolDef(d)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			data, err := xmlMarshallIndented(poolDef)
+			if err != nil {
+				t.Fatalf("could not marshall the pool: %s", err)
+			}
+			for _, expected := range test.expected {
+				if !strings.Contains(data, expected) {
+					t.Errorf("expected %s in:\n%s", expected, data)
+				}
+			}
+			if built := poolBuilt(d); built != test.built {
+				t.Errorf("poolBuilt() = %t, expected %t", built, test.built)
+			}
+		})
+	}
+}
+
+func TestNewPoolDefErrors(t *testing.T) {
+	tests := map[string]struct {
+		raw   map[string]interface{}
+		error string
+	}{
+		"dir without path": {
+			raw:   map[string]interface{}{"type": "dir"},
+			error: `"path" attribute is requires for storage pools of type "dir"`,
+		},
+		"unsupported type": {
+			raw:   map[string]interface{}{"type": "zfs"},
+			error: `storage pools of type "zfs" are not supported`,
+		},
+		"block of another type": {
+			raw: map[string]interface{}{
+				"type": "dir",
+				"path": "/var/lib/pool",
+				"rbd": []interface{}{map[string]interface{}{
+					"monitors": []interface{}{"mon1"},
+					"pool":     "libvirt",
+				}},
+			},
+			error: `the "rbd" block is only relevant to storage pools of type "rbd"`,
+		},
+		"iscsi without block": {
+			raw:   map[string]interface{}{"type": "iscsi"},
+			error: `the "iscsi" block is required`,
+		},
+		"rbd with path": {
+			raw: map[string]interface{}{
+				"type": "rbd",
+				"path": "/var/lib/pool",
+				"rbd": []interface{}{map[string]interface{}{
+					"monitors": []interface{}{"mon1"},
+					"pool":     "libvirt",
+				}},
+			},
+			error: `"path" attribute is not supported`,
+		},
+		"invalid monitor": {
+			raw: map[string]interface{}{
+				"type": "rbd",
+				"rbd": []interface{}{map[string]interface{}{
+					"monitors": []interface{}{"mon1:6789:1"},
+					"pool":     "libvirt",
+				}},
+			},
+			error: `invalid address 'mon1:6789:1'`,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			test.raw["name"] = "pool"
+			d := schema.TestResourceDataRaw(t, resourceLibvirtPool().Schema, test.raw)
+
+			_, err := newPoolDef(d)
+			if err == nil || !strings.Contains(err.Error(), test.error) {
+				t.Errorf("expected an error containing %q, got %v", test.error, err)
+			}
+		})
+	}
+}
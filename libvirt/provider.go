@@ -1,30 +1,102 @@
 package libvirt
 
 import (
+	"fmt"
 	"log"
+	"strings"
+	"sync"
 
+	uri "github.com/dmacvicar/terraform-provider-libvirt/libvirt/uri"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
 )
 
 // Provider libvirt.
 func Provider() *schema.Provider {
-	return &schema.Provider{
+	p := &schema.Provider{
 		Schema: map[string]*schema.Schema{
 			"uri": {
 				Type:        schema.TypeString,
-				Required:    true,
+				Optional:    true,
 				DefaultFunc: schema.EnvDefaultFunc("LIBVIRT_DEFAULT_URI", nil),
 				Description: "libvirt connection URI for operations. See https://libvirt.org/uri.html",
 			},
+			"hosts": {
+				Type:        schema.TypeList,
+				Optional:    true,
+				Description: "libvirt hosts to place domains and volumes on, instead of the single uri",
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"name": {
+							Type:     schema.TypeString,
+							Required: true,
+						},
+						"uri": {
+							Type:     schema.TypeString,
+							Required: true,
+						},
+					},
+				},
+			},
+			"placement": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Default:     "round_robin",
+				Description: "How domains and volumes without a host are placed on the hosts: " + strings.Join(placementPolicies, " or "),
+			},
+			"private_key": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Sensitive:   true,
+				Description: "PEM encoded SSH private key used instead of the keyfile URI parameter",
+			},
+			"max_concurrent_operations": {
+				Type:        schema.TypeInt,
+				Optional:    true,
+				Default:     0,
+				Description: "Maximum number of operations running at once on each host, 0 for no limit",
+			},
+			"retry": {
+				Type:        schema.TypeList,
+				Optional:    true,
+				MaxItems:    1,
+				Description: "How libvirt calls failing with a transient error are retried",
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"attempts": {
+							Type:     schema.TypeInt,
+							Optional: true,
+							Default:  5,
+						},
+						"delay": {
+							Type:     schema.TypeString,
+							Optional: true,
+							Default:  "1s",
+						},
+						"max_delay": {
+							Type:     schema.TypeString,
+							Optional: true,
+							Default:  "30s",
+						},
+						"error_codes": {
+							Type:     schema.TypeList,
+							Optional: true,
+							Elem: &schema.Schema{
+								Type: schema.TypeInt,
+							},
+						},
+					},
+				},
+			},
 		},
 
 		ResourcesMap: map[string]*schema.Resource{
-			"libvirt_domain":         resourceLibvirtDomain(),
-			"libvirt_volume":         resourceLibvirtVolume(),
-			"libvirt_network":        resourceLibvirtNetwork(),
-			"libvirt_pool":           resourceLibvirtPool(),
-			"libvirt_cloudinit_disk": resourceCloudInitDisk(),
-			"libvirt_ignition":       resourceIgnition(),
+			"libvirt_domain":          resourceLibvirtDomain(),
+			"libvirt_domain_snapshot": resourceLibvirtDomainSnapshot(),
+			"libvirt_volume":          resourceLibvirtVolume(),
+			"libvirt_network":         resourceLibvirtNetwork(),
+			"libvirt_pool":            resourceLibvirtPool(),
+			"libvirt_cloudinit_disk":  resourceCloudInitDisk(),
+			"libvirt_ignition":        resourceIgnition(),
 		},
 
 		DataSourcesMap: map[string]*schema.Resource{
@@ -34,35 +106,119 @@ func Provider() *schema.Provider {
 			"libvirt_node_info":                        datasourceLibvirtNodeInfo(),
 			"libvirt_node_device_info":                 datasourceLibvirtNodeDeviceInfo(),
 			"libvirt_node_devices":                     datasourceLibvirtNodeDevices(),
+			"libvirt_domain_snapshot":                  datasourceLibvirtDomainSnapshot(),
+			"libvirt_domain":                           datasourceLibvirtDomain(),
+			"libvirt_domain_guest_info":                datasourceLibvirtDomainGuestInfo(),
+			"libvirt_network":                          datasourceLibvirtNetwork(),
+			"libvirt_pool":                             datasourceLibvirtPool(),
+			"libvirt_volume":                           datasourceLibvirtVolume(),
 		},
 
 		ConfigureFunc: providerConfigure,
 	}
+
+	for _, r := range p.ResourcesMap {
+		throttleOperations(r)
+		retryReadOnReconnect(r)
+	}
+	for _, r := range p.DataSourcesMap {
+		throttleOperations(r)
+		retryReadOnReconnect(r)
+	}
+	placeOnHosts(p.ResourcesMap["libvirt_domain"], domainMemoryDemand, migrateDomain)
+	placeOnHosts(p.ResourcesMap["libvirt_volume"], nil, nil)
+	return p
+}
+
+// config -> client for multi instance support
+// (we share the same client for the same uri and settings).
+var (
+	globalClientMutex sync.Mutex
+	globalClientMap   = make(map[clientKey]*Client)
+)
+
+// clientKey tells apart the configs that cannot share a client: provider
+// instances connecting to the same URI with other settings have their own
+// connection, throttle and retry policy.
+type clientKey struct {
+	uri           string
+	privateKey    string
+	maxOperations int
+	retry         string
 }
 
-// uri -> client for multi instance support
-// (we share the same client for the same uri).
-var globalClientMap = make(map[string]*Client)
+func clientKeyOf(config Config) clientKey {
+	return clientKey{
+		uri:           config.URI,
+		privateKey:    config.PrivateKey,
+		maxOperations: config.MaxConcurrentOperations,
+		retry:         fmt.Sprintf("%+v", config.Retry),
+	}
+}
 
-// CleanupLibvirtConnections closes libvirt clients for all URIs.
+// CleanupLibvirtConnections closes libvirt clients for all URIs and writes
+// the connection profiles still queued.
 func CleanupLibvirtConnections() {
-	for uri, client := range globalClientMap {
-		log.Printf("[DEBUG] cleaning up connection for URI: %s", uri)
-		// TODO: Confirm appropriate IsAlive() validation
-		err := client.libvirt.ConnectClose()
+	globalClientMutex.Lock()
+	defer globalClientMutex.Unlock()
+	for key, client := range globalClientMap {
+		log.Printf("[DEBUG] cleaning up connection for URI: %s", key.uri)
+		err := client.conn.Close()
 		if err != nil {
 			log.Printf("[ERROR] cannot close libvirt connection: %v", err)
 		}
 	}
+	uri.FlushProfiles()
 }
 
 func providerConfigure(d *schema.ResourceData) (interface{}, error) {
-	config := Config{
-		URI: d.Get("uri").(string),
+	maxOperations, retry, err := throttleSettings(d)
+	if err != nil {
+		return nil, err
+	}
+	base := Config{
+		PrivateKey:              d.Get("private_key").(string),
+		MaxConcurrentOperations: maxOperations,
+		Retry:                   retry,
+	}
+
+	hosts := d.Get("hosts").([]interface{})
+	if len(hosts) == 0 {
+		config := base
+		config.URI = d.Get("uri").(string)
+		if config.URI == "" {
+			return nil, fmt.Errorf("either \"uri\" or \"hosts\" has to be set")
+		}
+		return configureClient(config)
+	}
+
+	if d.Get("uri").(string) != "" {
+		log.Printf("[WARN] Both uri and hosts are set, uri is not used")
+	}
+	pool, err := newHostPool(hosts, d.Get("placement").(string), base)
+	if err != nil {
+		return nil, err
+	}
+	// the resources that are not placed are on the first host
+	first, err := pool.client(pool.names[0])
+	if err != nil {
+		return nil, err
 	}
-	log.Printf("[DEBUG] Configuring provider for '%s': %v", config.URI, d)
+	client := *first
+	client.hosts = pool
+	return &client, nil
+}
+
+// configureClient returns the client for config, shared by all the provider
+// instances connecting to the same URI with the same settings.
+func configureClient(config Config) (*Client, error) {
+	log.Printf("[DEBUG] Configuring provider for '%s'", config.URI)
+
+	globalClientMutex.Lock()
+	defer globalClientMutex.Unlock()
 
-	if client, ok := globalClientMap[config.URI]; ok {
+	key := clientKeyOf(config)
+	if client, ok := globalClientMap[key]; ok {
 		log.Printf("[DEBUG] Reusing client for uri: '%s'", config.URI)
 		return client, nil
 	}
@@ -71,7 +227,7 @@ func providerConfigure(d *schema.ResourceData) (interface{}, error) {
 	if err != nil {
 		return nil, err
 	}
-	globalClientMap[config.URI] = client
+	globalClientMap[key] = client
 
 	return client, nil
 }
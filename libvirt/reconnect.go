@@ -0,0 +1,48 @@
+package libvirt
+
+import (
+	"context"
+	"errors"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+var errReadFailed = errors.New("read failed")
+
+// retryReadOnReconnect makes the read of r run once more when the connection
+// to libvirt was lost during it, as soon as it is established again. Reads
+// only query libvirt, so repeating them is safe, unlike the other
+// operations, which might have taken effect before the connection was lost.
+func retryReadOnReconnect(r *schema.Resource) {
+	if read := r.Read; read != nil {
+		r.Read = func(d *schema.ResourceData, meta interface{}) error {
+			client, ok := meta.(*Client)
+			if !ok || client.conn == nil {
+				return read(d, meta)
+			}
+			return client.conn.RetryIdempotent(func() error {
+				return read(d, meta)
+			})
+		}
+	}
+
+	if read := r.ReadContext; read != nil {
+		r.ReadContext = func(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+			client, ok := meta.(*Client)
+			if !ok || client.conn == nil {
+				return read(ctx, d, meta)
+			}
+			var diags diag.Diagnostics
+			// the failure is in diags
+			_ = client.conn.RetryIdempotent(func() error {
+				diags = read(ctx, d, meta)
+				if diags.HasError() {
+					return errReadFailed
+				}
+				return nil
+			})
+			return diags
+		}
+	}
+}
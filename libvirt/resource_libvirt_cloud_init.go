@@ -3,6 +3,7 @@ package libvirt
 import (
 	"context"
 	"log"
+	"time"
 
 	libvirt "github.com/digitalocean/go-libvirt"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
@@ -14,6 +15,15 @@ func resourceCloudInitDisk() *schema.Resource {
 		CreateContext: resourceCloudInitDiskCreate,
 		ReadContext:   resourceCloudInitDiskRead,
 		DeleteContext: resourceCloudInitDiskDelete,
+		Importer: &schema.ResourceImporter{
+			StateContext: resourceLibvirtCloudInitDiskImport,
+		},
+		Timeouts: &schema.ResourceTimeout{
+			//nolint:gomnd
+			Create: schema.DefaultTimeout(5 * time.Minute),
+			//nolint:gomnd
+			Delete: schema.DefaultTimeout(5 * time.Minute),
+		},
 		Schema: map[string]*schema.Schema{
 			"name": {
 				Type:     schema.TypeString,
@@ -66,7 +76,7 @@ func resourceCloudInitDiskCreate(ctx context.Context, d *schema.ResourceData, me
 	if err != nil {
 		return diag.FromErr(err)
 	}
-	key, err := cloudInit.UploadIso(client, iso)
+	key, err := cloudInit.UploadIso(ctx, client, iso)
 	if err != nil {
 		return diag.FromErr(err)
 	}
@@ -3,6 +3,7 @@ package libvirt
 import (
 	"context"
 	"log"
+	"time"
 
 	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
@@ -13,6 +14,12 @@ func resourceIgnition() *schema.Resource {
 		CreateContext: resourceIgnitionCreate,
 		ReadContext:   resourceIgnitionRead,
 		DeleteContext: resourceIgnitionDelete,
+		Timeouts: &schema.ResourceTimeout{
+			//nolint:gomnd
+			Create: schema.DefaultTimeout(5 * time.Minute),
+			//nolint:gomnd
+			Delete: schema.DefaultTimeout(5 * time.Minute),
+		},
 		Schema: map[string]*schema.Schema{
 			"name": {
 				Type:     schema.TypeString,
@@ -49,7 +56,7 @@ func resourceIgnitionCreate(ctx context.Context, d *schema.ResourceData, meta in
 
 	log.Printf("[INFO] ignition: %+v", ignition)
 
-	key, err := ignition.CreateAndUpload(client)
+	key, err := ignition.CreateAndUpload(ctx, client)
 	if err != nil {
 		return diag.FromErr(err)
 	}
@@ -15,6 +15,7 @@ import (
 	libvirt "github.com/digitalocean/go-libvirt"
 	"github.com/dmacvicar/terraform-provider-libvirt/libvirt/helper/suppress"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/customdiff"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
 	"libvirt.org/go/libvirtxml"
 )
@@ -35,14 +36,51 @@ func resourceLibvirtDomain() *schema.Resource {
 		ReadContext:   resourceLibvirtDomainRead,
 		DeleteContext: resourceLibvirtDomainDelete,
 		UpdateContext: resourceLibvirtDomainUpdate,
+		CustomizeDiff: customdiff.All(customizeDiffDomainDevices, customizeDiffDomainTuning, customizeDiffDomainWaitForGuest),
 		Importer: &schema.ResourceImporter{
-			StateContext: schema.ImportStatePassthroughContext,
+			StateContext: resourceLibvirtDomainImport,
 		},
 		Timeouts: &schema.ResourceTimeout{
 			//nolint:gomnd
 			Create: schema.DefaultTimeout(5 * time.Minute),
+			//nolint:gomnd
+			Update: schema.DefaultTimeout(5 * time.Minute),
+			//nolint:gomnd
+			Delete: schema.DefaultTimeout(5 * time.Minute),
 		},
 		Schema: map[string]*schema.Schema{
+			"host": {
+				Type:     schema.TypeString,
+				Optional: true,
+				Computed: true,
+			},
+			"migration": {
+				Type:     schema.TypeList,
+				Optional: true,
+				MaxItems: 1,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"live": {
+							Type:     schema.TypeBool,
+							Optional: true,
+							Default:  true,
+						},
+						"tunnelled": {
+							Type:     schema.TypeBool,
+							Optional: true,
+						},
+						"copy_storage": {
+							Type:     schema.TypeBool,
+							Optional: true,
+						},
+						"bandwidth": {
+							Type:        schema.TypeInt,
+							Optional:    true,
+							Description: "Maximum bandwidth of the migration, in MiB/s",
+						},
+					},
+				},
+			},
 			"name": {
 				Type:     schema.TypeString,
 				Required: true,
@@ -107,6 +145,22 @@ func resourceLibvirtDomain() *schema.Resource {
 					},
 				},
 			},
+			"secure_boot": {
+				Type:     schema.TypeList,
+				Optional: true,
+				ForceNew: true,
+				MaxItems: 1,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"enrolled_keys": {
+							Type:     schema.TypeBool,
+							Optional: true,
+							ForceNew: true,
+							Default:  true,
+						},
+					},
+				},
+			},
 			"running": {
 				Type:     schema.TypeBool,
 				Optional: true,
@@ -158,10 +212,53 @@ func resourceLibvirtDomain() *schema.Resource {
 					},
 				},
 			},
-			"disk": {
+			"hostdev": {
 				Type:     schema.TypeList,
 				Optional: true,
 				ForceNew: true,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"type": {
+							Type:     schema.TypeString,
+							Required: true,
+						},
+						"address": {
+							Type:     schema.TypeString,
+							Optional: true,
+						},
+						"managed": {
+							Type:     schema.TypeBool,
+							Optional: true,
+							Default:  true,
+						},
+						"vendor": {
+							Type:     schema.TypeString,
+							Optional: true,
+						},
+						"product": {
+							Type:     schema.TypeString,
+							Optional: true,
+						},
+						"uuid": {
+							Type:     schema.TypeString,
+							Optional: true,
+						},
+						"model": {
+							Type:     schema.TypeString,
+							Optional: true,
+							Default:  "vfio-pci",
+						},
+						"display": {
+							Type:     schema.TypeBool,
+							Optional: true,
+							Default:  false,
+						},
+					},
+				},
+			},
+			"disk": {
+				Type:     schema.TypeList,
+				Optional: true,
 				Elem: &schema.Resource{
 					Schema: map[string]*schema.Schema{
 						"volume_id": {
@@ -289,6 +386,33 @@ func resourceLibvirtDomain() *schema.Resource {
 							Type:     schema.TypeInt,
 							Optional: true,
 						},
+						"port": {
+							Type:     schema.TypeInt,
+							Optional: true,
+							Computed: true,
+							ForceNew: true,
+						},
+						"tls": {
+							Type:     schema.TypeBool,
+							Optional: true,
+							ForceNew: true,
+						},
+						"tls_port": {
+							Type:     schema.TypeInt,
+							Optional: true,
+							Computed: true,
+							ForceNew: true,
+						},
+						"password": {
+							Type:      schema.TypeString,
+							Optional:  true,
+							Sensitive: true,
+							ForceNew:  true,
+						},
+						"websocket_port": {
+							Type:     schema.TypeInt,
+							Computed: true,
+						},
 					},
 				},
 			},
@@ -362,6 +486,119 @@ func resourceLibvirtDomain() *schema.Resource {
 					},
 				},
 			},
+			"cputune": {
+				Type:     schema.TypeList,
+				Optional: true,
+				ForceNew: true,
+				MaxItems: 1,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"vcpupin": {
+							Type:     schema.TypeList,
+							Optional: true,
+							ForceNew: true,
+							Elem: &schema.Resource{
+								Schema: map[string]*schema.Schema{
+									"vcpu": {
+										Type:     schema.TypeInt,
+										Required: true,
+										ForceNew: true,
+									},
+									"cpuset": {
+										Type:     schema.TypeString,
+										Required: true,
+										ForceNew: true,
+									},
+								},
+							},
+						},
+						"emulatorpin": {
+							Type:     schema.TypeString,
+							Optional: true,
+							ForceNew: true,
+						},
+					},
+				},
+			},
+			"numatune": {
+				Type:     schema.TypeList,
+				Optional: true,
+				ForceNew: true,
+				MaxItems: 1,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"mode": {
+							Type:     schema.TypeString,
+							Optional: true,
+							ForceNew: true,
+							Default:  "strict",
+						},
+						"nodeset": {
+							Type:     schema.TypeString,
+							Required: true,
+							ForceNew: true,
+						},
+					},
+				},
+			},
+			"numa_cell": {
+				Type:     schema.TypeList,
+				Optional: true,
+				ForceNew: true,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"id": {
+							Type:     schema.TypeInt,
+							Required: true,
+							ForceNew: true,
+						},
+						"cpus": {
+							Type:     schema.TypeString,
+							Required: true,
+							ForceNew: true,
+						},
+						"memory": {
+							Type:     schema.TypeInt,
+							Required: true,
+							ForceNew: true,
+						},
+					},
+				},
+			},
+			"memory_backing": {
+				Type:     schema.TypeList,
+				Optional: true,
+				ForceNew: true,
+				MaxItems: 1,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"hugepages": {
+							Type:     schema.TypeList,
+							Optional: true,
+							ForceNew: true,
+							Elem: &schema.Resource{
+								Schema: map[string]*schema.Schema{
+									"size": {
+										Type:     schema.TypeInt,
+										Required: true,
+										ForceNew: true,
+									},
+									"nodeset": {
+										Type:     schema.TypeString,
+										Optional: true,
+										ForceNew: true,
+									},
+								},
+							},
+						},
+						"locked": {
+							Type:     schema.TypeBool,
+							Optional: true,
+							ForceNew: true,
+						},
+					},
+				},
+			},
 			"autostart": {
 				Type:     schema.TypeBool,
 				Optional: true,
@@ -427,6 +664,46 @@ func resourceLibvirtDomain() *schema.Resource {
 				Default:  false,
 				ForceNew: false,
 			},
+			"wait_for_guest": {
+				Type:     schema.TypeList,
+				Optional: true,
+				MaxItems: 1,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"addresses": {
+							Type:     schema.TypeBool,
+							Optional: true,
+							Default:  true,
+						},
+						"hostname": {
+							Type:     schema.TypeString,
+							Optional: true,
+						},
+						"command": {
+							Type:     schema.TypeList,
+							Optional: true,
+							Elem: &schema.Schema{
+								Type: schema.TypeString,
+							},
+						},
+						"timeout": {
+							Type:     schema.TypeString,
+							Optional: true,
+						},
+						"exclude_interfaces": {
+							Type:     schema.TypeList,
+							Optional: true,
+							Elem: &schema.Schema{
+								Type: schema.TypeString,
+							},
+						},
+						"prefer_family": {
+							Type:     schema.TypeString,
+							Optional: true,
+						},
+					},
+				},
+			},
 			"tpm": {
 				Type:     schema.TypeList,
 				Optional: true,
@@ -554,9 +831,12 @@ func resourceLibvirtDomainCreate(ctx context.Context, d *schema.ResourceData, me
 	setVideo(d, &domainDef)
 	setConsoles(d, &domainDef)
 	setCmdlineArgs(d, &domainDef)
-	setFirmware(d, &domainDef)
+	if err := setFirmware(d, &domainDef); err != nil {
+		return diag.FromErr(err)
+	}
 	setBootDevices(d, &domainDef)
 	setTPMs(d, &domainDef)
+	setTuning(d, &domainDef)
 
 	if err := setCoreOSIgnition(d, &domainDef, arch); err != nil {
 		return diag.FromErr(err)
@@ -570,6 +850,10 @@ func resourceLibvirtDomainCreate(ctx context.Context, d *schema.ResourceData, me
 		return diag.FromErr(err)
 	}
 
+	if err := setHostdevs(d, &domainDef, virConn); err != nil {
+		return diag.FromErr(err)
+	}
+
 	if err := setCloudinit(d, &domainDef, virConn); err != nil {
 		return diag.FromErr(err)
 	}
@@ -577,7 +861,7 @@ func resourceLibvirtDomainCreate(ctx context.Context, d *schema.ResourceData, me
 	var waitForLeases []*libvirtxml.DomainInterface
 	partialNetIfaces := make(map[string]*pendingMapping, d.Get("network_interface.#").(int))
 
-	if err := setNetworkInterfaces(d, &domainDef, virConn, partialNetIfaces, &waitForLeases); err != nil {
+	if err := setNetworkInterfaces(ctx, d, &domainDef, virConn, partialNetIfaces, &waitForLeases); err != nil {
 		return diag.FromErr(err)
 	}
 
@@ -598,7 +882,11 @@ func resourceLibvirtDomainCreate(ctx context.Context, d *schema.ResourceData, me
 		return diag.Errorf("error applying XSLT stylesheet: %s", err)
 	}
 
-	domain, err := virConn.DomainDefineXML(data)
+	var domain libvirt.Domain
+	err = retryLibvirt(ctx, virConn, func() (err error) {
+		domain, err = virConn.DomainDefineXML(data)
+		return err
+	})
 	if err != nil {
 		return diag.Errorf("error defining libvirt domain: %s", err)
 	}
@@ -608,13 +896,17 @@ func resourceLibvirtDomainCreate(ctx context.Context, d *schema.ResourceData, me
 		if autostart.(bool) {
 			autostartInt = 1
 		}
-		err = virConn.DomainSetAutostart(domain, autostartInt)
+		err = retryLibvirt(ctx, virConn, func() error {
+			return virConn.DomainSetAutostart(domain, autostartInt)
+		})
 		if err != nil {
 			return diag.Errorf("error setting autostart for domain: %s", err)
 		}
 	}
 
-	err = virConn.DomainCreate(domain)
+	err = retryLibvirt(ctx, virConn, func() error {
+		return virConn.DomainCreate(domain)
+	})
 	if err != nil {
 		return diag.Errorf("error creating libvirt domain: %s", err)
 	}
@@ -624,7 +916,10 @@ func resourceLibvirtDomainCreate(ctx context.Context, d *schema.ResourceData, me
 	log.Printf("[INFO] Domain ID: %s", d.Id())
 
 	if len(waitForLeases) > 0 {
-		err = domainWaitForLeases(ctx, virConn, domain, waitForLeases, d.Timeout(schema.TimeoutCreate), d)
+		// waiting on the guest does not hold an operation slot
+		err = meta.(*Client).throttle.outside(func() error {
+			return domainWaitForLeases(ctx, virConn, domain, waitForLeases, d.Timeout(schema.TimeoutCreate), d)
+		})
 		if err != nil {
 			ipNotFoundMsg := "Please check following: \n" +
 				"1) is the domain running properly? \n" +
@@ -640,6 +935,12 @@ func resourceLibvirtDomainCreate(ctx context.Context, d *schema.ResourceData, me
 		}
 	}
 
+	if err := meta.(*Client).throttle.outside(func() error {
+		return domainWaitForGuest(ctx, virConn, domain, d, d.Timeout(schema.TimeoutCreate))
+	}); err != nil {
+		return diag.FromErr(err)
+	}
+
 	// We save runnig state to not mix what we have and what we want
 	requiredStatus := d.Get("running")
 
@@ -650,39 +951,9 @@ func resourceLibvirtDomainCreate(ctx context.Context, d *schema.ResourceData, me
 	d.Set("running", requiredStatus)
 
 	// we must read devices again in order to set some missing ip/MAC/host mappings
-	for i := 0; i < d.Get("network_interface.#").(int); i++ {
-		prefix := fmt.Sprintf("network_interface.%d", i)
-		mac := strings.ToUpper(d.Get(prefix + ".mac").(string))
-		log.Printf("[DEBUG] Reading network_interface.%d with MAC: %s\n", i, mac)
-
-		// if we were waiting for an IP address for this MAC, go ahead.
-		if pending, ok := partialNetIfaces[mac]; ok {
-			// we should have the address now
-			addressesI, ok := d.GetOk(prefix + ".addresses")
-			if !ok {
-				log.Printf("Did not obtain the IP address for MAC=%s", mac)
-				continue
-			}
-
-			network, err := virConn.NetworkLookupByName(pending.networkName)
-			if err != nil {
-				log.Printf("Can't retrieve network '%s'", pending.networkName)
-				continue
-			}
-
-			for _, addressI := range addressesI.([]interface{}) {
-				address := addressI.(string)
-				log.Printf("[INFO] Finally adding IP/MAC/host=%s/%s/%s", address, mac, pending.hostname)
+	addPendingHostMappings(ctx, virConn, d, partialNetIfaces)
 
-				err = updateOrAddHost(virConn, network, address, mac, pending.hostname)
-				if err != nil {
-					log.Printf("Could not add IP/MAC/host=%s/%s/%s: %s", address, mac, pending.hostname, err)
-				}
-			}
-		}
-	}
-
-	if err := destroyDomainByUserRequest(virConn, d, domain); err != nil {
+	if err := destroyDomainByUserRequest(ctx, virConn, d, domain); err != nil {
 		return diag.FromErr(err)
 	}
 
@@ -710,7 +981,9 @@ func resourceLibvirtDomainUpdate(ctx context.Context, d *schema.ResourceData, me
 	}
 
 	if !domainRunningNow {
-		err = virConn.DomainCreate(domain)
+		err = retryLibvirt(ctx, virConn, func() error {
+			return virConn.DomainCreate(domain)
+		})
 		if err != nil {
 			return diag.Errorf("error creating libvirt domain: %s", err)
 		}
@@ -746,7 +1019,9 @@ func resourceLibvirtDomainUpdate(ctx context.Context, d *schema.ResourceData, me
 			autoStart = 1
 		}
 
-		err = virConn.DomainSetAutostart(domain, autoStart)
+		err = retryLibvirt(ctx, virConn, func() error {
+			return virConn.DomainSetAutostart(domain, autoStart)
+		})
 		if err != nil {
 			return diag.Errorf("error setting autostart for domain: %s", err)
 		}
@@ -754,13 +1029,25 @@ func resourceLibvirtDomainUpdate(ctx context.Context, d *schema.ResourceData, me
 
 	if d.HasChange("memory") {
 		memory := uint64(d.Get("memory").(int) * 1024)
-		err = virConn.DomainSetMemory(domain, memory)
+		err = retryLibvirt(ctx, virConn, func() error {
+			return virConn.DomainSetMemory(domain, memory)
+		})
 		if err != nil {
 			return diag.Errorf("error change memory: %s", err)
 		}
 	}
 
-	netIfacesCount := d.Get("network_interface.#").(int)
+	waitForLeases, partialNetIfaces, err := updateDomainDevices(ctx, virConn, d, domain)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
+	// the interfaces attached above got their mappings already
+	oldNetIfaces, _ := d.GetChange("network_interface")
+	netIfacesCount := len(oldNetIfaces.([]interface{}))
+	if count := d.Get("network_interface.#").(int); count < netIfacesCount {
+		netIfacesCount = count
+	}
 
 	for i := 0; i < netIfacesCount; i++ {
 		prefix := fmt.Sprintf("network_interface.%d", i)
@@ -791,13 +1078,43 @@ func resourceLibvirtDomainUpdate(ctx context.Context, d *schema.ResourceData, me
 
 				log.Printf("[INFO] Updating IP/MAC/host=%s/%s/%s in '%s' network", ip.String(), mac, hostname, network.Name)
 
-				if err := updateOrAddHost(virConn, network, ip.String(), mac, hostname); err != nil {
+				if err := updateOrAddHost(ctx, virConn, network, ip.String(), mac, hostname); err != nil {
 					return diag.FromErr(err)
 				}
 			}
 		}
 	}
 
+	if !d.HasChange("disk") && !d.HasChange("network_interface") {
+		return nil
+	}
+
+	if len(waitForLeases) > 0 {
+		// waiting on the guest does not hold an operation slot
+		err = meta.(*Client).throttle.outside(func() error {
+			return domainWaitForLeases(ctx, virConn, domain, waitForLeases, d.Timeout(schema.TimeoutUpdate), d)
+		})
+		if err != nil {
+			return diag.Errorf("couldn't retrieve IP address of the network interfaces attached to domain id: %s: %s", d.Id(), err)
+		}
+	}
+
+	if err := meta.(*Client).throttle.outside(func() error {
+		return domainWaitForGuest(ctx, virConn, domain, d, d.Timeout(schema.TimeoutUpdate))
+	}); err != nil {
+		return diag.FromErr(err)
+	}
+
+	requiredStatus := d.Get("running")
+
+	if diag := resourceLibvirtDomainRead(ctx, d, meta); diag.HasError() {
+		return diag
+	}
+
+	d.Set("running", requiredStatus)
+
+	addPendingHostMappings(ctx, virConn, d, partialNetIfaces)
+
 	return nil
 }
 
@@ -869,11 +1186,17 @@ func resourceLibvirtDomainRead(ctx context.Context, d *schema.ResourceData, meta
 		return diag.Errorf("invalid max_memory unit : %s", domainDef.Memory.Unit)
 	}
 
-	if domainDef.OS.Loader != nil {
+	// libvirt fills the loader and the NVRAM of the firmwares it selects,
+	// the NVRAM is only read when it was given then
+	firmwareAutoselected := domainDef.OS.Firmware != ""
+	if firmwareAutoselected {
+		d.Set("firmware", domainDef.OS.Firmware)
+	} else if domainDef.OS.Loader != nil {
 		d.Set("firmware", domainDef.OS.Loader.Path)
 	}
+	d.Set("secure_boot", readSecureBoot(d, domainDef))
 
-	if domainDef.OS.NVRam != nil {
+	if _, ok := d.GetOk("nvram.0"); domainDef.OS.NVRam != nil && (!firmwareAutoselected || ok) {
 		nvram := map[string]interface{}{}
 		if domainDef.OS.NVRam.NVRam != "" {
 			nvram["file"] = domainDef.OS.NVRam.NVRam
@@ -926,6 +1249,11 @@ func resourceLibvirtDomainRead(ctx context.Context, d *schema.ResourceData, meta
 		disk  map[string]interface{}
 	)
 	for _, diskDef := range domainDef.Devices.Disks {
+		// an empty drive, like a cdrom without a medium
+		if diskDef.Source == nil {
+			continue
+		}
+
 		// network drives do not have a volume associated
 		if diskDef.Source.Network != nil {
 			if len(diskDef.Source.Network.Hosts) < 1 {
@@ -949,6 +1277,10 @@ func resourceLibvirtDomainRead(ctx context.Context, d *schema.ResourceData, meta
 				continue
 			}
 
+			if diskDef.Source.File == nil {
+				continue
+			}
+
 			disk = map[string]interface{}{
 				"file": diskDef.Source.File.File,
 			}
@@ -961,12 +1293,18 @@ func resourceLibvirtDomainRead(ctx context.Context, d *schema.ResourceData, meta
 			// by the diskdef.Source.Volume once we realized it existed.
 			// This code will be removed in future versions of the provider.
 			virVol, err := virConn.StorageVolLookupByPath(diskDef.Source.File.File)
-			if err != nil {
+			if isError(err, libvirt.ErrNoStorageVol) {
+				// a file outside of the pools, as domains that were not
+				// created by the provider may have
+				disk = map[string]interface{}{
+					"file": diskDef.Source.File.File,
+				}
+			} else if err != nil {
 				return diag.Errorf("error retrieving volume for disk: %s", err)
-			}
-
-			disk = map[string]interface{}{
-				"volume_id": virVol.Key,
+			} else {
+				disk = map[string]interface{}{
+					"volume_id": virVol.Key,
+				}
 			}
 		} else {
 			pool, err := virConn.StoragePoolLookupByName(diskDef.Source.Volume.Pool)
@@ -1017,24 +1355,32 @@ func resourceLibvirtDomainRead(ctx context.Context, d *schema.ResourceData, meta
 		d.Set("filesystem", filesystems)
 	}
 
+	if hostdevs := readHostdevs(d, domainDef); len(hostdevs) > 0 {
+		d.Set("hostdev", hostdevs)
+	}
+
+	readTuning(d, domainDef)
+
+	if graphics := readGraphics(d, domainDef); len(graphics) > 0 {
+		d.Set("graphics", graphics)
+	}
+
 	// lookup interfaces with addresses
 	ifacesWithAddr, err := domainGetIfacesInfo(virConn, domain, d)
 	if err != nil {
 		return diag.Errorf("error retrieving interface addresses: %s", err)
 	}
 
+	// the addresses of the guest are filtered as it is waited for
+	guestFilter, err := domainGuestAddressFilter(d)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
 	addressesForMac := func(mac string) []string {
 		// look for an ip address and try to match it with the mac address
 		// not sure if using the target device name is a better idea here
-		var addrs []string
-		for _, ifaceWithAddr := range ifacesWithAddr {
-			if len(ifaceWithAddr.Hwaddr) > 0 && strings.ToUpper(ifaceWithAddr.Hwaddr[0]) == mac {
-				for _, addr := range ifaceWithAddr.Addrs {
-					addrs = append(addrs, addr.Addr)
-				}
-			}
-		}
-		return addrs
+		return guestFilter.addresses(ifacesWithAddr, mac)
 	}
 
 	var netIfaces []map[string]interface{}
@@ -1113,7 +1459,14 @@ func resourceLibvirtDomainRead(ctx context.Context, d *schema.ResourceData, meta
 		d.Set("network_interface", netIfaces)
 	}
 
-	if len(ifacesWithAddr) > 0 {
+	if guestFilter != nil {
+		if addrs := guestFilter.addresses(ifacesWithAddr, ""); len(addrs) > 0 {
+			d.SetConnInfo(map[string]string{
+				"type": "ssh",
+				"host": addrs[0],
+			})
+		}
+	} else if len(ifacesWithAddr) > 0 {
 		d.SetConnInfo(map[string]string{
 			"type": "ssh",
 			"host": ifacesWithAddr[0].Addrs[0].Addr,
@@ -1160,18 +1513,24 @@ func resourceLibvirtDomainDelete(ctx context.Context, d *schema.ResourceData, me
 	}
 
 	if state == int32(libvirt.DomainRunning) || state == int32(libvirt.DomainPaused) {
-		if err := virConn.DomainDestroy(domain); err != nil {
+		if err := retryLibvirt(ctx, virConn, func() error {
+			return virConn.DomainDestroy(domain)
+		}); err != nil {
 			return diag.Errorf("couldn't destroy libvirt domain: %s", err)
 		}
 	}
 
-	if err := virConn.DomainUndefineFlags(domain, libvirt.DomainUndefineNvram|
-		libvirt.DomainUndefineSnapshotsMetadata|libvirt.DomainUndefineManagedSave|
-		libvirt.DomainUndefineCheckpointsMetadata); err != nil {
+	if err := retryLibvirt(ctx, virConn, func() error {
+		return virConn.DomainUndefineFlags(domain, libvirt.DomainUndefineNvram|
+			libvirt.DomainUndefineSnapshotsMetadata|libvirt.DomainUndefineManagedSave|
+			libvirt.DomainUndefineCheckpointsMetadata)
+	}); err != nil {
 
 		if isError(err, libvirt.ErrNoSupport) || isError(err, libvirt.ErrInvalidArg) {
 			log.Printf("libvirt does not support undefine flags: will try again without flags")
-			if err := virConn.DomainUndefine(domain); err != nil {
+			if err := retryLibvirt(ctx, virConn, func() error {
+				return virConn.DomainUndefine(domain)
+			}); err != nil {
 				return diag.Errorf("couldn't undefine libvirt domain: %s", err)
 			}
 		} else {
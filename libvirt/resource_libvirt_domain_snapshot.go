@@ -0,0 +1,235 @@
+package libvirt
+
+import (
+	"context"
+	"log"
+	"time"
+
+	libvirt "github.com/digitalocean/go-libvirt"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+func resourceLibvirtDomainSnapshot() *schema.Resource {
+	return &schema.Resource{
+		CreateContext: resourceLibvirtDomainSnapshotCreate,
+		ReadContext:   resourceLibvirtDomainSnapshotRead,
+		UpdateContext: resourceLibvirtDomainSnapshotUpdate,
+		DeleteContext: resourceLibvirtDomainSnapshotDelete,
+		Timeouts: &schema.ResourceTimeout{
+			//nolint:gomnd
+			Create: schema.DefaultTimeout(5 * time.Minute),
+			//nolint:gomnd
+			Delete: schema.DefaultTimeout(5 * time.Minute),
+		},
+		Schema: map[string]*schema.Schema{
+			"domain_id": {
+				Type:     schema.TypeString,
+				Required: true,
+				ForceNew: true,
+			},
+			"name": {
+				Type:     schema.TypeString,
+				Optional: true,
+				Computed: true,
+				ForceNew: true,
+			},
+			"description": {
+				Type:     schema.TypeString,
+				Optional: true,
+				ForceNew: true,
+			},
+			"external": {
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  false,
+				ForceNew: true,
+			},
+			"memory": {
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  false,
+				ForceNew: true,
+			},
+			"memory_file": {
+				Type:     schema.TypeString,
+				Optional: true,
+				ForceNew: true,
+			},
+			"revert_on_destroy": {
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  false,
+			},
+			"revert_trigger": {
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+			"parent": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"children": {
+				Type:     schema.TypeList,
+				Computed: true,
+				Elem: &schema.Schema{
+					Type: schema.TypeString,
+				},
+			},
+			"state": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"creation_time": {
+				Type:     schema.TypeInt,
+				Computed: true,
+			},
+			"current": {
+				Type:     schema.TypeBool,
+				Computed: true,
+			},
+			"xml": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+		},
+		Importer: &schema.ResourceImporter{
+			StateContext: schema.ImportStatePassthroughContext,
+		},
+	}
+}
+
+func resourceLibvirtDomainSnapshotCreate(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	virConn := meta.(*Client).libvirt
+	if virConn == nil {
+		return diag.Errorf(LibVirtConIsNil)
+	}
+
+	domainUUID := d.Get("domain_id").(string)
+	domain, err := virConn.DomainLookupByUUID(parseUUID(domainUUID))
+	if err != nil {
+		return diag.Errorf("error retrieving libvirt domain %s: %s", domainUUID, err)
+	}
+
+	snapshotDef, flags, err := newDomainSnapshotDef(
+		d.Get("name").(string),
+		d.Get("description").(string),
+		d.Get("external").(bool),
+		d.Get("memory").(bool),
+		d.Get("memory_file").(string))
+	if err != nil {
+		return diag.FromErr(err)
+	}
+	data, err := xmlMarshallIndented(snapshotDef)
+	if err != nil {
+		return diag.Errorf("error serializing libvirt domain snapshot: %s", err)
+	}
+	log.Printf("[DEBUG] Generated XML for libvirt domain snapshot:\n%s", data)
+
+	var snapshot libvirt.DomainSnapshot
+	err = retryLibvirt(ctx, virConn, func() (err error) {
+		snapshot, err = virConn.DomainSnapshotCreateXML(domain, data, flags)
+		return err
+	})
+	if err != nil {
+		return diag.Errorf("error creating snapshot of libvirt domain %s: %s", domain.Name, err)
+	}
+	d.SetId(domainSnapshotID(domainUUID, snapshot.Name))
+	log.Printf("[INFO] Domain snapshot ID: %s", d.Id())
+
+	return resourceLibvirtDomainSnapshotRead(ctx, d, meta)
+}
+
+func resourceLibvirtDomainSnapshotRead(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	virConn := meta.(*Client).libvirt
+	if virConn == nil {
+		return diag.Errorf(LibVirtConIsNil)
+	}
+
+	snapshot, err := lookupDomainSnapshot(virConn, d.Id())
+	if err != nil {
+		if isError(err, libvirt.ErrNoDomain) || isError(err, libvirt.ErrNoDomainSnapshot) {
+			d.SetId("")
+			return nil
+		}
+		return diag.FromErr(err)
+	}
+
+	snapshotDef, err := setDomainSnapshotAttributes(virConn, d, snapshot)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
+	d.Set("external", isExternalDomainSnapshot(snapshotDef))
+	memory := snapshotDef.Memory != nil && snapshotDef.Memory.Snapshot != "no"
+	d.Set("memory", memory)
+	if memory && snapshotDef.Memory.File != "" {
+		d.Set("memory_file", snapshotDef.Memory.File)
+	}
+
+	return nil
+}
+
+func resourceLibvirtDomainSnapshotUpdate(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	virConn := meta.(*Client).libvirt
+	if virConn == nil {
+		return diag.Errorf(LibVirtConIsNil)
+	}
+
+	if d.HasChange("revert_trigger") {
+		snapshot, err := lookupDomainSnapshot(virConn, d.Id())
+		if err != nil {
+			return diag.FromErr(err)
+		}
+		log.Printf("[INFO] Reverting domain %s to snapshot %s", snapshot.Dom.Name, snapshot.Name)
+		if err := retryLibvirt(ctx, virConn, func() error {
+			return virConn.DomainRevertToSnapshot(snapshot, 0)
+		}); err != nil {
+			return diag.Errorf("error reverting libvirt domain %s to snapshot %s: %s", snapshot.Dom.Name, snapshot.Name, err)
+		}
+	}
+
+	return resourceLibvirtDomainSnapshotRead(ctx, d, meta)
+}
+
+func resourceLibvirtDomainSnapshotDelete(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	virConn := meta.(*Client).libvirt
+	if virConn == nil {
+		return diag.Errorf(LibVirtConIsNil)
+	}
+
+	snapshot, err := lookupDomainSnapshot(virConn, d.Id())
+	if err != nil {
+		if isError(err, libvirt.ErrNoDomain) || isError(err, libvirt.ErrNoDomainSnapshot) {
+			return nil
+		}
+		return diag.FromErr(err)
+	}
+
+	if d.Get("revert_on_destroy").(bool) {
+		log.Printf("[INFO] Reverting domain %s to snapshot %s before deleting it", snapshot.Dom.Name, snapshot.Name)
+		if err := retryLibvirt(ctx, virConn, func() error {
+			return virConn.DomainRevertToSnapshot(snapshot, 0)
+		}); err != nil {
+			return diag.Errorf("error reverting libvirt domain %s to snapshot %s: %s", snapshot.Dom.Name, snapshot.Name, err)
+		}
+	}
+
+	err = retryLibvirt(ctx, virConn, func() error {
+		return virConn.DomainSnapshotDelete(snapshot, 0)
+	})
+	// libvirt before 9.0 cannot delete external snapshots, which then
+	// leave their overlay images behind
+	if err != nil && d.Get("external").(bool) &&
+		(isError(err, libvirt.ErrConfigUnsupported) || isError(err, libvirt.ErrOperationUnsupported)) {
+		log.Printf("[WARN] libvirt cannot delete external snapshot %s of domain %s (%s), deleting its metadata only: "+
+			"its overlay images and memory file are left in place", snapshot.Name, snapshot.Dom.Name, err)
+		err = retryLibvirt(ctx, virConn, func() error {
+			return virConn.DomainSnapshotDelete(snapshot, libvirt.DomainSnapshotDeleteMetadataOnly)
+		})
+	}
+	if err != nil {
+		return diag.Errorf("error deleting snapshot %s of libvirt domain %s: %s", snapshot.Name, snapshot.Dom.Name, err)
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package libvirt
+
+import (
+	"fmt"
+	"testing"
+
+	libvirt "github.com/digitalocean/go-libvirt"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/acctest"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+)
+
+func testAccCheckLibvirtDomainSnapshotExists(name string, snapshot *libvirt.DomainSnapshot) resource.TestCheckFunc {
+	return func(state *terraform.State) error {
+		rs, err := getResourceFromTerraformState(name, state)
+		if err != nil {
+			return err
+		}
+
+		virConn := testAccProvider.Meta().(*Client).libvirt
+
+		retrievedSnapshot, err := lookupDomainSnapshot(virConn, rs.Primary.ID)
+		if err != nil {
+			return err
+		}
+
+		*snapshot = retrievedSnapshot
+
+		return nil
+	}
+}
+
+func testAccCheckLibvirtDomainSnapshotDestroy(s *terraform.State) error {
+	virConn := testAccProvider.Meta().(*Client).libvirt
+	for _, rs := range s.RootModule().Resources {
+		if rs.Type != "libvirt_domain_snapshot" {
+			continue
+		}
+		_, err := lookupDomainSnapshot(virConn, rs.Primary.ID)
+		if err == nil {
+			return fmt.Errorf("Error waiting for domain snapshot (%s) to be destroyed", rs.Primary.ID)
+		}
+	}
+
+	return nil
+}
+
+func TestAccLibvirtDomainSnapshot_Basic(t *testing.T) {
+	var snapshot libvirt.DomainSnapshot
+	randomDomainName := acctest.RandStringFromCharSet(10, acctest.CharSetAlpha)
+	randomVolumeName := acctest.RandStringFromCharSet(10, acctest.CharSetAlpha)
+
+	config := func(revertTrigger string) string {
+		return fmt.Sprintf(`
+		resource "libvirt_volume" "%s" {
+			name = "%s"
+			size = 1073741824
+			format = "qcow2"
+		}
+
+		resource "libvirt_domain" "%s" {
+			name = "%s"
+			running = false
+			disk {
+				volume_id = libvirt_volume.%s.id
+			}
+		}
+
+		resource "libvirt_domain_snapshot" "first" {
+			domain_id   = libvirt_domain.%s.id
+			name        = "first"
+			description = "before the changes"
+			revert_trigger = "%s"
+		}
+
+		resource "libvirt_domain_snapshot" "second" {
+			domain_id = libvirt_domain.%s.id
+			name      = "second"
+			depends_on = [libvirt_domain_snapshot.first]
+		}
+
+		data "libvirt_domain_snapshot" "first" {
+			domain_id  = libvirt_domain.%s.id
+			name       = "first"
+			depends_on = [libvirt_domain_snapshot.second]
+		}`, randomVolumeName, randomVolumeName, randomDomainName, randomDomainName, randomVolumeName,
+			randomDomainName, revertTrigger, randomDomainName, randomDomainName)
+	}
+
+	resource.Test(t, resource.TestCase{
+		PreCheck:     func() { testAccPreCheck(t) },
+		Providers:    testAccProviders,
+		CheckDestroy: testAccCheckLibvirtDomainSnapshotDestroy,
+		Steps: []resource.TestStep{
+			{
+				Config: config(""),
+				Check: resource.ComposeTestCheckFunc(
+					testAccCheckLibvirtDomainSnapshotExists("libvirt_domain_snapshot.first", &snapshot),
+					resource.TestCheckResourceAttr("libvirt_domain_snapshot.first", "description", "before the changes"),
+					resource.TestCheckResourceAttr("libvirt_domain_snapshot.first", "external", "false"),
+					resource.TestCheckResourceAttr("libvirt_domain_snapshot.first", "state", "shutoff"),
+					resource.TestCheckResourceAttr("libvirt_domain_snapshot.second", "parent", "first"),
+					resource.TestCheckResourceAttr("libvirt_domain_snapshot.second", "current", "true"),
+					resource.TestCheckResourceAttr("data.libvirt_domain_snapshot.first", "children.#", "1"),
+					resource.TestCheckResourceAttr("data.libvirt_domain_snapshot.first", "children.0", "second"),
+				),
+			},
+			{
+				// changing revert_trigger reverts the domain to the snapshot
+				Config: config("1"),
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("libvirt_domain_snapshot.first", "current", "true"),
+				),
+			},
+			{
+				ResourceName:            "libvirt_domain_snapshot.first",
+				ImportState:             true,
+				ImportStateVerify:       true,
+				ImportStateVerifyIgnore: []string{"revert_trigger", "current"},
+			},
+		},
+	})
+}
@@ -226,6 +226,88 @@ func TestAccLibvirtDomain_VolumeTwoDisks(t *testing.T) {
 	})
 }
 
+func TestAccLibvirtDomain_VolumeHotplug(t *testing.T) {
+	var domain libvirt.Domain
+	var domainID string
+
+	randomVolumeName := acctest.RandStringFromCharSet(10, acctest.CharSetAlpha)
+	randomVolumeName2 := acctest.RandStringFromCharSet(10, acctest.CharSetAlpha)
+	randomDomainName := acctest.RandStringFromCharSet(10, acctest.CharSetAlpha)
+	randomPoolName := acctest.RandStringFromCharSet(10, acctest.CharSetAlpha)
+	randomPoolPath := "/tmp/terraform-provider-libvirt-pool-" + randomPoolName
+
+	var configVolumes = fmt.Sprintf(`
+    resource "libvirt_pool" "%s" {
+        name = "%s"
+        type = "dir"
+        path = "%s"
+    }
+
+	resource "libvirt_volume" "%s" {
+		name = "%s"
+        pool = "${libvirt_pool.%s.name}"
+	}
+
+	resource "libvirt_volume" "%s" {
+		name = "%s"
+        pool = "${libvirt_pool.%s.name}"
+	}`, randomPoolName, randomPoolName, randomPoolPath, randomVolumeName, randomVolumeName, randomPoolName, randomVolumeName2, randomVolumeName2, randomPoolName)
+
+	var configOneDisk = configVolumes + fmt.Sprintf(`
+	resource "libvirt_domain" "%s" {
+		name = "%s"
+		disk {
+			volume_id = "${libvirt_volume.%s.id}"
+		}
+	}`, randomDomainName, randomDomainName, randomVolumeName)
+
+	var configTwoDisks = configVolumes + fmt.Sprintf(`
+	resource "libvirt_domain" "%s" {
+		name = "%s"
+		disk {
+			volume_id = "${libvirt_volume.%s.id}"
+		}
+
+		disk {
+			volume_id = "${libvirt_volume.%s.id}"
+		}
+	}`, randomDomainName, randomDomainName, randomVolumeName, randomVolumeName2)
+
+	resource.Test(t, resource.TestCase{
+		PreCheck:     func() { testAccPreCheck(t) },
+		Providers:    testAccProviders,
+		CheckDestroy: testAccCheckLibvirtDomainDestroy,
+		Steps: []resource.TestStep{
+			{
+				Config: configOneDisk,
+				Check: resource.ComposeTestCheckFunc(
+					testAccCheckLibvirtDomainExists("libvirt_domain."+randomDomainName, &domain),
+					func(state *terraform.State) error {
+						domainID = uuidString(domain.UUID)
+						return nil
+					},
+				),
+			},
+			{
+				Config: configTwoDisks,
+				Check: resource.ComposeTestCheckFunc(
+					testAccCheckLibvirtDomainExists("libvirt_domain."+randomDomainName, &domain),
+					resource.TestCheckResourceAttrPtr("libvirt_domain."+randomDomainName, "id", &domainID),
+					resource.TestCheckResourceAttr("libvirt_domain."+randomDomainName, "disk.#", "2"),
+				),
+			},
+			{
+				Config: configOneDisk,
+				Check: resource.ComposeTestCheckFunc(
+					testAccCheckLibvirtDomainExists("libvirt_domain."+randomDomainName, &domain),
+					resource.TestCheckResourceAttrPtr("libvirt_domain."+randomDomainName, "id", &domainID),
+					resource.TestCheckResourceAttr("libvirt_domain."+randomDomainName, "disk.#", "1"),
+				),
+			},
+		},
+	})
+}
+
 // tests that disk driver is set correctly for the volume format.
 func TestAccLibvirtDomain_VolumeDriver(t *testing.T) {
 	var domain libvirt.Domain
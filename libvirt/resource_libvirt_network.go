@@ -4,8 +4,8 @@ import (
 	"context"
 	"fmt"
 	"log"
-	"net"
 	"strings"
+	"time"
 
 	libvirt "github.com/digitalocean/go-libvirt"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
@@ -46,7 +46,15 @@ func resourceLibvirtNetwork() *schema.Resource {
 		DeleteContext: resourceLibvirtNetworkDelete,
 		UpdateContext: resourceLibvirtNetworkUpdate,
 		Importer: &schema.ResourceImporter{
-			StateContext: schema.ImportStatePassthroughContext,
+			StateContext: resourceLibvirtNetworkImport,
+		},
+		Timeouts: &schema.ResourceTimeout{
+			//nolint:gomnd
+			Create: schema.DefaultTimeout(5 * time.Minute),
+			//nolint:gomnd
+			Update: schema.DefaultTimeout(5 * time.Minute),
+			//nolint:gomnd
+			Delete: schema.DefaultTimeout(5 * time.Minute),
 		},
 		Schema: map[string]*schema.Schema{
 			"name": {
@@ -132,7 +140,6 @@ func resourceLibvirtNetwork() *schema.Resource {
 						"srvs": {
 							Type:     schema.TypeList,
 							Optional: true,
-							ForceNew: true,
 							Elem: &schema.Resource{
 								Schema: map[string]*schema.Schema{
 									"service": {
@@ -141,7 +148,6 @@ func resourceLibvirtNetwork() *schema.Resource {
 										// and therefore doesn't recognize that this is set when assigning from
 										// a rendered dns_host template.
 										Optional: true,
-										ForceNew: true,
 									},
 									"protocol": {
 										Type: schema.TypeString,
@@ -149,33 +155,43 @@ func resourceLibvirtNetwork() *schema.Resource {
 										// and therefore doesn't recognize that this is set when assigning from
 										// a rendered dns_host template.
 										Optional: true,
-										ForceNew: true,
 									},
 									"domain": {
 										Type:     schema.TypeString,
 										Optional: true,
 										Required: false,
-										ForceNew: true,
 									},
 									"target": {
 										Type:     schema.TypeString,
 										Optional: true,
-										ForceNew: true,
 									},
 									"port": {
 										Type:     schema.TypeString,
 										Optional: true,
-										ForceNew: true,
 									},
 									"weight": {
 										Type:     schema.TypeString,
 										Optional: true,
-										ForceNew: true,
 									},
 									"priority": {
 										Type:     schema.TypeString,
 										Optional: true,
-										ForceNew: true,
+									},
+								},
+							},
+						},
+						"txts": {
+							Type:     schema.TypeList,
+							Optional: true,
+							Elem: &schema.Resource{
+								Schema: map[string]*schema.Schema{
+									"name": {
+										Type:     schema.TypeString,
+										Optional: true,
+									},
+									"value": {
+										Type:     schema.TypeString,
+										Optional: true,
 									},
 								},
 							},
@@ -206,6 +222,26 @@ func resourceLibvirtNetwork() *schema.Resource {
 					},
 				},
 			},
+			"dhcp_host": {
+				Type:     schema.TypeSet,
+				Optional: true,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"mac": {
+							Type:     schema.TypeString,
+							Required: true,
+						},
+						"ip": {
+							Type:     schema.TypeString,
+							Required: true,
+						},
+						"hostname": {
+							Type:     schema.TypeString,
+							Optional: true,
+						},
+					},
+				},
+			},
 			"dhcp": {
 				Type:     schema.TypeList,
 				Optional: true,
@@ -317,24 +353,38 @@ func resourceLibvirtNetworkUpdate(ctx context.Context, d *schema.ResourceData, m
 
 	if activeInt != 1 {
 		log.Printf("[DEBUG] Activating network %s", network.Name)
-		if err := virConn.NetworkCreate(network); err != nil {
+		if err := retryLibvirt(ctx, virConn, func() error {
+			return virConn.NetworkCreate(network)
+		}); err != nil {
 			return diag.Errorf("error when activating network %s during update: %s", network.Name, err)
 		}
 	}
 
 	if d.HasChange("autostart") {
-		err = virConn.NetworkSetAutostart(network, bool2int(d.Get("autostart").(bool)))
+		err = retryLibvirt(ctx, virConn, func() error {
+			return virConn.NetworkSetAutostart(network, bool2int(d.Get("autostart").(bool)))
+		})
 		if err != nil {
 			return diag.Errorf("error updating autostart for network %s: %s", network.Name, err)
 		}
 	}
 
 	// detect changes in the DNS entries in this network
-	err = updateDNSHosts(d, meta, network)
+	err = updateDNSHosts(ctx, d, meta, network)
 	if err != nil {
 		return diag.Errorf("error updating DNS hosts for network %s: %s", network.Name, err)
 	}
 
+	err = updateDNSSRVsAndTXTs(ctx, d, meta, network)
+	if err != nil {
+		return diag.Errorf("error updating DNS records for network %s: %s", network.Name, err)
+	}
+
+	err = updateDHCPHosts(ctx, d, meta, network)
+	if err != nil {
+		return diag.Errorf("error updating DHCP hosts for network %s: %s", network.Name, err)
+	}
+
 	return nil
 }
 
@@ -377,6 +427,9 @@ func resourceLibvirtNetworkCreate(ctx context.Context, d *schema.ResourceData, m
 		if err != nil {
 			return diag.Errorf("could not set DHCP from adresses '%s'", err)
 		}
+		if err := getDHCPHostsFromResource(d, ips); err != nil {
+			return diag.FromErr(err)
+		}
 		networkDef.IPs = ips
 
 		dnsEnabled := getDNSEnableFromResource(d)
@@ -396,11 +449,17 @@ func resourceLibvirtNetworkCreate(ctx context.Context, d *schema.ResourceData, m
 			return diag.FromErr(err)
 		}
 
+		dnsTXTs, err := getDNSTXTsFromResource(d)
+		if err != nil {
+			return diag.FromErr(err)
+		}
+
 		dns := libvirtxml.NetworkDNS{
 			Enable:     dnsEnabled,
 			Forwarders: dnsForwarders,
 			Host:       dnsHosts,
 			SRVs:       dnsSRVs,
+			TXTs:       dnsTXTs,
 		}
 		networkDef.DNS = &dns
 
@@ -447,24 +506,35 @@ func resourceLibvirtNetworkCreate(ctx context.Context, d *schema.ResourceData, m
 		defer meta.(*Client).networkMutex.Unlock()
 
 		log.Printf("[DEBUG] creating libvirt network: %s", data)
-		return virConn.NetworkDefineXML(data)
+		var network libvirt.Network
+		err := retryLibvirt(ctx, virConn, func() (err error) {
+			network, err = virConn.NetworkDefineXML(data)
+			return err
+		})
+		return network, err
 	}()
 
 	if err != nil {
 		return diag.Errorf("error defining libvirt network: %s - %s", err, data)
 	}
 
-	err = virConn.NetworkCreate(network)
+	err = retryLibvirt(ctx, virConn, func() error {
+		return virConn.NetworkCreate(network)
+	})
 	if err != nil {
 		// in some cases, the network creation fails but an artifact is created
 		// an 'broken network". Remove the network in case of failure
 		// see https://github.com/dmacvicar/terraform-provider-libvirt/issues/739
 		// don't handle the error for destroying
-		if err := virConn.NetworkDestroy(network); err != nil {
+		if err := retryLibvirt(ctx, virConn, func() error {
+			return virConn.NetworkDestroy(network)
+		}); err != nil {
 			log.Printf("[WARNING] %v", err)
 		}
 
-		if err := virConn.NetworkUndefine(network); err != nil {
+		if err := retryLibvirt(ctx, virConn, func() error {
+			return virConn.NetworkUndefine(network)
+		}); err != nil {
 			log.Printf("[WARNING] %v", err)
 		}
 
@@ -489,7 +559,9 @@ func resourceLibvirtNetworkCreate(ctx context.Context, d *schema.ResourceData, m
 	}
 
 	if autostart, ok := d.GetOk("autostart"); ok {
-		err = virConn.NetworkSetAutostart(network, bool2int(autostart.(bool)))
+		err = retryLibvirt(ctx, virConn, func() error {
+			return virConn.NetworkSetAutostart(network, bool2int(autostart.(bool)))
+		})
 		if err != nil {
 			return diag.Errorf("error setting autostart for network: %s", err)
 		}
@@ -551,23 +623,9 @@ func resourceLibvirtNetworkRead(ctx context.Context, d *schema.ResourceData, met
 	d.Set("autostart", autostart > 0)
 
 	// read add the IP addresses
-	addresses := []string{}
-	//nolint:gomnd
-	for _, address := range networkDef.IPs {
-		// we get the host interface IP (ie, 10.10.8.1) but we want the network CIDR (ie, 10.10.8.0/24)
-		// so we need some transformations...
-		addr := net.ParseIP(address.Address)
-		if addr == nil {
-			return diag.Errorf("error parsing IP '%s': %s", address.Address, err)
-		}
-		bits := net.IPv6len * 8
-		if addr.To4() != nil {
-			bits = net.IPv4len * 8
-		}
-
-		mask := net.CIDRMask(int(address.Prefix), bits)
-		network := addr.Mask(mask)
-		addresses = append(addresses, fmt.Sprintf("%s/%d", network, address.Prefix))
+	addresses, err := getNetworkAddresses(networkDef)
+	if err != nil {
+		return diag.FromErr(err)
 	}
 	if len(addresses) > 0 {
 		d.Set("addresses", addresses)
@@ -630,11 +688,27 @@ func resourceLibvirtNetworkRead(ctx context.Context, d *schema.ResourceData, met
 			dnsBlock["hosts"] = hostsBlock
 		}
 
+		var txtsBlock []map[string]interface{}
+		for _, txt := range networkDef.DNS.TXTs {
+			txtsBlock = append(txtsBlock, map[string]interface{}{
+				"name":  txt.Name,
+				"value": txt.Value,
+			})
+		}
+
+		if len(txtsBlock) > 0 {
+			dnsBlock["txts"] = txtsBlock
+		}
+
 		if len(dnsBlock) > 0 {
 			d.Set("dns", []map[string]interface{}{dnsBlock})
 		}
 	}
 
+	if err := readDHCPHosts(d, networkDef); err != nil {
+		return diag.FromErr(err)
+	}
+
 	// and the static routes
 	var routesBlock []map[string]interface{}
 	for _, route := range networkDef.Routes {
@@ -680,16 +754,22 @@ func resourceLibvirtNetworkDelete(ctx context.Context, d *schema.ResourceData, m
 	// network can be in 2 states, handles this case by case
 	if active := int2bool(int(activeInt)); active {
 		// network is active, so we need to destroy it and undefine it
-		if err := virConn.NetworkDestroy(network); err != nil {
+		if err := retryLibvirt(ctx, virConn, func() error {
+			return virConn.NetworkDestroy(network)
+		}); err != nil {
 			return diag.Errorf("when destroying libvirt network: %s", err)
 		}
 
-		if err := virConn.NetworkUndefine(network); err != nil {
+		if err := retryLibvirt(ctx, virConn, func() error {
+			return virConn.NetworkUndefine(network)
+		}); err != nil {
 			return diag.Errorf("couldn't undefine libvirt network: %s", err)
 		}
 	} else {
 		// in case network is inactive just undefine it
-		if err := virConn.NetworkUndefine(network); err != nil {
+		if err := retryLibvirt(ctx, virConn, func() error {
+			return virConn.NetworkUndefine(network)
+		}); err != nil {
 			return diag.Errorf("couldn't undefine libvirt network: %s", err)
 		}
 	}
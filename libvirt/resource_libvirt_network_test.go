@@ -320,6 +320,127 @@ func TestAccLibvirtNetwork_DNSHosts(t *testing.T) {
 	})
 }
 
+func TestAccLibvirtNetwork_DHCPHosts(t *testing.T) {
+	skipIfPrivilegedDisabled(t)
+
+	randomNetworkResource := acctest.RandStringFromCharSet(10, acctest.CharSetAlpha)
+	randomNetworkName := acctest.RandStringFromCharSet(10, acctest.CharSetAlpha)
+	resourceName := "libvirt_network." + randomNetworkResource
+	resource.Test(t, resource.TestCase{
+		PreCheck:     func() { testAccPreCheck(t) },
+		Providers:    testAccProviders,
+		CheckDestroy: testAccCheckLibvirtNetworkDestroy,
+		Steps: []resource.TestStep{
+			{
+				Config: fmt.Sprintf(`
+				resource "libvirt_network" "%s" {
+					name      = "%s"
+					addresses = ["10.17.3.0/24"]
+					dhcp_host {
+						mac      = "52:54:00:6c:3c:01"
+						ip       = "10.17.3.10"
+						hostname = "node1"
+					}
+				}`, randomNetworkResource, randomNetworkName),
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr(resourceName, "dhcp_host.#", "1"),
+					resource.TestCheckTypeSetElemNestedAttrs(
+						resourceName, "dhcp_host.*", map[string]string{"mac": "52:54:00:6c:3c:01", "ip": "10.17.3.10", "hostname": "node1"}),
+				),
+			},
+			{
+				Config: fmt.Sprintf(`
+				resource "libvirt_network" "%s" {
+					name      = "%s"
+					addresses = ["10.17.3.0/24"]
+					dhcp_host {
+						mac      = "52:54:00:6c:3c:01"
+						ip       = "10.17.3.11"
+						hostname = "node1"
+					}
+					dhcp_host {
+						mac = "52:54:00:6c:3c:02"
+						ip  = "10.17.3.12"
+					}
+				}`, randomNetworkResource, randomNetworkName),
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr(resourceName, "dhcp_host.#", "2"),
+					resource.TestCheckTypeSetElemNestedAttrs(
+						resourceName, "dhcp_host.*", map[string]string{"mac": "52:54:00:6c:3c:01", "ip": "10.17.3.11"}),
+					resource.TestCheckTypeSetElemNestedAttrs(
+						resourceName, "dhcp_host.*", map[string]string{"mac": "52:54:00:6c:3c:02", "ip": "10.17.3.12"}),
+				),
+			},
+		},
+	})
+}
+
+func TestAccLibvirtNetwork_DNSRecords(t *testing.T) {
+	skipIfPrivilegedDisabled(t)
+
+	randomNetworkResource := acctest.RandStringFromCharSet(10, acctest.CharSetAlpha)
+	randomNetworkName := acctest.RandStringFromCharSet(10, acctest.CharSetAlpha)
+	resourceName := "libvirt_network." + randomNetworkResource
+	resource.Test(t, resource.TestCase{
+		PreCheck:     func() { testAccPreCheck(t) },
+		Providers:    testAccProviders,
+		CheckDestroy: testAccCheckLibvirtNetworkDestroy,
+		Steps: []resource.TestStep{
+			{
+				Config: fmt.Sprintf(`
+				resource "libvirt_network" "%s" {
+					name      = "%s"
+					domain    = "k8s.local"
+					addresses = ["10.17.3.0/24"]
+					dns {
+						srvs {
+							service  = "etcd"
+							protocol = "tcp"
+							domain   = "k8s.local"
+							target   = "node1.k8s.local"
+							port     = "2379"
+						}
+						txts {
+							name  = "cluster"
+							value = "k8s"
+						}
+					}
+				}`, randomNetworkResource, randomNetworkName),
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr(resourceName, "dns.0.srvs.0.service", "etcd"),
+					resource.TestCheckResourceAttr(resourceName, "dns.0.txts.0.name", "cluster"),
+					resource.TestCheckResourceAttr(resourceName, "dns.0.txts.0.value", "k8s"),
+				),
+			},
+			{
+				Config: fmt.Sprintf(`
+				resource "libvirt_network" "%s" {
+					name      = "%s"
+					domain    = "k8s.local"
+					addresses = ["10.17.3.0/24"]
+					dns {
+						srvs {
+							service  = "etcd"
+							protocol = "tcp"
+							domain   = "k8s.local"
+							target   = "node2.k8s.local"
+							port     = "2379"
+						}
+						txts {
+							name  = "cluster"
+							value = "k8s-2"
+						}
+					}
+				}`, randomNetworkResource, randomNetworkName),
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr(resourceName, "dns.0.srvs.0.target", "node2.k8s.local"),
+					resource.TestCheckResourceAttr(resourceName, "dns.0.txts.0.value", "k8s-2"),
+				),
+			},
+		},
+	})
+}
+
 func TestAccLibvirtNetwork_Import(t *testing.T) {
 	skipIfPrivilegedDisabled(t)
 
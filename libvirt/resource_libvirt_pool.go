@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/xml"
 	"log"
+	"time"
 
 	libvirt "github.com/digitalocean/go-libvirt"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
@@ -16,6 +17,12 @@ func resourceLibvirtPool() *schema.Resource {
 		CreateContext: resourceLibvirtPoolCreate,
 		ReadContext:   resourceLibvirtPoolRead,
 		DeleteContext: resourceLibvirtPoolDelete,
+		Timeouts: &schema.ResourceTimeout{
+			//nolint:gomnd
+			Create: schema.DefaultTimeout(5 * time.Minute),
+			//nolint:gomnd
+			Delete: schema.DefaultTimeout(5 * time.Minute),
+		},
 		Schema: map[string]*schema.Schema{
 			"name": {
 				Type:     schema.TypeString,
@@ -65,11 +72,139 @@ func resourceLibvirtPool() *schema.Resource {
 			"path": {
 				Type:     schema.TypeString,
 				Optional: true,
+				Computed: true,
+				ForceNew: true,
+			},
+
+			// Type-specific sources
+			"logical": {
+				Type:     schema.TypeList,
+				Optional: true,
+				Computed: true,
+				MaxItems: 1,
+				ForceNew: true,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"volume_group": {
+							Type:     schema.TypeString,
+							Optional: true,
+							Computed: true,
+							ForceNew: true,
+						},
+						"devices": {
+							Type:     schema.TypeList,
+							Optional: true,
+							ForceNew: true,
+							Elem: &schema.Schema{
+								Type: schema.TypeString,
+							},
+						},
+					},
+				},
+			},
+			"iscsi": {
+				Type:     schema.TypeList,
+				Optional: true,
+				Computed: true,
+				MaxItems: 1,
+				ForceNew: true,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"portal": {
+							Type:     schema.TypeString,
+							Required: true,
+							ForceNew: true,
+						},
+						"target": {
+							Type:     schema.TypeString,
+							Required: true,
+							ForceNew: true,
+						},
+						"initiator": {
+							Type:     schema.TypeString,
+							Optional: true,
+							ForceNew: true,
+						},
+						"username": {
+							Type:     schema.TypeString,
+							Optional: true,
+							ForceNew: true,
+						},
+						"secret_uuid": {
+							Type:         schema.TypeString,
+							Optional:     true,
+							ForceNew:     true,
+							RequiredWith: []string{"iscsi.0.username"},
+						},
+					},
+				},
+			},
+			"netfs": {
+				Type:     schema.TypeList,
+				Optional: true,
+				Computed: true,
+				MaxItems: 1,
+				ForceNew: true,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"host": {
+							Type:     schema.TypeString,
+							Required: true,
+							ForceNew: true,
+						},
+						"export": {
+							Type:     schema.TypeString,
+							Required: true,
+							ForceNew: true,
+						},
+						"format": {
+							Type:     schema.TypeString,
+							Optional: true,
+							Default:  "nfs",
+							ForceNew: true,
+						},
+					},
+				},
+			},
+			"rbd": {
+				Type:     schema.TypeList,
+				Optional: true,
+				Computed: true,
+				MaxItems: 1,
 				ForceNew: true,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"monitors": {
+							Type:     schema.TypeList,
+							Required: true,
+							ForceNew: true,
+							MinItems: 1,
+							Elem: &schema.Schema{
+								Type: schema.TypeString,
+							},
+						},
+						"pool": {
+							Type:     schema.TypeString,
+							Required: true,
+							ForceNew: true,
+						},
+						"username": {
+							Type:     schema.TypeString,
+							Optional: true,
+							ForceNew: true,
+						},
+						"secret_uuid": {
+							Type:         schema.TypeString,
+							Optional:     true,
+							ForceNew:     true,
+							RequiredWith: []string{"rbd.0.username"},
+						},
+					},
+				},
 			},
 		},
 		Importer: &schema.ResourceImporter{
-			StateContext: schema.ImportStatePassthroughContext,
+			StateContext: resourceLibvirtPoolImport,
 		},
 	}
 }
@@ -81,9 +216,9 @@ func resourceLibvirtPoolCreate(ctx context.Context, d *schema.ResourceData, meta
 		return diag.Errorf(LibVirtConIsNil)
 	}
 
-	poolType := d.Get("type").(string)
-	if poolType != "dir" {
-		return diag.Errorf("only storage pools of type \"dir\" are supported")
+	poolDef, err := newPoolDef(d)
+	if err != nil {
+		return diag.FromErr(err)
 	}
 
 	poolName := d.Get("name").(string)
@@ -98,18 +233,6 @@ func resourceLibvirtPoolCreate(ctx context.Context, d *schema.ResourceData, meta
 	}
 	log.Printf("[DEBUG] Pool with name '%s' does not exist yet", poolName)
 
-	poolPath := d.Get("path").(string)
-	if poolPath == "" {
-		return diag.Errorf("\"path\" attribute is requires for storage pools of type \"dir\"")
-	}
-
-	poolDef := libvirtxml.StoragePool{
-		Type: "dir",
-		Name: poolName,
-		Target: &libvirtxml.StoragePoolTarget{
-			Path: poolPath,
-		},
-	}
 	data, err := xmlMarshallIndented(poolDef)
 	if err != nil {
 		return diag.Errorf("error serializing libvirt storage pool: %s", err)
@@ -122,27 +245,42 @@ func resourceLibvirtPoolCreate(ctx context.Context, d *schema.ResourceData, meta
 	}
 
 	// create the pool
-	pool, err := virConn.StoragePoolDefineXML(data, 0)
+	var pool libvirt.StoragePool
+	err = retryLibvirt(ctx, virConn, func() (err error) {
+		pool, err = virConn.StoragePoolDefineXML(data, 0)
+		return err
+	})
 	if err != nil {
 		return diag.Errorf("error creating libvirt storage pool: %s", err)
 	}
 
-	err = virConn.StoragePoolBuild(pool, 0)
-	if err != nil {
-		return diag.Errorf("error building libvirt storage pool: %s", err)
+	// pools on existing storage have nothing to build
+	if poolBuilt(d) {
+		err = retryLibvirt(ctx, virConn, func() error {
+			return virConn.StoragePoolBuild(pool, 0)
+		})
+		if err != nil {
+			return diag.Errorf("error building libvirt storage pool: %s", err)
+		}
 	}
 
-	err = virConn.StoragePoolSetAutostart(pool, 1)
+	err = retryLibvirt(ctx, virConn, func() error {
+		return virConn.StoragePoolSetAutostart(pool, 1)
+	})
 	if err != nil {
 		return diag.Errorf("error setting up libvirt storage pool: %s", err)
 	}
 
-	err = virConn.StoragePoolCreate(pool, 0)
+	err = retryLibvirt(ctx, virConn, func() error {
+		return virConn.StoragePoolCreate(pool, 0)
+	})
 	if err != nil {
 		return diag.Errorf("error starting libvirt storage pool: %s", err)
 	}
 
-	err = virConn.StoragePoolRefresh(pool, 0)
+	err = retryLibvirt(ctx, virConn, func() error {
+		return virConn.StoragePoolRefresh(pool, 0)
+	})
 	if err != nil {
 		return diag.Errorf("error refreshing libvirt storage pool: %s", err)
 	}
@@ -221,6 +359,10 @@ func resourceLibvirtPoolRead(ctx context.Context, d *schema.ResourceData, meta i
 		d.Set("type", poolType)
 	}
 
+	if err := setPoolSourceAttributes(d, poolDef); err != nil {
+		return diag.Errorf("error setting the source of pool %s: %s", pool.Name, err)
+	}
+
 	return nil
 }
 
@@ -255,18 +397,27 @@ func resourceLibvirtPoolDelete(ctx context.Context, d *schema.ResourceData, meta
 	}
 
 	if state != uint8(libvirt.StoragePoolInactive) {
-		err := virConn.StoragePoolDestroy(pool)
+		err := retryLibvirt(ctx, virConn, func() error {
+			return virConn.StoragePoolDestroy(pool)
+		})
 		if err != nil {
 			return diag.Errorf("error deleting storage pool: %s", err)
 		}
 	}
 
-	err = virConn.StoragePoolDelete(pool, 0)
-	if err != nil {
-		return diag.Errorf("error deleting storage pool: %s", err)
+	// only delete what was built, the storage of the other pools is not ours
+	if poolBuilt(d) {
+		err = retryLibvirt(ctx, virConn, func() error {
+			return virConn.StoragePoolDelete(pool, 0)
+		})
+		if err != nil {
+			return diag.Errorf("error deleting storage pool: %s", err)
+		}
 	}
 
-	err = virConn.StoragePoolUndefine(pool)
+	err = retryLibvirt(ctx, virConn, func() error {
+		return virConn.StoragePoolUndefine(pool)
+	})
 	if err != nil {
 		return diag.Errorf("error deleting storage pool: %s", err)
 	}
@@ -2,8 +2,11 @@ package libvirt
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"log"
+	"time"
 
 	libvirt "github.com/digitalocean/go-libvirt"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
@@ -15,8 +18,22 @@ func resourceLibvirtVolume() *schema.Resource {
 	return &schema.Resource{
 		CreateContext: resourceLibvirtVolumeCreate,
 		ReadContext:   resourceLibvirtVolumeRead,
+		UpdateContext: resourceLibvirtVolumeUpdate,
 		DeleteContext: resourceLibvirtVolumeDelete,
+		CustomizeDiff: customizeDiffVolumeSize,
+		Timeouts: &schema.ResourceTimeout{
+			//nolint:gomnd
+			Create: schema.DefaultTimeout(20 * time.Minute),
+			//nolint:gomnd
+			Delete: schema.DefaultTimeout(5 * time.Minute),
+		},
 		Schema: map[string]*schema.Schema{
+			"host": {
+				Type:     schema.TypeString,
+				Optional: true,
+				Computed: true,
+				ForceNew: true,
+			},
 			"name": {
 				Type:     schema.TypeString,
 				Required: true,
@@ -33,11 +50,30 @@ func resourceLibvirtVolume() *schema.Resource {
 				Optional: true,
 				ForceNew: true,
 			},
+			"source_sha256": {
+				Type:     schema.TypeString,
+				Optional: true,
+				ForceNew: true,
+			},
+			"upload_parallelism": {
+				Type:     schema.TypeInt,
+				Optional: true,
+				Default:  defaultUploadParallelism,
+			},
 			"size": {
 				Type:     schema.TypeInt,
 				Optional: true,
 				Computed: true,
-				ForceNew: true,
+			},
+			"allow_shrink": {
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  false,
+			},
+			"resize_domains": {
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  true,
 			},
 			"format": {
 				Type:     schema.TypeString,
@@ -82,7 +118,7 @@ func resourceLibvirtVolume() *schema.Resource {
 			},
 		},
 		Importer: &schema.ResourceImporter{
-			StateContext: schema.ImportStatePassthroughContext,
+			StateContext: resourceLibvirtVolumeImport,
 		},
 	}
 }
@@ -143,6 +179,12 @@ func resourceLibvirtVolumeCreate(ctx context.Context, d *schema.ResourceData, me
 			return diag.Errorf("'base_volume_name' can't be specified when also 'source' is given")
 		}
 
+		if sum, ok := d.GetOk("source_sha256"); ok {
+			if b, err := hex.DecodeString(sum.(string)); err != nil || len(b) != sha256.Size {
+				return diag.Errorf("'source_sha256' must be a SHA-256 checksum in hexadecimal, got '%s'", sum)
+			}
+		}
+
 		if img, err = newImage(source.(string)); err != nil {
 			return diag.FromErr(err)
 		}
@@ -171,6 +213,9 @@ func resourceLibvirtVolumeCreate(ctx context.Context, d *schema.ResourceData, me
 		volumeDef.Capacity.Value = size
 	} else {
 		// the volume does not have a source image to upload
+		if _, ok := d.GetOk("source_sha256"); ok {
+			return diag.Errorf("'source_sha256' can only be specified along with 'source'")
+		}
 
 		// if size is given, set it to the specified value
 		if _, ok := d.GetOk("size"); ok {
@@ -245,8 +290,14 @@ be smaller than the backing store specified with
 		return diag.Errorf("error applying XSLT stylesheet: %s", err)
 	}
 
-	volume, err := virConn.StorageVolCreateXML(pool, data, 0)
+	created := true
+	var volume libvirt.StorageVol
+	err = retryLibvirt(ctx, virConn, func() (err error) {
+		volume, err = virConn.StorageVolCreateXML(pool, data, 0)
+		return err
+	})
 	if err != nil {
+		created = false
 		if !isError(err, libvirt.ErrStorageVolExist) {
 			return diag.Errorf("error creating libvirt volume: %s", err)
 		}
@@ -264,7 +315,14 @@ be smaller than the backing store specified with
 
 	// upload source if present
 	if _, ok := d.GetOk("source"); ok {
-		err = img.Import(newCopier(virConn, &volume, volumeDef.Capacity.Value), volumeDef)
+		uploadOptions := volumeUploadOptions{
+			parallelism: d.Get("upload_parallelism").(int),
+			sha256:      d.Get("source_sha256").(string),
+			// the chunks of zeros can be skipped when nothing was written
+			// to the volume yet
+			sparse: created && poolVolumesReadZeros(virConn, pool),
+		}
+		err = img.Import(newVolumeUploader(ctx, virConn, &volume, volumeDef.Capacity.Value, uploadOptions), volumeDef)
 		if err != nil {
 			//  don't save volume ID  in case of error. This will taint the volume after.
 			// If we don't throw away the id, we will keep instead a broken volume.
@@ -319,7 +377,9 @@ func resourceLibvirtVolumeRead(ctx context.Context, d *schema.ResourceData, meta
 			return resource.NonRetryableError(lookupErr)
 		}
 
-		if err := virConn.StoragePoolCreate(volPool, 0); err != nil {
+		if err := retryLibvirt(ctx, virConn, func() error {
+			return virConn.StoragePoolCreate(volPool, 0)
+		}); err != nil {
 			return resource.NonRetryableError(fmt.Errorf("error starting pool %s: %w", poolName, err))
 		}
 
@@ -370,6 +430,36 @@ func resourceLibvirtVolumeRead(ctx context.Context, d *schema.ResourceData, meta
 	return nil
 }
 
+// resourceLibvirtVolumeUpdate resizes a volume resource in place.
+func resourceLibvirtVolumeUpdate(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	client := meta.(*Client)
+	if client.libvirt == nil {
+		return diag.Errorf(LibVirtConIsNil)
+	}
+
+	if d.HasChange("size") {
+		o, n := d.GetChange("size")
+		if err := volumeResize(ctx, client, d.Id(), uint64(n.(int)), n.(int) < o.(int), d.Get("resize_domains").(bool)); err != nil {
+			return diag.FromErr(err)
+		}
+	}
+
+	return resourceLibvirtVolumeRead(ctx, d, meta)
+}
+
+// customizeDiffVolumeSize rejects shrinking a volume, which loses the data at
+// its end, unless allow_shrink is set.
+func customizeDiffVolumeSize(ctx context.Context, d *schema.ResourceDiff, meta interface{}) error {
+	if d.Id() == "" || !d.HasChange("size") || !d.NewValueKnown("size") {
+		return nil
+	}
+	o, n := d.GetChange("size")
+	if n.(int) < o.(int) && !d.Get("allow_shrink").(bool) {
+		return fmt.Errorf("shrinking volume %s from %d to %d bytes requires allow_shrink to be set", d.Get("name"), o, n)
+	}
+	return nil
+}
+
 // resourceLibvirtVolumeDelete removed a volume resource.
 func resourceLibvirtVolumeDelete(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
 	client := meta.(*Client)
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"regexp"
 	"testing"
 
 	libvirt "github.com/digitalocean/go-libvirt"
@@ -125,6 +126,71 @@ func TestAccLibvirtVolume_Basic(t *testing.T) {
 	})
 }
 
+func TestAccLibvirtVolume_Resize(t *testing.T) {
+	var volume libvirt.StorageVol
+	var volumeKey string
+	randomVolumeResource := acctest.RandStringFromCharSet(10, acctest.CharSetAlpha)
+	randomVolumeName := acctest.RandStringFromCharSet(10, acctest.CharSetAlpha)
+	randomPoolName := acctest.RandStringFromCharSet(10, acctest.CharSetAlpha)
+	randomPoolPath := "/tmp/terraform-provider-libvirt-pool-" + randomPoolName
+
+	config := func(size int, allowShrink bool) string {
+		return fmt.Sprintf(`
+                resource "libvirt_pool" "%s" {
+                    name = "%s"
+                    type = "dir"
+                    path = "%s"
+                }
+
+				resource "libvirt_volume" "%s" {
+					name         = "%s"
+					format       = "raw"
+					size         = %d
+					allow_shrink = %t
+                    pool = "${libvirt_pool.%s.name}"
+				}`, randomPoolName, randomPoolName, randomPoolPath, randomVolumeResource, randomVolumeName, size, allowShrink, randomPoolName)
+	}
+
+	resource.Test(t, resource.TestCase{
+		PreCheck:     func() { testAccPreCheck(t) },
+		Providers:    testAccProviders,
+		CheckDestroy: testAccCheckLibvirtVolumeDestroy,
+		Steps: []resource.TestStep{
+			{
+				Config: config(1073741824, false),
+				Check: resource.ComposeTestCheckFunc(
+					testAccCheckLibvirtVolumeExists("libvirt_volume."+randomVolumeResource, &volume),
+					func(state *terraform.State) error {
+						volumeKey = volume.Key
+						return nil
+					},
+				),
+			},
+			{
+				Config: config(2147483648, false),
+				Check: resource.ComposeTestCheckFunc(
+					testAccCheckLibvirtVolumeExists("libvirt_volume."+randomVolumeResource, &volume),
+					resource.TestCheckResourceAttrPtr("libvirt_volume."+randomVolumeResource, "id", &volumeKey),
+					resource.TestCheckResourceAttr(
+						"libvirt_volume."+randomVolumeResource, "size", "2147483648"),
+				),
+			},
+			{
+				Config:      config(1073741824, false),
+				ExpectError: regexp.MustCompile("requires allow_shrink to be set"),
+			},
+			{
+				Config: config(1073741824, true),
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttrPtr("libvirt_volume."+randomVolumeResource, "id", &volumeKey),
+					resource.TestCheckResourceAttr(
+						"libvirt_volume."+randomVolumeResource, "size", "1073741824"),
+				),
+			},
+		},
+	})
+}
+
 func TestAccLibvirtVolume_BackingStoreTestByID(t *testing.T) {
 	var volume libvirt.StorageVol
 	random := acctest.RandStringFromCharSet(10, acctest.CharSetAlpha)
@@ -0,0 +1,162 @@
+package libvirt
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"strings"
+
+	libvirt "github.com/digitalocean/go-libvirt"
+	"github.com/google/uuid"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"libvirt.org/go/libvirtxml"
+)
+
+// domainSnapshotID returns the terraform id of a snapshot: snapshot names
+// are only unique within their domain.
+func domainSnapshotID(domainUUID string, name string) string {
+	return domainUUID + "/" + name
+}
+
+// parseDomainSnapshotID returns the domain UUID and the snapshot name of a
+// terraform snapshot id, as created by domainSnapshotID.
+func parseDomainSnapshotID(id string) (libvirt.UUID, string, error) {
+	parts := strings.SplitN(id, "/", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return libvirt.UUID{}, "", fmt.Errorf("invalid snapshot id '%s', expected <domain uuid>/<snapshot name>", id)
+	}
+	domainUUID, err := uuid.Parse(parts[0])
+	if err != nil {
+		return libvirt.UUID{}, "", fmt.Errorf("invalid domain uuid in snapshot id '%s': %w", id, err)
+	}
+	return libvirt.UUID(domainUUID), parts[1], nil
+}
+
+// newDomainSnapshotDef returns the definition of a snapshot along with the
+// flags to create it with.
+//
+// Internal snapshots are stored in the qcow2 images of the domain, with the
+// memory state when memory is set. External snapshots make every disk
+// continue in a new overlay image, and save the memory state to memoryFile
+// when memory is set; without it only the disks are snapshotted.
+func newDomainSnapshotDef(name, description string, external, memory bool, memoryFile string) (libvirtxml.DomainSnapshot, uint32, error) {
+	def := libvirtxml.DomainSnapshot{
+		Name:        name,
+		Description: description,
+	}
+	var flags uint32
+
+	if !external {
+		if memoryFile != "" {
+			return def, 0, fmt.Errorf("memory_file is only used by external snapshots")
+		}
+		def.Memory = &libvirtxml.DomainSnapshotMemory{Snapshot: "no"}
+		if memory {
+			def.Memory.Snapshot = "internal"
+		}
+		return def, flags, nil
+	}
+
+	if memory {
+		if memoryFile == "" {
+			return def, 0, fmt.Errorf("memory_file is required to save the memory state with an external snapshot")
+		}
+		def.Memory = &libvirtxml.DomainSnapshotMemory{Snapshot: "external", File: memoryFile}
+	} else {
+		if memoryFile != "" {
+			return def, 0, fmt.Errorf("memory_file requires memory to be set")
+		}
+		flags |= uint32(libvirt.DomainSnapshotCreateDiskOnly)
+	}
+	// the disks use the default of the domain, which is external once a
+	// memory snapshot is external
+	return def, flags, nil
+}
+
+// newDomainSnapshotDefFromXML parses the XML description of a snapshot.
+func newDomainSnapshotDefFromXML(s string) (libvirtxml.DomainSnapshot, error) {
+	var def libvirtxml.DomainSnapshot
+	if err := xml.Unmarshal([]byte(s), &def); err != nil {
+		return libvirtxml.DomainSnapshot{}, err
+	}
+	return def, nil
+}
+
+// isExternalDomainSnapshot reports whether the memory or any disk of the
+// snapshot is saved outside of the disk images of the domain.
+func isExternalDomainSnapshot(def libvirtxml.DomainSnapshot) bool {
+	if def.Memory != nil && def.Memory.Snapshot == "external" {
+		return true
+	}
+	if def.Disks != nil {
+		for _, disk := range def.Disks.Disks {
+			if disk.Snapshot == "external" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// lookupDomainSnapshot returns the snapshot with the terraform id id.
+func lookupDomainSnapshot(virConn *libvirt.Libvirt, id string) (libvirt.DomainSnapshot, error) {
+	domainUUID, name, err := parseDomainSnapshotID(id)
+	if err != nil {
+		return libvirt.DomainSnapshot{}, err
+	}
+	domain, err := virConn.DomainLookupByUUID(domainUUID)
+	if err != nil {
+		return libvirt.DomainSnapshot{}, fmt.Errorf("error retrieving libvirt domain %s: %w", uuidString(domainUUID), err)
+	}
+	snapshot, err := virConn.DomainSnapshotLookupByName(domain, name, 0)
+	if err != nil {
+		return libvirt.DomainSnapshot{}, fmt.Errorf("error retrieving snapshot %s of libvirt domain %s: %w", name, domain.Name, err)
+	}
+	return snapshot, nil
+}
+
+// setDomainSnapshotAttributes sets the attributes shared by the snapshot
+// resource and data source from the snapshot, and returns its definition.
+func setDomainSnapshotAttributes(virConn *libvirt.Libvirt, d *schema.ResourceData, snapshot libvirt.DomainSnapshot) (libvirtxml.DomainSnapshot, error) {
+	snapshotXML, err := virConn.DomainSnapshotGetXMLDesc(snapshot, 0)
+	if err != nil {
+		return libvirtxml.DomainSnapshot{}, fmt.Errorf("could not get XML description for snapshot %s: %w", snapshot.Name, err)
+	}
+	snapshotDef, err := newDomainSnapshotDefFromXML(snapshotXML)
+	if err != nil {
+		return libvirtxml.DomainSnapshot{}, fmt.Errorf("could not get a snapshot definition from XML for %s: %w", snapshot.Name, err)
+	}
+
+	children, _, err := virConn.DomainSnapshotListAllChildren(snapshot, 1, 0)
+	if err != nil {
+		return libvirtxml.DomainSnapshot{}, fmt.Errorf("error retrieving the children of snapshot %s: %w", snapshot.Name, err)
+	}
+	childNames := make([]string, 0, len(children))
+	for _, child := range children {
+		childNames = append(childNames, child.Name)
+	}
+
+	current, err := virConn.DomainSnapshotIsCurrent(snapshot, 0)
+	if err != nil {
+		return libvirtxml.DomainSnapshot{}, fmt.Errorf("error checking whether snapshot %s is current: %w", snapshot.Name, err)
+	}
+
+	parent := ""
+	if snapshotDef.Parent != nil {
+		parent = snapshotDef.Parent.Name
+	}
+	// the creation time is in seconds since the epoch
+	creationTime, _ := strconv.ParseInt(snapshotDef.CreationTime, 10, 64)
+
+	d.Set("domain_id", uuidString(snapshot.Dom.UUID))
+	d.Set("name", snapshot.Name)
+	d.Set("description", snapshotDef.Description)
+	d.Set("parent", parent)
+	d.Set("children", childNames)
+	d.Set("state", snapshotDef.State)
+	d.Set("creation_time", creationTime)
+	d.Set("current", int2bool(int(current)))
+	d.Set("xml", snapshotXML)
+
+	return snapshotDef, nil
+}
@@ -0,0 +1,78 @@
+package libvirt
+
+import (
+	"testing"
+
+	libvirt "github.com/digitalocean/go-libvirt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDomainSnapshotID(t *testing.T) {
+	id := domainSnapshotID("1b4e28ba-2fa1-11d2-883f-0016d3cca427", "before-upgrade")
+	domainUUID, name, err := parseDomainSnapshotID(id)
+	require.NoError(t, err)
+	assert.Equal(t, "1b4e28ba-2fa1-11d2-883f-0016d3cca427", uuidString(domainUUID))
+	assert.Equal(t, "before-upgrade", name)
+
+	for _, id := range []string{"before-upgrade", "1b4e28ba-2fa1-11d2-883f-0016d3cca427/", "vm/before-upgrade"} {
+		_, _, err := parseDomainSnapshotID(id)
+		assert.Error(t, err, id)
+	}
+}
+
+func TestNewDomainSnapshotDef(t *testing.T) {
+	def, flags, err := newDomainSnapshotDef("s1", "first", false, true, "")
+	require.NoError(t, err)
+	assert.Equal(t, "s1", def.Name)
+	assert.Equal(t, "first", def.Description)
+	assert.Equal(t, "internal", def.Memory.Snapshot)
+	assert.Equal(t, uint32(0), flags)
+	assert.False(t, isExternalDomainSnapshot(def))
+
+	def, _, err = newDomainSnapshotDef("s1", "", false, false, "")
+	require.NoError(t, err)
+	assert.Equal(t, "no", def.Memory.Snapshot)
+
+	def, flags, err = newDomainSnapshotDef("s1", "", true, true, "/var/lib/libvirt/s1.mem")
+	require.NoError(t, err)
+	assert.Equal(t, "external", def.Memory.Snapshot)
+	assert.Equal(t, "/var/lib/libvirt/s1.mem", def.Memory.File)
+	assert.Equal(t, uint32(0), flags)
+	assert.True(t, isExternalDomainSnapshot(def))
+
+	def, flags, err = newDomainSnapshotDef("s1", "", true, false, "")
+	require.NoError(t, err)
+	assert.Nil(t, def.Memory)
+	assert.Equal(t, uint32(libvirt.DomainSnapshotCreateDiskOnly), flags)
+
+	_, _, err = newDomainSnapshotDef("s1", "", true, true, "")
+	assert.ErrorContains(t, err, "memory_file is required")
+	_, _, err = newDomainSnapshotDef("s1", "", false, true, "/tmp/s1.mem")
+	assert.ErrorContains(t, err, "only used by external snapshots")
+	_, _, err = newDomainSnapshotDef("s1", "", true, false, "/tmp/s1.mem")
+	assert.ErrorContains(t, err, "requires memory")
+}
+
+func TestDomainSnapshotDefUnmarshall(t *testing.T) {
+	def, err := newDomainSnapshotDefFromXML(`
+		<domainsnapshot>
+			<name>s2</name>
+			<state>shutoff</state>
+			<parent>
+				<name>s1</name>
+			</parent>
+			<creationTime>1700000000</creationTime>
+			<memory snapshot='no'/>
+			<disks>
+				<disk name='vda' snapshot='external' type='file'>
+					<source file='/var/lib/libvirt/images/vm.s2'/>
+				</disk>
+			</disks>
+		</domainsnapshot>`)
+	require.NoError(t, err)
+	assert.Equal(t, "s2", def.Name)
+	assert.Equal(t, "s1", def.Parent.Name)
+	assert.Equal(t, "1700000000", def.CreationTime)
+	assert.True(t, isExternalDomainSnapshot(def))
+}
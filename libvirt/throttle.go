@@ -0,0 +1,229 @@
+package libvirt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	libvirt "github.com/digitalocean/go-libvirt"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+// defaultRetryErrorCodes are the libvirt errors retried when the retry block
+// has no error_codes: a state change lock that could not be acquired in
+// time, and a busy resource. Both are reported before the call takes
+// effect, unlike a failed RPC, which may come after it did.
+var defaultRetryErrorCodes = []int{
+	int(libvirt.ErrOperationTimeout),
+	int(libvirt.ErrResourceBusy),
+}
+
+// retryPolicy retries the libvirt calls failing with one of errorCodes up
+// to attempts times, waiting delay before the first retry, twice as long
+// before each of the next ones, and at most maxDelay.
+type retryPolicy struct {
+	attempts   int
+	delay      time.Duration
+	maxDelay   time.Duration
+	errorCodes []int
+}
+
+// retryable returns whether err is a libvirt error the policy retries.
+func (p retryPolicy) retryable(err error) bool {
+	var libvirtErr libvirt.Error
+	if !errors.As(err, &libvirtErr) {
+		return false
+	}
+	for _, code := range p.errorCodes {
+		if uint32(code) == libvirtErr.Code {
+			return true
+		}
+	}
+	return false
+}
+
+// backoff returns how long to wait before the retry number attempt,
+// starting from 1.
+func (p retryPolicy) backoff(attempt int) time.Duration {
+	delay := p.delay
+	for i := 1; i < attempt && delay < p.maxDelay; i++ {
+		delay *= 2
+	}
+	if p.maxDelay > 0 && delay > p.maxDelay {
+		return p.maxDelay
+	}
+	return delay
+}
+
+// operationThrottle bounds the operations of the resources running at once
+// on a host, and retries the libvirt calls to the host that fail with a
+// transient error. A nil throttle neither bounds nor retries anything.
+type operationThrottle struct {
+	// slots holds a value for each operation running, nil without a bound
+	slots  chan struct{}
+	policy retryPolicy
+}
+
+func newOperationThrottle(maxOperations int, policy retryPolicy) *operationThrottle {
+	t := &operationThrottle{policy: policy}
+	if maxOperations > 0 {
+		t.slots = make(chan struct{}, maxOperations)
+	}
+	return t
+}
+
+// acquire waits for a free operation slot, and returns the function giving
+// it back.
+func (t *operationThrottle) acquire(ctx context.Context) (func(), error) {
+	if t == nil || t.slots == nil {
+		return func() {}, nil
+	}
+	select {
+	case t.slots <- struct{}{}:
+	default:
+		log.Printf("[DEBUG] %d libvirt operations in progress, waiting for one to finish", cap(t.slots))
+		select {
+		case t.slots <- struct{}{}:
+		case <-ctx.Done():
+			return nil, fmt.Errorf("waiting for one of the %d libvirt operations in progress to finish: %w", cap(t.slots), ctx.Err())
+		}
+	}
+	return func() { <-t.slots }, nil
+}
+
+// outside runs wait, which waits on the guest rather than on libvirt, with
+// the slot of the running operation given back meanwhile, so that other
+// operations go on.
+func (t *operationThrottle) outside(wait func() error) error {
+	if t == nil || t.slots == nil {
+		return wait()
+	}
+	<-t.slots
+	// the operation gives the slot back once done, so it has to take one
+	// again whatever happens
+	defer func() { t.slots <- struct{}{} }()
+	return wait()
+}
+
+// retry runs call, a libvirt call that does not take effect when it fails
+// with one of the retried errors, again as the retry policy says, until
+// ctx, the context of the operation, is done.
+func (t *operationThrottle) retry(ctx context.Context, call func() error) error {
+	err := call()
+	if t == nil {
+		return err
+	}
+	for attempt := 1; err != nil && attempt <= t.policy.attempts && t.policy.retryable(err); attempt++ {
+		delay := t.policy.backoff(attempt)
+		log.Printf("[DEBUG] libvirt call failed with a retryable error (attempt %d of %d), retrying in %s: %v", attempt, t.policy.attempts, delay, err)
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%w (not retried: %v)", err, ctx.Err())
+		case <-time.After(delay):
+		}
+		err = call()
+	}
+	return err
+}
+
+// throttles are the throttles of the libvirt connections, which the
+// functions only given a connection retry their calls with.
+var (
+	throttlesMutex sync.Mutex
+	throttles      = make(map[*libvirt.Libvirt]*operationThrottle)
+)
+
+func registerThrottle(virConn *libvirt.Libvirt, t *operationThrottle) {
+	throttlesMutex.Lock()
+	defer throttlesMutex.Unlock()
+	throttles[virConn] = t
+}
+
+// retryLibvirt runs call, a libvirt call on virConn that does not take
+// effect when it fails with one of the retried errors, again as the retry
+// policy of the provider says, within the operation of ctx.
+func retryLibvirt(ctx context.Context, virConn *libvirt.Libvirt, call func() error) error {
+	throttlesMutex.Lock()
+	t := throttles[virConn]
+	throttlesMutex.Unlock()
+	return t.retry(ctx, call)
+}
+
+// throttleSettings returns the max_concurrent_operations and the retry
+// policy the provider d sets.
+func throttleSettings(d *schema.ResourceData) (int, retryPolicy, error) {
+	var policy retryPolicy
+	maxOperations := d.Get("max_concurrent_operations").(int)
+	if maxOperations < 0 {
+		return 0, policy, fmt.Errorf("max_concurrent_operations must not be negative")
+	}
+
+	if len(d.Get("retry").([]interface{})) == 0 {
+		return maxOperations, policy, nil
+	}
+	policy.attempts = d.Get("retry.0.attempts").(int)
+	for key, value := range map[string]*time.Duration{"delay": &policy.delay, "max_delay": &policy.maxDelay} {
+		configured := d.Get("retry.0." + key).(string)
+		duration, err := time.ParseDuration(configured)
+		if err != nil {
+			return 0, policy, fmt.Errorf("invalid retry %s \"%s\": %w", key, configured, err)
+		}
+		*value = duration
+	}
+	policy.errorCodes = defaultRetryErrorCodes
+	if codes := d.Get("retry.0.error_codes").([]interface{}); len(codes) > 0 {
+		policy.errorCodes = nil
+		for _, code := range codes {
+			code, _ := code.(int)
+			policy.errorCodes = append(policy.errorCodes, code)
+		}
+	}
+	return maxOperations, policy, nil
+}
+
+// throttleOperations makes the operations of r wait for a free operation
+// slot of the host they run on, so that a large plan does not run more of
+// them at once than max_concurrent_operations. Waiting is bounded by the
+// timeout of the operation.
+func throttleOperations(r *schema.Resource) {
+	throttleOf := func(meta interface{}) *operationThrottle {
+		if client, ok := meta.(*Client); ok {
+			return client.throttle
+		}
+		return nil
+	}
+
+	type contextFunc = func(context.Context, *schema.ResourceData, interface{}) diag.Diagnostics
+	wrap := func(f contextFunc) contextFunc {
+		if f == nil {
+			return nil
+		}
+		return func(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+			release, err := throttleOf(meta).acquire(ctx)
+			if err != nil {
+				return diag.FromErr(err)
+			}
+			defer release()
+			return f(ctx, d, meta)
+		}
+	}
+	r.CreateContext = wrap(r.CreateContext)
+	r.ReadContext = wrap(r.ReadContext)
+	r.UpdateContext = wrap(r.UpdateContext)
+	r.DeleteContext = wrap(r.DeleteContext)
+
+	if read := r.Read; read != nil {
+		r.Read = func(d *schema.ResourceData, meta interface{}) error {
+			release, err := throttleOf(meta).acquire(context.Background())
+			if err != nil {
+				return err
+			}
+			defer release()
+			return read(d, meta)
+		}
+	}
+}
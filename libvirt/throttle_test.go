@@ -0,0 +1,195 @@
+package libvirt
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	libvirt "github.com/digitalocean/go-libvirt"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := retryPolicy{delay: time.Second, maxDelay: 5 * time.Second}
+	expected := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	for i, delay := range expected {
+		if got := policy.backoff(i + 1); got != delay {
+			t.Errorf("backoff(%d) = %s, expected %s", i+1, got, delay)
+		}
+	}
+}
+
+func TestRetryPolicyRetryable(t *testing.T) {
+	policy := retryPolicy{errorCodes: defaultRetryErrorCodes}
+
+	tests := map[string]struct {
+		err       error
+		retryable bool
+	}{
+		"state change lock": {err: libvirt.Error{Code: uint32(libvirt.ErrOperationTimeout)}, retryable: true},
+		"busy resource":     {err: libvirt.Error{Code: uint32(libvirt.ErrResourceBusy)}, retryable: true},
+		"rpc":               {err: libvirt.Error{Code: uint32(libvirt.ErrRPC)}},
+		"no domain":         {err: libvirt.Error{Code: uint32(libvirt.ErrNoDomain)}},
+		"not libvirt":       {err: errors.New("connection refused")},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := policy.retryable(test.err); got != test.retryable {
+				t.Errorf("retryable = %t, expected %t", got, test.retryable)
+			}
+		})
+	}
+}
+
+func TestOperationThrottleRetry(t *testing.T) {
+	locked := libvirt.Error{Code: uint32(libvirt.ErrOperationTimeout)}
+	throttle := newOperationThrottle(0, retryPolicy{attempts: 2, delay: time.Millisecond, errorCodes: defaultRetryErrorCodes})
+
+	calls := 0
+	err := throttle.retry(context.Background(), func() error {
+		calls++
+		return locked
+	})
+	if err == nil || calls != 3 {
+		t.Errorf("a call always failing was made %d times with %v, expected 3 times and an error", calls, err)
+	}
+
+	calls = 0
+	err = throttle.retry(context.Background(), func() error {
+		calls++
+		if calls == 1 {
+			return locked
+		}
+		return nil
+	})
+	if err != nil || calls != 2 {
+		t.Errorf("a call failing once was made %d times with %v, expected 2 times", calls, err)
+	}
+
+	// the wait for the next attempt ends with the operation
+	slow := newOperationThrottle(0, retryPolicy{attempts: 2, delay: time.Hour, errorCodes: defaultRetryErrorCodes})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	calls = 0
+	err = slow.retry(ctx, func() error {
+		calls++
+		return locked
+	})
+	if !errors.Is(err, locked) || !strings.Contains(err.Error(), context.DeadlineExceeded.Error()) || calls != 1 {
+		t.Errorf("a call retried past the deadline was made %d times with %v, expected once and the call error", calls, err)
+	}
+
+	calls = 0
+	var nilThrottle *operationThrottle
+	if err := nilThrottle.retry(context.Background(), func() error { calls++; return locked }); err == nil || calls != 1 {
+		t.Errorf("a nil throttle made the call %d times with %v, expected once", calls, err)
+	}
+}
+
+func TestOperationThrottleSlots(t *testing.T) {
+	throttle := newOperationThrottle(1, retryPolicy{})
+
+	release, err := throttle.acquire(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := throttle.acquire(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("acquiring a slot of a full throttle returned %v, expected the deadline to be exceeded", err)
+	}
+
+	// the slot is free while the guest is waited on
+	err = throttle.outside(func() error {
+		other, err := throttle.acquire(context.Background())
+		if err != nil {
+			return err
+		}
+		other()
+		return nil
+	})
+	if err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	if len(throttle.slots) != 1 {
+		t.Errorf("%d slots are taken after waiting outside of the slot, expected 1", len(throttle.slots))
+	}
+
+	release()
+	if len(throttle.slots) != 0 {
+		t.Errorf("%d slots are taken after releasing the slot, expected 0", len(throttle.slots))
+	}
+}
+
+func TestThrottleSettings(t *testing.T) {
+	tests := map[string]struct {
+		raw           map[string]interface{}
+		maxOperations int
+		policy        retryPolicy
+		error         string
+	}{
+		"defaults": {
+			raw: map[string]interface{}{},
+		},
+		"default retry": {
+			raw:           map[string]interface{}{"max_concurrent_operations": 4, "retry": []interface{}{map[string]interface{}{}}},
+			maxOperations: 4,
+			policy:        retryPolicy{attempts: 5, delay: time.Second, maxDelay: 30 * time.Second, errorCodes: defaultRetryErrorCodes},
+		},
+		"error codes": {
+			raw: map[string]interface{}{"retry": []interface{}{map[string]interface{}{
+				"attempts": 3, "delay": "500ms", "max_delay": "2s", "error_codes": []interface{}{68},
+			}}},
+			policy: retryPolicy{attempts: 3, delay: 500 * time.Millisecond, maxDelay: 2 * time.Second, errorCodes: []int{68}},
+		},
+		"negative operations": {
+			raw:   map[string]interface{}{"max_concurrent_operations": -1},
+			error: "must not be negative",
+		},
+		"invalid delay": {
+			raw:   map[string]interface{}{"retry": []interface{}{map[string]interface{}{"delay": "1 second"}}},
+			error: `invalid retry delay "1 second"`,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			d := schema.TestResourceDataRaw(t, Provider().Schema, test.raw)
+			maxOperations, policy, err := throttleSettings(d)
+			if test.error != "" {
+				if err == nil || !strings.Contains(err.Error(), test.error) {
+					t.Errorf("expected an error containing %q, got %v", test.error, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if maxOperations != test.maxOperations || !reflect.DeepEqual(policy, test.policy) {
+				t.Errorf("settings = %d, %+v, expected %d, %+v", maxOperations, policy, test.maxOperations, test.policy)
+			}
+		})
+	}
+}
+
+func TestClientKeyOf(t *testing.T) {
+	base := Config{URI: "qemu+ssh://root@hv1/system", Retry: retryPolicy{attempts: 5, errorCodes: defaultRetryErrorCodes}}
+	if clientKeyOf(base) != clientKeyOf(base) {
+		t.Errorf("the same config does not share its client")
+	}
+
+	for name, config := range map[string]Config{
+		"max operations": {URI: base.URI, Retry: base.Retry, MaxConcurrentOperations: 2},
+		"retry":          {URI: base.URI, Retry: retryPolicy{attempts: 1, errorCodes: defaultRetryErrorCodes}},
+		"private key":    {URI: base.URI, Retry: base.Retry, PrivateKey: "key"},
+	} {
+		if clientKeyOf(config) == clientKeyOf(base) {
+			t.Errorf("a config with other %s shares the client of the same URI", name)
+		}
+	}
+}
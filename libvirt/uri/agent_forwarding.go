@@ -0,0 +1,45 @@
+package uri
+
+import (
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// forwardAgent forwards the ssh agent used for authentication to the remote
+// host, for agent_forwarding, so that scripts run there can use the local
+// keys, e.g. to reach a git server. It reuses the agent connection of auth
+// and keeps a session open on client for the lifetime of the connection, as
+// the server drops the forwarded agent along with the session it was
+// requested on. Forwarding is best effort: problems are logged and the
+// connection carries on without it.
+func (u *ConnectionURI) forwardAgent(auth *sshAuth, client *ssh.Client) {
+	usesAgent := false
+	for _, name := range auth.names {
+		usesAgent = usesAgent || name == "agent"
+	}
+	if !usesAgent {
+		u.logf("[WARN] agent_forwarding is set, but the agent method of sshauth is not used, not forwarding the ssh agent")
+		return
+	}
+	agentClient, err := auth.agent()
+	if err != nil || agentClient == nil {
+		u.logf("[WARN] agent_forwarding is set, but there is no ssh agent to forward (SSH_AUTH_SOCK): %v", err)
+		return
+	}
+
+	if err := agent.ForwardToAgent(client, agentClient); err != nil {
+		u.logf("[WARN] failed to forward the ssh agent: %v", err)
+		return
+	}
+	session, err := client.NewSession()
+	if err != nil {
+		u.logf("[WARN] failed to open a session to forward the ssh agent: %v", err)
+		return
+	}
+	if err := agent.RequestAgentForwarding(session); err != nil {
+		session.Close()
+		u.logf("[WARN] the server refused to forward the ssh agent: %v", err)
+		return
+	}
+	u.logf("[DEBUG] forwarding the ssh agent to %s", u.Hostname())
+}
@@ -0,0 +1,67 @@
+package uri
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func TestDialSSHAgentForwarding(t *testing.T) {
+	s := newTestSSHServer(t)
+	t.Setenv("HTTP_PROXY", "")
+	t.Setenv("ALL_PROXY", "")
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	signer, err := ssh.NewSignerFromKey(priv)
+	require.NoError(t, err)
+	s.Authorize(signer.PublicKey())
+	serverConns := make(chan *ssh.ServerConn, 1)
+	s.connected = func(conn *ssh.ServerConn) { serverConns <- conn }
+
+	u := testSSHURI(t, s, "sshauth=agent&agent_forwarding=yes")
+	a := newTestAgent(t)
+	require.NoError(t, a.Add(agent.AddedKey{PrivateKey: priv}))
+	t.Setenv("SSH_AUTH_SOCK", a.Socket)
+
+	conn, err := u.Dial()
+	require.NoError(t, err)
+	defer conn.Close()
+	serverConn := <-serverConns
+	assert.Eventually(t, func() bool {
+		for _, r := range s.SessionRequests() {
+			if r == "auth-agent-req@openssh.com" {
+				return true
+			}
+		}
+		return false
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// the remote host reaches the local agent through the connection
+	ch, reqs, err := serverConn.OpenChannel("auth-agent@openssh.com", nil)
+	require.NoError(t, err)
+	go ssh.DiscardRequests(reqs)
+	defer ch.Close()
+	keys, err := agent.NewClient(ch).List()
+	require.NoError(t, err)
+	require.Len(t, keys, 1)
+	assert.Equal(t, signer.PublicKey().Marshal(), keys[0].Marshal())
+}
+
+func TestDialSSHAgentForwardingWithoutAgent(t *testing.T) {
+	s := newTestSSHServer(t)
+	t.Setenv("HTTP_PROXY", "")
+	t.Setenv("ALL_PROXY", "")
+
+	// without an agent the connection is made without forwarding
+	u := testSSHURI(t, s, "sshauth=agent,ssh-password&agent_forwarding=yes")
+	conn, err := u.Dial()
+	require.NoError(t, err)
+	conn.Close()
+	assert.NotContains(t, s.SessionRequests(), "auth-agent-req@openssh.com")
+}
@@ -0,0 +1,92 @@
+package uri
+
+import (
+	"context"
+	"time"
+)
+
+// AuditSink receives a record of every connection attempt, for an audit
+// trail kept apart from the debug log, e.g. to forward it to a SIEM.
+type AuditSink interface {
+	Record(AuditRecord)
+}
+
+// AuditSinkFunc is an adapter to use an ordinary function as an AuditSink.
+type AuditSinkFunc func(AuditRecord)
+
+// Record calls f(r).
+func (f AuditSinkFunc) Record(r AuditRecord) {
+	f(r)
+}
+
+// AuditRecord describes one connection attempt and its outcome.
+type AuditRecord struct {
+	// Time is when the attempt started
+	Time     time.Time     `json:"time"`
+	Duration time.Duration `json:"duration"`
+	Attempt  int           `json:"attempt"`
+
+	Transport string `json:"transport"`
+	Host      string `json:"host"`
+	Tag       string `json:"tag,omitempty"`
+	// User is the user logged in as, once resolved
+	User string `json:"user,omitempty"`
+
+	// AuthMethod and HostKey are reported for the ssh transport, as far as
+	// the attempt got
+	AuthMethod string        `json:"auth_method,omitempty"`
+	HostKey    *AuditHostKey `json:"host_key,omitempty"`
+
+	Success bool   `json:"success"`
+	Error   string `json:"error,omitempty"`
+}
+
+// AuditHostKey is the host key verification decision of an attempt.
+type AuditHostKey struct {
+	Algorithm   string `json:"algorithm"`
+	Fingerprint string `json:"fingerprint"`
+	Verified    bool   `json:"verified"`
+	Error       string `json:"error,omitempty"`
+}
+
+// auditKey is the context key holding the record of the current attempt.
+type auditKey struct{}
+
+func auditRecord(ctx context.Context) *AuditRecord {
+	r, _ := ctx.Value(auditKey{}).(*AuditRecord)
+	return r
+}
+
+// startAudit returns ctx holding a new record for attempt, when an Audit
+// sink is set.
+func (u *ConnectionURI) startAudit(ctx context.Context, attempt int) context.Context {
+	if u.Audit == nil {
+		return ctx
+	}
+	r := &AuditRecord{
+		Time:      time.Now(),
+		Attempt:   attempt,
+		Transport: u.transport(),
+		Host:      u.Host,
+		Tag:       u.tag(),
+	}
+	if u.User != nil {
+		r.User = u.User.Username()
+	}
+	return context.WithValue(ctx, auditKey{}, r)
+}
+
+// audit completes the record of the attempt running in ctx with its outcome
+// and hands it to the Audit sink.
+func (u *ConnectionURI) audit(ctx context.Context, err error) {
+	r := auditRecord(ctx)
+	if r == nil {
+		return
+	}
+	r.Duration = time.Since(r.Time)
+	r.Success = err == nil
+	if err != nil {
+		r.Error = err.Error()
+	}
+	u.Audit.Record(*r)
+}
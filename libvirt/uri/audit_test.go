@@ -0,0 +1,122 @@
+package uri
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+// testAuditSink collects the audit records it receives.
+type testAuditSink struct {
+	mu      sync.Mutex
+	records []AuditRecord
+}
+
+func (s *testAuditSink) Record(r AuditRecord) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records = append(s.records, r)
+}
+
+func (s *testAuditSink) Records() []AuditRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]AuditRecord(nil), s.records...)
+}
+
+func TestAuditSuccess(t *testing.T) {
+	s := newTestSSHServer(t)
+	sink := &testAuditSink{}
+
+	u := testSSHURI(t, s, "conn_tag=plan-42")
+	u.Audit = sink
+	conn, err := u.Dial()
+	require.NoError(t, err)
+	conn.Close()
+
+	records := sink.Records()
+	require.Len(t, records, 1)
+	r := records[0]
+	assert.True(t, r.Success)
+	assert.Empty(t, r.Error)
+	assert.Equal(t, 1, r.Attempt)
+	assert.Equal(t, "ssh", r.Transport)
+	assert.Equal(t, s.Addr(), r.Host)
+	assert.Equal(t, "plan-42", r.Tag)
+	assert.Equal(t, testSSHUser, r.User)
+	assert.Equal(t, "ssh-password", r.AuthMethod)
+	require.NotNil(t, r.HostKey)
+	assert.Equal(t, ssh.FingerprintSHA256(s.hostKey.PublicKey()), r.HostKey.Fingerprint)
+	assert.False(t, r.Time.IsZero())
+}
+
+func TestAuditFailures(t *testing.T) {
+	s := newTestSSHServer(t)
+	s.exec["uname -m"] = "aarch64\n"
+
+	t.Run("unreachable", func(t *testing.T) {
+		sink := &testAuditSink{}
+		u, err := Parse("qemu+tcp://127.0.0.1:" + closedPort(t) + "/system?connect_retries=1&connect_retry_delay=10ms")
+		require.NoError(t, err)
+		u.Audit = sink
+		_, err = u.Dial()
+		require.Error(t, err)
+
+		records := sink.Records()
+		require.Len(t, records, 2)
+		for i, r := range records {
+			assert.Equal(t, i+1, r.Attempt)
+			assert.False(t, r.Success)
+			assert.Contains(t, r.Error, "connection refused")
+			assert.Nil(t, r.HostKey)
+		}
+	})
+
+	t.Run("configuration", func(t *testing.T) {
+		sink := &testAuditSink{}
+		u := testSSHURI(t, s, "no_verify=&knownhosts=/nonexistent/known_hosts")
+		u.Audit = sink
+		_, err := u.Dial()
+		require.Error(t, err)
+
+		records := sink.Records()
+		require.Len(t, records, 1)
+		assert.False(t, records[0].Success)
+		assert.Contains(t, records[0].Error, "failed to read ssh known hosts")
+		assert.Empty(t, records[0].AuthMethod)
+	})
+
+	t.Run("after authentication", func(t *testing.T) {
+		sink := &testAuditSink{}
+		u := testSSHURI(t, s, "require_arch=x86_64")
+		u.Audit = sink
+		_, err := u.Dial()
+		require.Error(t, err)
+
+		records := sink.Records()
+		require.Len(t, records, 1)
+		r := records[0]
+		assert.False(t, r.Success)
+		assert.Contains(t, r.Error, "does not match required architecture")
+		assert.Equal(t, testSSHUser, r.User)
+		assert.Equal(t, "ssh-password", r.AuthMethod)
+		require.NotNil(t, r.HostKey)
+		assert.True(t, r.HostKey.Verified)
+	})
+
+	t.Run("libvirt socket", func(t *testing.T) {
+		sink := &testAuditSink{}
+		u := testSSHURI(t, s, "subsystem=libvirt")
+		u.Audit = sink
+		_, err := u.Dial()
+		require.Error(t, err)
+
+		records := sink.Records()
+		require.Len(t, records, 1)
+		assert.False(t, records[0].Success)
+		assert.Contains(t, records[0].Error, "failed to connect to libvirt on the remote host")
+	})
+}
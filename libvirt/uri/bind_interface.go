@@ -0,0 +1,47 @@
+package uri
+
+import (
+	"fmt"
+	"net"
+)
+
+// bindInterfaceAddr returns the local address to dial addr from when the
+// bind_interface parameter names a network interface, like OpenSSH's
+// BindInterface, e.g. to force the connection onto a VPN tunnel. Among the
+// addresses of the interface, the first one of the same family as addr is
+// picked.
+func (u *ConnectionURI) bindInterfaceAddr(name string, addr string) (*net.TCPAddr, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, fmt.Errorf("invalid value '%s' for bind_interface: %w", name, err)
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the addresses of interface %s: %w", name, err)
+	}
+
+	ip := selectInterfaceAddr(addrs, net.ParseIP(addr))
+	if ip == nil {
+		return nil, fmt.Errorf("interface %s has no address to reach %s from", name, addr)
+	}
+	u.logf("[DEBUG] binding the connection to %s to %s (%s)", addr, name, ip)
+	return &net.TCPAddr{IP: ip}, nil
+}
+
+// selectInterfaceAddr picks the address of an interface to connect to
+// target from, or nil when there is none of the same family. IPv6
+// link-local addresses are skipped, as they only reach the local link.
+func selectInterfaceAddr(addrs []net.Addr, target net.IP) net.IP {
+	wantIPv4 := target == nil || target.To4() != nil
+	for _, a := range addrs {
+		ipNet, ok := a.(*net.IPNet)
+		if !ok || (ipNet.IP.To4() != nil) != wantIPv4 {
+			continue
+		}
+		if !wantIPv4 && ipNet.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		return ipNet.IP
+	}
+	return nil
+}
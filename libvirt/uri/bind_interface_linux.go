@@ -0,0 +1,27 @@
+package uri
+
+import (
+	"errors"
+	"syscall"
+)
+
+// bindToDevice returns a dialer control function that binds the socket to
+// the interface with SO_BINDTODEVICE, so that traffic cannot leave through
+// another interface whatever the routing table says. Binding needs
+// CAP_NET_RAW; without it, the connection is only bound to the source
+// address of the interface.
+func (u *ConnectionURI) bindToDevice(name string) func(network, address string, c syscall.RawConn) error {
+	return func(network, address string, c syscall.RawConn) error {
+		var err error
+		if cerr := c.Control(func(fd uintptr) {
+			err = syscall.SetsockoptString(int(fd), syscall.SOL_SOCKET, syscall.SO_BINDTODEVICE, name)
+		}); cerr != nil {
+			return cerr
+		}
+		if errors.Is(err, syscall.EPERM) {
+			u.logf("[DEBUG] not allowed to bind to device %s, only binding to its address", name)
+			return nil
+		}
+		return err
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package uri
+
+import (
+	"syscall"
+)
+
+// bindToDevice returns nil, as binding a socket to an interface is only
+// supported on Linux. The connection is still bound to the source address
+// of the interface.
+func (u *ConnectionURI) bindToDevice(name string) func(network, address string, c syscall.RawConn) error {
+	return nil
+}
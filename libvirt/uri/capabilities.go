@@ -0,0 +1,103 @@
+package uri
+
+import (
+	"fmt"
+	"sync"
+
+	libvirt "github.com/digitalocean/go-libvirt"
+)
+
+// Capabilities connects to libvirt, retrieves the capabilities XML of the
+// remote host and disconnects again, without performing any other operation.
+//
+// It is meant for inspecting what a hypervisor supports (machine types, CPU
+// models, architectures) without setting up a long lived client.
+func (u *ConnectionURI) Capabilities() (string, error) {
+	l, err := u.ConnectLibvirt()
+	if err != nil {
+		return "", fmt.Errorf("failed to connect: %w", u.ExplainOpenError(err))
+	}
+	defer func() {
+		if err := l.Disconnect(); err != nil {
+			u.logf("[WARN] cannot close libvirt connection: %v", err)
+		}
+	}()
+
+	caps, err := l.ConnectGetCapabilities()
+	if err != nil {
+		return "", fmt.Errorf("failed to retrieve capabilities: %w", err)
+	}
+	return caps, nil
+}
+
+// capabilitiesEntry is the capabilities of one host, fetched once. Its
+// mutex is held while fetching them, so that a slow host only holds up the
+// callers waiting for that host.
+type capabilitiesEntry struct {
+	mutex sync.Mutex
+	caps  string
+	ok    bool
+}
+
+// capabilitiesCache holds the capabilities fetched by CachedCapabilities,
+// keyed by capabilitiesKey.
+var (
+	capabilitiesMutex sync.Mutex
+	capabilitiesCache = make(map[string]*capabilitiesEntry)
+)
+
+// capabilitiesKey identifies the hypervisor a URI points to, including the
+// socket and daemon libvirt is reached through.
+func (u *ConnectionURI) capabilitiesKey() string {
+	q := u.Query()
+	return fmt.Sprintf("%s://%s%s?socket=%s&readonly=%s&daemon=%s",
+		u.Scheme, u.Host, u.Path, q.Get("socket"), q.Get("readonly"), q.Get("daemon"))
+}
+
+// cachedCapabilities returns the capabilities of the host of u, calling
+// fetch only when they were not fetched yet. A failed fetch is not cached.
+func (u *ConnectionURI) cachedCapabilities(fetch func() (string, error)) (string, error) {
+	key := u.capabilitiesKey()
+
+	capabilitiesMutex.Lock()
+	entry, ok := capabilitiesCache[key]
+	if !ok {
+		entry = &capabilitiesEntry{}
+		capabilitiesCache[key] = entry
+	}
+	capabilitiesMutex.Unlock()
+
+	entry.mutex.Lock()
+	defer entry.mutex.Unlock()
+	if entry.ok {
+		return entry.caps, nil
+	}
+
+	caps, err := fetch()
+	if err != nil {
+		return "", err
+	}
+	entry.caps, entry.ok = caps, true
+	return caps, nil
+}
+
+// CachedCapabilities returns the capabilities XML of the remote host,
+// fetching it only on the first call for that host. Combined with the
+// readonly parameter this gives data sources a cheap way to inspect the
+// hypervisor repeatedly during a run.
+func (u *ConnectionURI) CachedCapabilities() (string, error) {
+	return u.cachedCapabilities(u.Capabilities)
+}
+
+// CachedCapabilitiesOf is CachedCapabilities fetching the capabilities over
+// l, an established connection to the host of u, instead of a connection of
+// its own.
+func (u *ConnectionURI) CachedCapabilitiesOf(l *libvirt.Libvirt) (string, error) {
+	return u.cachedCapabilities(func() (string, error) {
+		caps, err := l.ConnectGetCapabilities()
+		if err != nil {
+			return "", fmt.Errorf("failed to retrieve capabilities: %w", err)
+		}
+		return caps, nil
+	})
+}
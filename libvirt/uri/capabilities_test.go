@@ -0,0 +1,163 @@
+package uri
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testCapabilities = `<capabilities>
+  <host>
+    <cpu>
+      <arch>x86_64</arch>
+      <model>Skylake-Client-IBRS</model>
+    </cpu>
+  </host>
+  <guest>
+    <os_type>hvm</os_type>
+    <arch name='x86_64'>
+      <machine>pc-q35-8.0</machine>
+    </arch>
+  </guest>
+</capabilities>
+`
+
+func TestCapabilities(t *testing.T) {
+	s := newTestLibvirtServer(t)
+	s.Handle(testProcConnectGetCapabilities, func([]byte) ([]byte, error) {
+		return xdrString(testCapabilities), nil
+	})
+
+	u, err := Parse("qemu:///system?socket=" + s.Socket)
+	require.NoError(t, err)
+
+	caps, err := u.Capabilities()
+	require.NoError(t, err)
+	assert.Equal(t, testCapabilities, caps)
+	assert.Equal(t, 1, s.Calls(testProcConnectOpen))
+	assert.Equal(t, 1, s.Calls(testProcConnectGetCapabilities))
+	assert.Equal(t, 1, s.Calls(testProcConnectClose))
+}
+
+func TestCapabilitiesError(t *testing.T) {
+	s := newTestLibvirtServer(t)
+	s.Handle(testProcConnectGetCapabilities, func([]byte) ([]byte, error) {
+		return nil, testLibvirtError{Code: 38, Message: "internal error: cannot get capabilities"}
+	})
+
+	u, err := Parse("qemu:///system?socket=" + s.Socket)
+	require.NoError(t, err)
+
+	_, err = u.Capabilities()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "cannot get capabilities")
+}
+
+func TestCachedCapabilities(t *testing.T) {
+	s := newTestLibvirtServer(t)
+	s.Handle(testProcConnectGetCapabilities, func([]byte) ([]byte, error) {
+		return xdrString(testCapabilities), nil
+	})
+
+	u, err := Parse("qemu:///system?readonly=1&socket=" + s.Socket)
+	require.NoError(t, err)
+	forgetCapabilities(t, u)
+
+	for i := 0; i < 3; i++ {
+		caps, err := u.CachedCapabilities()
+		require.NoError(t, err)
+		assert.Equal(t, testCapabilities, caps)
+	}
+	assert.Equal(t, 1, s.Calls(testProcConnectOpen))
+	assert.Equal(t, 1, s.Calls(testProcConnectGetCapabilities))
+}
+
+func TestCachedCapabilitiesOf(t *testing.T) {
+	s := newTestLibvirtServer(t)
+	s.Handle(testProcConnectGetCapabilities, func([]byte) ([]byte, error) {
+		return xdrString(testCapabilities), nil
+	})
+
+	u, err := Parse("qemu:///system?socket=" + s.Socket)
+	require.NoError(t, err)
+	forgetCapabilities(t, u)
+
+	l, err := u.ConnectLibvirt()
+	require.NoError(t, err)
+	defer l.Disconnect()
+
+	for i := 0; i < 3; i++ {
+		caps, err := u.CachedCapabilitiesOf(l)
+		require.NoError(t, err)
+		assert.Equal(t, testCapabilities, caps)
+	}
+	assert.Equal(t, 1, s.Calls(testProcConnectOpen))
+	assert.Equal(t, 1, s.Calls(testProcConnectGetCapabilities))
+}
+
+func TestCachedCapabilitiesSlowHost(t *testing.T) {
+	slow := newTestLibvirtServer(t)
+	fetching := make(chan struct{})
+	unblock := make(chan struct{})
+	slow.Handle(testProcConnectGetCapabilities, func([]byte) ([]byte, error) {
+		close(fetching)
+		<-unblock
+		return xdrString(testCapabilities), nil
+	})
+	fast := newTestLibvirtServer(t)
+	fast.Handle(testProcConnectGetCapabilities, func([]byte) ([]byte, error) {
+		return xdrString(testCapabilities), nil
+	})
+
+	slowURI, err := Parse("qemu:///system?socket=" + slow.Socket)
+	require.NoError(t, err)
+	forgetCapabilities(t, slowURI)
+	fastURI, err := Parse("qemu:///system?socket=" + fast.Socket)
+	require.NoError(t, err)
+	forgetCapabilities(t, fastURI)
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := slowURI.CachedCapabilities()
+		done <- err
+	}()
+	<-fetching
+
+	// the other host is not held up by the fetch in progress
+	caps, err := fastURI.CachedCapabilities()
+	require.NoError(t, err)
+	assert.Equal(t, testCapabilities, caps)
+
+	close(unblock)
+	require.NoError(t, <-done)
+}
+
+func TestCapabilitiesKey(t *testing.T) {
+	base, err := Parse("qemu+ssh://root@hv/system")
+	require.NoError(t, err)
+
+	for _, other := range []string{
+		"qemu+ssh://root@hv/system?socket=/run/libvirt/virtqemud-sock",
+		"qemu+ssh://root@hv/system?readonly=1",
+		"qemu+ssh://root@hv/system?daemon=virtqemud",
+		"qemu+ssh://root@hv/session",
+	} {
+		u, err := Parse(other)
+		require.NoError(t, err)
+		assert.NotEqual(t, base.capabilitiesKey(), u.capabilitiesKey(), other)
+	}
+
+	same, err := Parse("qemu+ssh://root@hv/system?keyfile=/tmp/key")
+	require.NoError(t, err)
+	assert.Equal(t, base.capabilitiesKey(), same.capabilitiesKey())
+}
+
+// forgetCapabilities drops the capabilities cached for u once t is done.
+func forgetCapabilities(t *testing.T, u *ConnectionURI) {
+	t.Cleanup(func() {
+		capabilitiesMutex.Lock()
+		delete(capabilitiesCache, u.capabilitiesKey())
+		capabilitiesMutex.Unlock()
+	})
+}
@@ -0,0 +1,36 @@
+package uri
+
+import (
+	"golang.org/x/crypto/ssh"
+)
+
+// ChannelHandler serves a channel opened by the SSH server, such as a remote
+// forward or an agent forwarding request. It accepts or rejects the channel
+// and owns it from then on.
+type ChannelHandler func(ssh.NewChannel)
+
+// routeChannels hands the incoming channels of a type in ChannelHandlers to
+// their handler and passes the others on to the SSH client, which rejects
+// the ones it did not ask for.
+//
+// The channels are routed before the client sees them, so that none is
+// rejected in the window between the handshake and the registration of a
+// handler.
+func (u *ConnectionURI) routeChannels(in <-chan ssh.NewChannel) <-chan ssh.NewChannel {
+	if len(u.ChannelHandlers) == 0 {
+		return in
+	}
+	out := make(chan ssh.NewChannel)
+	go func() {
+		defer close(out)
+		for newChannel := range in {
+			if handler, ok := u.ChannelHandlers[newChannel.ChannelType()]; ok {
+				u.logf("[DEBUG] incoming %s channel from %s", newChannel.ChannelType(), u.Hostname())
+				go handler(newChannel)
+				continue
+			}
+			out <- newChannel
+		}
+	}()
+	return out
+}
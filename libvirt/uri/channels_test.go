@@ -0,0 +1,54 @@
+package uri
+
+import (
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestDialSSHChannelHandlers(t *testing.T) {
+	s := newTestSSHServer(t)
+	replies := make(chan string, 1)
+	rejected := make(chan error, 1)
+	s.connected = func(conn *ssh.ServerConn) {
+		_, _, err := conn.OpenChannel("x11", nil)
+		rejected <- err
+
+		ch, reqs, err := conn.OpenChannel("forwarded-tcpip", nil)
+		if err != nil {
+			replies <- err.Error()
+			return
+		}
+		go ssh.DiscardRequests(reqs)
+		defer ch.Close()
+		ch.Write([]byte("ping"))
+		ch.CloseWrite()
+		reply, _ := io.ReadAll(ch)
+		replies <- string(reply)
+	}
+
+	u := testSSHURI(t, s, "")
+	u.ChannelHandlers = map[string]ChannelHandler{
+		"forwarded-tcpip": func(newChannel ssh.NewChannel) {
+			ch, reqs, err := newChannel.Accept()
+			if err != nil {
+				return
+			}
+			go ssh.DiscardRequests(reqs)
+			defer ch.Close()
+			msg, _ := io.ReadAll(ch)
+			ch.Write(append(msg, " pong"...))
+		},
+	}
+	conn, err := u.Dial()
+	require.NoError(t, err)
+	defer conn.Close()
+
+	var openErr *ssh.OpenChannelError
+	require.ErrorAs(t, <-rejected, &openErr)
+	assert.Equal(t, ssh.UnknownChannelType, openErr.Reason)
+	assert.Equal(t, "ping pong", <-replies)
+}
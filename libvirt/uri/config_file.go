@@ -0,0 +1,165 @@
+package uri
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Decryptor returns the plaintext of an encrypted connection config file.
+type Decryptor interface {
+	Decrypt(path string, ciphertext []byte) ([]byte, error)
+}
+
+// DecryptorFunc adapts a function to the Decryptor interface.
+type DecryptorFunc func(path string, ciphertext []byte) ([]byte, error)
+
+func (f DecryptorFunc) Decrypt(path string, ciphertext []byte) ([]byte, error) {
+	return f(path, ciphertext)
+}
+
+// commandDecryptor decrypts a file by running an external tool that prints
+// the plaintext on its standard output.
+type commandDecryptor struct {
+	name string
+	args func(path string) ([]string, error)
+}
+
+func (d commandDecryptor) Decrypt(path string, _ []byte) ([]byte, error) {
+	args, err := d.args(path)
+	if err != nil {
+		return nil, err
+	}
+	var stderr bytes.Buffer
+	cmd := exec.Command(d.name, args...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%s failed to decrypt '%s': %w: %s", d.name, path, err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	return out, nil
+}
+
+var (
+	// sops picks up its keys from the usual SOPS_* environment variables
+	sopsDecryptor = commandDecryptor{
+		name: "sops",
+		args: func(path string) ([]string, error) {
+			return []string{"--decrypt", "--output-type", "json", path}, nil
+		},
+	}
+
+	// age needs the identity file, taken from LIBVIRT_AGE_IDENTITY or the
+	// one sops would use
+	ageDecryptor = commandDecryptor{
+		name: "age",
+		args: func(path string) ([]string, error) {
+			identity := os.Getenv("LIBVIRT_AGE_IDENTITY")
+			if identity == "" {
+				identity = os.Getenv("SOPS_AGE_KEY_FILE")
+			}
+			if identity == "" {
+				return nil, fmt.Errorf("no age identity to decrypt '%s', set LIBVIRT_AGE_IDENTITY or SOPS_AGE_KEY_FILE", path)
+			}
+			return []string{"--decrypt", "--identity", os.ExpandEnv(identity), path}, nil
+		},
+	}
+
+	decryptorsMutex sync.RWMutex
+	decryptors      = map[string]Decryptor{
+		".age": ageDecryptor,
+	}
+)
+
+// RegisterDecryptor sets the decryptor used for config files with the given
+// extension (e.g. ".age"). Files with an unregistered extension are
+// decrypted with sops.
+func RegisterDecryptor(ext string, d Decryptor) {
+	decryptorsMutex.Lock()
+	defer decryptorsMutex.Unlock()
+	decryptors[ext] = d
+}
+
+func decryptorFor(path string) Decryptor {
+	decryptorsMutex.RLock()
+	defer decryptorsMutex.RUnlock()
+	if d, ok := decryptors[filepath.Ext(path)]; ok {
+		return d
+	}
+	return sopsDecryptor
+}
+
+// applyConfigFile decrypts the file given in the config_file parameter and
+// applies the connection parameters it contains.
+//
+// The plaintext is a JSON object of strings. The host, port, user and
+// password keys fill the authority section of the URI; any other key is
+// used as a query parameter. Values already present in the URI win.
+func (u *ConnectionURI) applyConfigFile() error {
+	q := u.Query()
+	path := q.Get("config_file")
+	if path == "" {
+		return nil
+	}
+	path = os.ExpandEnv(path)
+
+	ciphertext, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read connection config file: %w", err)
+	}
+	plaintext, err := decryptorFor(path).Decrypt(path, ciphertext)
+	if err != nil {
+		return err
+	}
+
+	params := make(map[string]string)
+	if err := json.Unmarshal(plaintext, &params); err != nil {
+		return fmt.Errorf("failed to parse connection config file '%s': %w", path, err)
+	}
+
+	host, port := u.Hostname(), u.Port()
+	if host == "" {
+		host = params["host"]
+	}
+	if port == "" {
+		port = params["port"]
+	}
+	switch {
+	case port != "":
+		u.Host = net.JoinHostPort(host, port)
+	case strings.Contains(host, ":"):
+		// an IPv6 literal, bracketed as without the port
+		u.Host = "[" + host + "]"
+	default:
+		u.Host = host
+	}
+
+	if u.User == nil || u.User.Username() == "" {
+		if user := params["user"]; user != "" {
+			if password, ok := params["password"]; ok {
+				u.User = url.UserPassword(user, password)
+			} else {
+				u.User = url.User(user)
+			}
+		}
+	}
+
+	for k, v := range params {
+		switch k {
+		case "host", "port", "user", "password":
+			continue
+		}
+		if !q.Has(k) {
+			q.Set(k, v)
+		}
+	}
+	u.RawQuery = q.Encode()
+	return nil
+}
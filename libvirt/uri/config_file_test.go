@@ -0,0 +1,118 @@
+package uri
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testConfigKeyEnv names the environment variable holding the hex encoded
+// AES key used by the test decryptor.
+const testConfigKeyEnv = "TEST_LIBVIRT_CONFIG_KEY"
+
+func testAESGCM(t *testing.T, key []byte) cipher.AEAD {
+	block, err := aes.NewCipher(key)
+	require.NoError(t, err)
+	gcm, err := cipher.NewGCM(block)
+	require.NoError(t, err)
+	return gcm
+}
+
+func TestParseEncryptedConfigFile(t *testing.T) {
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	require.NoError(t, err)
+	t.Setenv(testConfigKeyEnv, hex.EncodeToString(key))
+
+	RegisterDecryptor(".testenc", DecryptorFunc(func(path string, ciphertext []byte) ([]byte, error) {
+		key, err := hex.DecodeString(os.Getenv(testConfigKeyEnv))
+		if err != nil {
+			return nil, err
+		}
+		gcm := testAESGCM(t, key)
+		if len(ciphertext) < gcm.NonceSize() {
+			return nil, errors.New("ciphertext too short")
+		}
+		nonce, sealed := ciphertext[:gcm.NonceSize()], ciphertext[gcm.NonceSize():]
+		return gcm.Open(nil, nonce, sealed, nil)
+	}))
+
+	plaintext := `{
+  "host": "hv1.example.com",
+  "port": "2222",
+  "user": "admin",
+  "password": "s3cret",
+  "keyfile": "/secrets/id_ed25519",
+  "sshauth": "privkey"
+}`
+	gcm := testAESGCM(t, key)
+	nonce := make([]byte, gcm.NonceSize())
+	_, err = rand.Read(nonce)
+	require.NoError(t, err)
+	fixture := filepath.Join(t.TempDir(), "secret.testenc")
+	require.NoError(t, os.WriteFile(fixture, gcm.Seal(nonce, nonce, []byte(plaintext), nil), 0600))
+
+	u, err := Parse("qemu+ssh:///system?sshauth=agent&config_file=" + fixture)
+	require.NoError(t, err)
+	assert.Equal(t, "hv1.example.com", u.Hostname())
+	assert.Equal(t, "2222", u.Port())
+	assert.Equal(t, "admin", u.User.Username())
+	password, ok := u.User.Password()
+	assert.True(t, ok)
+	assert.Equal(t, "s3cret", password)
+	assert.Equal(t, "/secrets/id_ed25519", u.Query().Get("keyfile"))
+	// the URI wins over the config file
+	assert.Equal(t, "agent", u.Query().Get("sshauth"))
+	assert.Equal(t, "qemu:///system", u.RemoteName())
+
+	// the wrong key fails decryption
+	t.Setenv(testConfigKeyEnv, hex.EncodeToString(make([]byte, 32)))
+	_, err = Parse("qemu+ssh:///system?config_file=" + fixture)
+	assert.Error(t, err)
+}
+
+func TestConfigFileIPv6Host(t *testing.T) {
+	RegisterDecryptor(".testplain", DecryptorFunc(func(path string, ciphertext []byte) ([]byte, error) {
+		return ciphertext, nil
+	}))
+
+	tests := map[string]struct {
+		uri      string
+		contents string
+		host     string
+	}{
+		"uri without port": {uri: "qemu+ssh://[::1]/system", contents: `{"user": "admin"}`, host: "[::1]"},
+		"config host":      {uri: "qemu+ssh:///system", contents: `{"host": "fd00::10"}`, host: "[fd00::10]"},
+		"config port":      {uri: "qemu+ssh://[::1]/system", contents: `{"port": "2222"}`, host: "[::1]:2222"},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			fixture := filepath.Join(t.TempDir(), "config.testplain")
+			require.NoError(t, os.WriteFile(fixture, []byte(test.contents), 0600))
+
+			u, err := Parse(test.uri + "?config_file=" + fixture)
+			require.NoError(t, err)
+			assert.Equal(t, test.host, u.Host)
+			assert.Equal(t, strings.Trim(strings.Split(test.host, "]")[0], "["), u.Hostname())
+		})
+	}
+}
+
+func TestAgeDecryptorWithoutIdentity(t *testing.T) {
+	t.Setenv("LIBVIRT_AGE_IDENTITY", "")
+	t.Setenv("SOPS_AGE_KEY_FILE", "")
+
+	_, err := ageDecryptor.Decrypt("secret.age", nil)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "set LIBVIRT_AGE_IDENTITY or SOPS_AGE_KEY_FILE")
+}
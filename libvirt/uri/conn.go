@@ -0,0 +1,172 @@
+package uri
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Conn is the connection returned by Dial.
+//
+// The timeouts used while connecting never apply to the returned
+// connection, so a long transfer such as a volume upload is not cut short
+// by them. The layer above bounds such operations itself, with
+// ExtendDeadline or the usual deadline methods.
+type Conn struct {
+	net.Conn
+
+	// transport is the connection carrying Conn, when deadlines cannot be
+	// set on Conn itself, like for a channel of an SSH connection
+	transport net.Conn
+	// ssh is the SSH connection carrying Conn, for SSH
+	ssh *sshTransport
+
+	// hostKeyVerified is set when the host key of the SSH server was
+	// checked against the known hosts
+	hostKeyVerified bool
+
+	// expiry is when the credential the connection authenticated with
+	// expires, if it does
+	expiry time.Time
+	// recycled is closed when the connection is closed ahead of expiry
+	recycled chan struct{}
+
+	mu           sync.Mutex
+	recycleTimer *time.Timer
+
+	// counts holds the bytes moved over the connection, when account_bytes
+	// is set
+	counts *byteCounts
+
+	// metrics is told when the connection is closed, when set
+	metrics Metrics
+	closed  bool
+}
+
+type byteCounts struct {
+	read    int64
+	written int64
+
+	logf func(format string, v ...interface{})
+}
+
+// Read counts the bytes read once per call, so that accounting costs no
+// more than an atomic add on the data path.
+func (c *Conn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if c.counts != nil {
+		atomic.AddInt64(&c.counts.read, int64(n))
+	}
+	if err != nil {
+		err = c.transportError(err)
+	}
+	return n, err
+}
+
+func (c *Conn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	if c.counts != nil {
+		atomic.AddInt64(&c.counts.written, int64(n))
+	}
+	if err != nil {
+		err = c.transportError(err)
+	}
+	return n, err
+}
+
+// HostKeyVerified reports whether the connection verified the host key of
+// the SSH server, rather than accepting any key because verification was
+// disabled. It is false for the other transports.
+func (c *Conn) HostKeyVerified() bool {
+	return c.hostKeyVerified
+}
+
+// CredentialExpiry returns when the certificate the connection
+// authenticated with expires, or the zero time when it does not expire.
+func (c *Conn) CredentialExpiry() time.Time {
+	return c.expiry
+}
+
+// Recycled returns a channel that is closed when the connection is closed
+// ahead of the expiry of its certificate, because of cert_renew_before. The
+// caller is then expected to dial again, which authenticates with the
+// renewed certificate. Without cert_renew_before, it is never closed.
+func (c *Conn) Recycled() <-chan struct{} {
+	return c.recycled
+}
+
+// BytesRead returns the number of bytes read from the connection so far. It
+// is always 0 unless the URI has account_bytes set.
+func (c *Conn) BytesRead() int64 {
+	if c.counts == nil {
+		return 0
+	}
+	return atomic.LoadInt64(&c.counts.read)
+}
+
+// BytesWritten returns the number of bytes written to the connection so
+// far. It is always 0 unless the URI has account_bytes set.
+func (c *Conn) BytesWritten() int64 {
+	if c.counts == nil {
+		return 0
+	}
+	return atomic.LoadInt64(&c.counts.written)
+}
+
+// Close closes the connection. For SSH it also closes the underlying SSH
+// connection, which is not shared with other connections, so that its file
+// descriptor is freed.
+func (c *Conn) Close() error {
+	c.mu.Lock()
+	if c.recycleTimer != nil {
+		c.recycleTimer.Stop()
+	}
+	closing := !c.closed
+	c.closed = true
+	c.mu.Unlock()
+	if c.metrics != nil && closing {
+		c.metrics.OpenConns(-1)
+	}
+	err := c.Conn.Close()
+	if c.transport != nil {
+		c.transport.Close()
+	}
+	releaseFD()
+	if c.counts != nil {
+		c.counts.logf("[DEBUG] connection closed after reading %d bytes and writing %d bytes", c.BytesRead(), c.BytesWritten())
+	}
+	return err
+}
+
+func (c *Conn) deadlineConn() net.Conn {
+	if c.transport != nil {
+		return c.transport
+	}
+	return c.Conn
+}
+
+// SetDeadline sets the read and write deadlines of the connection. For SSH
+// they apply to the underlying SSH connection, so a missed deadline closes
+// it.
+func (c *Conn) SetDeadline(t time.Time) error {
+	return c.deadlineConn().SetDeadline(t)
+}
+
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	return c.deadlineConn().SetReadDeadline(t)
+}
+
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	return c.deadlineConn().SetWriteDeadline(t)
+}
+
+// ExtendDeadline gives pending and future I/O d more time from now, or
+// removes the deadline when d is 0. Call it before starting a long running
+// operation.
+func (c *Conn) ExtendDeadline(d time.Duration) error {
+	if d == 0 {
+		return c.SetDeadline(time.Time{})
+	}
+	return c.SetDeadline(time.Now().Add(d))
+}
@@ -0,0 +1,224 @@
+package uri
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestConnTransferOutlivesConnectTimeout(t *testing.T) {
+	s := newTestSSHServer(t)
+	s.streamlocal = func(_ string, ch ssh.Channel) {
+		defer ch.Close()
+		// a slow transfer, well past the total connection timeout
+		for i := 0; i < 8; i++ {
+			time.Sleep(100 * time.Millisecond)
+			if _, err := io.CopyN(ch, ch, 64*1024); err != nil {
+				return
+			}
+		}
+	}
+
+	u := testSSHURI(t, s, "total_timeout=300ms")
+	conn, err := u.Dial()
+	require.NoError(t, err)
+	defer conn.Close()
+
+	chunk := bytes.Repeat([]byte{0x42}, 64*1024)
+	buf := make([]byte, len(chunk))
+	for i := 0; i < 8; i++ {
+		_, err := conn.Write(chunk)
+		require.NoError(t, err)
+		_, err = io.ReadFull(conn, buf)
+		require.NoError(t, err, "chunk %d", i)
+	}
+}
+
+func TestConnExtendDeadline(t *testing.T) {
+	s := newTestSSHServer(t)
+	s.streamlocal = func(_ string, ch ssh.Channel) {
+		// never answers
+		io.Copy(io.Discard, ch)
+	}
+
+	u := testSSHURI(t, s, "")
+	c, err := u.Dial()
+	require.NoError(t, err)
+	defer c.Close()
+	conn, ok := c.(*Conn)
+	require.True(t, ok)
+
+	require.NoError(t, conn.ExtendDeadline(50*time.Millisecond))
+	_, err = conn.Read(make([]byte, 1))
+	require.Error(t, err)
+}
+
+func TestConnClearDeadline(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		time.Sleep(200 * time.Millisecond)
+		c.Write([]byte("ok"))
+	}()
+
+	u, err := Parse("qemu+tcp://" + l.Addr().String() + "/system")
+	require.NoError(t, err)
+	c, err := u.Dial()
+	require.NoError(t, err)
+	defer c.Close()
+	conn := c.(*Conn)
+
+	require.NoError(t, conn.ExtendDeadline(50*time.Millisecond))
+	_, err = conn.Read(make([]byte, 2))
+	var netErr net.Error
+	require.True(t, errors.As(err, &netErr) && netErr.Timeout(), "expected a timeout, got %v", err)
+
+	require.NoError(t, conn.ExtendDeadline(0))
+	buf := make([]byte, 2)
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	assert.Equal(t, "ok", string(buf))
+}
+
+func TestConnSurvivesServerRekey(t *testing.T) {
+	s := newTestSSHServer(t)
+	s.Configure(func(config *ssh.ServerConfig) {
+		// a single algorithm of each kind leaves no room for negotiation
+		config.KeyExchanges = []string{"curve25519-sha256"}
+		config.Ciphers = []string{"aes128-gcm@openssh.com"}
+		config.MACs = []string{"hmac-sha2-256"}
+		// rekey every few KiB, so a transfer goes through many of them
+		config.RekeyThreshold = 16 * 1024
+	})
+	s.streamlocal = func(_ string, ch ssh.Channel) {
+		defer ch.Close()
+		io.Copy(ch, ch)
+	}
+
+	u := testSSHURI(t, s, "")
+	conn, err := u.Dial()
+	require.NoError(t, err)
+	defer conn.Close()
+
+	chunk := bytes.Repeat([]byte{0x42}, 32*1024)
+	buf := make([]byte, len(chunk))
+	for i := 0; i < 16; i++ {
+		_, err := conn.Write(chunk)
+		require.NoError(t, err)
+		_, err = io.ReadFull(conn, buf)
+		require.NoError(t, err, "chunk %d", i)
+	}
+}
+
+func TestConnRekeyFailure(t *testing.T) {
+	s := newTestSSHServer(t)
+	s.Configure(func(config *ssh.ServerConfig) {
+		config.RekeyThreshold = 16 * 1024
+	})
+	s.streamlocal = func(_ string, ch ssh.Channel) {
+		defer ch.Close()
+		io.Copy(ch, ch)
+	}
+
+	u := testSSHURI(t, s, "")
+	q := u.Query()
+	q.Del("no_verify")
+	u.RawQuery = q.Encode()
+	// the host key is known for the first key exchange only, so that the
+	// first re-exchange fails
+	var lookups int32
+	u.HostKeyStore = HostKeyStoreFunc(func(ctx context.Context, hostname string) ([]ssh.PublicKey, error) {
+		if atomic.AddInt32(&lookups, 1) > 1 {
+			return nil, errors.New("store offline")
+		}
+		return []ssh.PublicKey{s.hostKey.PublicKey()}, nil
+	})
+	conn, err := u.Dial()
+	require.NoError(t, err)
+	defer conn.Close()
+
+	chunk := bytes.Repeat([]byte{0x42}, 32*1024)
+	buf := make([]byte, len(chunk))
+	for i := 0; i < 16 && err == nil; i++ {
+		if _, err = conn.Write(chunk); err == nil {
+			_, err = io.ReadFull(conn, buf)
+		}
+	}
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "SSH key re-exchange with "+s.Addr()+" failed on the established connection: host key verification failed")
+	assert.Contains(t, err.Error(), "store offline")
+}
+
+func TestRekeyFailure(t *testing.T) {
+	tests := map[string]struct {
+		err    error
+		reason string
+	}{
+		"closed":            {err: io.EOF},
+		"host key":          {err: &hostKeyError{err: errors.New("key mismatch")}, reason: "host key verification failed"},
+		"no common":         {err: errors.New("ssh: no common algorithm for key exchange; client offered: [a], server offered: [b]"), reason: "no common algorithm"},
+		"server disconnect": {err: errors.New("ssh: disconnect, reason 3: key exchange failed"), reason: "key exchange failed"},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, test.reason, rekeyFailure(test.err))
+		})
+	}
+}
+
+func TestConnAccountBytes(t *testing.T) {
+	s := newTestSSHServer(t)
+	s.streamlocal = func(_ string, ch ssh.Channel) {
+		defer ch.Close()
+		// answers every 1000 bytes received with 10
+		buf := make([]byte, 1000)
+		for {
+			if _, err := io.ReadFull(ch, buf); err != nil {
+				return
+			}
+			if _, err := ch.Write(buf[:10]); err != nil {
+				return
+			}
+		}
+	}
+
+	u := testSSHURI(t, s, "account_bytes=true")
+	c, err := u.Dial()
+	require.NoError(t, err)
+	defer c.Close()
+	conn := c.(*Conn)
+
+	buf := make([]byte, 10)
+	for i := 0; i < 300; i++ {
+		_, err := conn.Write(bytes.Repeat([]byte{0x42}, 1000))
+		require.NoError(t, err)
+		_, err = io.ReadFull(conn, buf)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, int64(300*1000), conn.BytesWritten())
+	assert.Equal(t, int64(300*10), conn.BytesRead())
+
+	// off by default
+	c, err = testSSHURI(t, s, "").Dial()
+	require.NoError(t, err)
+	defer c.Close()
+	_, err = c.Write(make([]byte, 1000))
+	require.NoError(t, err)
+	assert.Zero(t, c.(*Conn).BytesWritten())
+}
@@ -4,27 +4,176 @@
 package uri
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net"
 	"net/url"
+	"os"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
 const (
-	dialTimeout = 2 * time.Second
+	dialTimeout              = 2 * time.Second
+	defaultConnectRetryDelay = time.Second
 )
 
+// lookupHost resolves the addresses of the remote host. It is consulted on
+// every dial instead of caching the result, so that a reconnect after a DNS
+// based failover reaches the new address instead of the dead one.
+var lookupHost = net.DefaultResolver.LookupHost
+
+// dialHost resolves host and tries the resulting addresses in order until one
+// of them accepts the connection.
+//
+// With resolved_ip the host is not resolved at all and that address is
+// dialed instead. Everything else, like ssh config matching and host key
+// verification, still uses the host name.
+//
+// With bind_interface the connection leaves through that network interface.
+//
+// With address_family=inet6-first the IPv6 addresses are tried before the
+// IPv4 ones, each with the full dial timeout, so that IPv4 is only used when
+// IPv6 actually fails rather than when it is merely slow.
+func (u *ConnectionURI) dialHost(ctx context.Context, network, host, port string) (net.Conn, error) {
+	family := u.Query().Get("address_family")
+	switch family {
+	case "", "inet6-first":
+	default:
+		return nil, fmt.Errorf("invalid value '%s' for address_family", family)
+	}
+
+	var addrs []string
+	if ip := u.Query().Get("resolved_ip"); ip != "" && host == u.Hostname() {
+		if net.ParseIP(ip) == nil {
+			return nil, fmt.Errorf("invalid value '%s' for resolved_ip", ip)
+		}
+		u.logf("[DEBUG] connecting to %s at %s, without resolving it", host, ip)
+		addrs = []string{ip}
+	} else {
+		lookupCtx, cancel := context.WithTimeout(ctx, dialTimeout)
+		defer cancel()
+
+		var err error
+		start := time.Now()
+		addrs, err = lookupHost(lookupCtx, host)
+		profileStep(ctx, profileDNS, start, time.Now(), err)
+		if hostNotFound(err) {
+			return nil, fmt.Errorf("host %s does not resolve: %w", host, err)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if family == "inet6-first" {
+		addrs = preferIPv6(addrs)
+	}
+
+	bindInterface := u.Query().Get("bind_interface")
+	var lastErr error
+	for _, addr := range addrs {
+		d := net.Dialer{Timeout: dialTimeout}
+		if bindInterface != "" {
+			localAddr, err := u.bindInterfaceAddr(bindInterface, addr)
+			if err != nil {
+				lastErr = err
+				continue
+			}
+			d.LocalAddr = localAddr
+			d.Control = u.bindToDevice(bindInterface)
+		}
+		address := net.JoinHostPort(addr, port)
+		start := time.Now()
+		c, err := u.dialWithFDBackpressure(ctx, func() (net.Conn, error) {
+			return d.DialContext(ctx, network, address)
+		})
+		profileStep(ctx, profileTCP, start, time.Now(), err)
+		if err == nil {
+			return c, nil
+		}
+		u.logf("[DEBUG] failed to connect to %s: %v", addr, err)
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// hostNotFound reports whether err says that the host name does not exist
+// (NXDOMAIN). Unlike a DNS timeout or server failure, that is not fixed by
+// trying again, typically it is a typo.
+func hostNotFound(err error) bool {
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr) && dnsErr.IsNotFound
+}
+
+// preferIPv6 returns addrs with the IPv6 addresses first, keeping the
+// resolver order within each family.
+func preferIPv6(addrs []string) []string {
+	var v6, v4 []string
+	for _, addr := range addrs {
+		if ip := net.ParseIP(addr); ip != nil && ip.To4() == nil {
+			v6 = append(v6, addr)
+		} else {
+			v4 = append(v4, addr)
+		}
+	}
+	return append(v6, v4...)
+}
+
 type ConnectionURI struct {
 	*url.URL
+
+	// OnEvent, when set, is called as the dial progresses through its
+	// phases, to give live feedback to users.
+	OnEvent func(ConnectionEvent)
+
+	// PrivateKey, when set, is the PEM encoded key used by the privkey
+	// authentication method instead of the one read from keyfile. It is
+	// never logged.
+	PrivateKey []byte
+
+	// PassphraseProvider, when set, supplies the passphrases of encrypted
+	// private keys instead of keyfile_passphrase and the
+	// LIBVIRT_SSH_KEY_PASSPHRASE environment variable.
+	PassphraseProvider PassphraseProvider
+
+	// SSHConfig, when set, is the ssh config content used instead of the
+	// file given by the ssh_config parameter.
+	SSHConfig string
+
+	// ChannelHandlers, when set, serve the channels the SSH server opens
+	// towards the client, by channel type (e.g. "forwarded-tcpip").
+	ChannelHandlers map[string]ChannelHandler
+
+	// HostKeyStore, when set, supplies the known host keys instead of the
+	// known_hosts file.
+	HostKeyStore HostKeyStore
+
+	// Audit, when set, receives a record of every connection attempt.
+	Audit AuditSink
+
+	// Metrics, when set, receives the connection health metrics, see
+	// ExpvarMetrics.
+	Metrics Metrics
 }
 
+// Parse parses a libvirt connection URI. Like virsh, an empty uriStr falls
+// back to the LIBVIRT_DEFAULT_URI environment variable.
 func Parse(uriStr string) (*ConnectionURI, error) {
+	if uriStr == "" {
+		uriStr = os.Getenv("LIBVIRT_DEFAULT_URI")
+	}
 	url, err := url.Parse(uriStr)
 	if err != nil {
 		return nil, err
 	}
-	return &ConnectionURI{URL: url}, nil
+	u := &ConnectionURI{URL: url}
+	if err := u.applyConfigFile(); err != nil {
+		return nil, err
+	}
+	return u, nil
 }
 
 // According to https://libvirt.org/uri.html
@@ -62,23 +211,161 @@ func (u *ConnectionURI) driver() string {
 	return strings.Split(u.Scheme, "+")[0]
 }
 
+// durationParam returns the duration given in the named query parameter,
+// either as a Go duration ("1m30s") or as a number of seconds.
+func (u *ConnectionURI) durationParam(name string) (time.Duration, error) {
+	v := u.Query().Get(name)
+	if v == "" {
+		return 0, nil
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		return time.Duration(secs) * time.Second, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value '%s' for %s: %w", v, name, err)
+	}
+	return d, nil
+}
+
 // Dial implements go-libvirt Dialer interface, which is used
 // to retrieve connections to talk via RPC to libvirtd.
 //
 // For example, a qemu+ssh:/// uri would return a SSH connection
 // to localhost, and a new URI to qemu+unix:///system
 // dials the transport for this connection URI.
+//
+// Failed dials are retried connect_retries times, waiting
+// connect_retry_delay in between, except for a host name that does not
+// resolve. Until a host was reached once,
+// initial_connect_retries is used instead, when set, to wait for a host
+// that is still booting. total_timeout bounds the whole
+// process, retries included, but not the use of the returned *Conn.
+//
+// While the host is in maintenance (see maintenanceError), Dial fails
+// right away with the maintenance message.
 func (u *ConnectionURI) Dial() (net.Conn, error) {
+	return u.dialContext(context.Background())
+}
+
+func (u *ConnectionURI) dialContext(ctx context.Context) (net.Conn, error) {
+	if err := u.maintenanceError(); err != nil {
+		return nil, u.failed(ctx, err)
+	}
+	q := u.Query()
+
+	totalTimeout, err := u.durationParam("total_timeout")
+	if err != nil {
+		return nil, err
+	}
+	retryDelay, err := u.durationParam("connect_retry_delay")
+	if err != nil {
+		return nil, err
+	}
+	if retryDelay == 0 {
+		retryDelay = defaultConnectRetryDelay
+	}
+	reconnect := contacted(u.contactKey())
+	retriesParam := "connect_retries"
+	if !reconnect && q.Get("initial_connect_retries") != "" {
+		retriesParam = "initial_connect_retries"
+	}
+	retries := 0
+	if v := q.Get(retriesParam); v != "" {
+		if retries, err = strconv.Atoi(v); err != nil {
+			return nil, fmt.Errorf("invalid value '%s' for %s: %w", v, retriesParam, err)
+		}
+	}
+
+	if totalTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, totalTimeout)
+		defer cancel()
+	}
+
+	ctx = u.startProfile(ctx)
+	var attempts []string
+	for attempt := 1; ; attempt++ {
+		attemptCtx := u.startAudit(context.WithValue(ctx, attemptKey{}, attempt), attempt)
+		u.emit(attemptCtx, PhaseConnecting, nil)
+		start := time.Now()
+		c, err := u.dialTransport(attemptCtx)
+		u.audit(attemptCtx, err)
+		u.observe(attemptCtx, start, reconnect, err)
+		if err == nil {
+			conn, ok := c.(*Conn)
+			if !ok {
+				conn = &Conn{Conn: c}
+			}
+			if nonZero(u.Query().Get("account_bytes")) {
+				conn.counts = &byteCounts{logf: u.logf}
+			}
+			if u.Metrics != nil {
+				conn.metrics = u.Metrics
+				u.Metrics.OpenConns(1)
+			}
+			markContacted(u.contactKey())
+			u.emitConnected(attemptCtx, conn)
+			u.finishProfile(ctx, attempt, nil)
+			return conn, nil
+		}
+		attempts = append(attempts, fmt.Sprintf("attempt %d: %v", attempt, err))
+
+		if ctx.Err() != nil {
+			err = u.totalTimeoutError(totalTimeout, attempts)
+			u.finishProfile(ctx, attempt, err)
+			return nil, u.failed(attemptCtx, err)
+		}
+		if attempt > retries || hostNotFound(err) {
+			u.finishProfile(ctx, attempt, err)
+			return nil, u.failed(attemptCtx, err)
+		}
+		u.logf("[DEBUG] connection attempt %d to '%s' failed, retrying in %s: %v", attempt, u.Host, retryDelay, err)
+
+		select {
+		case <-time.After(retryDelay):
+		case <-ctx.Done():
+			err = u.totalTimeoutError(totalTimeout, attempts)
+			u.finishProfile(ctx, attempt, err)
+			return nil, u.failed(attemptCtx, err)
+		}
+	}
+}
+
+// contactedHosts records the hosts that were reached during this run.
+var (
+	contactedMutex sync.Mutex
+	contactedHosts = make(map[string]bool)
+)
+
+// contactKey identifies the host a URI connects to.
+func (u *ConnectionURI) contactKey() string {
+	return u.transport() + "://" + u.Host
+}
+
+func contacted(key string) bool {
+	contactedMutex.Lock()
+	defer contactedMutex.Unlock()
+	return contactedHosts[key]
+}
+
+func markContacted(key string) {
+	contactedMutex.Lock()
+	defer contactedMutex.Unlock()
+	contactedHosts[key] = true
+}
+
+func (u *ConnectionURI) totalTimeoutError(timeout time.Duration, attempts []string) error {
+	return fmt.Errorf("exceeded total connection timeout of %s after %d attempts (%s)",
+		timeout, len(attempts), strings.Join(attempts, "; "))
+}
+
+// dialTransport dials the transport for this connection URI once.
+func (u *ConnectionURI) dialTransport(ctx context.Context) (net.Conn, error) {
 	t := u.transport()
-	switch t {
-	case "tcp":
-		return u.dialTCP()
-	case "tls":
-		return u.dialTLS()
-	case "unix":
-		return u.dialUNIX()
-	case "ssh":
-		return u.dialSSH()
-	}
-	return nil, fmt.Errorf("transport '%s' not implemented", t)
+	newTransport := transportFor(t)
+	if newTransport == nil {
+		return nil, fmt.Errorf("transport '%s' not implemented", t)
+	}
+	return newTransport(u).Dial(ctx)
 }
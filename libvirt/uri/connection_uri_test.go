@@ -1,8 +1,13 @@
 package uri
 
 import (
-	"github.com/stretchr/testify/assert"
+	"context"
+	"net"
 	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestURI(t *testing.T) {
@@ -30,3 +35,290 @@ func TestURI(t *testing.T) {
 		assert.Equal(t, fixture.RemoteName, u.RemoteName())
 	}
 }
+
+func TestParseDefaultURI(t *testing.T) {
+	t.Setenv("LIBVIRT_DEFAULT_URI", "qemu+ssh://root@hv1.example.com/system?sshauth=agent")
+
+	u, err := Parse("")
+	require.NoError(t, err)
+	assert.Equal(t, "ssh", u.transport())
+	assert.Equal(t, "hv1.example.com", u.Hostname())
+	assert.Equal(t, "agent", u.Query().Get("sshauth"))
+	assert.Equal(t, "qemu:///system", u.RemoteName())
+
+	// an explicit URI wins
+	u, err = Parse("qemu:///session")
+	require.NoError(t, err)
+	assert.Equal(t, "qemu:///session", u.RemoteName())
+}
+
+func TestDialReResolvesHost(t *testing.T) {
+	oldNode, err := net.Listen("tcp", "127.0.0.2:0")
+	if err != nil {
+		t.Skipf("127.0.0.2 not available: %v", err)
+	}
+	_, port, err := net.SplitHostPort(oldNode.Addr().String())
+	require.NoError(t, err)
+	newNode, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", port))
+	require.NoError(t, err)
+	defer newNode.Close()
+
+	resolved := []string{"127.0.0.2", "127.0.0.1"}
+	lookups := 0
+	oldLookupHost := lookupHost
+	lookupHost = func(ctx context.Context, host string) ([]string, error) {
+		assert.Equal(t, "libvirt.example.com", host)
+		addr := resolved[lookups]
+		lookups++
+		return []string{addr}, nil
+	}
+	defer func() { lookupHost = oldLookupHost }()
+
+	u, err := Parse("qemu+tcp://libvirt.example.com:" + port + "/system")
+	require.NoError(t, err)
+
+	conn, err := u.Dial()
+	require.NoError(t, err)
+	assert.Equal(t, oldNode.Addr().String(), conn.RemoteAddr().String())
+	conn.Close()
+
+	// the old node goes away and DNS now points to the new one
+	oldNode.Close()
+
+	conn, err = u.Dial()
+	require.NoError(t, err)
+	assert.Equal(t, newNode.Addr().String(), conn.RemoteAddr().String())
+	conn.Close()
+	assert.Equal(t, 2, lookups)
+}
+
+func TestDialDNSFailures(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	_, port, err := net.SplitHostPort(l.Addr().String())
+	require.NoError(t, err)
+
+	var failures []error
+	lookups := 0
+	oldLookupHost := lookupHost
+	lookupHost = func(ctx context.Context, host string) ([]string, error) {
+		lookups++
+		if len(failures) > 0 {
+			err := failures[0]
+			failures = failures[1:]
+			return nil, err
+		}
+		return []string{"127.0.0.1"}, nil
+	}
+	defer func() { lookupHost = oldLookupHost }()
+
+	u, err := Parse("qemu+tcp://libvirt.example.com:" + port + "/system?connect_retries=3&connect_retry_delay=1ms")
+	require.NoError(t, err)
+
+	// NXDOMAIN is not retried
+	failures = []error{&net.DNSError{Err: "no such host", Name: "libvirt.example.com", IsNotFound: true}}
+	_, err = u.Dial()
+	assert.ErrorContains(t, err, "host libvirt.example.com does not resolve: ")
+	assert.Equal(t, 1, lookups)
+
+	// a DNS timeout is
+	lookups = 0
+	failures = []error{&net.DNSError{Err: "i/o timeout", Name: "libvirt.example.com", IsTimeout: true}}
+	conn, err := u.Dial()
+	require.NoError(t, err)
+	conn.Close()
+	assert.Equal(t, 2, lookups)
+
+	// and so is a server failure
+	lookups = 0
+	failures = []error{&net.DNSError{Err: "server misbehaving", Name: "libvirt.example.com", IsTemporary: true}}
+	conn, err = u.Dial()
+	require.NoError(t, err)
+	conn.Close()
+	assert.Equal(t, 2, lookups)
+}
+
+// closedPort returns a local TCP port nothing listens on.
+func closedPort(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	_, port, err := net.SplitHostPort(l.Addr().String())
+	require.NoError(t, err)
+	l.Close()
+	return port
+}
+
+func TestDialInet6First(t *testing.T) {
+	v6, err := net.Listen("tcp", "[::1]:0")
+	if err != nil {
+		t.Skipf("IPv6 loopback not available: %v", err)
+	}
+	_, port, err := net.SplitHostPort(v6.Addr().String())
+	require.NoError(t, err)
+	v4, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", port))
+	if err != nil {
+		v6.Close()
+		t.Skipf("port %s not available on IPv4: %v", port, err)
+	}
+	defer v4.Close()
+
+	oldLookupHost := lookupHost
+	lookupHost = func(ctx context.Context, host string) ([]string, error) {
+		return []string{"127.0.0.1", "::1"}, nil
+	}
+	defer func() { lookupHost = oldLookupHost }()
+
+	u, err := Parse("qemu+tcp://libvirt.example.com:" + port + "/system?address_family=inet6-first")
+	require.NoError(t, err)
+
+	conn, err := u.Dial()
+	require.NoError(t, err)
+	assert.Equal(t, v6.Addr().String(), conn.RemoteAddr().String())
+	conn.Close()
+
+	// IPv6 now refuses, so the dial falls back to IPv4
+	v6.Close()
+
+	conn, err = u.Dial()
+	require.NoError(t, err)
+	assert.Equal(t, v4.Addr().String(), conn.RemoteAddr().String())
+	conn.Close()
+
+	u, err = Parse("qemu+tcp://libvirt.example.com:" + port + "/system?address_family=inet7")
+	require.NoError(t, err)
+	_, err = u.Dial()
+	assert.ErrorContains(t, err, "invalid value 'inet7' for address_family")
+}
+
+func TestDialBindInterface(t *testing.T) {
+	var loopback string
+	ifaces, err := net.Interfaces()
+	require.NoError(t, err)
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagLoopback != 0 {
+			loopback = iface.Name
+			break
+		}
+	}
+	if loopback == "" {
+		t.Skip("no loopback interface")
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+
+	u, err := Parse("qemu+tcp://" + l.Addr().String() + "/system?bind_interface=" + loopback)
+	require.NoError(t, err)
+	conn, err := u.Dial()
+	require.NoError(t, err)
+	assert.Equal(t, "127.0.0.1", conn.LocalAddr().(*net.TCPAddr).IP.String())
+	conn.Close()
+
+	u, err = Parse("qemu+tcp://" + l.Addr().String() + "/system?bind_interface=nosuchif0")
+	require.NoError(t, err)
+	_, err = u.Dial()
+	assert.ErrorContains(t, err, "invalid value 'nosuchif0' for bind_interface")
+}
+
+func TestSelectInterfaceAddr(t *testing.T) {
+	ipNet := func(s string) net.Addr {
+		ip, n, err := net.ParseCIDR(s)
+		require.NoError(t, err)
+		n.IP = ip
+		return n
+	}
+	addrs := []net.Addr{
+		ipNet("fe80::1/64"),
+		ipNet("10.8.0.2/24"),
+		ipNet("fd00::2/64"),
+	}
+
+	assert.Equal(t, "10.8.0.2", selectInterfaceAddr(addrs, net.ParseIP("192.0.2.1")).String())
+	assert.Equal(t, "fd00::2", selectInterfaceAddr(addrs, net.ParseIP("2001:db8::1")).String())
+	assert.Nil(t, selectInterfaceAddr(addrs[:2], net.ParseIP("2001:db8::1")))
+	assert.Nil(t, selectInterfaceAddr(addrs[2:], net.ParseIP("192.0.2.1")))
+}
+
+func TestDialRetries(t *testing.T) {
+	u, err := Parse("qemu+tcp://127.0.0.1:" + closedPort(t) + "/system?connect_retries=2&connect_retry_delay=10ms")
+	require.NoError(t, err)
+
+	start := time.Now()
+	_, err = u.Dial()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "connection refused")
+	assert.GreaterOrEqual(t, time.Since(start), 20*time.Millisecond)
+}
+
+func TestDialInitialConnectRetries(t *testing.T) {
+	port := closedPort(t)
+	u, err := Parse("qemu+tcp://127.0.0.1:" + port + "/system?initial_connect_retries=3&connect_retries=1&connect_retry_delay=10ms")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		contactedMutex.Lock()
+		delete(contactedHosts, u.contactKey())
+		contactedMutex.Unlock()
+	})
+
+	attempts := 0
+	u.OnEvent = func(e ConnectionEvent) {
+		if e.Phase == PhaseConnecting {
+			attempts = e.Attempt
+		}
+	}
+
+	// the host was never reached, so it gets the generous budget
+	_, err = u.Dial()
+	require.Error(t, err)
+	assert.Equal(t, 4, attempts)
+	_, err = u.Dial()
+	require.Error(t, err)
+	assert.Equal(t, 4, attempts)
+
+	l, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", port))
+	require.NoError(t, err)
+	conn, err := u.Dial()
+	require.NoError(t, err)
+	conn.Close()
+	l.Close()
+
+	// once it was up, failures are reported quickly
+	_, err = u.Dial()
+	require.Error(t, err)
+	assert.Equal(t, 2, attempts)
+}
+
+func TestDialTotalTimeout(t *testing.T) {
+	// without a total timeout these retries would take about 10 seconds
+	u, err := Parse("qemu+tcp://127.0.0.1:" + closedPort(t) + "/system?connect_retries=100&connect_retry_delay=100ms&total_timeout=350ms")
+	require.NoError(t, err)
+
+	start := time.Now()
+	_, err = u.Dial()
+	elapsed := time.Since(start)
+	require.Error(t, err)
+	assert.Less(t, elapsed, time.Second)
+	assert.Regexp(t, `^exceeded total connection timeout of 350ms after [0-9]+ attempts \(attempt 1: .*connection refused`, err.Error())
+}
+
+func TestDurationParam(t *testing.T) {
+	u, err := Parse("qemu:///system?a=30&b=1m30s&c=soon")
+	require.NoError(t, err)
+
+	d, err := u.durationParam("a")
+	assert.NoError(t, err)
+	assert.Equal(t, 30*time.Second, d)
+
+	d, err = u.durationParam("b")
+	assert.NoError(t, err)
+	assert.Equal(t, 90*time.Second, d)
+
+	_, err = u.durationParam("c")
+	assert.Error(t, err)
+
+	d, err = u.durationParam("missing")
+	assert.NoError(t, err)
+	assert.Zero(t, d)
+}
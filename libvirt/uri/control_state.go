@@ -0,0 +1,129 @@
+package uri
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// The control state file remembers, for every host, the ControlMaster socket
+// a connection went through, so that a later provider process can attach to
+// the same master instead of doing a new SSH handshake. Only the socket
+// path is kept: the master itself lives as long as its ControlPersist.
+var controlStateMutex sync.Mutex
+
+// controlStateFile returns the file given by control_state_file, or "".
+func (u *ConnectionURI) controlStateFile() string {
+	path := u.Query().Get("control_state_file")
+	if path == "" {
+		return ""
+	}
+	return os.ExpandEnv(strings.Replace(path, "~", "$HOME", 1))
+}
+
+func readControlState(path string) (map[string]string, error) {
+	state := make(map[string]string)
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(content, &state); err != nil {
+		return nil, fmt.Errorf("invalid control state file %s: %w", path, err)
+	}
+	return state, nil
+}
+
+// writeControlState replaces the file atomically, as other provider
+// processes may read it at the same time.
+func writeControlState(path string, state map[string]string) error {
+	content, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(content, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// saveControlPath records the ControlMaster socket the host was reached
+// through in the control state file, if there is one, or removes the entry
+// of the host when controlPath is "".
+func (u *ConnectionURI) saveControlPath(controlPath string) {
+	path := u.controlStateFile()
+	if path == "" {
+		return
+	}
+	controlStateMutex.Lock()
+	defer controlStateMutex.Unlock()
+
+	state, err := readControlState(path)
+	if err != nil {
+		u.logf("[WARN] failed to read control state: %v", err)
+		return
+	}
+	key := u.contactKey()
+	if state[key] == controlPath {
+		return
+	}
+	if controlPath == "" {
+		delete(state, key)
+	} else {
+		state[key] = controlPath
+	}
+	if err := writeControlState(path, state); err != nil {
+		u.logf("[WARN] failed to write control state: %v", err)
+	}
+}
+
+// dialSavedControlMaster attaches to the ControlMaster recorded for the host
+// in the control state file. It returns nil when there is none, or when the
+// master is gone, in which case the stale entry is dropped and the caller
+// connects on its own.
+func (u *ConnectionURI) dialSavedControlMaster(ctx context.Context, host, port string) net.Conn {
+	path := u.controlStateFile()
+	if path == "" {
+		return nil
+	}
+	controlStateMutex.Lock()
+	state, err := readControlState(path)
+	controlStateMutex.Unlock()
+	if err != nil {
+		u.logf("[WARN] failed to read control state: %v", err)
+		return nil
+	}
+	controlPath := state[u.contactKey()]
+	if controlPath == "" {
+		return nil
+	}
+
+	conn, err := u.dialControlMaster(ctx, controlPath, host, port)
+	if err != nil {
+		u.logf("[DEBUG] ControlMaster %s of %s is gone, connecting directly: %v", controlPath, u.Hostname(), err)
+		u.saveControlPath("")
+		return nil
+	}
+	u.logf("[DEBUG] attached to the ControlMaster %s of %s", controlPath, u.Hostname())
+	return conn
+}
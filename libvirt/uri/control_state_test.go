@@ -0,0 +1,234 @@
+package uri
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+// testControlMaster is a minimal stand-in for an OpenSSH ControlMaster in
+// proxy mode (PROTOCOL.mux): it forwards the direct-tcpip channels opened by
+// its clients to plain TCP connections.
+type testControlMaster struct {
+	Path     string
+	listener net.Listener
+
+	mu    sync.Mutex
+	opens []string
+}
+
+func newTestControlMaster(t *testing.T) *testControlMaster {
+	m := &testControlMaster{Path: filepath.Join(t.TempDir(), "master.sock")}
+	l, err := net.Listen("unix", m.Path)
+	require.NoError(t, err)
+	m.listener = l
+	t.Cleanup(m.Stop)
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go m.handleConn(conn)
+		}
+	}()
+	return m
+}
+
+// Stop closes the master, like it does once ControlPersist expires.
+func (m *testControlMaster) Stop() {
+	m.listener.Close()
+}
+
+// Opens returns the addresses of the channels opened so far.
+func (m *testControlMaster) Opens() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]string(nil), m.opens...)
+}
+
+func readMuxMessage(r io.Reader) ([]byte, error) {
+	var l uint32
+	if err := binary.Read(r, binary.BigEndian, &l); err != nil {
+		return nil, err
+	}
+	b := make([]byte, l)
+	_, err := io.ReadFull(r, b)
+	return b, err
+}
+
+func writeMuxMessage(w io.Writer, values ...uint32) error {
+	b := make([]byte, 4+4*len(values))
+	binary.BigEndian.PutUint32(b, uint32(4*len(values)))
+	for i, v := range values {
+		binary.BigEndian.PutUint32(b[4+4*i:], v)
+	}
+	_, err := w.Write(b)
+	return err
+}
+
+func (m *testControlMaster) handleConn(conn net.Conn) {
+	defer conn.Close()
+
+	// hello, then the proxy request
+	if _, err := readMuxMessage(conn); err != nil {
+		return
+	}
+	if writeMuxMessage(conn, 0x00000001, 4) != nil {
+		return
+	}
+	req, err := readMuxMessage(conn)
+	if err != nil || len(req) < 8 {
+		return
+	}
+	if writeMuxMessage(conn, 0x8000000f, binary.BigEndian.Uint32(req[4:])) != nil {
+		return
+	}
+
+	// from here on, SSH connection protocol packets with a padding byte
+	var writeMu sync.Mutex
+	send := func(msg interface{}) {
+		payload := ssh.Marshal(msg)
+		b := make([]byte, 5, 5+len(payload))
+		binary.BigEndian.PutUint32(b, uint32(len(payload)+1))
+		writeMu.Lock()
+		conn.Write(append(b, payload...))
+		writeMu.Unlock()
+	}
+
+	type channelOpen struct {
+		ChanType    string `sshtype:"90"`
+		PeersID     uint32
+		PeersWindow uint32
+		MaxPacket   uint32
+		Rest        []byte `ssh:"rest"`
+	}
+	type openConfirm struct {
+		PeersID   uint32 `sshtype:"91"`
+		MyID      uint32
+		MyWindow  uint32
+		MaxPacket uint32
+	}
+	type channelData struct {
+		PeersID uint32 `sshtype:"94"`
+		Data    []byte
+	}
+	type channelClose struct {
+		PeersID uint32 `sshtype:"97"`
+	}
+
+	channels := make(map[uint32]net.Conn)
+	defer func() {
+		for _, c := range channels {
+			c.Close()
+		}
+	}()
+	for {
+		packet, err := readMuxMessage(conn)
+		if err != nil || len(packet) < 2 {
+			return
+		}
+		payload := packet[1:]
+		switch payload[0] {
+		case 90:
+			var open channelOpen
+			var target struct {
+				Host     string
+				Port     uint32
+				OrigHost string
+				OrigPort uint32
+			}
+			if ssh.Unmarshal(payload, &open) != nil || ssh.Unmarshal(open.Rest, &target) != nil {
+				return
+			}
+			addr := net.JoinHostPort(target.Host, strconv.Itoa(int(target.Port)))
+			m.mu.Lock()
+			m.opens = append(m.opens, addr)
+			m.mu.Unlock()
+			c, err := net.Dial("tcp", addr)
+			if err != nil {
+				return
+			}
+			id := uint32(len(channels))
+			channels[id] = c
+			send(openConfirm{PeersID: open.PeersID, MyID: id, MyWindow: 1 << 30, MaxPacket: 1 << 15})
+			go func(peer uint32, c net.Conn, maxPacket uint32) {
+				buf := make([]byte, maxPacket)
+				for {
+					n, err := c.Read(buf)
+					if n > 0 {
+						send(channelData{PeersID: peer, Data: append([]byte(nil), buf[:n]...)})
+					}
+					if err != nil {
+						send(channelClose{PeersID: peer})
+						return
+					}
+				}
+			}(open.PeersID, c, open.MaxPacket)
+		case 94:
+			var data struct {
+				MyID uint32 `sshtype:"94"`
+				Data []byte
+			}
+			if ssh.Unmarshal(payload, &data) != nil {
+				return
+			}
+			if c, ok := channels[data.MyID]; ok {
+				c.Write(data.Data)
+			}
+		case 97:
+			id := binary.BigEndian.Uint32(payload[1:])
+			if c, ok := channels[id]; ok {
+				c.Close()
+				delete(channels, id)
+			}
+		}
+	}
+}
+
+func TestDialSSHSavedControlMaster(t *testing.T) {
+	s := newTestSSHServer(t)
+	m := newTestControlMaster(t)
+	stateFile := filepath.Join(t.TempDir(), "control_state.json")
+
+	// the first provider process is told about the master
+	u := testSSHURI(t, s, "SSHControlPath="+m.Path+"&control_state_file="+stateFile)
+	conn, err := u.Dial()
+	require.NoError(t, err)
+	conn.Close()
+	assert.Equal(t, []string{s.Addr()}, m.Opens())
+
+	state, err := readControlState(stateFile)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"ssh://" + s.Addr(): m.Path}, state)
+
+	// a later process only has the state file, not the control path
+	u = testSSHURI(t, s, "control_state_file="+stateFile)
+	conn, err = u.Dial()
+	require.NoError(t, err)
+	conn.Close()
+	assert.Equal(t, []string{s.Addr(), s.Addr()}, m.Opens())
+
+	// once the master is gone, the connection is made directly
+	m.Stop()
+	conn, err = u.Dial()
+	require.NoError(t, err)
+	conn.Close()
+	assert.Len(t, m.Opens(), 2)
+
+	state, err = readControlState(stateFile)
+	require.NoError(t, err)
+	assert.Empty(t, state)
+	_, err = os.Stat(stateFile)
+	assert.NoError(t, err)
+}
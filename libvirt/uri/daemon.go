@@ -0,0 +1,55 @@
+package uri
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+// modularDaemons are the daemons of the modular libvirt deployment, by the
+// driver of the URI.
+var modularDaemons = map[string]string{
+	"qemu":      "virtqemud",
+	"lxc":       "virtlxcd",
+	"xen":       "virtxend",
+	"ch":        "virtchd",
+	"vbox":      "virtvboxd",
+	"network":   "virtnetworkd",
+	"nwfilter":  "virtnwfilterd",
+	"storage":   "virtstoraged",
+	"nodedev":   "virtnodedevd",
+	"interface": "virtinterfaced",
+	"secret":    "virtsecretd",
+}
+
+// daemonSocket returns the socket of the daemon selected with the daemon
+// parameter, or "" when it is not set: with monolithic the libvirtd socket,
+// with modular the socket of the daemon serving the driver of the URI,
+// e.g. virtqemud for qemu:///system or virtnetworkd for network:///system.
+// The session sockets of the local user are used for /session URIs of the
+// unix transport.
+func (u *ConnectionURI) daemonSocket(readonly bool) (string, error) {
+	var name string
+	switch daemon := u.Query().Get("daemon"); daemon {
+	case "":
+		return "", nil
+	case "monolithic":
+		name = "libvirt"
+	case "modular":
+		var ok bool
+		if name, ok = modularDaemons[u.driver()]; !ok {
+			return "", fmt.Errorf("daemon=modular has no daemon for driver '%s'", u.driver())
+		}
+	default:
+		return "", fmt.Errorf("invalid value '%s' for daemon", daemon)
+	}
+
+	socket := name + "-sock"
+	if readonly {
+		socket += "-ro"
+	}
+	dir := filepath.Dir(defaultUnixSock)
+	if u.Path == "/session" && u.transport() == "unix" {
+		dir = filepath.Dir(sessionSockets()[0])
+	}
+	return filepath.Join(dir, socket), nil
+}
@@ -0,0 +1,72 @@
+package uri
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLibvirtSocketDaemon(t *testing.T) {
+	t.Setenv("XDG_RUNTIME_DIR", "/run/user/1000")
+	for _, tc := range []struct {
+		uri    string
+		socket string
+	}{
+		{"qemu:///system", "/var/run/libvirt/libvirt-sock"},
+		{"qemu:///system?daemon=monolithic", "/var/run/libvirt/libvirt-sock"},
+		{"qemu:///system?daemon=monolithic&readonly=1", "/var/run/libvirt/libvirt-sock-ro"},
+		{"qemu:///system?daemon=modular", "/var/run/libvirt/virtqemud-sock"},
+		{"qemu:///system?daemon=modular&readonly=1", "/var/run/libvirt/virtqemud-sock-ro"},
+		{"network:///system?daemon=modular", "/var/run/libvirt/virtnetworkd-sock"},
+		{"storage:///system?daemon=modular", "/var/run/libvirt/virtstoraged-sock"},
+		{"qemu+ssh://hv1.example.com/system?daemon=modular", "/var/run/libvirt/virtqemud-sock"},
+		{"network+ssh://hv1.example.com/system?daemon=modular", "/var/run/libvirt/virtnetworkd-sock"},
+		{"qemu:///session?daemon=modular", "/run/user/1000/libvirt/virtqemud-sock"},
+		{"qemu:///session?daemon=monolithic", "/run/user/1000/libvirt/libvirt-sock"},
+		// the session directory of a remote user is not known
+		{"qemu+ssh://hv1.example.com/session?daemon=modular", "/var/run/libvirt/virtqemud-sock"},
+		// an explicit socket wins
+		{"qemu:///system?daemon=modular&socket=/tmp/libvirt-sock", "/tmp/libvirt-sock"},
+	} {
+		u, err := Parse(tc.uri)
+		require.NoError(t, err)
+		socket, err := u.libvirtSocket()
+		require.NoError(t, err, tc.uri)
+		assert.Equal(t, tc.socket, socket, tc.uri)
+	}
+
+	u, err := Parse("qemu:///system?daemon=split")
+	require.NoError(t, err)
+	_, err = u.libvirtSocket()
+	assert.EqualError(t, err, "invalid value 'split' for daemon")
+
+	u, err = Parse("test:///system?daemon=modular")
+	require.NoError(t, err)
+	_, err = u.libvirtSocket()
+	assert.EqualError(t, err, "daemon=modular has no daemon for driver 'test'")
+}
+
+func TestDialSSHDaemon(t *testing.T) {
+	s := newTestSSHServer(t)
+	t.Setenv("HTTP_PROXY", "")
+	t.Setenv("ALL_PROXY", "")
+
+	for _, params := range []string{"daemon=monolithic", "daemon=modular"} {
+		u := testSSHURI(t, s, params)
+		conn, err := u.Dial()
+		require.NoError(t, err)
+		conn.Close()
+	}
+	u := testSSHURI(t, s, "daemon=modular")
+	u.Scheme = "network+ssh"
+	conn, err := u.Dial()
+	require.NoError(t, err)
+	conn.Close()
+
+	assert.Equal(t, []string{
+		"/var/run/libvirt/libvirt-sock",
+		"/var/run/libvirt/virtqemud-sock",
+		"/var/run/libvirt/virtnetworkd-sock",
+	}, s.DialedSockets())
+}
@@ -0,0 +1,99 @@
+package uri
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	libvirt "github.com/digitalocean/go-libvirt"
+)
+
+// systemSockets are the sockets of the system daemons: the monolithic
+// libvirtd and the modular virtqemud.
+var systemSockets = []string{defaultUnixSock, "/var/run/libvirt/virtqemud-sock"}
+
+// sessionSockets returns the sockets of the session daemons of the local
+// user. The runtime directory of a remote user is not known, so they are
+// only probed for the unix transport.
+func sessionSockets() []string {
+	dir := os.Getenv("XDG_RUNTIME_DIR")
+	if dir == "" {
+		dir = filepath.Join(os.Getenv("HOME"), ".cache")
+	}
+	return []string{
+		filepath.Join(dir, "libvirt", "libvirt-sock"),
+		filepath.Join(dir, "libvirt", "virtqemud-sock"),
+	}
+}
+
+// ExplainOpenError adds guidance to the error returned when opening the
+// libvirt connection failed because the daemon behind the socket has no
+// driver for the URI, typically qemu:///system while only the session
+// daemon runs or the other way around. It probes the other sockets on the
+// host and suggests the URI that reaches a daemon. Other errors are returned
+// unchanged.
+func (u *ConnectionURI) ExplainOpenError(err error) error {
+	var libvirtErr libvirt.Error
+	if !errors.As(err, &libvirtErr) || libvirtErr.Code != uint32(libvirt.ErrNoConnect) {
+		return err
+	}
+
+	type candidate struct {
+		path    string
+		sockets []string
+	}
+	var candidates []candidate
+	switch u.Path {
+	case "/system":
+		if u.transport() == "unix" {
+			candidates = append(candidates, candidate{"/session", sessionSockets()})
+		}
+	case "/session":
+		candidates = append(candidates, candidate{"/system", systemSockets})
+	}
+
+	for _, c := range candidates {
+		for _, socket := range c.sockets {
+			if !u.socketAnswers(socket) {
+				continue
+			}
+			suggested := *u.URL
+			suggested.Path = c.path
+			q := suggested.Query()
+			q.Del("socket")
+			// this package dials the system socket unless told otherwise
+			if socket != defaultUnixSock {
+				q.Set("socket", socket)
+			}
+			suggested.RawQuery = q.Encode()
+			return fmt.Errorf("%w: a libvirt daemon answers on %s, try %s", err, socket, suggested.Redacted())
+		}
+	}
+	return fmt.Errorf("%w: check that the driver in the URI is right and that its daemon runs on the host", err)
+}
+
+// socketAnswers reports whether a connection to the libvirt socket at path
+// can be made through the transport of the URI. The probe authenticates and
+// verifies the host key like the connection, but is not reported as one.
+func (u *ConnectionURI) socketAnswers(path string) bool {
+	probe := *u
+	probe.URL = new(url.URL)
+	*probe.URL = *u.URL
+	probe.OnEvent, probe.Audit, probe.Metrics = nil, nil, nil
+	q := probe.Query()
+	q.Set("socket", path)
+	probe.RawQuery = q.Encode()
+
+	ctx, cancel := context.WithTimeout(context.Background(), dialTimeout)
+	defer cancel()
+	conn, err := probe.dialTransport(ctx)
+	if err != nil {
+		u.logf("[DEBUG] no libvirt socket at %s: %v", path, err)
+		return false
+	}
+	conn.Close()
+	return true
+}
@@ -0,0 +1,76 @@
+package uri
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestExplainOpenErrorSuggestsSession(t *testing.T) {
+	s := newTestLibvirtServer(t)
+	s.Handle(testProcConnectOpen, func([]byte) ([]byte, error) {
+		return nil, testLibvirtError{Code: 5, Message: "no connection driver available for qemu:///system"}
+	})
+
+	runtimeDir := t.TempDir()
+	t.Setenv("XDG_RUNTIME_DIR", runtimeDir)
+	sessionSocket := filepath.Join(runtimeDir, "libvirt", "libvirt-sock")
+	require.NoError(t, os.MkdirAll(filepath.Dir(sessionSocket), 0700))
+	l, err := net.Listen("unix", sessionSocket)
+	require.NoError(t, err)
+	defer l.Close()
+
+	u, err := Parse("qemu:///system?socket=" + s.Socket)
+	require.NoError(t, err)
+	_, err = u.Capabilities()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "no connection driver available for qemu:///system")
+	assert.Contains(t, err.Error(), "a libvirt daemon answers on "+sessionSocket+", try qemu:///session?socket=")
+}
+
+func TestExplainOpenErrorNoAlternative(t *testing.T) {
+	s := newTestLibvirtServer(t)
+	s.Handle(testProcConnectOpen, func([]byte) ([]byte, error) {
+		return nil, testLibvirtError{Code: 5, Message: "no connection driver available for qemu:///system"}
+	})
+	t.Setenv("XDG_RUNTIME_DIR", t.TempDir())
+
+	u, err := Parse("qemu:///system?socket=" + s.Socket)
+	require.NoError(t, err)
+	_, err = u.Capabilities()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "check that the driver in the URI is right")
+
+	// other errors are left alone
+	other := errors.New("connection reset by peer")
+	assert.Same(t, other, u.ExplainOpenError(other))
+}
+
+func TestSocketAnswersWithHostKeyStore(t *testing.T) {
+	s := newTestSSHServer(t)
+	u := testSSHURI(t, s, "")
+	q := u.Query()
+	q.Del("no_verify")
+	u.RawQuery = q.Encode()
+	lookups := 0
+	u.HostKeyStore = HostKeyStoreFunc(func(ctx context.Context, hostname string) ([]ssh.PublicKey, error) {
+		lookups++
+		return []ssh.PublicKey{s.hostKey.PublicKey()}, nil
+	})
+	events := 0
+	u.OnEvent = func(ConnectionEvent) { events++ }
+
+	// the probe verifies the host key like the connection does
+	assert.True(t, u.socketAnswers("/run/user/1000/libvirt/libvirt-sock"))
+	assert.Equal(t, 1, lookups)
+	assert.Equal(t, []string{"/run/user/1000/libvirt/libvirt-sock"}, s.DialedSockets())
+	assert.Zero(t, events, "the probe is reported as a connection")
+	assert.Empty(t, u.Query().Get("socket"), "the probe changed the URI")
+}
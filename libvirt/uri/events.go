@@ -0,0 +1,75 @@
+package uri
+
+import (
+	"context"
+	"time"
+)
+
+// ConnectionPhase identifies a step in establishing a connection.
+type ConnectionPhase string
+
+const (
+	PhaseConnecting       ConnectionPhase = "connecting"
+	PhaseVerifyingHostKey ConnectionPhase = "verifying host key"
+	PhaseAuthenticating   ConnectionPhase = "authenticating"
+	PhaseOpeningSocket    ConnectionPhase = "opening libvirt socket"
+	PhaseConnected        ConnectionPhase = "connected"
+	PhaseFailed           ConnectionPhase = "failed"
+)
+
+// ConnectionEvent reports the progress of a dial, for user facing feedback.
+type ConnectionEvent struct {
+	Phase ConnectionPhase
+	// Host is the host being connected to
+	Host string
+	// Tag is the conn_tag of the connection, if any
+	Tag string
+	// Attempt is the number of the current attempt, starting at 1
+	Attempt int
+	// Err is set for PhaseFailed
+	Err error
+	// Conn is the established connection, set for PhaseConnected
+	Conn *Conn
+	Time time.Time
+}
+
+// attemptKey is the context key holding the number of the current attempt.
+type attemptKey struct{}
+
+// emit reports a connection event to the OnEvent callback, if any.
+func (u *ConnectionURI) emit(ctx context.Context, phase ConnectionPhase, err error) {
+	if u.OnEvent == nil {
+		return
+	}
+	attempt, _ := ctx.Value(attemptKey{}).(int)
+	u.OnEvent(ConnectionEvent{
+		Phase:   phase,
+		Host:    u.Host,
+		Tag:     u.tag(),
+		Attempt: attempt,
+		Err:     err,
+		Time:    time.Now(),
+	})
+}
+
+// emitConnected reports that conn was established.
+func (u *ConnectionURI) emitConnected(ctx context.Context, conn *Conn) {
+	if u.OnEvent == nil {
+		return
+	}
+	attempt, _ := ctx.Value(attemptKey{}).(int)
+	u.OnEvent(ConnectionEvent{
+		Phase:   PhaseConnected,
+		Host:    u.Host,
+		Tag:     u.tag(),
+		Attempt: attempt,
+		Conn:    conn,
+		Time:    time.Now(),
+	})
+}
+
+// failed reports err as the final outcome of the dial and returns it.
+func (u *ConnectionURI) failed(ctx context.Context, err error) error {
+	u.emit(ctx, PhaseFailed, err)
+	return err
+}
@@ -0,0 +1,58 @@
+package uri
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDialEvents(t *testing.T) {
+	s := newTestSSHServer(t)
+	u := testSSHURI(t, s, "")
+
+	var events []ConnectionEvent
+	u.OnEvent = func(e ConnectionEvent) {
+		events = append(events, e)
+	}
+
+	conn, err := u.Dial()
+	require.NoError(t, err)
+	conn.Close()
+
+	var phases []ConnectionPhase
+	for _, e := range events {
+		phases = append(phases, e.Phase)
+		assert.Equal(t, s.Addr(), e.Host)
+		assert.Equal(t, 1, e.Attempt)
+		assert.NoError(t, e.Err)
+	}
+	assert.Equal(t, []ConnectionPhase{
+		PhaseConnecting,
+		PhaseVerifyingHostKey,
+		PhaseAuthenticating,
+		PhaseOpeningSocket,
+		PhaseConnected,
+	}, phases)
+	assert.Same(t, conn, events[len(events)-1].Conn)
+}
+
+func TestDialEventsFailure(t *testing.T) {
+	u, err := Parse("qemu+tcp://127.0.0.1:" + closedPort(t) + "/system?connect_retries=1&connect_retry_delay=1ms")
+	require.NoError(t, err)
+
+	var events []ConnectionEvent
+	u.OnEvent = func(e ConnectionEvent) {
+		events = append(events, e)
+	}
+
+	_, err = u.Dial()
+	require.Error(t, err)
+	require.Len(t, events, 3)
+	assert.Equal(t, PhaseConnecting, events[0].Phase)
+	assert.Equal(t, 1, events[0].Attempt)
+	assert.Equal(t, PhaseConnecting, events[1].Phase)
+	assert.Equal(t, 2, events[1].Attempt)
+	assert.Equal(t, PhaseFailed, events[2].Phase)
+	assert.Equal(t, err, events[2].Err)
+}
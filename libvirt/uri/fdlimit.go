@@ -0,0 +1,79 @@
+package uri
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// fdRetryDelay bounds the wait for a file descriptor, since descriptors
+	// are also freed by parts of the process that do not notify us
+	fdRetryDelay = 500 * time.Millisecond
+	// fdMaxWait bounds the whole wait when the dial has no deadline
+	fdMaxWait = time.Minute
+)
+
+var (
+	fdMutex sync.Mutex
+	// fdReleased is closed, and replaced, whenever a connection is closed
+	fdReleased = make(chan struct{})
+)
+
+// releaseFD wakes up the dials waiting for a file descriptor.
+func releaseFD() {
+	fdMutex.Lock()
+	defer fdMutex.Unlock()
+	close(fdReleased)
+	fdReleased = make(chan struct{})
+}
+
+func fdReleasedChan() <-chan struct{} {
+	fdMutex.Lock()
+	defer fdMutex.Unlock()
+	return fdReleased
+}
+
+// isFDLimit reports whether err means the process ran out of file
+// descriptors.
+func isFDLimit(err error) bool {
+	return errors.Is(err, syscall.EMFILE) || errors.Is(err, syscall.ENFILE)
+}
+
+// dialWithFDBackpressure calls dial, and while it fails because the process
+// ran out of file descriptors, waits for one of our connections to be
+// closed and tries again, until ctx is done or fdMaxWait passed. Large
+// parallel applies then slow down instead of failing resources.
+func (u *ConnectionURI) dialWithFDBackpressure(ctx context.Context, dial func() (net.Conn, error)) (net.Conn, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, fdMaxWait)
+		defer cancel()
+	}
+
+	warned := false
+	for {
+		released := fdReleasedChan()
+		c, err := dial()
+		if err == nil || !isFDLimit(err) {
+			return c, err
+		}
+		if !warned {
+			u.logf("[WARN] file descriptor limit reached, waiting for a connection to be closed; reduce -parallelism or raise the limit with ulimit -n: %v", err)
+			warned = true
+		}
+
+		timer := time.NewTimer(fdRetryDelay)
+		select {
+		case <-released:
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, err
+		}
+		timer.Stop()
+	}
+}
@@ -0,0 +1,69 @@
+package uri
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDialWithFDBackpressure(t *testing.T) {
+	u, err := Parse("qemu+tcp://libvirt.example.com/system")
+	require.NoError(t, err)
+	logs := captureLog(t)
+
+	// a connection held by another resource, closed while the dial waits
+	held, other := net.Pipe()
+	defer other.Close()
+	heldConn := &Conn{Conn: held}
+
+	emfile := &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("socket", syscall.EMFILE)}
+	dials := 0
+	start := time.Now()
+	c, err := u.dialWithFDBackpressure(context.Background(), func() (net.Conn, error) {
+		dials++
+		if dials == 1 {
+			go func() {
+				time.Sleep(20 * time.Millisecond)
+				heldConn.Close()
+			}()
+			return nil, emfile
+		}
+		client, server := net.Pipe()
+		server.Close()
+		return client, nil
+	})
+	require.NoError(t, err)
+	c.Close()
+
+	assert.Equal(t, 2, dials)
+	// retried on the release, not after the fallback delay
+	assert.Less(t, time.Since(start), fdRetryDelay)
+	assert.Contains(t, logs.String(), "file descriptor limit reached")
+	assert.Contains(t, logs.String(), "reduce -parallelism or raise the limit with ulimit -n")
+}
+
+func TestDialWithFDBackpressureGivesUp(t *testing.T) {
+	u, err := Parse("qemu+tcp://libvirt.example.com/system")
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = u.dialWithFDBackpressure(ctx, func() (net.Conn, error) {
+		return nil, os.NewSyscallError("socket", syscall.EMFILE)
+	})
+	assert.True(t, errors.Is(err, syscall.EMFILE), "unexpected error: %v", err)
+
+	// other errors are returned right away
+	refused := os.NewSyscallError("connect", syscall.ECONNREFUSED)
+	_, err = u.dialWithFDBackpressure(context.Background(), func() (net.Conn, error) {
+		return nil, refused
+	})
+	assert.Same(t, refused, err)
+}
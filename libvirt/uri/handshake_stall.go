@@ -0,0 +1,120 @@
+package uri
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// handshakeConn watches what the SSH server sends during the handshake, to
+// tell apart the ways a handshake can stall.
+type handshakeConn struct {
+	net.Conn
+
+	mu sync.Mutex
+	// watching is cleared once the handshake is done
+	watching bool
+	// received is the number of bytes read
+	received int
+	// banner is set once the server version line was read completely,
+	// afterBanner counts the bytes read after it
+	banner      bool
+	afterBanner int
+	// line collects the line being read until the version line was found,
+	// version is the version line without its line ending
+	line    []byte
+	version string
+	// bannerAt is when the version line was read
+	bannerAt time.Time
+}
+
+// maxBannerLine bounds the lines collected while looking for the version
+// line, as RFC 4253 allows other lines before it.
+const maxBannerLine = 8192
+
+func newHandshakeConn(c net.Conn) *handshakeConn {
+	return &handshakeConn{Conn: c, watching: true}
+}
+
+func (c *handshakeConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.mu.Lock()
+	if c.watching && n > 0 {
+		c.received += n
+		if c.banner {
+			c.afterBanner += n
+		} else {
+			c.readBanner(b[:n])
+		}
+	}
+	c.mu.Unlock()
+	return n, err
+}
+
+// readBanner looks for the server version line in data.
+func (c *handshakeConn) readBanner(data []byte) {
+	for !c.banner {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			if len(c.line)+len(data) <= maxBannerLine {
+				c.line = append(c.line, data...)
+			}
+			return
+		}
+		c.line = append(c.line, data[:i]...)
+		data = data[i+1:]
+		if bytes.HasPrefix(c.line, []byte("SSH-")) {
+			c.banner = true
+			c.bannerAt = time.Now()
+			c.version = string(bytes.TrimSuffix(c.line, []byte("\r")))
+			c.afterBanner += len(data)
+		}
+		c.line = c.line[:0]
+	}
+}
+
+// serverVersion returns the version line the server sent, e.g.
+// "SSH-2.0-OpenSSH_9.6", or "" when it was not read yet.
+func (c *handshakeConn) serverVersion() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.version
+}
+
+// bannerTime returns when the version line was read, or the zero time
+// when it was not read yet.
+func (c *handshakeConn) bannerTime() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.bannerAt
+}
+
+// done stops watching, once the handshake completed.
+func (c *handshakeConn) done() {
+	c.mu.Lock()
+	c.watching = false
+	c.mu.Unlock()
+}
+
+// stallHint explains a handshake that timed out, from how far the server
+// got. A banner followed by silence is the typical sign of a path that
+// passes small packets but drops large ones, such as the key exchange.
+func (c *handshakeConn) stallHint() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch {
+	case c.received == 0:
+		return "handshake stalled after connect: no SSH banner was received, the port may not be served by an SSH server"
+	case c.banner && c.afterBanner == 0:
+		return "handshake stalled after connect: the server sent its banner but no key exchange, possible MTU/path MTU issue on the network path (try lowering the MTU of the VPN or tunnel interface)"
+	}
+	return ""
+}
+
+// isTimeout reports whether err is a timeout of a network operation.
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
@@ -0,0 +1,62 @@
+package uri
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+// stallingServer accepts connections, sends banner and then reads without
+// ever answering, like a path dropping the large key exchange packets.
+func stallingServer(t *testing.T, banner string) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				conn.Write([]byte(banner))
+				io.Copy(io.Discard, conn)
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+func TestSSHHandshakeStallHint(t *testing.T) {
+	for name, tc := range map[string]struct {
+		banner string
+		hint   string
+	}{
+		"after banner": {"SSH-2.0-OpenSSH_9.6\r\n", "the server sent its banner but no key exchange, possible MTU/path MTU issue"},
+		"no banner":    {"", "no SSH banner was received"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			addr := stallingServer(t, tc.banner)
+			u, err := Parse("qemu+ssh://" + testSSHUser + "@" + addr + "/system")
+			require.NoError(t, err)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+			defer cancel()
+			_, _, err = u.sshClient(ctx, nil, ssh.ClientConfig{
+				User:            testSSHUser,
+				HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+			})
+			require.Error(t, err)
+			assert.True(t, errors.Is(err, context.DeadlineExceeded), "unexpected error: %v", err)
+			assert.Contains(t, err.Error(), "handshake stalled after connect: "+tc.hint)
+		})
+	}
+}
@@ -0,0 +1,103 @@
+package uri
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// hostCA is a certificate authority trusted to sign host certificates, for
+// the hosts matching its patterns only.
+type hostCA struct {
+	key      ssh.PublicKey
+	patterns []string
+}
+
+// readHostCAs reads the trusted host CAs from the host_ca_file at path. The
+// file uses the known_hosts format, with or without the @cert-authority
+// marker: every line is a comma separated list of host patterns followed by
+// the CA key, e.g. "*.prod.example.com ssh-ed25519 AAAA...".
+func readHostCAs(path string) ([]hostCA, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read host CA file: %w", err)
+	}
+
+	var cas []hostCA
+	for rest := content; len(bytes.TrimSpace(rest)) > 0; {
+		marker, hosts, key, _, next, err := ssh.ParseKnownHosts(rest)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse host CA file %s: %w", path, err)
+		}
+		rest = next
+		if marker != "" && marker != "cert-authority" {
+			return nil, fmt.Errorf("unsupported marker @%s in host CA file %s", marker, path)
+		}
+		cas = append(cas, hostCA{key: key, patterns: hosts})
+	}
+	return cas, nil
+}
+
+// matchHostPatterns reports whether host matches the OpenSSH style patterns,
+// where "*" and "?" are wildcards and a pattern starting with "!" excludes
+// the hosts it matches.
+func matchHostPatterns(patterns []string, host string) bool {
+	host = strings.ToLower(host)
+	matched := false
+	for _, p := range patterns {
+		negate := strings.HasPrefix(p, "!")
+		p = strings.ToLower(strings.TrimPrefix(p, "!"))
+		if ok, _ := path.Match(p, host); !ok {
+			continue
+		}
+		if negate {
+			return false
+		}
+		matched = true
+	}
+	return matched
+}
+
+// withHostCAs wraps cb so that host certificates signed by one of cas are
+// accepted, provided the CA is trusted for the host. A certificate signed by
+// a known CA for a host outside of its patterns is rejected, so that a
+// compromised CA cannot impersonate hosts it was never meant to sign for.
+// Everything else is left to cb.
+func (u *ConnectionURI) withHostCAs(cb ssh.HostKeyCallback, cas []hostCA) ssh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		cert, ok := key.(*ssh.Certificate)
+		if !ok {
+			return cb(hostname, remote, key)
+		}
+
+		host := hostname
+		if h, _, err := net.SplitHostPort(hostname); err == nil {
+			host = h
+		}
+
+		known := false
+		for _, ca := range cas {
+			if !bytes.Equal(ca.key.Marshal(), cert.SignatureKey.Marshal()) {
+				continue
+			}
+			known = true
+			if !matchHostPatterns(ca.patterns, host) {
+				continue
+			}
+			checker := &ssh.CertChecker{
+				IsHostAuthority: func(ssh.PublicKey, string) bool { return true },
+			}
+			u.logf("[DEBUG] host certificate of %s signed by a CA trusted for %s", host, strings.Join(ca.patterns, ","))
+			return checker.CheckHostKey(hostname, remote, key)
+		}
+		if known {
+			return fmt.Errorf("host certificate of %s is signed by a CA that is not trusted for this host", host)
+		}
+		return cb(hostname, remote, key)
+	}
+}
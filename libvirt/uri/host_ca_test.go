@@ -0,0 +1,81 @@
+package uri
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+// newTestHostCert returns a host certificate for principal, signed by ca.
+func newTestHostCert(t *testing.T, ca ssh.Signer, principal string) *ssh.Certificate {
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	key, err := ssh.NewPublicKey(pub)
+	require.NoError(t, err)
+
+	cert := &ssh.Certificate{
+		Key:             key,
+		CertType:        ssh.HostCert,
+		ValidPrincipals: []string{principal},
+		ValidBefore:     ssh.CertTimeInfinity,
+	}
+	require.NoError(t, cert.SignCert(rand.Reader, ca))
+	return cert
+}
+
+func newTestSigner(t *testing.T) ssh.Signer {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	signer, err := ssh.NewSignerFromKey(priv)
+	require.NoError(t, err)
+	return signer
+}
+
+func TestHostCANameConstraints(t *testing.T) {
+	dir := t.TempDir()
+	prodCA := newTestSigner(t)
+	otherCA := newTestSigner(t)
+
+	caFile := filepath.Join(dir, "host_ca")
+	require.NoError(t, os.WriteFile(caFile, []byte(fmt.Sprintf("# production hypervisors\n@cert-authority *.prod.example.com,!bastion.prod.example.com %s",
+		ssh.MarshalAuthorizedKey(prodCA.PublicKey()))), 0600))
+	knownHosts := filepath.Join(dir, "known_hosts")
+	require.NoError(t, os.WriteFile(knownHosts, nil, 0600))
+
+	u, err := Parse(fmt.Sprintf("qemu+ssh://hv1.prod.example.com/system?knownhosts=%s&host_ca_file=%s", knownHosts, caFile))
+	require.NoError(t, err)
+	cb, err := u.hostKeyCallback(context.Background(), nil)
+	require.NoError(t, err)
+	remote := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 22}
+
+	assert.NoError(t, cb("hv1.prod.example.com:22", remote, newTestHostCert(t, prodCA, "hv1.prod.example.com")))
+
+	// validly signed, but for a host the CA is not trusted for
+	err = cb("hv1.dev.example.com:22", remote, newTestHostCert(t, prodCA, "hv1.dev.example.com"))
+	assert.ErrorContains(t, err, "host certificate of hv1.dev.example.com is signed by a CA that is not trusted for this host")
+	err = cb("bastion.prod.example.com:22", remote, newTestHostCert(t, prodCA, "bastion.prod.example.com"))
+	assert.ErrorContains(t, err, "not trusted for this host")
+
+	// the certificate still has to name the host
+	assert.Error(t, cb("hv2.prod.example.com:22", remote, newTestHostCert(t, prodCA, "hv1.prod.example.com")))
+
+	// unknown CAs and plain keys are left to the known_hosts file
+	assert.Error(t, cb("hv1.prod.example.com:22", remote, newTestHostCert(t, otherCA, "hv1.prod.example.com")))
+	assert.Error(t, cb("hv1.prod.example.com:22", remote, newTestSigner(t).PublicKey()))
+}
+
+func TestReadHostCAsRejectsRevoked(t *testing.T) {
+	caFile := filepath.Join(t.TempDir(), "host_ca")
+	require.NoError(t, os.WriteFile(caFile, []byte(fmt.Sprintf("@revoked * %s", ssh.MarshalAuthorizedKey(newTestSigner(t).PublicKey()))), 0600))
+	_, err := readHostCAs(caFile)
+	assert.ErrorContains(t, err, "unsupported marker @revoked")
+}
@@ -0,0 +1,99 @@
+package uri
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+const defaultHostKeyStoreTimeout = 5 * time.Second
+
+// HostKeyStore supplies the known host keys from a source other than the
+// known_hosts file, e.g. Vault or LDAP.
+type HostKeyStore interface {
+	// Lookup returns the keys known for hostname, given as host:port like
+	// to a HostKeyCallback. No keys means the host is unknown. The lookup
+	// should give up when ctx is done; the connection does not wait for
+	// it past that in any case.
+	Lookup(ctx context.Context, hostname string) ([]ssh.PublicKey, error)
+}
+
+// HostKeyStoreFunc is an adapter to use an ordinary function as a
+// HostKeyStore.
+type HostKeyStoreFunc func(ctx context.Context, hostname string) ([]ssh.PublicKey, error)
+
+// Lookup calls f(ctx, hostname).
+func (f HostKeyStoreFunc) Lookup(ctx context.Context, hostname string) ([]ssh.PublicKey, error) {
+	return f(ctx, hostname)
+}
+
+// hostKeySourceError is returned when the HostKeyStore could not be asked
+// for the keys of a host, as opposed to the host key not matching them.
+type hostKeySourceError struct {
+	hostname string
+	err      error
+}
+
+func (e *hostKeySourceError) Error() string {
+	return fmt.Sprintf("host key source unavailable for %s: %v", e.hostname, e.err)
+}
+
+func (e *hostKeySourceError) Unwrap() error { return e.err }
+
+// hostKeyStoreCallback returns the callback checking host keys against the
+// HostKeyStore. Each lookup is bounded by host_key_store_timeout (default
+// 5s) and the deadline of ctx, so that a slow backend fails the host key
+// verification instead of blocking the connection.
+func (u *ConnectionURI) hostKeyStoreCallback(ctx context.Context) (ssh.HostKeyCallback, error) {
+	timeout, err := u.durationParam("host_key_store_timeout")
+	if err != nil {
+		return nil, err
+	}
+	if timeout == 0 {
+		timeout = defaultHostKeyStoreTimeout
+	}
+	store := u.HostKeyStore
+
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		lookupCtx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+
+		type result struct {
+			keys []ssh.PublicKey
+			err  error
+		}
+		done := make(chan result, 1)
+		go func() {
+			keys, err := store.Lookup(lookupCtx, hostname)
+			done <- result{keys, err}
+		}()
+
+		var r result
+		select {
+		case r = <-done:
+		case <-lookupCtx.Done():
+			r.err = lookupCtx.Err()
+		}
+		if r.err != nil {
+			if errors.Is(r.err, context.DeadlineExceeded) {
+				r.err = fmt.Errorf("lookup did not finish within %s: %w", timeout, r.err)
+			}
+			return &hostKeySourceError{hostname: hostname, err: r.err}
+		}
+
+		keyErr := &knownhosts.KeyError{}
+		for _, k := range r.keys {
+			if bytes.Equal(k.Marshal(), key.Marshal()) {
+				return nil
+			}
+			keyErr.Want = append(keyErr.Want, knownhosts.KnownKey{Key: k})
+		}
+		return keyErr
+	}, nil
+}
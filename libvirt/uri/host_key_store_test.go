@@ -0,0 +1,86 @@
+package uri
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestDialSSHHostKeyStore(t *testing.T) {
+	s := newTestSSHServer(t)
+	t.Setenv("HTTP_PROXY", "")
+	t.Setenv("ALL_PROXY", "")
+
+	var looked []string
+	u := testSSHURI(t, s, "no_verify=&knownhosts=/nonexistent/known_hosts&require_verified=1")
+	u.HostKeyStore = HostKeyStoreFunc(func(ctx context.Context, hostname string) ([]ssh.PublicKey, error) {
+		looked = append(looked, hostname)
+		return []ssh.PublicKey{newTestSigner(t).PublicKey(), s.hostKey.PublicKey()}, nil
+	})
+	conn, err := u.Dial()
+	require.NoError(t, err)
+	conn.Close()
+	assert.Equal(t, []string{s.Addr()}, looked)
+
+	u.HostKeyStore = HostKeyStoreFunc(func(ctx context.Context, hostname string) ([]ssh.PublicKey, error) {
+		return []ssh.PublicKey{newTestSigner(t).PublicKey()}, nil
+	})
+	_, err = u.Dial()
+	assert.ErrorContains(t, err, ": host key mismatch: ")
+
+	u.HostKeyStore = HostKeyStoreFunc(func(ctx context.Context, hostname string) ([]ssh.PublicKey, error) {
+		return nil, nil
+	})
+	_, err = u.Dial()
+	assert.ErrorContains(t, err, ": unknown host key: ")
+
+	u.HostKeyStore = HostKeyStoreFunc(func(ctx context.Context, hostname string) ([]ssh.PublicKey, error) {
+		return nil, errors.New("vault: permission denied")
+	})
+	_, err = u.Dial()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), ": host key source unavailable: ")
+	assert.Contains(t, err.Error(), "host key source unavailable for "+s.Addr()+": vault: permission denied")
+}
+
+func TestDialSSHHostKeyStoreTimeout(t *testing.T) {
+	s := newTestSSHServer(t)
+	t.Setenv("HTTP_PROXY", "")
+	t.Setenv("ALL_PROXY", "")
+
+	// a backend that ignores the context and blocks past the deadline
+	unblock := make(chan struct{})
+	defer close(unblock)
+	store := HostKeyStoreFunc(func(ctx context.Context, hostname string) ([]ssh.PublicKey, error) {
+		<-unblock
+		return []ssh.PublicKey{s.hostKey.PublicKey()}, nil
+	})
+
+	u := testSSHURI(t, s, "no_verify=&host_key_store_timeout=100ms")
+	u.HostKeyStore = store
+	start := time.Now()
+	_, err := u.Dial()
+	require.Error(t, err)
+	assert.Less(t, time.Since(start), time.Second)
+	assert.Contains(t, err.Error(), ": host key source unavailable: ")
+	assert.Contains(t, err.Error(), "lookup did not finish within 100ms")
+	assert.NotContains(t, err.Error(), "mismatch")
+
+	// the deadline of the dial applies as well
+	u = testSSHURI(t, s, "no_verify=&total_timeout=1")
+	u.HostKeyStore = store
+	start = time.Now()
+	_, err = u.Dial()
+	require.Error(t, err)
+	assert.Less(t, time.Since(start), 3*time.Second)
+
+	u = testSSHURI(t, s, "no_verify=&host_key_store_timeout=soon")
+	u.HostKeyStore = store
+	_, err = u.Dial()
+	assert.ErrorContains(t, err, "invalid value 'soon' for host_key_store_timeout")
+}
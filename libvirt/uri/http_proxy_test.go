@@ -0,0 +1,69 @@
+package uri
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/kevinburke/ssh_config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDialSSHHTTPProxy(t *testing.T) {
+	s := newTestSSHServer(t)
+	p := newTestHTTPProxy(t, "Basic cHJveHk6c2VjcmV0") // proxy:secret
+	t.Setenv("ALL_PROXY", "")
+	t.Setenv("NO_PROXY", "")
+	t.Setenv("no_proxy", "")
+
+	t.Setenv("HTTP_PROXY", "http://proxy:secret@"+p.Addr())
+	u := testSSHURI(t, s, "")
+	conn, err := u.Dial()
+	require.NoError(t, err)
+	conn.Close()
+	assert.Equal(t, []string{s.Addr()}, p.Requests())
+	assert.Equal(t, []string{defaultUnixSock}, s.DialedSockets())
+
+	// a refused CONNECT fails the dial with the status of the proxy
+	u = testSSHURI(t, s, "")
+	host, port := u.Hostname(), u.Port()
+	_, err = u.dialSSHHost(context.Background(), "http://"+p.Addr(), host, port)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "refused to connect to "+s.Addr()+": 407 Proxy Authentication Required")
+}
+
+func TestSSHProxyNoProxy(t *testing.T) {
+	t.Setenv("HTTP_PROXY", "http://proxy.example.com:3128")
+	t.Setenv("ALL_PROXY", "")
+	t.Setenv("NO_PROXY", "")
+	t.Setenv("no_proxy", "internal.example.com,10.0.0.0/8,192.0.2.1,hv9.example.com:2222")
+
+	sshcfg, err := ssh_config.Decode(strings.NewReader("Host hv-alias\n  HostName hv1.internal.example.com\n"))
+	require.NoError(t, err)
+	for _, tc := range []struct {
+		uri      string
+		expected string
+	}{
+		{"qemu+ssh://libvirt.example.com/system", "http://proxy.example.com:3128"},
+		{"qemu+ssh://hv1.internal.example.com/system", ""},
+		{"qemu+ssh://internal.example.com/system", ""},
+		{"qemu+ssh://notinternal.example.com/system", "http://proxy.example.com:3128"},
+		{"qemu+ssh://hv-alias/system", ""},
+		{"qemu+ssh://10.1.2.3/system", ""},
+		{"qemu+ssh://192.0.2.1/system", ""},
+		{"qemu+ssh://192.0.2.2/system", "http://proxy.example.com:3128"},
+		{"qemu+ssh://hv9.example.com/system", "http://proxy.example.com:3128"},
+		{"qemu+ssh://hv9.example.com:2222/system", ""},
+		// an explicit proxy is always used
+		{"qemu+ssh://hv1.internal.example.com/system?proxy=socks5://other.example.com:1080", "socks5://other.example.com:1080"},
+	} {
+		u, err := Parse(tc.uri)
+		require.NoError(t, err)
+		assert.Equal(t, tc.expected, u.sshProxy(sshcfg), tc.uri)
+	}
+
+	assert.True(t, matchNoProxy("*", "anything.example.com", "22"))
+	assert.True(t, matchNoProxy(".example.com", "hv1.example.com", "22"))
+	assert.False(t, matchNoProxy(".example.com", "example.org", "22"))
+}
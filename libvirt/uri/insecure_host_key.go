@@ -0,0 +1,41 @@
+package uri
+
+import (
+	"sync"
+	"time"
+)
+
+// insecureWarningInterval is how often the warning about disabled host key
+// verification is logged per host, so that the many connections of a
+// Terraform run keep it visible without flooding the log.
+var insecureWarningInterval = time.Minute
+
+var (
+	insecureWarningMutex sync.Mutex
+	insecureWarned       = make(map[string]time.Time)
+)
+
+// warnInsecureHostKey logs that the host key of the host is not verified,
+// naming the setting that turned verification off.
+func (u *ConnectionURI) warnInsecureHostKey() {
+	host := u.Hostname()
+	insecureWarningMutex.Lock()
+	last, ok := insecureWarned[host]
+	now := time.Now()
+	if ok && now.Sub(last) < insecureWarningInterval {
+		insecureWarningMutex.Unlock()
+		return
+	}
+	insecureWarned[host] = now
+	insecureWarningMutex.Unlock()
+
+	q := u.Query()
+	reason := "StrictHostKeyChecking in the ssh config"
+	switch {
+	case q.Get("no_verify") != "":
+		reason = "no_verify"
+	case q.Get("known_hosts_verify") == "ignore":
+		reason = "known_hosts_verify=ignore"
+	}
+	u.logf("[WARN] host key verification is DISABLED for host %s by %s, this is insecure: any server, including a man in the middle, is accepted", host, reason)
+}
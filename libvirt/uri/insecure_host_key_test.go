@@ -0,0 +1,74 @@
+package uri
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func resetInsecureWarnings(t *testing.T, interval time.Duration) {
+	insecureWarningMutex.Lock()
+	defer insecureWarningMutex.Unlock()
+	old := insecureWarningInterval
+	insecureWarningInterval = interval
+	insecureWarned = make(map[string]time.Time)
+	t.Cleanup(func() {
+		insecureWarningMutex.Lock()
+		defer insecureWarningMutex.Unlock()
+		insecureWarningInterval = old
+	})
+}
+
+func TestDialSSHWarnsWithoutHostKeyVerification(t *testing.T) {
+	s := newTestSSHServer(t)
+	t.Setenv("HTTP_PROXY", "")
+	t.Setenv("ALL_PROXY", "")
+	resetInsecureWarnings(t, 0)
+
+	dial := func(u *ConnectionURI) string {
+		logs := captureLog(t)
+		conn, err := u.Dial()
+		require.NoError(t, err)
+		conn.Close()
+		return logs.String()
+	}
+
+	for _, tc := range []struct {
+		params    string
+		sshConfig string
+		reason    string
+	}{
+		{"no_verify=1", "", "no_verify"},
+		{"no_verify=&known_hosts_verify=ignore", "", "known_hosts_verify=ignore"},
+		{"no_verify=", "Host 127.0.0.1\n  StrictHostKeyChecking no\n", "StrictHostKeyChecking in the ssh config"},
+	} {
+		u := testSSHURI(t, s, tc.params)
+		u.SSHConfig = tc.sshConfig
+		assert.Contains(t, dial(u), "[WARN] host key verification is DISABLED for host 127.0.0.1 by "+tc.reason+", this is insecure", tc.params)
+	}
+
+	known := knownhosts.Line([]string{knownhosts.Normalize(s.Addr())}, s.hostKey.PublicKey())
+	u := testSSHURIWithKnownHosts(t, s, []string{known}, "")
+	assert.NotContains(t, dial(u), "DISABLED")
+}
+
+func TestInsecureHostKeyWarningRateLimit(t *testing.T) {
+	resetInsecureWarnings(t, time.Hour)
+	logs := captureLog(t)
+
+	u, err := Parse("qemu+ssh://hv1.example.com/system?no_verify=1")
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		u.warnInsecureHostKey()
+	}
+	other, err := Parse("qemu+ssh://hv2.example.com/system?no_verify=1")
+	require.NoError(t, err)
+	other.warnInsecureHostKey()
+
+	assert.Equal(t, 1, strings.Count(logs.String(), "DISABLED for host hv1.example.com"))
+	assert.Equal(t, 1, strings.Count(logs.String(), "DISABLED for host hv2.example.com"))
+}
@@ -0,0 +1,67 @@
+package uri
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/kevinburke/ssh_config"
+	"golang.org/x/crypto/ssh"
+)
+
+// keepaliveInterval returns how often keepalives are sent over the SSH
+// connection: keepalive_interval, or else the ServerAliveInterval of the host
+// in the ssh config. 0 sends none.
+func (u *ConnectionURI) keepaliveInterval(sshcfg *ssh_config.Config) (time.Duration, error) {
+	if u.Query().Get("keepalive_interval") != "" {
+		interval, err := u.durationParam("keepalive_interval")
+		if err != nil {
+			return 0, err
+		}
+		if interval < 0 {
+			return 0, fmt.Errorf("invalid value '%s' for keepalive_interval", u.Query().Get("keepalive_interval"))
+		}
+		return interval, nil
+	}
+	if sshcfg == nil {
+		return 0, nil
+	}
+	v, err := sshcfg.Get(u.Hostname(), "ServerAliveInterval")
+	if err != nil || v == "" {
+		return 0, nil
+	}
+	secs, err := strconv.Atoi(v)
+	if err != nil || secs < 0 {
+		u.logf("[WARN] ignoring invalid ServerAliveInterval '%s' in ssh config", v)
+		return 0, nil
+	}
+	return time.Duration(secs) * time.Second, nil
+}
+
+// keepAlive sends a keepalive request over client every interval, so that
+// a connection idle between the operations of a long apply is not torn
+// down by a stateful firewall or the ClientAliveInterval of sshd. It stops
+// once the connection is closed, or when a keepalive fails.
+func (u *ConnectionURI) keepAlive(client *ssh.Client, interval time.Duration) {
+	closed := make(chan struct{})
+	go func() {
+		client.Wait()
+		close(closed)
+	}()
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-closed:
+				return
+			case <-ticker.C:
+			}
+			if _, _, err := client.SendRequest("keepalive@openssh.com", true, nil); err != nil {
+				u.logf("[DEBUG] stopping SSH keepalives: %v", err)
+				return
+			}
+		}
+	}()
+}
@@ -0,0 +1,60 @@
+package uri
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDialSSHKeepalive(t *testing.T) {
+	s := newTestSSHServer(t)
+	t.Setenv("HTTP_PROXY", "")
+	t.Setenv("ALL_PROXY", "")
+	keepalives := func() int {
+		n := 0
+		for _, r := range s.GlobalRequests() {
+			if r == "keepalive@openssh.com" {
+				n++
+			}
+		}
+		return n
+	}
+
+	u := testSSHURI(t, s, "keepalive_interval=20ms")
+	conn, err := u.Dial()
+	require.NoError(t, err)
+	assert.Eventually(t, func() bool { return keepalives() >= 2 }, 5*time.Second, 10*time.Millisecond)
+
+	// no more keepalives once the connection is closed
+	conn.Close()
+	time.Sleep(50 * time.Millisecond)
+	sent := keepalives()
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, sent, keepalives())
+}
+
+func TestKeepaliveInterval(t *testing.T) {
+	u, err := Parse("qemu+ssh://hv1/system?keepalive_interval=30")
+	require.NoError(t, err)
+	interval, err := u.keepaliveInterval(nil)
+	require.NoError(t, err)
+	assert.Equal(t, 30*time.Second, interval)
+
+	u, err = Parse("qemu+ssh://hv1/system")
+	require.NoError(t, err)
+	interval, err = u.keepaliveInterval(nil)
+	require.NoError(t, err)
+	assert.Zero(t, interval)
+
+	u.SSHConfig = "Host hv1\n  ServerAliveInterval 15\n"
+	interval, err = u.keepaliveInterval(u.sshConfig())
+	require.NoError(t, err)
+	assert.Equal(t, 15*time.Second, interval)
+
+	u, err = Parse("qemu+ssh://hv1/system?keepalive_interval=-1")
+	require.NoError(t, err)
+	_, err = u.keepaliveInterval(nil)
+	assert.EqualError(t, err, "invalid value '-1' for keepalive_interval")
+}
@@ -0,0 +1,189 @@
+package uri
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/kevinburke/ssh_config"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	defaultProbeKeysParallelism = 4
+	// probeTimeout bounds the probing of all keys
+	probeTimeout = 10 * time.Second
+)
+
+// errKeyProbed ends a probe connection once the server accepted its key.
+var errKeyProbed = errors.New("key accepted by the server, ending probe")
+
+// probeSigner offers a key without ever signing with it: the SSH client
+// only asks for a signature after the server said it accepts the key, so
+// Sign reports the key as accepted and fails the probe connection.
+type probeSigner struct {
+	ssh.Signer
+	accept func()
+}
+
+func (s probeSigner) Sign(io.Reader, []byte) (*ssh.Signature, error) {
+	s.accept()
+	return nil, errKeyProbed
+}
+
+type probeAlgorithmSigner struct {
+	probeSigner
+}
+
+func (s probeAlgorithmSigner) SignWithAlgorithm(io.Reader, []byte, string) (*ssh.Signature, error) {
+	s.accept()
+	return nil, errKeyProbed
+}
+
+type probeMultiAlgorithmSigner struct {
+	probeAlgorithmSigner
+	algorithms []string
+}
+
+func (s probeMultiAlgorithmSigner) Algorithms() []string {
+	return s.algorithms
+}
+
+// newProbeSigner wraps signer in a probeSigner offering the same signature
+// algorithms, so that e.g. an RSA key is probed with rsa-sha2-256 rather
+// than ssh-rsa.
+func newProbeSigner(signer ssh.Signer, accept func()) ssh.Signer {
+	p := probeSigner{Signer: signer, accept: accept}
+	switch s := signer.(type) {
+	case ssh.MultiAlgorithmSigner:
+		return probeMultiAlgorithmSigner{probeAlgorithmSigner{p}, s.Algorithms()}
+	case ssh.AlgorithmSigner:
+		return probeAlgorithmSigner{p}
+	}
+	return p
+}
+
+// probeKeysParallelism returns the number of probe connections open at
+// once with probe_keys.
+func (u *ConnectionURI) probeKeysParallelism() (int, error) {
+	v := u.Query().Get("probe_keys_parallelism")
+	if v == "" {
+		return defaultProbeKeysParallelism, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("invalid value '%s' for probe_keys_parallelism", v)
+	}
+	return n, nil
+}
+
+// probeKeys finds out which of the keys of auth the server accepts, with
+// probe_keys, before the real connection is made: every key is offered on a
+// short-lived connection of its own, at most probe_keys_parallelism at
+// once, and the first key accepted becomes the only one the real
+// connection offers. That spares going through many keys one after the
+// other, and the MaxAuthTries of the server. The probes never sign, so keys
+// of the ssh agent that need a confirmation are not prompted for.
+//
+// When no key is accepted, all of them are offered as usual. Probing is
+// skipped for rendezvous and proxyjump, which cannot be dialed again or
+// would have the jump hosts answer for the host.
+func (u *ConnectionURI) probeKeys(ctx context.Context, auth *sshAuth, sshcfg *ssh_config.Config, user string, hostKeyCallback ssh.HostKeyCallback) error {
+	if u.Query().Get("rendezvous") != "" || u.sshProxyJump(sshcfg) != "" {
+		u.logf("[DEBUG] probe_keys: not probing keys through rendezvous or proxyjump")
+		return nil
+	}
+	parallelism, err := u.probeKeysParallelism()
+	if err != nil {
+		return err
+	}
+
+	var signers []ssh.Signer
+	seen := make(map[string]bool)
+	for _, source := range auth.signerSources() {
+		result, err := source()
+		if err != nil {
+			u.logf("[WARN] probe_keys: failed to list keys: %v", err)
+			continue
+		}
+		for _, signer := range result {
+			fingerprint := ssh.FingerprintSHA256(signer.PublicKey())
+			if !seen[fingerprint] {
+				seen[fingerprint] = true
+				signers = append(signers, signer)
+			}
+		}
+	}
+	if len(signers) < 2 {
+		return nil
+	}
+
+	// the probes do not count towards the profile and audit record of ctx
+	deadline := time.Now().Add(probeTimeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	probeCtx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+
+	var (
+		mu       sync.Mutex
+		accepted string
+		wg       sync.WaitGroup
+	)
+	sem := make(chan struct{}, parallelism)
+	for _, signer := range signers {
+		select {
+		case sem <- struct{}{}:
+		case <-probeCtx.Done():
+		}
+		mu.Lock()
+		done := accepted != "" || probeCtx.Err() != nil
+		mu.Unlock()
+		if done {
+			break
+		}
+
+		fingerprint := ssh.FingerprintSHA256(signer.PublicKey())
+		cfg := ssh.ClientConfig{
+			User: user,
+			Auth: []ssh.AuthMethod{ssh.PublicKeys(newProbeSigner(signer, func() {
+				mu.Lock()
+				defer mu.Unlock()
+				if accepted == "" {
+					accepted = fingerprint
+				}
+			}))},
+			HostKeyCallback: hostKeyCallback,
+			Timeout:         dialTimeout,
+			ClientVersion:   u.sshClientVersion(),
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			release, err := u.acquireHandshake(probeCtx)
+			if err != nil {
+				return
+			}
+			defer release()
+			client, _, err := u.sshClient(probeCtx, sshcfg, cfg)
+			if err == nil {
+				client.Close()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if accepted == "" {
+		u.logf("[DEBUG] probe_keys: the server accepted none of %d keys, offering all of them", len(signers))
+		return nil
+	}
+	u.logf("[DEBUG] probe_keys: the server accepts key %s, only offering that one", accepted)
+	auth.preferKey(accepted)
+	return nil
+}
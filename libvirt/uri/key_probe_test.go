@@ -0,0 +1,128 @@
+package uri
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestDialSSHProbeKeys(t *testing.T) {
+	s := newTestSSHServer(t)
+	t.Setenv("HTTP_PROXY", "")
+	t.Setenv("ALL_PROXY", "")
+
+	dir := t.TempDir()
+	var paths []string
+	var signers []ssh.Signer
+	for i := 0; i < 5; i++ {
+		path := filepath.Join(dir, fmt.Sprintf("id_ed25519_%d", i))
+		signers = append(signers, writeTestKey(t, path))
+		paths = append(paths, path)
+	}
+	s.Authorize(signers[3].PublicKey())
+
+	// the keys offered on each connection, by session
+	var mu sync.Mutex
+	offered := make(map[string][]string)
+	s.Configure(func(config *ssh.ServerConfig) {
+		check := config.PublicKeyCallback
+		config.PublicKeyCallback = func(c ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			mu.Lock()
+			offered[string(c.SessionID())] = append(offered[string(c.SessionID())], ssh.FingerprintSHA256(key))
+			mu.Unlock()
+			return check(c, key)
+		}
+	})
+	authenticated := make(chan string, 2)
+	s.connected = func(conn *ssh.ServerConn) { authenticated <- string(conn.SessionID()) }
+
+	keyfile := strings.Join(paths, ",")
+	u := testSSHURI(t, s, "sshauth=privkey&keyfile="+keyfile+"&probe_keys=1&probe_keys_parallelism=2")
+	conn, err := u.Dial()
+	require.NoError(t, err)
+	conn.Close()
+
+	session := <-authenticated
+	mu.Lock()
+	assert.Equal(t, []string{ssh.FingerprintSHA256(signers[3].PublicKey())}, offered[session])
+	// a probe connection for each key, up to the accepted one
+	assert.GreaterOrEqual(t, len(offered), 4+1)
+	mu.Unlock()
+
+	// without probing, the keys are tried one after the other
+	u = testSSHURI(t, s, "sshauth=privkey&keyfile="+keyfile)
+	conn, err = u.Dial()
+	require.NoError(t, err)
+	conn.Close()
+	session = <-authenticated
+	mu.Lock()
+	assert.Len(t, offered[session], 4)
+	mu.Unlock()
+
+	// none of the keys being accepted, all of them are offered
+	u = testSSHURI(t, s, "sshauth=privkey,ssh-password&keyfile="+strings.Join(paths[:3], ",")+"&probe_keys=1")
+	conn, err = u.Dial()
+	require.NoError(t, err)
+	conn.Close()
+	session = <-authenticated
+	mu.Lock()
+	assert.Len(t, offered[session], 3)
+	mu.Unlock()
+
+	u = testSSHURI(t, s, "sshauth=privkey&keyfile="+keyfile+"&probe_keys=1&probe_keys_parallelism=0")
+	_, err = u.Dial()
+	assert.EqualError(t, err, "invalid value '0' for probe_keys_parallelism")
+}
+
+func TestDialSSHProbeKeysHandshakeLimit(t *testing.T) {
+	s := newTestSSHServer(t)
+	t.Setenv("HTTP_PROXY", "")
+	t.Setenv("ALL_PROXY", "")
+
+	dir := t.TempDir()
+	var paths []string
+	var signers []ssh.Signer
+	for i := 0; i < 4; i++ {
+		path := filepath.Join(dir, fmt.Sprintf("id_ed25519_%d", i))
+		signers = append(signers, writeTestKey(t, path))
+		paths = append(paths, path)
+	}
+	s.Authorize(signers[3].PublicKey())
+
+	// the handshakes checking a key at the same time
+	var mu sync.Mutex
+	var inFlight, maxInFlight int
+	s.Configure(func(config *ssh.ServerConfig) {
+		check := config.PublicKeyCallback
+		config.PublicKeyCallback = func(c ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			mu.Lock()
+			inFlight++
+			if inFlight > maxInFlight {
+				maxInFlight = inFlight
+			}
+			mu.Unlock()
+			time.Sleep(20 * time.Millisecond)
+			mu.Lock()
+			inFlight--
+			mu.Unlock()
+			return check(c, key)
+		}
+	})
+
+	// the probes share the handshake slots of the host with the connection
+	u := testSSHURI(t, s, "sshauth=privkey&keyfile="+strings.Join(paths, ",")+"&probe_keys=1&probe_keys_parallelism=4&parallelism=1")
+	conn, err := u.Dial()
+	require.NoError(t, err)
+	conn.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, 1, maxInFlight)
+}
@@ -0,0 +1,81 @@
+package uri
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// knownHostLineCallback returns a callback that accepts only the key of the
+// known_hosts line given by known_host_line, for the hosts of that line, so
+// that a single host can be pinned without a known_hosts file. Plain,
+// wildcard and hashed host names are supported; markers are not.
+func knownHostLineCallback(line string) (ssh.HostKeyCallback, error) {
+	marker, hosts, pinned, _, rest, err := ssh.ParseKnownHosts([]byte(line))
+	if err != nil {
+		return nil, fmt.Errorf("invalid value '%s' for known_host_line: %w", line, err)
+	}
+	if marker != "" {
+		return nil, fmt.Errorf("invalid value '%s' for known_host_line: @%s lines are not supported", line, marker)
+	}
+	if len(bytes.TrimSpace(rest)) > 0 {
+		return nil, fmt.Errorf("invalid value '%s' for known_host_line: expected a single line", line)
+	}
+
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		host := knownhosts.Normalize(hostname)
+		if !matchKnownHostLine(hosts, host) {
+			return fmt.Errorf("host %s is not in known_host_line", host)
+		}
+		if !bytes.Equal(key.Marshal(), pinned.Marshal()) {
+			return fmt.Errorf("host key %s of %s does not match known_host_line, which pins %s",
+				ssh.FingerprintSHA256(key), host, ssh.FingerprintSHA256(pinned))
+		}
+		return nil
+	}, nil
+}
+
+// matchKnownHostLine reports whether the normalized host (as produced by
+// knownhosts.Normalize) is one of the hosts of a known_hosts line.
+func matchKnownHostLine(hosts []string, host string) bool {
+	var patterns []string
+	for _, h := range hosts {
+		if strings.HasPrefix(h, "|1|") {
+			if matchHashedHost(h, host) {
+				return true
+			}
+			continue
+		}
+		// brackets around a host with a port are literal, not a
+		// character class
+		patterns = append(patterns, strings.NewReplacer("[", `\[`, "]", `\]`).Replace(h))
+	}
+	return matchHostPatterns(patterns, host)
+}
+
+// matchHashedHost reports whether host matches the hashed host name
+// |1|salt|hash written by ssh-keygen -H.
+func matchHashedHost(hashed, host string) bool {
+	parts := strings.Split(strings.TrimPrefix(hashed, "|1|"), "|")
+	if len(parts) != 2 {
+		return false
+	}
+	salt, err := base64.StdEncoding.DecodeString(parts[0])
+	if err != nil {
+		return false
+	}
+	want, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha1.New, salt)
+	mac.Write([]byte(host))
+	return hmac.Equal(mac.Sum(nil), want)
+}
@@ -0,0 +1,65 @@
+package uri
+
+import (
+	"net"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func TestDialSSHKnownHostLine(t *testing.T) {
+	s := newTestSSHServer(t)
+	t.Setenv("HTTP_PROXY", "")
+	t.Setenv("ALL_PROXY", "")
+	line := knownhosts.Line([]string{knownhosts.Normalize(s.Addr())}, s.hostKey.PublicKey())
+
+	u := testSSHURI(t, s, "no_verify=&knownhosts=/nonexistent/known_hosts&require_verified=1&known_host_line="+url.QueryEscape(line))
+	conn, err := u.Dial()
+	require.NoError(t, err)
+	conn.Close()
+
+	// another key for the host is rejected
+	other := knownhosts.Line([]string{knownhosts.Normalize(s.Addr())}, newTestSigner(t).PublicKey())
+	u = testSSHURI(t, s, "no_verify=&known_host_line="+url.QueryEscape(other))
+	_, err = u.Dial()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "does not match known_host_line")
+}
+
+func TestKnownHostLineCallback(t *testing.T) {
+	key := newTestSigner(t).PublicKey()
+	remote := &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 22}
+
+	cb, err := knownHostLineCallback(knownhosts.Line([]string{"hv1.example.com", "[hv2.example.com]:2222"}, key))
+	require.NoError(t, err)
+	assert.NoError(t, cb("hv1.example.com:22", remote, key))
+	assert.NoError(t, cb("hv2.example.com:2222", remote, key))
+	err = cb("hv2.example.com:22", remote, key)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "host hv2.example.com is not in known_host_line")
+
+	cb, err = knownHostLineCallback(knownhosts.Line([]string{"*.example.com,!bastion.example.com"}, key))
+	require.NoError(t, err)
+	assert.NoError(t, cb("hv3.example.com:22", remote, key))
+	assert.Error(t, cb("bastion.example.com:22", remote, key))
+
+	cb, err = knownHostLineCallback(knownhosts.Line([]string{knownhosts.HashHostname("hv1.example.com")}, key))
+	require.NoError(t, err)
+	assert.NoError(t, cb("hv1.example.com:22", remote, key))
+	assert.Error(t, cb("hv2.example.com:22", remote, key))
+
+	for _, line := range []string{
+		"hv1.example.com",
+		"hv1.example.com ssh-ed25519 notbase64!",
+		"@revoked " + knownhosts.Line([]string{"hv1.example.com"}, key),
+		strings.Repeat(knownhosts.Line([]string{"hv1.example.com"}, key)+"\n", 2),
+	} {
+		_, err := knownHostLineCallback(line)
+		require.Error(t, err, line)
+		assert.Contains(t, err.Error(), "invalid value")
+	}
+}
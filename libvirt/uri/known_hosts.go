@@ -0,0 +1,248 @@
+package uri
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/kevinburke/ssh_config"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// knownHostsMaxLines returns the limit given by known_hosts_max_lines, or 0
+// when the known_hosts file may grow unbounded.
+func (u *ConnectionURI) knownHostsMaxLines() (int, error) {
+	v := u.Query().Get("known_hosts_max_lines")
+	if v == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid value '%s' for known_hosts_max_lines", v)
+	}
+	return n, nil
+}
+
+// appendKnownHost adds line to the known_hosts file at path. When maxLines is
+// positive, the oldest host entries are dropped so that the file keeps at most
+// maxLines of them. Comments, blank lines and marker lines (@cert-authority,
+// @revoked) are kept, as they are configuration rather than learned hosts.
+//
+// The file is rewritten atomically, so a concurrent reader never sees it
+// half written.
+func appendKnownHost(path string, line string, maxLines int) error {
+	existing, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read known hosts file: %w", err)
+	}
+
+	var lines []string
+	scanner := bufio.NewScanner(bytes.NewReader(existing))
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read known hosts file: %w", err)
+	}
+	lines = append(lines, line)
+
+	if maxLines > 0 {
+		lines = trimKnownHosts(lines, maxLines)
+	}
+
+	mode := os.FileMode(0600)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("failed to create known hosts directory: %w", err)
+	}
+	tmp, err := os.CreateTemp(dir, filepath.Base(path)+".tmp")
+	if err != nil {
+		return fmt.Errorf("failed to write known hosts file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.WriteString(strings.Join(lines, "\n") + "\n"); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write known hosts file: %w", err)
+	}
+	if err := tmp.Chmod(mode); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write known hosts file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write known hosts file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to write known hosts file: %w", err)
+	}
+	return nil
+}
+
+// knownHostsMutex serializes the additions to known_hosts files, so that
+// connections accepting new hosts at the same time do not lose entries.
+var knownHostsMutex sync.Mutex
+
+// createKnownHosts creates an empty known_hosts file at path if there is
+// none yet, for accept-new to add the first host to.
+func createKnownHosts(path string) error {
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to create known hosts directory: %w", err)
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create known hosts file: %w", err)
+	}
+	return f.Close()
+}
+
+// withAcceptNew wraps cb so that the key of a host cb knows nothing about
+// is added to the known_hosts file at path and accepted, like OpenSSH's
+// StrictHostKeyChecking=accept-new. A host known with other keys is still
+// rejected, as that is what a man in the middle looks like. The plain key of
+// a host certificate is added, as the certificate expires, and with hash the
+// host name is hashed like ssh-keygen -H does.
+func (u *ConnectionURI) withAcceptNew(cb ssh.HostKeyCallback, path string, maxLines int, hash bool) ssh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		err := cb(hostname, remote, key)
+		var keyErr *knownhosts.KeyError
+		if !errors.As(err, &keyErr) || len(keyErr.Want) > 0 {
+			return err
+		}
+
+		if cert, ok := key.(*ssh.Certificate); ok {
+			key = cert.Key
+		}
+		host := knownhosts.Normalize(hostname)
+		if hash {
+			host = knownhosts.HashHostname(host)
+		}
+		line := knownhosts.Line([]string{host}, key)
+		knownHostsMutex.Lock()
+		err = appendKnownHost(path, line, maxLines)
+		knownHostsMutex.Unlock()
+		if err != nil {
+			return err
+		}
+		u.logf("[WARN] Permanently added %s key %s of %s to %s", key.Type(), ssh.FingerprintSHA256(key), hostname, path)
+		return nil
+	}
+}
+
+// withPlainHostKey wraps cb, the callback of a known_hosts file, so that a
+// host certificate signed by no @cert-authority of the file is verified by
+// its plain key instead, like OpenSSH falls back to the plain key when no CA
+// matches. Hosts moving to certificates keep working with the keys known
+// already, while a certificate of a known CA is still checked as such.
+func (u *ConnectionURI) withPlainHostKey(cb ssh.HostKeyCallback) ssh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		err := cb(hostname, remote, key)
+		cert, ok := key.(*ssh.Certificate)
+		if !ok || err == nil || !strings.Contains(err.Error(), "no authorities for hostname") {
+			return err
+		}
+		u.logf("[DEBUG] no known CA signed the host certificate of %s, verifying its plain %s key", hostname, cert.Key.Type())
+		return cb(hostname, remote, cert.Key)
+	}
+}
+
+// hashesKnownHosts reports whether the host names added to known_hosts are
+// hashed, by known_hosts_hash or the HashKnownHosts of the host in the ssh
+// config.
+func (u *ConnectionURI) hashesKnownHosts(sshcfg *ssh_config.Config) bool {
+	if v := u.Query().Get("known_hosts_hash"); v != "" {
+		return nonZero(v)
+	}
+	if sshcfg == nil {
+		return false
+	}
+	hash, err := sshcfg.Get(u.Hostname(), "HashKnownHosts")
+	return err == nil && strings.EqualFold(hash, "yes")
+}
+
+// trimKnownHosts drops the oldest host entries from lines until at most
+// maxLines are left, keeping everything that is not a host entry.
+func trimKnownHosts(lines []string, maxLines int) []string {
+	isEntry := func(l string) bool {
+		l = strings.TrimSpace(l)
+		return l != "" && !strings.HasPrefix(l, "#") && !strings.HasPrefix(l, "@")
+	}
+
+	entries := 0
+	for _, l := range lines {
+		if isEntry(l) {
+			entries++
+		}
+	}
+
+	drop := entries - maxLines
+	result := make([]string, 0, len(lines))
+	for _, l := range lines {
+		if drop > 0 && isEntry(l) {
+			drop--
+			continue
+		}
+		result = append(result, l)
+	}
+	return result
+}
+
+// readRevokedKeys returns the keys of the @revoked entries of the
+// known_hosts file at path.
+func readRevokedKeys(path string) ([]ssh.PublicKey, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ssh known hosts: %w", err)
+	}
+	var revoked []ssh.PublicKey
+	for rest := content; len(bytes.TrimSpace(rest)) > 0; {
+		marker, _, key, _, next, err := ssh.ParseKnownHosts(rest)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read ssh known hosts: %w", err)
+		}
+		rest = next
+		if marker == "revoked" {
+			revoked = append(revoked, key)
+		}
+	}
+	return revoked, nil
+}
+
+// withRevokedKeys wraps cb so that a host key listed in revoked is rejected
+// before anything else is considered, whatever other entry would accept it.
+// For a certificate, the certificate itself, its key and the CA that signed
+// it are all checked, like OpenSSH does.
+func (u *ConnectionURI) withRevokedKeys(cb ssh.HostKeyCallback, revoked []ssh.PublicKey, path string) ssh.HostKeyCallback {
+	if len(revoked) == 0 {
+		return cb
+	}
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		keys := []ssh.PublicKey{key}
+		if cert, ok := key.(*ssh.Certificate); ok {
+			keys = append(keys, cert.Key, cert.SignatureKey)
+		}
+		for _, k := range keys {
+			for _, r := range revoked {
+				if bytes.Equal(k.Marshal(), r.Marshal()) {
+					return fmt.Errorf("host key of %s has been revoked: %s %s is marked @revoked in %s",
+						hostname, k.Type(), ssh.FingerprintSHA256(k), path)
+				}
+			}
+		}
+		return cb(hostname, remote, key)
+	}
+}
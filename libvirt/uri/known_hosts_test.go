@@ -0,0 +1,205 @@
+package uri
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func TestAppendKnownHostTrims(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "known_hosts")
+	require.NoError(t, os.WriteFile(path, []byte("# managed by terraform\n@cert-authority *.example.com ssh-ed25519 AAAA\n"), 0644))
+
+	for i := 1; i <= 5; i++ {
+		require.NoError(t, appendKnownHost(path, fmt.Sprintf("host%d ssh-ed25519 AAAA%d", i, i), 3))
+	}
+
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, strings.Join([]string{
+		"# managed by terraform",
+		"@cert-authority *.example.com ssh-ed25519 AAAA",
+		"host3 ssh-ed25519 AAAA3",
+		"host4 ssh-ed25519 AAAA4",
+		"host5 ssh-ed25519 AAAA5",
+	}, "\n")+"\n", string(content))
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0644), info.Mode().Perm())
+
+	// no temporary files are left behind
+	files, err := os.ReadDir(filepath.Dir(path))
+	require.NoError(t, err)
+	assert.Len(t, files, 1)
+}
+
+func TestAppendKnownHostUnbounded(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".ssh", "known_hosts")
+	for i := 1; i <= 5; i++ {
+		require.NoError(t, appendKnownHost(path, fmt.Sprintf("host%d ssh-ed25519 AAAA%d", i, i), 0))
+	}
+
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Len(t, strings.Split(strings.TrimSpace(string(content)), "\n"), 5)
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+}
+
+func TestKnownHostsMaxLines(t *testing.T) {
+	u, err := Parse("qemu+ssh://host/system?known_hosts_max_lines=100")
+	require.NoError(t, err)
+	n, err := u.knownHostsMaxLines()
+	assert.NoError(t, err)
+	assert.Equal(t, 100, n)
+
+	u, err = Parse("qemu+ssh://host/system?known_hosts_max_lines=-1")
+	require.NoError(t, err)
+	_, err = u.knownHostsMaxLines()
+	assert.Error(t, err)
+}
+
+func TestHostKeyCallbackRevoked(t *testing.T) {
+	s := newTestSSHServer(t)
+	addr, err := net.ResolveTCPAddr("tcp", s.Addr())
+	require.NoError(t, err)
+
+	// the key is on record for the host, and revoked as well
+	known := knownhosts.Line([]string{knownhosts.Normalize(s.Addr())}, s.hostKey.PublicKey())
+	revoked := "@revoked * " + string(ssh.MarshalAuthorizedKey(s.hostKey.PublicKey()))
+	u := testSSHURIWithKnownHosts(t, s, []string{known, revoked}, "")
+	cb, err := u.hostKeyCallback(context.Background(), nil)
+	require.NoError(t, err)
+	err = cb(s.Addr(), addr, s.hostKey.PublicKey())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "host key of "+s.Addr()+" has been revoked")
+	assert.Contains(t, err.Error(), ssh.FingerprintSHA256(s.hostKey.PublicKey()))
+
+	// other keys are still verified as usual
+	other := newTestSigner(t).PublicKey()
+	u = testSSHURIWithKnownHosts(t, s, []string{known, "@revoked * " + string(ssh.MarshalAuthorizedKey(other))}, "")
+	cb, err = u.hostKeyCallback(context.Background(), nil)
+	require.NoError(t, err)
+	assert.NoError(t, cb(s.Addr(), addr, s.hostKey.PublicKey()))
+
+	// a certificate signed by a revoked CA, even one trusted by host_ca_file
+	ca := newTestSigner(t)
+	caFile := filepath.Join(t.TempDir(), "host_ca")
+	require.NoError(t, os.WriteFile(caFile, []byte("* "+string(ssh.MarshalAuthorizedKey(ca.PublicKey()))), 0600))
+	u = testSSHURIWithKnownHosts(t, s, []string{"@revoked * " + string(ssh.MarshalAuthorizedKey(ca.PublicKey()))}, "host_ca_file="+caFile)
+	cb, err = u.hostKeyCallback(context.Background(), nil)
+	require.NoError(t, err)
+	err = cb("hv1.example.com:22", addr, newTestHostCert(t, ca, "hv1.example.com"))
+	assert.ErrorContains(t, err, "host key of hv1.example.com:22 has been revoked")
+}
+
+func TestDialSSHAcceptNew(t *testing.T) {
+	s := newTestSSHServer(t)
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("SSH_AUTH_SOCK", "")
+	knownHostsPath := filepath.Join(t.TempDir(), "ssh", "known_hosts")
+
+	u, err := Parse(fmt.Sprintf("qemu+ssh://%s:%s@%s/system?sshauth=ssh-password&knownhosts=%s&known_hosts_verify=accept-new",
+		testSSHUser, testSSHPassword, s.Addr(), knownHostsPath))
+	require.NoError(t, err)
+	conn, err := u.Dial()
+	require.NoError(t, err)
+	conn.Close()
+
+	content, err := os.ReadFile(knownHostsPath)
+	require.NoError(t, err)
+	known := knownhosts.Line([]string{knownhosts.Normalize(s.Addr())}, s.hostKey.PublicKey())
+	assert.Equal(t, known+"\n", string(content))
+
+	// the host is known now and verified as usual
+	conn, err = u.Dial()
+	require.NoError(t, err)
+	conn.Close()
+	content, err = os.ReadFile(knownHostsPath)
+	require.NoError(t, err)
+	assert.Equal(t, known+"\n", string(content))
+
+	// a changed key is still rejected
+	addr, err := net.ResolveTCPAddr("tcp", s.Addr())
+	require.NoError(t, err)
+	cb, err := u.hostKeyCallback(context.Background(), nil)
+	require.NoError(t, err)
+	err = cb(s.Addr(), addr, newTestSigner(t).PublicKey())
+	var keyErr *knownhosts.KeyError
+	require.True(t, errors.As(err, &keyErr), "unexpected error: %v", err)
+	assert.NotEmpty(t, keyErr.Want)
+
+	// StrictHostKeyChecking accept-new in the ssh config does the same
+	u, err = Parse("qemu+ssh://hv1.example.com/system?knownhosts=" + knownHostsPath)
+	require.NoError(t, err)
+	u.SSHConfig = "Host hv1.example.com\n  StrictHostKeyChecking accept-new\n"
+	cb, err = u.hostKeyCallback(context.Background(), u.sshConfig())
+	require.NoError(t, err)
+	require.NoError(t, cb("hv1.example.com:22", addr, s.hostKey.PublicKey()))
+	content, err = os.ReadFile(knownHostsPath)
+	require.NoError(t, err)
+	assert.Contains(t, string(content), "hv1.example.com ")
+}
+
+func TestHostKeyCallbackCertificates(t *testing.T) {
+	s := newTestSSHServer(t)
+	addr, err := net.ResolveTCPAddr("tcp", "192.0.2.10:22")
+	require.NoError(t, err)
+	ca := newTestSigner(t)
+	cert := newTestHostCert(t, ca, "hv1.example.com")
+
+	// a certificate signed by a CA of an @cert-authority entry
+	authority := "@cert-authority *.example.com " + string(ssh.MarshalAuthorizedKey(ca.PublicKey()))
+	u := testSSHURIWithKnownHosts(t, s, []string{authority}, "")
+	cb, err := u.hostKeyCallback(context.Background(), nil)
+	require.NoError(t, err)
+	assert.NoError(t, cb("hv1.example.com:22", addr, cert))
+	assert.Error(t, cb("hv2.example.com:22", addr, cert), "the certificate is not valid for another host")
+
+	// a certificate no CA is known for is verified by its plain key, which
+	// may be known by a hashed entry
+	hashed := knownhosts.Line([]string{knownhosts.HashHostname("hv1.example.com")}, cert.Key)
+	u = testSSHURIWithKnownHosts(t, s, []string{hashed}, "")
+	cb, err = u.hostKeyCallback(context.Background(), nil)
+	require.NoError(t, err)
+	assert.NoError(t, cb("hv1.example.com:22", addr, cert))
+	err = cb("hv1.example.com:22", addr, newTestHostCert(t, ca, "hv1.example.com"))
+	var keyErr *knownhosts.KeyError
+	require.True(t, errors.As(err, &keyErr), "unexpected error: %v", err)
+	assert.NotEmpty(t, keyErr.Want)
+
+	// accept-new adds the plain key of a certificate, with the host name
+	// hashed when asked to
+	u = testSSHURIWithKnownHosts(t, s, nil, "known_hosts_verify=accept-new&known_hosts_hash=1")
+	cb, err = u.hostKeyCallback(context.Background(), nil)
+	require.NoError(t, err)
+	require.NoError(t, cb("hv1.example.com:22", addr, cert))
+	content, err := os.ReadFile(u.Query().Get("knownhosts"))
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(strings.TrimSpace(string(content)), "|1|"), "unexpected entry: %s", content)
+	assert.NotContains(t, string(content), "hv1.example.com")
+	assert.Contains(t, string(content), strings.TrimSpace(string(ssh.MarshalAuthorizedKey(cert.Key))))
+	cb, err = u.hostKeyCallback(context.Background(), nil)
+	require.NoError(t, err)
+	assert.NoError(t, cb("hv1.example.com:22", addr, cert.Key))
+
+	// HashKnownHosts in the ssh config does the same
+	u, err = Parse("qemu+ssh://hv1.example.com/system")
+	require.NoError(t, err)
+	u.SSHConfig = "Host hv1.example.com\n  HashKnownHosts yes\n"
+	assert.True(t, u.hashesKnownHosts(u.sshConfig()))
+	assert.False(t, u.hashesKnownHosts(nil))
+}
@@ -0,0 +1,100 @@
+package uri
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	libvirt "github.com/digitalocean/go-libvirt"
+)
+
+// defaultLibvirtRetryCodes are the libvirt errors retried when
+// libvirt_retries is set without libvirt_retry_codes: a daemon that is busy
+// or whose operation timed out.
+var defaultLibvirtRetryCodes = []uint32{
+	uint32(libvirt.ErrOperationTimeout),
+	uint32(libvirt.ErrAgentUnresponsive),
+	uint32(libvirt.ErrResourceBusy),
+}
+
+// libvirtRetryCodes returns the libvirt error codes given by
+// libvirt_retry_codes, as comma separated numbers.
+func (u *ConnectionURI) libvirtRetryCodes() ([]uint32, error) {
+	v := u.Query().Get("libvirt_retry_codes")
+	if v == "" {
+		return defaultLibvirtRetryCodes, nil
+	}
+	var codes []uint32
+	for _, s := range strings.Split(v, ",") {
+		code, err := strconv.ParseUint(strings.TrimSpace(s), 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value '%s' for libvirt_retry_codes: %w", v, err)
+		}
+		codes = append(codes, uint32(code))
+	}
+	return codes, nil
+}
+
+// RetryLibvirt runs op, and runs it again up to libvirt_retries times as long
+// as it fails with a libvirt error whose code is in libvirt_retry_codes,
+// doubling the wait in between, starting from connect_retry_delay. This
+// covers transient states of a daemon that was reached, unlike
+// connect_retries, which only retries establishing the transport.
+func (u *ConnectionURI) RetryLibvirt(op func() error) error {
+	retries := 0
+	if v := u.Query().Get("libvirt_retries"); v != "" {
+		var err error
+		if retries, err = strconv.Atoi(v); err != nil {
+			return fmt.Errorf("invalid value '%s' for libvirt_retries: %w", v, err)
+		}
+	}
+	codes, err := u.libvirtRetryCodes()
+	if err != nil {
+		return err
+	}
+	delay, err := u.durationParam("connect_retry_delay")
+	if err != nil {
+		return err
+	}
+	if delay == 0 {
+		delay = defaultConnectRetryDelay
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := op()
+		var libvirtErr libvirt.Error
+		if err == nil || attempt > retries || !errors.As(err, &libvirtErr) || !containsCode(codes, libvirtErr.Code) {
+			return err
+		}
+		u.logf("[DEBUG] libvirt call failed with retryable error %d (attempt %d), retrying in %s: %v", libvirtErr.Code, attempt, delay, err)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+func containsCode(codes []uint32, code uint32) bool {
+	for _, c := range codes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+// ConnectLibvirt returns a libvirt client dialing through u, connected to
+// the driver of the URI. Transient libvirt errors are retried as RetryLibvirt
+// does, each time with a new client, as a go-libvirt client cannot be
+// connected again right after a failed attempt.
+func (u *ConnectionURI) ConnectLibvirt() (*libvirt.Libvirt, error) {
+	var l *libvirt.Libvirt
+	err := u.RetryLibvirt(func() error {
+		l = libvirt.NewWithDialer(u)
+		return l.ConnectToURI(libvirt.ConnectURI(u.RemoteName()))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return l, nil
+}
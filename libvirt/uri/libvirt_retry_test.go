@@ -0,0 +1,55 @@
+package uri
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnectLibvirtRetries(t *testing.T) {
+	s := newTestLibvirtServer(t)
+	opens := 0
+	s.Handle(testProcConnectOpen, func([]byte) ([]byte, error) {
+		opens++
+		if opens == 1 {
+			return nil, testLibvirtError{Code: 87, Message: "resource busy"}
+		}
+		return nil, nil
+	})
+	s.Handle(testProcConnectGetCapabilities, func([]byte) ([]byte, error) {
+		return xdrString(testCapabilities), nil
+	})
+
+	u, err := Parse("qemu:///system?libvirt_retries=2&libvirt_retry_codes=87&connect_retry_delay=10ms&socket=" + s.Socket)
+	require.NoError(t, err)
+	caps, err := u.Capabilities()
+	require.NoError(t, err)
+	assert.Equal(t, testCapabilities, caps)
+	assert.Equal(t, 2, s.Calls(testProcConnectOpen))
+}
+
+func TestConnectLibvirtRetriesOtherCodes(t *testing.T) {
+	s := newTestLibvirtServer(t)
+	s.Handle(testProcConnectOpen, func([]byte) ([]byte, error) {
+		return nil, testLibvirtError{Code: 38, Message: "internal error"}
+	})
+
+	// only the listed codes are retried
+	u, err := Parse("qemu:///system?libvirt_retries=2&libvirt_retry_codes=68,87&connect_retry_delay=10ms&socket=" + s.Socket)
+	require.NoError(t, err)
+	_, err = u.Capabilities()
+	assert.ErrorContains(t, err, "internal error")
+	assert.Equal(t, 1, s.Calls(testProcConnectOpen))
+
+	u, err = Parse("qemu:///system?libvirt_retries=2&libvirt_retry_codes=38&connect_retry_delay=10ms&socket=" + s.Socket)
+	require.NoError(t, err)
+	_, err = u.Capabilities()
+	assert.ErrorContains(t, err, "internal error")
+	assert.Equal(t, 4, s.Calls(testProcConnectOpen))
+
+	u, err = Parse("qemu:///system?libvirt_retries=1&libvirt_retry_codes=busy&socket=" + s.Socket)
+	require.NoError(t, err)
+	err = u.RetryLibvirt(func() error { return nil })
+	assert.ErrorContains(t, err, "invalid value 'busy' for libvirt_retry_codes")
+}
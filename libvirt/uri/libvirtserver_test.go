@@ -0,0 +1,175 @@
+package uri
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testLibvirtProgram = 0x20008086
+
+	testProcConnectOpen            = 1
+	testProcConnectClose           = 2
+	testProcConnectGetCapabilities = 7
+	testProcAuthList               = 66
+	testProcConnectGetLibVersion   = 157
+)
+
+// testLibvirtError is returned by a procedure handler to make the server
+// answer with a libvirt error instead of a reply.
+type testLibvirtError struct {
+	Code    uint32
+	Message string
+}
+
+func (e testLibvirtError) Error() string {
+	return e.Message
+}
+
+// testLibvirtServer speaks just enough of the libvirt RPC protocol on a unix
+// socket to open a connection and answer the procedures it is given.
+type testLibvirtServer struct {
+	Socket string
+
+	mu sync.Mutex
+	// procedures maps a procedure number to the handler producing its reply
+	procedures map[uint32]func(payload []byte) ([]byte, error)
+	// calls counts the calls received per procedure
+	calls map[uint32]int
+	// conns are the open client connections
+	conns map[net.Conn]bool
+}
+
+func newTestLibvirtServer(t *testing.T) *testLibvirtServer {
+	s := &testLibvirtServer{
+		Socket: filepath.Join(t.TempDir(), "libvirt-sock"),
+		procedures: map[uint32]func([]byte) ([]byte, error){
+			// a single entry: no authentication
+			testProcAuthList:     func([]byte) ([]byte, error) { return []byte{0, 0, 0, 1, 0, 0, 0, 0}, nil },
+			testProcConnectOpen:  func([]byte) ([]byte, error) { return nil, nil },
+			testProcConnectClose: func([]byte) ([]byte, error) { return nil, nil },
+		},
+		calls: make(map[uint32]int),
+		conns: make(map[net.Conn]bool),
+	}
+
+	l, err := net.Listen("unix", s.Socket)
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.handle(conn)
+		}
+	}()
+	return s
+}
+
+// Handle sets the handler for a procedure.
+func (s *testLibvirtServer) Handle(procedure uint32, handler func(payload []byte) ([]byte, error)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.procedures[procedure] = handler
+}
+
+// Calls returns how many times a procedure was called.
+func (s *testLibvirtServer) Calls(procedure uint32) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls[procedure]
+}
+
+// Drop closes the open client connections, like a libvirtd restart.
+func (s *testLibvirtServer) Drop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+}
+
+func (s *testLibvirtServer) handle(conn net.Conn) {
+	s.mu.Lock()
+	s.conns[conn] = true
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		conn.Close()
+	}()
+	for {
+		var header struct {
+			Len       uint32
+			Program   uint32
+			Version   uint32
+			Procedure uint32
+			Type      uint32
+			Serial    uint32
+			Status    uint32
+		}
+		if err := binary.Read(conn, binary.BigEndian, &header); err != nil {
+			return
+		}
+		payload := make([]byte, header.Len-28)
+		if _, err := io.ReadFull(conn, payload); err != nil {
+			return
+		}
+
+		s.mu.Lock()
+		s.calls[header.Procedure]++
+		handler, ok := s.procedures[header.Procedure]
+		s.mu.Unlock()
+
+		var reply []byte
+		var err error
+		if ok {
+			reply, err = handler(payload)
+		} else {
+			err = testLibvirtError{Code: 1, Message: "unknown procedure"}
+		}
+
+		status := uint32(0)
+		if err != nil {
+			status = 1
+			code := uint32(1)
+			if lerr, ok := err.(testLibvirtError); ok {
+				code = lerr.Code
+			}
+			var buf bytes.Buffer
+			binary.Write(&buf, binary.BigEndian, []uint32{code, 0, 1})
+			buf.Write(xdrString(err.Error()))
+			binary.Write(&buf, binary.BigEndian, uint32(2))
+			reply = buf.Bytes()
+		}
+
+		var out bytes.Buffer
+		binary.Write(&out, binary.BigEndian, []uint32{
+			uint32(28 + len(reply)), testLibvirtProgram, 1, header.Procedure, 1, header.Serial, status,
+		})
+		out.Write(reply)
+		if _, err := conn.Write(out.Bytes()); err != nil {
+			return
+		}
+	}
+}
+
+// xdrString encodes s as an XDR string.
+func xdrString(s string) []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, uint32(len(s)))
+	buf.WriteString(s)
+	buf.Write(make([]byte, (4-len(s)%4)%4))
+	return buf.Bytes()
+}
@@ -0,0 +1,50 @@
+package uri
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// tag returns the conn_tag parameter, used to correlate the logs of a
+// connection with the operation it belongs to.
+func (u *ConnectionURI) tag() string {
+	return u.Query().Get("conn_tag")
+}
+
+// logf logs like log.Printf, adding the connection tag after the log level
+// so that log filtering by level keeps working.
+func (u *ConnectionURI) logf(format string, v ...interface{}) {
+	msg := fmt.Sprintf(format, v...)
+	if tag := u.tag(); tag != "" {
+		level := ""
+		if strings.HasPrefix(msg, "[") {
+			if i := strings.Index(msg, "] "); i >= 0 {
+				level, msg = msg[:i+2], msg[i+2:]
+			}
+		}
+		msg = fmt.Sprintf("%s[conn_tag=%s] %s", level, tag, msg)
+	}
+	log.Print(msg)
+}
+
+// sshClientVersion returns the version string sent to the SSH server, with
+// the connection tag as comment so that it shows up in the server logs. An
+// empty string selects the default of the ssh package.
+func (u *ConnectionURI) sshClientVersion() string {
+	tag := u.tag()
+	if tag == "" {
+		return ""
+	}
+	// the identification string is printable ASCII without spaces
+	tag = strings.Map(func(r rune) rune {
+		if r <= ' ' || r > '~' {
+			return '_'
+		}
+		return r
+	}, tag)
+	if len(tag) > 200 {
+		tag = tag[:200]
+	}
+	return "SSH-2.0-Go " + tag
+}
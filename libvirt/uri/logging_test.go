@@ -0,0 +1,87 @@
+package uri
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+// captureLog redirects the standard logger for the duration of the test.
+func captureLog(t *testing.T) *syncBuffer {
+	buf := &syncBuffer{}
+	out, flags := log.Writer(), log.Flags()
+	log.SetOutput(buf)
+	log.SetFlags(0)
+	t.Cleanup(func() {
+		log.SetOutput(out)
+		log.SetFlags(flags)
+	})
+	return buf
+}
+
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestConnTag(t *testing.T) {
+	s := newTestSSHServer(t)
+	var (
+		mu            sync.Mutex
+		clientVersion string
+	)
+	s.Configure(func(config *ssh.ServerConfig) {
+		checkPassword := config.PasswordCallback
+		config.PasswordCallback = func(c ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+			mu.Lock()
+			clientVersion = string(c.ClientVersion())
+			mu.Unlock()
+			return checkPassword(c, pass)
+		}
+	})
+	logs := captureLog(t)
+
+	// the unsupported method is only there to produce a log line
+	u := testSSHURI(t, s, "conn_tag=apply 42&sshauth=ssh-password,unknown")
+	conn, err := u.Dial()
+	require.NoError(t, err)
+	conn.Close()
+
+	mu.Lock()
+	assert.Equal(t, "SSH-2.0-Go apply_42", clientVersion)
+	mu.Unlock()
+	lines := strings.Split(strings.TrimSpace(logs.String()), "\n")
+	require.NotEmpty(t, lines)
+	for _, line := range lines {
+		assert.Regexp(t, `^\[[A-Z]+\] \[conn_tag=apply 42\] `, line)
+	}
+	assert.Contains(t, logs.String(), "[WARN] [conn_tag=apply 42] Unsupported auth method: unknown")
+}
+
+func TestLogfWithoutTag(t *testing.T) {
+	logs := captureLog(t)
+	u, err := Parse("qemu+ssh://libvirt.example.com/system")
+	require.NoError(t, err)
+
+	u.logf("[DEBUG] connecting to %s", u.Host)
+	assert.Equal(t, "[DEBUG] connecting to libvirt.example.com\n", logs.String())
+	assert.Equal(t, "", u.sshClientVersion())
+}
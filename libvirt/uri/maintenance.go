@@ -0,0 +1,35 @@
+package uri
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// maintenanceError returns the error to fail the connection with, without
+// dialing, while the host is under maintenance, or nil otherwise.
+//
+// The maintenance_message parameter puts the host of that URI in
+// maintenance. The LIBVIRT_MAINTENANCE_MESSAGE environment variable does the
+// same for every host, or only for the hosts matching the comma separated
+// patterns of LIBVIRT_MAINTENANCE_HOSTS when that is set, so that operators
+// can gate part of a fleet without touching the configuration.
+func (u *ConnectionURI) maintenanceError() error {
+	msg := u.Query().Get("maintenance_message")
+	if msg == "" {
+		msg = os.Getenv("LIBVIRT_MAINTENANCE_MESSAGE")
+		if hosts := os.Getenv("LIBVIRT_MAINTENANCE_HOSTS"); msg != "" && hosts != "" {
+			host := u.Hostname()
+			if host == "" {
+				host = "localhost"
+			}
+			if !matchHostPatterns(strings.Split(hosts, ","), host) {
+				msg = ""
+			}
+		}
+	}
+	if msg == "" {
+		return nil
+	}
+	return fmt.Errorf("host %s is in maintenance: %s", u.Host, msg)
+}
@@ -0,0 +1,65 @@
+package uri
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDialMaintenance(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	var accepted int64
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt64(&accepted, 1)
+			c.Close()
+		}
+	}()
+	_, port, err := net.SplitHostPort(l.Addr().String())
+	require.NoError(t, err)
+
+	var lookups int64
+	oldLookupHost := lookupHost
+	lookupHost = func(ctx context.Context, host string) ([]string, error) {
+		atomic.AddInt64(&lookups, 1)
+		return []string{"127.0.0.1"}, nil
+	}
+	defer func() { lookupHost = oldLookupHost }()
+
+	var events []ConnectionEvent
+	u, err := Parse("qemu+tcp://hv1.example.com:" + port + "/system?connect_retries=3&maintenance_message=kernel+upgrade")
+	require.NoError(t, err)
+	u.OnEvent = func(e ConnectionEvent) { events = append(events, e) }
+	_, err = u.Dial()
+	assert.EqualError(t, err, "host hv1.example.com:"+port+" is in maintenance: kernel upgrade")
+	require.Len(t, events, 1)
+	assert.Equal(t, PhaseFailed, events[0].Phase)
+
+	// the environment variable only applies to the matching hosts
+	t.Setenv("LIBVIRT_MAINTENANCE_MESSAGE", "rack 4 is being moved")
+	t.Setenv("LIBVIRT_MAINTENANCE_HOSTS", "hv*.example.com,!hv2.example.com")
+	u, err = Parse("qemu+tcp://hv1.example.com:" + port + "/system")
+	require.NoError(t, err)
+	_, err = u.Dial()
+	assert.ErrorContains(t, err, "is in maintenance: rack 4 is being moved")
+
+	assert.Zero(t, atomic.LoadInt64(&lookups))
+	assert.Zero(t, atomic.LoadInt64(&accepted))
+
+	u, err = Parse("qemu+tcp://hv2.example.com:" + port + "/system")
+	require.NoError(t, err)
+	conn, err := u.Dial()
+	require.NoError(t, err)
+	conn.Close()
+	assert.Equal(t, int64(1), atomic.LoadInt64(&lookups))
+}
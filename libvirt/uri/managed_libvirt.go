@@ -0,0 +1,348 @@
+package uri
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	libvirt "github.com/digitalocean/go-libvirt"
+)
+
+const (
+	defaultLibvirtKeepaliveTimeout = 10 * time.Second
+	defaultReconnectTimeout        = time.Minute
+	maxReconnectDelay              = time.Minute
+)
+
+// ManagedLibvirt is a libvirt client that stays connected for as long as it
+// is used, e.g. for the whole run of the provider. Once the connection is
+// lost it connects again in the background, waiting connect_retry_delay
+// between the attempts, doubled each time up to a minute.
+//
+// With libvirt_keepalive_interval, libvirt is pinged that often, so that a
+// connection that went dead without being closed, e.g. behind a NAT gateway,
+// is dropped and established again once a ping is not answered within
+// libvirt_keepalive_timeout (10s by default).
+type ManagedLibvirt struct {
+	u *ConnectionURI
+	l *libvirt.Libvirt
+
+	retryDelay       time.Duration
+	reconnectTimeout time.Duration
+
+	// connectMu serializes connecting and disconnecting l
+	connectMu sync.Mutex
+	// dialed is the connection dialed by the current connect
+	dialed *libvirtConnection
+
+	mu sync.Mutex
+	// current is the last established connection
+	current *libvirtConnection
+
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+// libvirtConnection tracks one of the successive connections of a
+// ManagedLibvirt.
+type libvirtConnection struct {
+	conn net.Conn
+
+	// established is closed once l.ConnectToURI connected it
+	established chan struct{}
+	closeOnce   sync.Once
+	// closed is closed once it was closed by the client
+	closed chan struct{}
+
+	lostOnce sync.Once
+	// lost is closed once the connection failed or was closed
+	lost chan struct{}
+	// replaced is closed once the next connection is established
+	replaced chan struct{}
+}
+
+func (c *libvirtConnection) markLost() {
+	c.lostOnce.Do(func() { close(c.lost) })
+}
+
+// managedConn marks its libvirtConnection as lost as soon as it fails, before
+// go-libvirt fails the calls waiting for a reply.
+type managedConn struct {
+	net.Conn
+	c *libvirtConnection
+}
+
+func (c *managedConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if c.failed(err) {
+		// go-libvirt records the connection at the end of ConnectToURI
+		// without synchronizing with the goroutine reading it, which cleans
+		// up once a read fails. Unless the client gave up on the connection,
+		// fail the read only after that, or after a while for a connection
+		// lost during ConnectToURI.
+		select {
+		case <-c.c.established:
+		case <-c.c.closed:
+		case <-time.After(dialTimeout):
+		}
+	}
+	return n, err
+}
+
+func (c *managedConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.failed(err)
+	return n, err
+}
+
+func (c *managedConn) Close() error {
+	c.c.closeOnce.Do(func() { close(c.c.closed) })
+	c.c.markLost()
+	return c.Conn.Close()
+}
+
+// failed marks the connection as lost on the errors go-libvirt does not
+// recover from, and reports whether it did.
+func (c *managedConn) failed(err error) bool {
+	if err == nil {
+		return false
+	}
+	if opErr, ok := err.(*net.OpError); ok && opErr.Temporary() {
+		return false
+	}
+	c.c.markLost()
+	return true
+}
+
+// ConnectManagedLibvirt returns a ManagedLibvirt dialing through u, connected
+// to the driver of the URI. Transient libvirt errors of the first connection
+// are retried as ConnectLibvirt does.
+func (u *ConnectionURI) ConnectManagedLibvirt() (*ManagedLibvirt, error) {
+	keepaliveInterval, err := u.durationParam("libvirt_keepalive_interval")
+	if err != nil {
+		return nil, err
+	}
+	if keepaliveInterval < 0 {
+		return nil, fmt.Errorf("invalid value '%s' for libvirt_keepalive_interval", u.Query().Get("libvirt_keepalive_interval"))
+	}
+	keepaliveTimeout, err := u.durationParam("libvirt_keepalive_timeout")
+	if err != nil {
+		return nil, err
+	}
+	if keepaliveTimeout <= 0 {
+		keepaliveTimeout = defaultLibvirtKeepaliveTimeout
+	}
+	reconnectTimeout, err := u.durationParam("reconnect_timeout")
+	if err != nil {
+		return nil, err
+	}
+	if reconnectTimeout <= 0 {
+		reconnectTimeout = defaultReconnectTimeout
+	}
+	retryDelay, err := u.durationParam("connect_retry_delay")
+	if err != nil {
+		return nil, err
+	}
+	if retryDelay == 0 {
+		retryDelay = defaultConnectRetryDelay
+	}
+
+	m := &ManagedLibvirt{
+		u:                u,
+		retryDelay:       retryDelay,
+		reconnectTimeout: reconnectTimeout,
+		done:             make(chan struct{}),
+	}
+	err = u.RetryLibvirt(func() error {
+		m.l = libvirt.NewWithDialer(m)
+		return m.connect()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	go m.watch()
+	if keepaliveInterval > 0 {
+		go m.keepAlive(keepaliveInterval, keepaliveTimeout)
+	}
+	return m, nil
+}
+
+// Libvirt returns the client. It is the same one across reconnects.
+func (m *ManagedLibvirt) Libvirt() *libvirt.Libvirt {
+	return m.l
+}
+
+// Dial implements the go-libvirt Dialer interface.
+func (m *ManagedLibvirt) Dial() (net.Conn, error) {
+	conn, err := m.u.Dial()
+	if err != nil {
+		return nil, err
+	}
+	c := &libvirtConnection{
+		conn:        conn,
+		established: make(chan struct{}),
+		closed:      make(chan struct{}),
+		lost:        make(chan struct{}),
+		replaced:    make(chan struct{}),
+	}
+	// called by l.ConnectToURI, on the goroutine of connect
+	m.dialed = c
+	return &managedConn{Conn: conn, c: c}, nil
+}
+
+// connect connects l to libvirt and makes this connection the current one.
+func (m *ManagedLibvirt) connect() error {
+	m.connectMu.Lock()
+	defer m.connectMu.Unlock()
+	select {
+	case <-m.done:
+		return errors.New("the libvirt connection is closed")
+	default:
+	}
+
+	m.dialed = nil
+	if err := m.l.ConnectToURI(libvirt.ConnectURI(m.u.RemoteName())); err != nil {
+		return err
+	}
+	close(m.dialed.established)
+
+	m.mu.Lock()
+	previous := m.current
+	m.current = m.dialed
+	m.mu.Unlock()
+	if previous != nil {
+		close(previous.replaced)
+	}
+	return nil
+}
+
+func (m *ManagedLibvirt) currentConnection() *libvirtConnection {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.current
+}
+
+// watch connects again every time the connection is lost, until Close.
+func (m *ManagedLibvirt) watch() {
+	for {
+		select {
+		case <-m.currentConnection().lost:
+		case <-m.done:
+			return
+		}
+
+		// go-libvirt has to clean up the old connection before l can be
+		// connected again
+		m.connectMu.Lock()
+		disconnected := m.l.Disconnected()
+		m.connectMu.Unlock()
+		select {
+		case <-disconnected:
+		case <-m.done:
+			return
+		}
+
+		m.u.logf("[INFO] lost the connection to libvirt, reconnecting")
+		delay := m.retryDelay
+		for {
+			err := m.connect()
+			if err == nil {
+				break
+			}
+			m.u.logf("[WARN] failed to reconnect to libvirt, retrying in %s: %v", delay, err)
+			select {
+			case <-time.After(delay):
+			case <-m.done:
+				return
+			}
+			delay *= 2
+			if delay > maxReconnectDelay {
+				delay = maxReconnectDelay
+			}
+		}
+		m.u.logf("[INFO] reconnected to libvirt")
+	}
+}
+
+// keepAlive pings libvirt every interval, and drops the connection when a
+// ping is not answered within timeout, so that watch establishes a new one.
+func (m *ManagedLibvirt) keepAlive(interval, timeout time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-m.done:
+			return
+		}
+
+		c := m.currentConnection()
+		select {
+		case <-c.lost:
+			continue
+		default:
+		}
+
+		replied := make(chan error, 1)
+		go func() {
+			_, err := m.l.ConnectGetLibVersion()
+			replied <- err
+		}()
+		select {
+		case err := <-replied:
+			// an error from libvirt itself still proves the connection alive
+			var libvirtErr libvirt.Error
+			if err == nil || errors.As(err, &libvirtErr) {
+				continue
+			}
+			m.u.logf("[WARN] libvirt keepalive failed, dropping the connection: %v", err)
+		case <-time.After(timeout):
+			m.u.logf("[WARN] libvirt did not answer the keepalive within %s, dropping the connection", timeout)
+		case <-m.done:
+			return
+		}
+		c.conn.Close()
+	}
+}
+
+// RetryIdempotent runs op, and runs it once more when it failed and the
+// connection to libvirt was lost meanwhile, as soon as it is established
+// again, waiting at most reconnect_timeout (1m by default). op must be safe
+// to repeat, like a read.
+func (m *ManagedLibvirt) RetryIdempotent(op func() error) error {
+	c := m.currentConnection()
+	err := op()
+	if err == nil {
+		return nil
+	}
+	select {
+	case <-c.lost:
+	default:
+		return err
+	}
+
+	select {
+	case <-c.replaced:
+	case <-time.After(m.reconnectTimeout):
+		return fmt.Errorf("%w (not reconnected to libvirt within %s)", err, m.reconnectTimeout)
+	case <-m.done:
+		return err
+	}
+	m.u.logf("[DEBUG] retrying after reconnecting to libvirt: %v", err)
+	return op()
+}
+
+// Close stops reconnecting and closes the connection to libvirt.
+func (m *ManagedLibvirt) Close() error {
+	m.closeOnce.Do(func() { close(m.done) })
+
+	m.connectMu.Lock()
+	defer m.connectMu.Unlock()
+	if !m.l.IsConnected() {
+		return nil
+	}
+	return m.l.Disconnect()
+}
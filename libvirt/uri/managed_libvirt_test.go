@@ -0,0 +1,133 @@
+package uri
+
+import (
+	"encoding/binary"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testLibVersion([]byte) ([]byte, error) {
+	version := make([]byte, 8)
+	binary.BigEndian.PutUint64(version, 9001002)
+	return version, nil
+}
+
+func TestManagedLibvirtReconnects(t *testing.T) {
+	s := newTestLibvirtServer(t)
+	s.Handle(testProcConnectGetLibVersion, testLibVersion)
+
+	u, err := Parse("qemu:///system?connect_retry_delay=10ms&socket=" + s.Socket)
+	require.NoError(t, err)
+	m, err := u.ConnectManagedLibvirt()
+	require.NoError(t, err)
+	t.Cleanup(func() { m.Close() })
+
+	s.Drop()
+	require.Eventually(t, func() bool {
+		return s.Calls(testProcConnectOpen) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+
+	v, err := m.Libvirt().ConnectGetLibVersion()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(9001002), v)
+}
+
+func TestManagedLibvirtRetryIdempotent(t *testing.T) {
+	s := newTestLibvirtServer(t)
+	var calls int32
+	s.Handle(testProcConnectGetLibVersion, func(payload []byte) ([]byte, error) {
+		// the first call is interrupted by a restart of libvirtd
+		if atomic.AddInt32(&calls, 1) == 1 {
+			s.Drop()
+		}
+		return testLibVersion(payload)
+	})
+
+	u, err := Parse("qemu:///system?connect_retry_delay=10ms&socket=" + s.Socket)
+	require.NoError(t, err)
+	m, err := u.ConnectManagedLibvirt()
+	require.NoError(t, err)
+	t.Cleanup(func() { m.Close() })
+
+	var v uint64
+	err = m.RetryIdempotent(func() error {
+		var err error
+		v, err = m.Libvirt().ConnectGetLibVersion()
+		return err
+	})
+	require.NoError(t, err)
+	assert.Equal(t, uint64(9001002), v)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+	assert.Equal(t, 2, s.Calls(testProcConnectOpen))
+
+	// failures on a working connection are not retried
+	s.Handle(testProcConnectGetLibVersion, func([]byte) ([]byte, error) {
+		return nil, testLibvirtError{Code: 1, Message: "internal error"}
+	})
+	runs := 0
+	err = m.RetryIdempotent(func() error {
+		runs++
+		_, err := m.Libvirt().ConnectGetLibVersion()
+		return err
+	})
+	assert.ErrorContains(t, err, "internal error")
+	assert.Equal(t, 1, runs)
+}
+
+func TestManagedLibvirtKeepalive(t *testing.T) {
+	s := newTestLibvirtServer(t)
+	hang := make(chan struct{})
+	t.Cleanup(func() { close(hang) })
+	var calls int32
+	s.Handle(testProcConnectGetLibVersion, func(payload []byte) ([]byte, error) {
+		// the first connection goes silent, like one dropped by a firewall
+		if atomic.AddInt32(&calls, 1) == 1 {
+			<-hang
+		}
+		return testLibVersion(payload)
+	})
+
+	u, err := Parse("qemu:///system?libvirt_keepalive_interval=50ms&libvirt_keepalive_timeout=100ms&connect_retry_delay=10ms&socket=" + s.Socket)
+	require.NoError(t, err)
+	logs := captureLog(t)
+	m, err := u.ConnectManagedLibvirt()
+	require.NoError(t, err)
+	t.Cleanup(func() { m.Close() })
+
+	require.Eventually(t, func() bool {
+		return s.Calls(testProcConnectOpen) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Contains(t, logs.String(), "libvirt did not answer the keepalive within 100ms")
+}
+
+func TestManagedLibvirtClose(t *testing.T) {
+	s := newTestLibvirtServer(t)
+
+	u, err := Parse("qemu:///system?connect_retry_delay=10ms&socket=" + s.Socket)
+	require.NoError(t, err)
+	m, err := u.ConnectManagedLibvirt()
+	require.NoError(t, err)
+
+	require.NoError(t, m.Close())
+	assert.Equal(t, 1, s.Calls(testProcConnectClose))
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, 1, s.Calls(testProcConnectOpen))
+	assert.False(t, m.Libvirt().IsConnected())
+}
+
+func TestManagedLibvirtInvalidParams(t *testing.T) {
+	for _, params := range []string{
+		"libvirt_keepalive_interval=-1",
+		"libvirt_keepalive_timeout=soon",
+		"reconnect_timeout=soon",
+	} {
+		u, err := Parse("qemu:///system?" + params)
+		require.NoError(t, err)
+		_, err = u.ConnectManagedLibvirt()
+		assert.ErrorContains(t, err, "invalid value", params)
+	}
+}
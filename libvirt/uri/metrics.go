@@ -0,0 +1,140 @@
+package uri
+
+import (
+	"context"
+	"expvar"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Metrics receives the connection health metrics of a ConnectionURI, for
+// fleet-wide visibility when the connection layer runs in a long-lived
+// service. ExpvarMetrics publishes them with expvar; to export them to
+// Prometheus, implement Metrics over counters and a histogram registered
+// with the prometheus.Registerer of the service.
+type Metrics interface {
+	// Attempt is called when a connection attempt ends.
+	Attempt(MetricsAttempt)
+	// OpenConns is called with 1 when Dial returns a connection and with
+	// -1 when that connection is closed.
+	OpenConns(delta int)
+}
+
+// MetricsAttempt describes the outcome of one connection attempt.
+type MetricsAttempt struct {
+	Transport string
+	Host      string
+	// Duration covers the dial and, for ssh, the handshake
+	Duration time.Duration
+	// Reconnect is set once the host was reached before during this run
+	Reconnect bool
+
+	Success bool
+	// Reason classifies a failure, e.g. "authentication_failed",
+	// "timed_out", "host_key_mismatch" or "other"
+	Reason string
+}
+
+// failureReason classifies err for MetricsAttempt.Reason.
+func failureReason(err error) string {
+	if hostNotFound(err) {
+		return "host_not_found"
+	}
+	reason := sshFailure(err)
+	if reason == "" {
+		return "other"
+	}
+	return strings.ReplaceAll(reason, " ", "_")
+}
+
+// observe hands the outcome of the attempt started at start to the Metrics,
+// when set.
+func (u *ConnectionURI) observe(ctx context.Context, start time.Time, reconnect bool, err error) {
+	if u.Metrics == nil {
+		return
+	}
+	a := MetricsAttempt{
+		Transport: u.transport(),
+		Host:      u.Host,
+		Duration:  time.Since(start),
+		Reconnect: reconnect,
+		Success:   err == nil,
+	}
+	if err != nil {
+		a.Reason = failureReason(err)
+	}
+	u.Metrics.Attempt(a)
+}
+
+// handshakeBuckets are the upper bounds, in seconds, of the buckets of the
+// handshake duration histogram of ExpvarMetrics.
+var handshakeBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// ExpvarMetrics publishes connection metrics as an expvar map:
+//
+//	attempts, successes, reconnects   counters
+//	failures                          counters by reason
+//	open_connections                  gauge
+//	handshake_seconds                 cumulative histogram, by upper bound,
+//	                                  with "+Inf", "count" and "sum"
+type ExpvarMetrics struct {
+	attempts   expvar.Int
+	successes  expvar.Int
+	reconnects expvar.Int
+	failures   expvar.Map
+	open       expvar.Int
+	handshake  expvar.Map
+}
+
+// NewExpvarMetrics returns ExpvarMetrics published under name. Like
+// expvar.Publish, it panics when name is already in use.
+func NewExpvarMetrics(name string) *ExpvarMetrics {
+	m := &ExpvarMetrics{}
+	m.failures.Init()
+	m.handshake.Init()
+	for _, b := range handshakeBuckets {
+		m.handshake.Add(strconv.FormatFloat(b, 'g', -1, 64), 0)
+	}
+	m.handshake.Add("+Inf", 0)
+	m.handshake.Add("count", 0)
+	m.handshake.AddFloat("sum", 0)
+
+	v := expvar.NewMap(name)
+	v.Set("attempts", &m.attempts)
+	v.Set("successes", &m.successes)
+	v.Set("reconnects", &m.reconnects)
+	v.Set("failures", &m.failures)
+	v.Set("open_connections", &m.open)
+	v.Set("handshake_seconds", &m.handshake)
+	return m
+}
+
+// Attempt counts a, and adds the duration of a successful one to the
+// handshake histogram.
+func (m *ExpvarMetrics) Attempt(a MetricsAttempt) {
+	m.attempts.Add(1)
+	if !a.Success {
+		m.failures.Add(a.Reason, 1)
+		return
+	}
+	m.successes.Add(1)
+	if a.Reconnect {
+		m.reconnects.Add(1)
+	}
+
+	secs := a.Duration.Seconds()
+	for _, b := range handshakeBuckets {
+		if secs <= b {
+			m.handshake.Add(strconv.FormatFloat(b, 'g', -1, 64), 1)
+		}
+	}
+	m.handshake.Add("+Inf", 1)
+	m.handshake.Add("count", 1)
+	m.handshake.AddFloat("sum", secs)
+}
+
+// OpenConns adjusts the open_connections gauge.
+func (m *ExpvarMetrics) OpenConns(delta int) {
+	m.open.Add(int64(delta))
+}
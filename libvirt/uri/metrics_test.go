@@ -0,0 +1,69 @@
+package uri
+
+import (
+	"encoding/json"
+	"expvar"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDialMetrics(t *testing.T) {
+	s := newTestSSHServer(t)
+	t.Setenv("HTTP_PROXY", "")
+	t.Setenv("ALL_PROXY", "")
+	m := NewExpvarMetrics("libvirt_connections_test")
+
+	u := testSSHURI(t, s, "")
+	u.Metrics = m
+	conn, err := u.Dial()
+	require.NoError(t, err)
+	again, err := u.Dial()
+	require.NoError(t, err)
+	assert.EqualValues(t, 2, m.open.Value())
+	conn.Close()
+	conn.Close()
+	again.Close()
+	assert.EqualValues(t, 0, m.open.Value())
+
+	u.User = url.UserPassword(testSSHUser, "wrong")
+	_, err = u.Dial()
+	require.Error(t, err)
+
+	u, err = Parse("qemu+ssh://" + testSSHUser + ":" + testSSHPassword + "@127.0.0.1:" + closedPort(t) + "/system?sshauth=ssh-password&no_verify=1&connect_retries=1&connect_retry_delay=1ms")
+	require.NoError(t, err)
+	u.Metrics = m
+	_, err = u.Dial()
+	require.Error(t, err)
+
+	assert.EqualValues(t, 5, m.attempts.Value())
+	assert.EqualValues(t, 2, m.successes.Value())
+	assert.EqualValues(t, 1, m.reconnects.Value())
+	assert.Equal(t, "1", m.failures.Get("authentication_failed").String())
+	assert.Equal(t, "2", m.failures.Get("connection_refused").String())
+	assert.Equal(t, "2", m.handshake.Get("count").String())
+	assert.Equal(t, "2", m.handshake.Get("+Inf").String())
+
+	var published map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(expvar.Get("libvirt_connections_test").String()), &published))
+	assert.EqualValues(t, 5, published["attempts"])
+	assert.EqualValues(t, 0, published["open_connections"])
+}
+
+func TestExpvarMetricsHistogram(t *testing.T) {
+	m := NewExpvarMetrics("libvirt_connections_histogram_test")
+	m.Attempt(MetricsAttempt{Success: true, Duration: 200 * time.Millisecond})
+	m.Attempt(MetricsAttempt{Success: true, Duration: 3 * time.Second})
+	m.Attempt(MetricsAttempt{Success: true, Duration: time.Minute})
+
+	assert.Equal(t, "0", m.handshake.Get("0.1").String())
+	assert.Equal(t, "1", m.handshake.Get("0.25").String())
+	assert.Equal(t, "1", m.handshake.Get("2.5").String())
+	assert.Equal(t, "2", m.handshake.Get("5").String())
+	assert.Equal(t, "2", m.handshake.Get("10").String())
+	assert.Equal(t, "3", m.handshake.Get("+Inf").String())
+	assert.Equal(t, "63.2", m.handshake.Get("sum").String())
+}
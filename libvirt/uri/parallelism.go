@@ -0,0 +1,94 @@
+package uri
+
+import (
+	"context"
+	"strconv"
+	"sync"
+)
+
+// maxHandshakesPerHost matches the default MaxStartups of sshd, which
+// starts dropping connections once more than 10 are unauthenticated.
+const maxHandshakesPerHost = 10
+
+// handshakeSlots bounds the concurrent SSH handshakes per host, once a
+// parallelism hint was given, so that a large apply neither overloads sshd
+// nor leaves operations waiting for no reason. The semaphores are keyed by
+// contactKey.
+var (
+	parallelismMutex sync.Mutex
+	parallelismHint  int
+	handshakeSlots   = make(map[string]chan struct{})
+)
+
+// SetParallelismHint tells how many operations Terraform runs at once, as
+// given by -parallelism, to size the concurrency of the connections after
+// it. Each host gets as many concurrent SSH handshakes, up to the 10 sshd
+// accepts by default. A hint of 0 removes the limit, which is the default.
+func SetParallelismHint(n int) {
+	parallelismMutex.Lock()
+	defer parallelismMutex.Unlock()
+	parallelismHint = n
+	// handshakes in flight release the slots of the semaphore they took
+	handshakeSlots = make(map[string]chan struct{})
+}
+
+// handshakeLimit returns the number of concurrent SSH handshakes allowed per
+// host for a parallelism of n, or 0 for no limit.
+func handshakeLimit(n int) int {
+	if n > maxHandshakesPerHost {
+		return maxHandshakesPerHost
+	}
+	if n < 0 {
+		return 0
+	}
+	return n
+}
+
+// handshakeSemaphore returns the semaphore bounding the SSH handshakes to
+// the host, or nil when they are not bounded. The parallelism parameter
+// takes precedence over the hint given to SetParallelismHint.
+func (u *ConnectionURI) handshakeSemaphore() chan struct{} {
+	parallelismMutex.Lock()
+	defer parallelismMutex.Unlock()
+
+	n := parallelismHint
+	if v := u.Query().Get("parallelism"); v != "" {
+		if p, err := strconv.Atoi(v); err == nil {
+			n = p
+		} else {
+			u.logf("[WARN] ignoring invalid value '%s' for parallelism", v)
+		}
+	}
+	limit := handshakeLimit(n)
+	if limit == 0 {
+		return nil
+	}
+
+	key := u.contactKey()
+	slots, ok := handshakeSlots[key]
+	if !ok || cap(slots) != limit {
+		slots = make(chan struct{}, limit)
+		handshakeSlots[key] = slots
+	}
+	return slots
+}
+
+// acquireHandshake waits for a free handshake slot for the host, and
+// returns the function giving it back.
+func (u *ConnectionURI) acquireHandshake(ctx context.Context) (func(), error) {
+	slots := u.handshakeSemaphore()
+	if slots == nil {
+		return func() {}, nil
+	}
+	select {
+	case slots <- struct{}{}:
+	default:
+		u.logf("[DEBUG] %d SSH handshakes to %s in progress, waiting for one to finish", cap(slots), u.Host)
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	return func() { <-slots }, nil
+}
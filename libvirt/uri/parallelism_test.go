@@ -0,0 +1,67 @@
+package uri
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetParallelismHint(t *testing.T) {
+	t.Cleanup(func() { SetParallelismHint(0) })
+	u, err := Parse("qemu+ssh://hv1.example.com/system")
+	require.NoError(t, err)
+
+	assert.Nil(t, u.handshakeSemaphore())
+
+	SetParallelismHint(4)
+	assert.Equal(t, 4, cap(u.handshakeSemaphore()))
+	SetParallelismHint(32)
+	assert.Equal(t, maxHandshakesPerHost, cap(u.handshakeSemaphore()))
+
+	// the parameter wins over the hint
+	u, err = Parse("qemu+ssh://hv1.example.com/system?parallelism=2")
+	require.NoError(t, err)
+	assert.Equal(t, 2, cap(u.handshakeSemaphore()))
+	u, err = Parse("qemu+ssh://hv1.example.com/system?parallelism=0")
+	require.NoError(t, err)
+	assert.Nil(t, u.handshakeSemaphore())
+}
+
+func TestAcquireHandshake(t *testing.T) {
+	t.Cleanup(func() { SetParallelismHint(0) })
+	SetParallelismHint(2)
+	u, err := Parse("qemu+ssh://hv1.example.com/system")
+	require.NoError(t, err)
+
+	release1, err := u.acquireHandshake(context.Background())
+	require.NoError(t, err)
+	release2, err := u.acquireHandshake(context.Background())
+	require.NoError(t, err)
+
+	// other hosts have their own slots
+	other, err := Parse("qemu+ssh://hv2.example.com/system")
+	require.NoError(t, err)
+	releaseOther, err := other.acquireHandshake(context.Background())
+	require.NoError(t, err)
+	releaseOther()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = u.acquireHandshake(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	acquired := make(chan struct{})
+	go func() {
+		release, err := u.acquireHandshake(context.Background())
+		if err == nil {
+			release()
+		}
+		close(acquired)
+	}()
+	release1()
+	<-acquired
+	release2()
+}
@@ -0,0 +1,118 @@
+package uri
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+)
+
+// PassphraseProvider supplies the passphrase of an encrypted private key.
+// It is only asked for keys that turn out to be encrypted. A nil passphrase
+// without error means the provider has none for the key.
+type PassphraseProvider interface {
+	Passphrase(keyPath string) ([]byte, error)
+}
+
+// PassphraseProviderFunc is an adapter to use an ordinary function as a
+// PassphraseProvider.
+type PassphraseProviderFunc func(keyPath string) ([]byte, error)
+
+// Passphrase calls f(keyPath).
+func (f PassphraseProviderFunc) Passphrase(keyPath string) ([]byte, error) {
+	return f(keyPath)
+}
+
+// FirstPassphrase returns a provider asking each of providers in turn, and
+// returning the first passphrase one of them has.
+func FirstPassphrase(providers ...PassphraseProvider) PassphraseProvider {
+	return PassphraseProviderFunc(func(keyPath string) ([]byte, error) {
+		for _, p := range providers {
+			passphrase, err := p.Passphrase(keyPath)
+			if err != nil || passphrase != nil {
+				return passphrase, err
+			}
+		}
+		return nil, nil
+	})
+}
+
+// EnvPassphrase returns a provider reading the passphrase of every key from
+// the environment variable name.
+func EnvPassphrase(name string) PassphraseProvider {
+	return PassphraseProviderFunc(func(string) ([]byte, error) {
+		if passphrase := os.Getenv(name); passphrase != "" {
+			return []byte(passphrase), nil
+		}
+		return nil, nil
+	})
+}
+
+// CommandPassphrase returns a provider running name with args and the key
+// path as last argument, and using what it prints, without the trailing
+// line break, as the passphrase, e.g. a password manager CLI.
+func CommandPassphrase(name string, args ...string) PassphraseProvider {
+	return PassphraseProviderFunc(func(keyPath string) ([]byte, error) {
+		out, err := exec.Command(name, append(args, keyPath)...).Output()
+		if err != nil {
+			// the output is not part of the error, it may be the secret
+			return nil, fmt.Errorf("passphrase command %s failed: %w", name, err)
+		}
+		out = bytes.TrimSuffix(bytes.TrimSuffix(out, []byte("\n")), []byte("\r"))
+		if len(out) == 0 {
+			return nil, nil
+		}
+		return out, nil
+	})
+}
+
+// KeychainPassphrase returns a provider looking the passphrase of a key up
+// in the keychain of the user: the login keychain on macOS, as a generic
+// password of service with the key path as account, and elsewhere the
+// Secret Service (GNOME Keyring, KWallet) through secret-tool, with the
+// attributes service and key. A key without an entry has no passphrase.
+func KeychainPassphrase(service string) PassphraseProvider {
+	lookup := func(keyPath string) *exec.Cmd {
+		if runtime.GOOS == "darwin" {
+			return exec.Command("security", "find-generic-password", "-s", service, "-a", keyPath, "-w")
+		}
+		return exec.Command("secret-tool", "lookup", "service", service, "key", keyPath)
+	}
+	return PassphraseProviderFunc(func(keyPath string) ([]byte, error) {
+		cmd := lookup(keyPath)
+		out, err := cmd.Output()
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			// no entry for the key
+			return nil, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to query the keychain with %s: %w", cmd.Path, err)
+		}
+		out = bytes.TrimSuffix(out, []byte("\n"))
+		if len(out) == 0 {
+			return nil, nil
+		}
+		return out, nil
+	})
+}
+
+// passphraseProvider returns the PassphraseProvider of the connection, or
+// else the default one: the keyfile_passphrase parameter, then the
+// LIBVIRT_SSH_KEY_PASSPHRASE environment variable.
+func (u *ConnectionURI) passphraseProvider() PassphraseProvider {
+	if u.PassphraseProvider != nil {
+		return u.PassphraseProvider
+	}
+	return FirstPassphrase(
+		PassphraseProviderFunc(func(string) ([]byte, error) {
+			if passphrase := u.Query().Get("keyfile_passphrase"); passphrase != "" {
+				return []byte(passphrase), nil
+			}
+			return nil, nil
+		}),
+		EnvPassphrase("LIBVIRT_SSH_KEY_PASSPHRASE"),
+	)
+}
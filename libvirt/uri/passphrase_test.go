@@ -0,0 +1,94 @@
+package uri
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+// writeEncryptedTestKey writes an ed25519 key encrypted with passphrase to
+// path and returns its signer.
+func writeEncryptedTestKey(t *testing.T, path, passphrase string) ssh.Signer {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	block, err := ssh.MarshalPrivateKeyWithPassphrase(priv, "", []byte(passphrase))
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(block), 0600))
+	signer, err := ssh.NewSignerFromKey(priv)
+	require.NoError(t, err)
+	return signer
+}
+
+func TestDialSSHPassphraseProvider(t *testing.T) {
+	s := newTestSSHServer(t)
+	t.Setenv("HTTP_PROXY", "")
+	t.Setenv("ALL_PROXY", "")
+	dir := t.TempDir()
+	keyPath := filepath.Join(dir, "id_ed25519")
+	s.Authorize(writeEncryptedTestKey(t, keyPath, "s3cret").PublicKey())
+	plainPath := filepath.Join(dir, "id_plain")
+	writeTestKey(t, plainPath)
+
+	// the provider is only asked for the encrypted key, and wins over
+	// keyfile_passphrase
+	var asked []string
+	u := testSSHURI(t, s, "sshauth=privkey&keyfile="+plainPath+","+keyPath+"&keyfile_passphrase=wrong")
+	u.PassphraseProvider = PassphraseProviderFunc(func(path string) ([]byte, error) {
+		asked = append(asked, path)
+		return []byte("s3cret"), nil
+	})
+	conn, err := u.Dial()
+	require.NoError(t, err)
+	conn.Close()
+	assert.Equal(t, []string{keyPath}, asked)
+
+	// a failing provider leaves the key out
+	u = testSSHURI(t, s, "sshauth=privkey&keyfile="+keyPath)
+	u.PassphraseProvider = PassphraseProviderFunc(func(string) ([]byte, error) {
+		return nil, errors.New("vault is sealed")
+	})
+	assert.Empty(t, u.parseAuthMethods(nil).names)
+}
+
+func TestPassphraseProviders(t *testing.T) {
+	t.Setenv("TEST_KEY_PASSPHRASE", "from-env")
+	none := PassphraseProviderFunc(func(string) ([]byte, error) { return nil, nil })
+
+	passphrase, err := FirstPassphrase(none, EnvPassphrase("TEST_KEY_PASSPHRASE")).Passphrase("id_ed25519")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("from-env"), passphrase)
+
+	passphrase, err = FirstPassphrase(none, EnvPassphrase("TEST_UNSET_PASSPHRASE")).Passphrase("id_ed25519")
+	require.NoError(t, err)
+	assert.Nil(t, passphrase)
+
+	// the key path is passed as last argument, $0 of sh -c
+	passphrase, err = CommandPassphrase("sh", "-c", `echo "pass for $0"`).Passphrase("/keys/id_ed25519")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("pass for /keys/id_ed25519"), passphrase)
+
+	_, err = CommandPassphrase("sh", "-c", "echo s3cret; exit 1").Passphrase("id_ed25519")
+	require.Error(t, err)
+	assert.NotContains(t, err.Error(), "s3cret")
+
+	// the default provider reads keyfile_passphrase, then the environment
+	u, err := Parse("qemu+ssh://hv1/system?keyfile_passphrase=from-uri")
+	require.NoError(t, err)
+	passphrase, err = u.passphraseProvider().Passphrase("id_ed25519")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("from-uri"), passphrase)
+	t.Setenv("LIBVIRT_SSH_KEY_PASSPHRASE", "from-env")
+	u, err = Parse("qemu+ssh://hv1/system")
+	require.NoError(t, err)
+	passphrase, err = u.passphraseProvider().Passphrase("id_ed25519")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("from-env"), passphrase)
+}
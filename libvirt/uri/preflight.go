@@ -0,0 +1,44 @@
+package uri
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+	"time"
+)
+
+const (
+	preflightTimeout = time.Second
+)
+
+// preflightDial opens the connection used to probe the remote port.
+var preflightDial = (&net.Dialer{}).DialContext
+
+// preflight checks that the remote port accepts TCP connections before
+// the real connection is attempted, so that a closed or filtered port is
+// reported as such instead of as a generic handshake failure.
+func (u *ConnectionURI) preflight(ctx context.Context, host, port string) error {
+	address := net.JoinHostPort(host, port)
+	ctx, cancel := context.WithTimeout(ctx, preflightTimeout)
+	defer cancel()
+
+	conn, err := preflightDial(ctx, "tcp", address)
+	if err == nil {
+		conn.Close()
+		u.logf("[DEBUG] preflight check of %s succeeded", address)
+		return nil
+	}
+
+	var netErr net.Error
+	switch {
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return fmt.Errorf("port %s on %s is closed, the service is not running: %w", port, host, err)
+	case errors.Is(err, syscall.EHOSTUNREACH), errors.Is(err, syscall.ENETUNREACH):
+		return fmt.Errorf("%s is unreachable: %w", host, err)
+	case errors.As(err, &netErr) && netErr.Timeout(), errors.Is(err, context.DeadlineExceeded):
+		return fmt.Errorf("port %s on %s did not answer within %s, it is filtered or the host is unreachable: %w", port, host, preflightTimeout, err)
+	}
+	return fmt.Errorf("preflight check of %s failed: %w", address, err)
+}
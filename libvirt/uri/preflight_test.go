@@ -0,0 +1,56 @@
+package uri
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestPreflightRefused(t *testing.T) {
+	port := closedPort(t)
+
+	u, err := Parse("qemu+ssh://127.0.0.1:" + port + "/system?preflight=1")
+	require.NoError(t, err)
+
+	err = u.preflight(context.Background(), "127.0.0.1", port)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "port "+port+" on 127.0.0.1 is closed")
+
+	_, _, err = u.sshClient(context.Background(), nil, ssh.ClientConfig{
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "port "+port+" on 127.0.0.1 is closed")
+}
+
+func TestPreflightTimeout(t *testing.T) {
+	oldPreflightDial := preflightDial
+	preflightDial = func(ctx context.Context, network, address string) (net.Conn, error) {
+		// a filtered port drops the SYN and never answers
+		<-ctx.Done()
+		return nil, &net.OpError{Op: "dial", Net: network, Err: ctx.Err()}
+	}
+	defer func() { preflightDial = oldPreflightDial }()
+
+	u, err := Parse("qemu+ssh://libvirt.example.com/system?preflight=1")
+	require.NoError(t, err)
+	err = u.preflight(context.Background(), "libvirt.example.com", "22")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "port 22 on libvirt.example.com did not answer within 1s, it is filtered or the host is unreachable")
+}
+
+func TestPreflightOpen(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	host, port, err := net.SplitHostPort(l.Addr().String())
+	require.NoError(t, err)
+
+	u, err := Parse("qemu+ssh://" + l.Addr().String() + "/system?preflight=1")
+	require.NoError(t, err)
+	assert.NoError(t, u.preflight(context.Background(), host, port))
+}
@@ -0,0 +1,278 @@
+package uri
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Steps of a connection recorded in its profile.
+const (
+	profileDNS    = "dns"
+	profileTCP    = "tcp"
+	profileBanner = "banner"
+	profileKex    = "kex"
+	profileVerify = "verify"
+	profileAuth   = "auth"
+	profileSocket = "socket"
+)
+
+// connectionProfile records where the time establishing a connection went,
+// for the profile parameter. Durations are in nanoseconds.
+type connectionProfile struct {
+	Time      time.Time     `json:"time"`
+	Duration  time.Duration `json:"duration_ns"`
+	Transport string        `json:"transport"`
+	Host      string        `json:"host"`
+	Tag       string        `json:"tag,omitempty"`
+	// Attempts is the number of attempts made, Attempts-1 of them retries
+	Attempts int `json:"attempts"`
+	// ProxyHops counts what the connection went through on its way to the
+	// host: SOCKS proxies, proxy commands and jump hosts
+	ProxyHops int            `json:"proxy_hops"`
+	Steps     []profileEntry `json:"steps"`
+	Success   bool           `json:"success"`
+	Error     string         `json:"error,omitempty"`
+
+	mu sync.Mutex
+}
+
+// profileEntry is one step of a connection attempt. Start is relative to
+// the start of the connection.
+type profileEntry struct {
+	Step     string        `json:"step"`
+	Attempt  int           `json:"attempt"`
+	Start    time.Duration `json:"start_ns"`
+	Duration time.Duration `json:"duration_ns"`
+	Error    string        `json:"error,omitempty"`
+}
+
+// profileKey is the context key holding the profile of the connection.
+type profileKey struct{}
+
+func connProfile(ctx context.Context) *connectionProfile {
+	p, _ := ctx.Value(profileKey{}).(*connectionProfile)
+	return p
+}
+
+// startProfile returns ctx holding a new profile, when the profile
+// parameter is set.
+func (u *ConnectionURI) startProfile(ctx context.Context) context.Context {
+	if u.Query().Get("profile") == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, profileKey{}, &connectionProfile{
+		Time:      time.Now(),
+		Transport: u.transport(),
+		Host:      u.Host,
+		Tag:       u.tag(),
+	})
+}
+
+// profileStep records that step of the attempt running in ctx ran from
+// start to end.
+func profileStep(ctx context.Context, step string, start, end time.Time, err error) {
+	p := connProfile(ctx)
+	if p == nil {
+		return
+	}
+	attempt, _ := ctx.Value(attemptKey{}).(int)
+	e := profileEntry{Step: step, Attempt: attempt, Start: start.Sub(p.Time), Duration: end.Sub(start)}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	p.mu.Lock()
+	p.Steps = append(p.Steps, e)
+	p.mu.Unlock()
+}
+
+// handshakeTimings splits the SSH handshake into the steps of the profile:
+// the server banner, the key exchange up to the host key verification, the
+// verification itself and the authentication after it.
+type handshakeTimings struct {
+	start       time.Time
+	verifyStart time.Time
+	verifyEnd   time.Time
+	verifyErr   error
+}
+
+// wrap returns cb timing the host key verification.
+func (t *handshakeTimings) wrap(cb ssh.HostKeyCallback) ssh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		t.verifyStart = time.Now()
+		err := cb(hostname, remote, key)
+		t.verifyEnd, t.verifyErr = time.Now(), err
+		return err
+	}
+}
+
+// record adds the steps of the handshake that ended with err, as far as it
+// got, to the profile of ctx.
+func (t *handshakeTimings) record(ctx context.Context, bannerAt time.Time, err error) {
+	if connProfile(ctx) == nil {
+		return
+	}
+	end := time.Now()
+	if bannerAt.IsZero() {
+		profileStep(ctx, profileBanner, t.start, end, err)
+		return
+	}
+	profileStep(ctx, profileBanner, t.start, bannerAt, nil)
+	if t.verifyStart.IsZero() {
+		profileStep(ctx, profileKex, bannerAt, end, err)
+		return
+	}
+	profileStep(ctx, profileKex, bannerAt, t.verifyStart, nil)
+	profileStep(ctx, profileVerify, t.verifyStart, t.verifyEnd, t.verifyErr)
+	if t.verifyErr == nil {
+		profileStep(ctx, profileAuth, t.verifyEnd, end, err)
+	}
+}
+
+// profileProxyHop counts a proxy hop of the connection profiled in ctx.
+func profileProxyHop(ctx context.Context) {
+	if p := connProfile(ctx); p != nil {
+		p.mu.Lock()
+		p.ProxyHops++
+		p.mu.Unlock()
+	}
+}
+
+// finishProfile completes the profile of ctx with the outcome of the
+// connection and queues it to be written to the profile file or directory.
+func (u *ConnectionURI) finishProfile(ctx context.Context, attempts int, err error) {
+	p := connProfile(ctx)
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	p.Duration = time.Since(p.Time)
+	p.Attempts = attempts
+	p.Success = err == nil
+	if err != nil {
+		p.Error = err.Error()
+	}
+	data, jsonErr := json.Marshal(p)
+	p.mu.Unlock()
+	if jsonErr != nil {
+		u.logf("[WARN] failed to encode connection profile: %v", jsonErr)
+		return
+	}
+	queueProfile(profileWrite{
+		path: os.ExpandEnv(strings.Replace(u.Query().Get("profile"), "~", "$HOME", 1)),
+		key:  u.profileName(p.Time),
+		data: data,
+		logf: u.logf,
+	})
+}
+
+var (
+	profileNameChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+	// profileSequence tells apart the profiles of connections started at
+	// the same time
+	profileSequence uint64
+)
+
+// profileName is the file name of the profile of the connection started at
+// start in a profile directory: the conn_tag, or the host when there is
+// none, followed by the start time and a sequence number, so that every
+// connection gets its own file.
+func (u *ConnectionURI) profileName(start time.Time) string {
+	name := u.tag()
+	if name == "" {
+		name = u.Host
+	}
+	if name == "" {
+		name = "localhost"
+	}
+	return fmt.Sprintf("%s-%s-%d.json", profileNameChars.ReplaceAllString(name, "_"),
+		start.UTC().Format("20060102T150405.000000000"), atomic.AddUint64(&profileSequence, 1))
+}
+
+type profileWrite struct {
+	path string
+	key  string
+	data []byte
+	logf func(format string, v ...interface{})
+}
+
+// Profiles are written by a single goroutine, so that writing them never
+// holds up a connection. When it falls behind, profiles are dropped.
+var (
+	profileOnce    sync.Once
+	profileQueue   chan profileWrite
+	profilePending sync.WaitGroup
+)
+
+const profileQueueSize = 256
+
+func queueProfile(w profileWrite) {
+	profileOnce.Do(func() {
+		profileQueue = make(chan profileWrite, profileQueueSize)
+		go func() {
+			for w := range profileQueue {
+				if err := w.write(); err != nil {
+					w.logf("[WARN] failed to write connection profile: %v", err)
+				}
+				profilePending.Done()
+			}
+		}()
+	})
+	profilePending.Add(1)
+	select {
+	case profileQueue <- w:
+	default:
+		profilePending.Done()
+		w.logf("[WARN] dropping connection profile, %d are waiting to be written", profileQueueSize)
+	}
+}
+
+// FlushProfiles waits for the queued connection profiles to be written. It
+// is meant to be called before exiting, so that the profiles of the last
+// connections are not lost.
+func FlushProfiles() {
+	profilePending.Wait()
+}
+
+// write stores the profile as its own file when path is a directory, and
+// appends it as a line to path otherwise.
+func (w profileWrite) write() error {
+	if info, err := os.Stat(w.path); (err == nil && info.IsDir()) || strings.HasSuffix(w.path, string(os.PathSeparator)) {
+		if err := os.MkdirAll(w.path, 0700); err != nil {
+			return err
+		}
+		tmp, err := os.CreateTemp(w.path, ".profile-*")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(tmp.Name())
+		if _, err := tmp.Write(append(w.data, '\n')); err != nil {
+			tmp.Close()
+			return err
+		}
+		if err := tmp.Close(); err != nil {
+			return err
+		}
+		return os.Rename(tmp.Name(), filepath.Join(w.path, w.key))
+	}
+
+	f, err := os.OpenFile(w.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(w.data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
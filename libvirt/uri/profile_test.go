@@ -0,0 +1,102 @@
+package uri
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDialProfile(t *testing.T) {
+	s := newTestSSHServer(t)
+	jump := newTestSSHServer(t)
+	t.Setenv("HTTP_PROXY", "")
+	t.Setenv("ALL_PROXY", "")
+	dir := t.TempDir()
+
+	u := testSSHURI(t, s, "profile="+dir+"&conn_tag=vm/1&proxyjump="+jump.Addr())
+	conn, err := u.Dial()
+	require.NoError(t, err)
+	conn.Close()
+	FlushProfiles()
+
+	names, err := filepath.Glob(filepath.Join(dir, "vm_1-*.json"))
+	require.NoError(t, err)
+	require.Len(t, names, 1)
+	data, err := os.ReadFile(names[0])
+	require.NoError(t, err)
+	var profile struct {
+		Duration  int64  `json:"duration_ns"`
+		Transport string `json:"transport"`
+		Host      string `json:"host"`
+		Tag       string `json:"tag"`
+		Attempts  int    `json:"attempts"`
+		ProxyHops int    `json:"proxy_hops"`
+		Success   bool   `json:"success"`
+		Steps     []struct {
+			Step     string `json:"step"`
+			Attempt  int    `json:"attempt"`
+			Start    int64  `json:"start_ns"`
+			Duration int64  `json:"duration_ns"`
+		} `json:"steps"`
+	}
+	require.NoError(t, json.Unmarshal(data, &profile))
+	assert.Equal(t, "ssh", profile.Transport)
+	assert.Equal(t, s.Addr(), profile.Host)
+	assert.Equal(t, "vm/1", profile.Tag)
+	assert.Equal(t, 1, profile.Attempts)
+	assert.Equal(t, 1, profile.ProxyHops)
+	assert.True(t, profile.Success)
+	assert.Positive(t, profile.Duration)
+
+	var steps []string
+	for _, step := range profile.Steps {
+		steps = append(steps, step.Step)
+		assert.Equal(t, 1, step.Attempt)
+		assert.GreaterOrEqual(t, step.Duration, int64(0))
+		assert.LessOrEqual(t, step.Start+step.Duration, profile.Duration)
+	}
+	assert.Equal(t, []string{"dns", "tcp", "banner", "kex", "verify", "auth", "socket"}, steps)
+
+	// every connection with the same tag gets its own file
+	conn, err = u.Dial()
+	require.NoError(t, err)
+	conn.Close()
+	FlushProfiles()
+	names, err = filepath.Glob(filepath.Join(dir, "vm_1-*.json"))
+	require.NoError(t, err)
+	assert.Len(t, names, 2)
+
+	// a file collects the profiles of all connections, one per line,
+	// failed ones included
+	file := filepath.Join(t.TempDir(), "profiles.jsonl")
+	u = testSSHURI(t, s, "profile="+file)
+	conn, err = u.Dial()
+	require.NoError(t, err)
+	conn.Close()
+	u, err = Parse("qemu+tcp://127.0.0.1:" + closedPort(t) + "/system?connect_retries=1&connect_retry_delay=1ms&profile=" + file)
+	require.NoError(t, err)
+	_, err = u.Dial()
+	require.Error(t, err)
+	FlushProfiles()
+
+	f, err := os.Open(file)
+	require.NoError(t, err)
+	defer f.Close()
+	var lines []map[string]interface{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var line map[string]interface{}
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &line))
+		lines = append(lines, line)
+	}
+	require.Len(t, lines, 2)
+	assert.Equal(t, true, lines[0]["success"])
+	assert.Equal(t, false, lines[1]["success"])
+	assert.Equal(t, float64(2), lines[1]["attempts"])
+	assert.Contains(t, lines[1]["error"], "connection refused")
+}
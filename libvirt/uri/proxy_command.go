@@ -0,0 +1,184 @@
+package uri
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kevinburke/ssh_config"
+)
+
+// sshProxyCommand returns the ProxyCommand of the host in the ssh config, or
+// "" when there is none. An explicit proxy parameter in the URI takes
+// precedence, as it does over the proxy environment variables.
+func (u *ConnectionURI) sshProxyCommand(sshcfg *ssh_config.Config) string {
+	if sshcfg == nil || u.Query().Get("proxy") != "" {
+		return ""
+	}
+	cmd, err := sshcfg.Get(u.Hostname(), "ProxyCommand")
+	if err != nil || cmd == "none" {
+		return ""
+	}
+	return strings.TrimSpace(cmd)
+}
+
+// proxyCommandUnsafe are the characters the values substituted into a
+// ProxyCommand must not contain, as the shell would interpret them. Like
+// OpenSSH since 9.6, such hosts and users are rejected rather than quoted.
+const proxyCommandUnsafe = "'`\"$\\;&<>|(){}[]*?!~# \t"
+
+// checkProxyCommandValue returns an error when value, the what of the
+// connection, cannot be safely substituted into a ProxyCommand.
+func checkProxyCommandValue(what, value string) error {
+	if strings.HasPrefix(value, "-") {
+		return fmt.Errorf("%s '%s' starts with '-', refusing to pass it to the proxy command", what, value)
+	}
+	for _, r := range value {
+		if r < 0x20 || r == 0x7f || strings.ContainsRune(proxyCommandUnsafe, r) {
+			return fmt.Errorf("%s %q contains characters the shell interprets, refusing to pass it to the proxy command", what, value)
+		}
+	}
+	return nil
+}
+
+// expandProxyCommand replaces the %h, %p, %r, %n and %% tokens of a
+// ProxyCommand like OpenSSH does: %h is the host connected to, after
+// HostName, and %n the host as given in the URI. The values come from the
+// URI, so the ones the shell would interpret are an error.
+func expandProxyCommand(command, alias, host, port, user string) (string, error) {
+	for _, value := range []struct{ what, value string }{
+		{"host", host}, {"host alias", alias}, {"port", port}, {"user", user},
+	} {
+		if err := checkProxyCommandValue(value.what, value.value); err != nil {
+			return "", err
+		}
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(command); i++ {
+		if command[i] != '%' || i+1 == len(command) {
+			b.WriteByte(command[i])
+			continue
+		}
+		i++
+		switch command[i] {
+		case 'h':
+			b.WriteString(host)
+		case 'n':
+			b.WriteString(alias)
+		case 'p':
+			b.WriteString(port)
+		case 'r':
+			b.WriteString(user)
+		case '%':
+			b.WriteByte('%')
+		default:
+			b.WriteByte('%')
+			b.WriteByte(command[i])
+		}
+	}
+	return b.String(), nil
+}
+
+// dialProxyCommand runs command through the shell and returns a connection
+// speaking over its standard input and output. The host is never resolved
+// or dialed directly, so it can be a name only the command knows about.
+func (u *ConnectionURI) dialProxyCommand(ctx context.Context, command string) (net.Conn, error) {
+	stdinR, stdinW, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	stdoutR, stdoutW, err := os.Pipe()
+	if err != nil {
+		stdinR.Close()
+		stdinW.Close()
+		return nil, err
+	}
+
+	// exec, like OpenSSH, so that killing the shell stops the command
+	cmd := exec.Command("/bin/sh", "-c", "exec "+command)
+	cmd.Stdin = stdinR
+	cmd.Stdout = stdoutW
+	cmd.Stderr = &logWriter{logf: u.logf, prefix: "[DEBUG] proxy command: "}
+
+	u.logf("[DEBUG] connecting to %s through proxy command '%s'", u.Hostname(), command)
+	err = cmd.Start()
+	stdinR.Close()
+	stdoutW.Close()
+	if err != nil {
+		stdinW.Close()
+		stdoutR.Close()
+		return nil, fmt.Errorf("failed to run proxy command '%s': %w", command, err)
+	}
+
+	conn := &proxyCommandConn{cmd: cmd, r: stdoutR, w: stdinW, host: u.Hostname()}
+	if ctx.Err() != nil {
+		conn.Close()
+		return nil, ctx.Err()
+	}
+	return conn, nil
+}
+
+// proxyCommandConn is a net.Conn over the standard input and output of a
+// ProxyCommand process.
+type proxyCommandConn struct {
+	cmd  *exec.Cmd
+	r    *os.File
+	w    *os.File
+	host string
+
+	closeOnce sync.Once
+}
+
+func (c *proxyCommandConn) Read(b []byte) (int, error)  { return c.r.Read(b) }
+func (c *proxyCommandConn) Write(b []byte) (int, error) { return c.w.Write(b) }
+
+// Close closes the pipes and stops the command. It may be called more than
+// once, as the SSH client closes its transport from several goroutines.
+func (c *proxyCommandConn) Close() error {
+	c.closeOnce.Do(func() {
+		c.w.Close()
+		c.r.Close()
+		c.cmd.Process.Kill()
+		c.cmd.Wait()
+	})
+	return nil
+}
+
+func (c *proxyCommandConn) LocalAddr() net.Addr  { return proxyCommandAddr("proxy command") }
+func (c *proxyCommandConn) RemoteAddr() net.Addr { return proxyCommandAddr(c.host) }
+
+func (c *proxyCommandConn) SetDeadline(t time.Time) error {
+	if err := c.r.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.w.SetWriteDeadline(t)
+}
+
+func (c *proxyCommandConn) SetReadDeadline(t time.Time) error  { return c.r.SetReadDeadline(t) }
+func (c *proxyCommandConn) SetWriteDeadline(t time.Time) error { return c.w.SetWriteDeadline(t) }
+
+type proxyCommandAddr string
+
+func (a proxyCommandAddr) Network() string { return "proxycommand" }
+func (a proxyCommandAddr) String() string  { return string(a) }
+
+// logWriter logs every line written to it.
+type logWriter struct {
+	logf   func(format string, v ...interface{})
+	prefix string
+}
+
+func (w *logWriter) Write(p []byte) (int, error) {
+	for _, line := range strings.Split(string(p), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			w.logf("%s%s", w.prefix, line)
+		}
+	}
+	return len(p), nil
+}
@@ -0,0 +1,109 @@
+package uri
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestHelperProxyCommand is not a real test: run as a ProxyCommand, it
+// forwards its standard input and output to the port given after "--" on
+// the local host, when it is called for the host prod-hv.
+func TestHelperProxyCommand(t *testing.T) {
+	if os.Getenv("GO_WANT_PROXY_COMMAND") == "" {
+		return
+	}
+	args := os.Args
+	for len(args) > 0 && args[0] != "--" {
+		args = args[1:]
+	}
+	if len(args) != 3 || args[1] != "prod-hv" {
+		fmt.Fprintf(os.Stderr, "unexpected arguments %v\n", args)
+		os.Exit(1)
+	}
+	conn, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", args[2]))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	go io.Copy(conn, os.Stdin)
+	io.Copy(os.Stdout, conn)
+	os.Exit(0)
+}
+
+func TestExpandProxyCommand(t *testing.T) {
+	command, err := expandProxyCommand("cloudflared access ssh --hostname %h --port %p --user %r --alias %n 100%% %x", "prod-hv", "prod-hv.example.com", "22", "root")
+	require.NoError(t, err)
+	assert.Equal(t, "cloudflared access ssh --hostname prod-hv.example.com --port 22 --user root --alias prod-hv 100% %x", command)
+
+	command, err = expandProxyCommand("nc %h %p", "fd00::10", "fd00::10", "22", "")
+	require.NoError(t, err)
+	assert.Equal(t, "nc fd00::10 22", command)
+
+	for _, values := range [][4]string{
+		{"a$(id)b", "a$(id)b", "22", "root"},
+		{"hv", "hv`id`", "22", "root"},
+		{"hv", "hv", "22", "u;id"},
+		{"hv", "hv", "22", "u id"},
+		{"hv", "hv", "22", "u\nid"},
+		{"hv", "-oProxyCommand=id", "22", "root"},
+	} {
+		_, err := expandProxyCommand("nc %h %p %r %n", values[0], values[1], values[2], values[3])
+		assert.Error(t, err, "values %q", values)
+	}
+}
+
+func TestDialSSHProxyCommandInjection(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("SSH_AUTH_SOCK", "")
+	dir := t.TempDir()
+
+	for _, uri := range []string{
+		"qemu+ssh://u;touch%20pwned:secret@hv/system",
+		"qemu+ssh://root:secret@hv$(>pwned)/system",
+	} {
+		u, err := Parse(uri)
+		require.NoError(t, err)
+		u.SSHConfig = fmt.Sprintf("Host *\n  ProxyCommand cd %s && true %%r %%h\n", dir)
+		q := u.Query()
+		q.Set("sshauth", "ssh-password")
+		q.Set("no_verify", "1")
+		u.RawQuery = q.Encode()
+
+		_, err = u.Dial()
+		require.Error(t, err, uri)
+		assert.Contains(t, err.Error(), "refusing to pass it to the proxy command")
+		assert.NoFileExists(t, filepath.Join(dir, "pwned"))
+	}
+}
+
+func TestDialSSHProxyCommand(t *testing.T) {
+	s := newTestSSHServer(t)
+	_, port, err := net.SplitHostPort(s.Addr())
+	require.NoError(t, err)
+	t.Setenv("HTTP_PROXY", "")
+	t.Setenv("ALL_PROXY", "")
+
+	oldLookupHost := lookupHost
+	lookupHost = func(ctx context.Context, host string) ([]string, error) {
+		t.Errorf("unexpected lookup of %s", host)
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	defer func() { lookupHost = oldLookupHost }()
+
+	u := testSSHURI(t, s, "")
+	u.Host = "prod-hv:" + port
+	u.SSHConfig = fmt.Sprintf("Host prod-hv\n  ProxyCommand env GO_WANT_PROXY_COMMAND=1 %s -test.run=TestHelperProxyCommand -- %%h %%p\n", os.Args[0])
+
+	conn, err := u.Dial()
+	require.NoError(t, err)
+	conn.Close()
+	assert.Equal(t, []string{defaultUnixSock}, s.DialedSockets())
+}
@@ -0,0 +1,220 @@
+package uri
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kevinburke/ssh_config"
+	"golang.org/x/crypto/ssh"
+)
+
+// jumpHop is one of the jump hosts the SSH connection goes through.
+type jumpHop struct {
+	user string
+	host string
+	port string
+}
+
+func (h jumpHop) address() string {
+	return net.JoinHostPort(h.host, h.port)
+}
+
+// sshProxyJump returns the jump hosts given by the proxyjump parameter, a
+// proxy parameter of ssh:// URLs, or else the ProxyJump of the host in the
+// ssh config, or "" when there are none. A ProxyJump of none disables it, as
+// with OpenSSH.
+func (u *ConnectionURI) sshProxyJump(sshcfg *ssh_config.Config) string {
+	q := u.Query()
+	if jump := q.Get("proxyjump"); jump != "" {
+		if jump == "none" {
+			return ""
+		}
+		return jump
+	}
+	if p := q.Get("proxy"); isSSHProxy(p) {
+		return p
+	}
+	if sshcfg == nil || q.Get("proxy") != "" {
+		return ""
+	}
+	jump, err := sshcfg.Get(u.Hostname(), "ProxyJump")
+	if err != nil {
+		u.logf("[WARN] Failed to read ProxyJump from ssh config: %v", err)
+		return ""
+	}
+	if jump == "none" {
+		return ""
+	}
+	return strings.TrimSpace(jump)
+}
+
+// isSSHProxy reports whether the proxy parameter names jump hosts rather
+// than a SOCKS or HTTP proxy, as ssh://[user@]host[:port] URLs.
+func isSSHProxy(p string) bool {
+	return strings.HasPrefix(p, "ssh://")
+}
+
+// parseJumpHops parses comma separated [user@]host[:port] jump hosts. IPv6
+// addresses are given in brackets, with an optional zone, e.g.
+// [2001:db8::1]:2222 or [fe80::1%eth0]. The HostName, Port and User of a hop
+// in sshcfg apply to it like they do to the target host.
+func parseJumpHops(jump string, sshcfg *ssh_config.Config) ([]jumpHop, error) {
+	var hops []jumpHop
+	for _, s := range strings.Split(jump, ",") {
+		s = strings.TrimSpace(s)
+		hop, err := parseJumpHop(strings.TrimPrefix(s, "ssh://"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid jump host '%s' in proxyjump: %w", s, err)
+		}
+		if sshcfg != nil {
+			alias := hop.host
+			if hostName, err := sshcfg.Get(alias, "HostName"); err == nil && hostName != "" {
+				hop.host = strings.ReplaceAll(hostName, "%h", alias)
+			}
+			if hop.port == "" {
+				hop.port, _ = sshcfg.Get(alias, "Port")
+			}
+			if hop.user == "" {
+				hop.user, _ = sshcfg.Get(alias, "User")
+			}
+		}
+		if hop.port == "" {
+			hop.port = defaultSSHPort
+		}
+		hops = append(hops, hop)
+	}
+	return hops, nil
+}
+
+// parseJumpHop parses a single [user@]host[:port] jump host.
+func parseJumpHop(s string) (jumpHop, error) {
+	var hop jumpHop
+	if i := strings.LastIndex(s, "@"); i >= 0 {
+		hop.user, s = s[:i], s[i+1:]
+	}
+	switch {
+	case strings.HasPrefix(s, "["):
+		end := strings.Index(s, "]")
+		if end < 0 {
+			return hop, fmt.Errorf("missing ']' after IPv6 address")
+		}
+		hop.host = s[1:end]
+		if rest := s[end+1:]; rest != "" {
+			if !strings.HasPrefix(rest, ":") {
+				return hop, fmt.Errorf("unexpected '%s' after IPv6 address", rest)
+			}
+			hop.port = rest[1:]
+		}
+		addr := hop.host
+		if i := strings.Index(addr, "%"); i >= 0 {
+			if i == len(addr)-1 {
+				return hop, fmt.Errorf("empty zone in IPv6 address")
+			}
+			addr = addr[:i]
+		}
+		if net.ParseIP(addr) == nil || !strings.Contains(addr, ":") {
+			return hop, fmt.Errorf("'%s' is not an IPv6 address", hop.host)
+		}
+	case strings.Count(s, ":") == 1:
+		hop.host, hop.port, _ = net.SplitHostPort(s)
+	default:
+		// a bare IPv6 address cannot have a port
+		hop.host = s
+	}
+	if hop.host == "" {
+		return hop, fmt.Errorf("missing host")
+	}
+	if hop.port != "" {
+		if port, err := strconv.Atoi(hop.port); err != nil || port < 1 || port > 65535 {
+			return hop, fmt.Errorf("invalid port '%s'", hop.port)
+		}
+	}
+	return hop, nil
+}
+
+// dialProxyJump reaches host:port through the chain of jump hosts, like
+// OpenSSH's ProxyJump: the first hop is dialed as the target would be, and
+// every following hop, and finally the target, through a direct-tcpip
+// channel of the previous one. Every hop is authenticated with the auth
+// methods of cfg and has its host key verified by cfg's callback, logging in
+// as the user of the hop or else the user of cfg.
+func (u *ConnectionURI) dialProxyJump(ctx context.Context, jump string, sshcfg *ssh_config.Config, cfg ssh.ClientConfig, proxyURI, host, port string) (net.Conn, error) {
+	hops, err := parseJumpHops(jump, sshcfg)
+	if err != nil {
+		return nil, err
+	}
+
+	conn := &jumpConn{}
+	for i, hop := range hops {
+		var hopConn net.Conn
+		if i == 0 {
+			hopConn, err = u.dialSSHHost(ctx, proxyURI, hop.host, hop.port)
+			if err == nil {
+				if deadline, ok := ctx.Deadline(); ok {
+					hopConn.SetDeadline(deadline)
+				}
+				conn.first = hopConn
+			}
+		} else {
+			hopConn, err = conn.clients[i-1].DialContext(ctx, "tcp", hop.address())
+		}
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed to connect to jump host %s: %w", hop.address(), err)
+		}
+
+		hopCfg := cfg
+		if hop.user != "" {
+			hopCfg.User = hop.user
+		}
+		u.logf("[DEBUG] connecting to jump host %s@%s", hopCfg.User, hop.address())
+		ncc, chans, reqs, err := ssh.NewClientConn(hopConn, hop.address(), &hopCfg)
+		if err != nil {
+			hopConn.Close()
+			conn.Close()
+			return nil, fmt.Errorf("failed to connect to jump host %s: %w", hop.address(), err)
+		}
+		conn.clients = append(conn.clients, ssh.NewClient(ncc, chans, reqs))
+		profileProxyHop(ctx)
+	}
+	if conn.first != nil {
+		conn.first.SetDeadline(time.Time{})
+	}
+
+	address := net.JoinHostPort(host, port)
+	target, err := conn.clients[len(conn.clients)-1].DialContext(ctx, "tcp", address)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to connect to %s through jump host %s: %w", address, hops[len(hops)-1].address(), err)
+	}
+	conn.Conn = target
+	return conn, nil
+}
+
+// jumpConn is the connection to the target through jump hosts. Closing it
+// also closes the connections to the jump hosts.
+type jumpConn struct {
+	net.Conn
+	first   net.Conn
+	clients []*ssh.Client
+
+	closeOnce sync.Once
+	closeErr  error
+}
+
+func (c *jumpConn) Close() error {
+	c.closeOnce.Do(func() {
+		if c.Conn != nil {
+			c.closeErr = c.Conn.Close()
+		}
+		for i := len(c.clients) - 1; i >= 0; i-- {
+			c.clients[i].Close()
+		}
+	})
+	return c.closeErr
+}
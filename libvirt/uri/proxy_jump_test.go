@@ -0,0 +1,180 @@
+package uri
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestDialSSHProxyJump(t *testing.T) {
+	s := newTestSSHServer(t)
+	first := newTestSSHServer(t)
+	second := newTestSSHServer(t)
+	t.Setenv("HTTP_PROXY", "")
+	t.Setenv("ALL_PROXY", "")
+
+	u := testSSHURI(t, s, "proxyjump="+first.Addr()+","+second.Addr()+"&socket=/run/libvirt/libvirt-sock")
+	conn, err := u.Dial()
+	require.NoError(t, err)
+	conn.Close()
+	assert.Equal(t, []string{second.Addr()}, first.DialedAddrs())
+	assert.Equal(t, []string{s.Addr()}, second.DialedAddrs())
+	assert.Equal(t, []string{"/run/libvirt/libvirt-sock"}, s.DialedSockets())
+
+	// a jump host that cannot be reached fails the dial
+	u = testSSHURI(t, s, "proxyjump=127.0.0.1:"+closedPort(t))
+	_, err = u.Dial()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to connect to jump host 127.0.0.1:")
+
+	u = testSSHURI(t, s, "proxyjump=@")
+	_, err = u.Dial()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid jump host '@' in proxyjump")
+}
+
+func TestDialSSHProxyJumpUnixSocket(t *testing.T) {
+	libvirtHost := newTestSSHServer(t)
+	bastion := newTestSSHServer(t)
+	t.Setenv("HTTP_PROXY", "")
+	t.Setenv("ALL_PROXY", "")
+
+	// the socket is opened by the libvirt host, the bastion only forwards
+	// the SSH connection to it
+	for _, params := range []string{"", "&daemon=modular", "&socket=@libvirt-sock"} {
+		u := testSSHURI(t, libvirtHost, "proxyjump="+bastion.Addr()+params)
+		conn, err := u.Dial()
+		require.NoError(t, err, params)
+		conn.Close()
+	}
+	assert.Equal(t, []string{
+		"/var/run/libvirt/libvirt-sock",
+		"/var/run/libvirt/virtqemud-sock",
+		"\x00libvirt-sock",
+	}, libvirtHost.DialedSockets())
+	assert.Empty(t, bastion.DialedSockets())
+	assert.Equal(t, []string{libvirtHost.Addr(), libvirtHost.Addr(), libvirtHost.Addr()}, bastion.DialedAddrs())
+}
+
+func TestDialSSHProxySSHURL(t *testing.T) {
+	s := newTestSSHServer(t)
+	first := newTestSSHServer(t)
+	second := newTestSSHServer(t)
+	// the jump hosts are not reached through the proxy of the environment
+	t.Setenv("HTTP_PROXY", "socks5://127.0.0.1:"+closedPort(t))
+	t.Setenv("ALL_PROXY", "")
+
+	u := testSSHURI(t, s, "proxy=ssh://"+testSSHUser+"@"+first.Addr()+",ssh://"+second.Addr())
+	conn, err := u.Dial()
+	require.NoError(t, err)
+	conn.Close()
+	assert.Equal(t, []string{second.Addr()}, first.DialedAddrs())
+	assert.Equal(t, []string{s.Addr()}, second.DialedAddrs())
+	assert.Equal(t, []string{"/var/run/libvirt/libvirt-sock"}, s.DialedSockets())
+
+	// proxyjump wins
+	u = testSSHURI(t, s, "proxy=ssh://"+first.Addr()+"&proxyjump="+second.Addr())
+	conn, err = u.Dial()
+	require.NoError(t, err)
+	conn.Close()
+	assert.Equal(t, []string{s.Addr(), s.Addr()}, second.DialedAddrs())
+	assert.Len(t, first.DialedAddrs(), 1)
+}
+
+func TestDialSSHProxyJumpUser(t *testing.T) {
+	s := newTestSSHServer(t)
+	jump := newTestSSHServer(t)
+	t.Setenv("HTTP_PROXY", "")
+	t.Setenv("ALL_PROXY", "")
+	users := make(chan string, 1)
+	jump.Configure(func(config *ssh.ServerConfig) {
+		config.PasswordCallback = func(c ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+			users <- c.User()
+			if c.User() == "bastion" && string(pass) == testSSHPassword {
+				return nil, nil
+			}
+			return nil, ssh.ErrNoAuth
+		}
+	})
+
+	u := testSSHURI(t, s, "proxyjump=bastion@"+jump.Addr())
+	conn, err := u.Dial()
+	require.NoError(t, err)
+	conn.Close()
+	assert.Equal(t, "bastion", <-users)
+	assert.Equal(t, []string{s.Addr()}, jump.DialedAddrs())
+}
+
+func TestDialSSHConfigProxyJump(t *testing.T) {
+	s := newTestSSHServer(t)
+	jump := newTestSSHServer(t)
+	t.Setenv("HTTP_PROXY", "")
+	t.Setenv("ALL_PROXY", "")
+	jumpHost, jumpPort, err := net.SplitHostPort(jump.Addr())
+	require.NoError(t, err)
+
+	// the jump host is an alias of the ssh config itself
+	u := testSSHURI(t, s, "")
+	u.SSHConfig = "Host 127.0.0.1\n  ProxyJump bastion\n\nHost bastion\n  HostName " + jumpHost + "\n  Port " + jumpPort + "\n"
+	conn, err := u.Dial()
+	require.NoError(t, err)
+	conn.Close()
+	assert.Equal(t, []string{s.Addr()}, jump.DialedAddrs())
+
+	// proxyjump=none turns it off
+	u = testSSHURI(t, s, "proxyjump=none")
+	u.SSHConfig = "Host 127.0.0.1\n  ProxyJump 127.0.0.1:" + closedPort(t) + "\n"
+	conn, err = u.Dial()
+	require.NoError(t, err)
+	conn.Close()
+}
+
+func TestParseJumpHopsIPv6(t *testing.T) {
+	hops, err := parseJumpHops("admin@[2001:db8::1]:2222,[fe80::1%eth0],bastion.example.com:2200,2001:db8::2", nil)
+	require.NoError(t, err)
+	assert.Equal(t, []jumpHop{
+		{user: "admin", host: "2001:db8::1", port: "2222"},
+		{host: "fe80::1%eth0", port: "22"},
+		{host: "bastion.example.com", port: "2200"},
+		{host: "2001:db8::2", port: "22"},
+	}, hops)
+
+	// every hop is dialed with its address bracketed
+	var addrs []string
+	for _, hop := range hops {
+		addrs = append(addrs, hop.address())
+	}
+	assert.Equal(t, []string{"[2001:db8::1]:2222", "[fe80::1%eth0]:22", "bastion.example.com:2200", "[2001:db8::2]:22"}, addrs)
+
+	for spec, msg := range map[string]string{
+		"[2001:db8::1":        "missing ']' after IPv6 address",
+		"[2001:db8::1]2222":   "unexpected '2222' after IPv6 address",
+		"[fe80::1%]":          "empty zone in IPv6 address",
+		"[192.0.2.1]:22":      "'192.0.2.1' is not an IPv6 address",
+		"[2001:db8::1]:65536": "invalid port '65536'",
+		"admin@":              "missing host",
+	} {
+		_, err := parseJumpHops(spec, nil)
+		assert.EqualError(t, err, "invalid jump host '"+spec+"' in proxyjump: "+msg, spec)
+	}
+}
+
+func TestDialSSHProxyJumpIPv6(t *testing.T) {
+	s := newTestSSHServer(t)
+	jump := newTestSSHServer(t)
+	t.Setenv("HTTP_PROXY", "")
+	t.Setenv("ALL_PROXY", "")
+	_, jumpPort, err := net.SplitHostPort(jump.Addr())
+	require.NoError(t, err)
+
+	// an IPv4-mapped IPv6 address reaches the IPv4 loopback jump host,
+	// sandboxes often lack an IPv6 loopback
+	u := testSSHURI(t, s, "proxyjump="+testSSHUser+"@[::ffff:127.0.0.1]:"+jumpPort)
+	conn, err := u.Dial()
+	require.NoError(t, err)
+	conn.Close()
+	assert.Equal(t, []string{s.Addr()}, jump.DialedAddrs())
+}
@@ -0,0 +1,70 @@
+package uri
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+)
+
+// proxyProtocolV2Signature starts every PROXY protocol v2 header.
+var proxyProtocolV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// proxyProtocolHeader returns the PROXY protocol header announcing the
+// addresses of conn, for load balancers in front of the SSH server that
+// require it (https://www.haproxy.org/download/2.9/doc/proxy-protocol.txt).
+// Connections that are not TCP are announced as unknown.
+func proxyProtocolHeader(version string, conn net.Conn) ([]byte, error) {
+	src, srcOK := conn.LocalAddr().(*net.TCPAddr)
+	dst, dstOK := conn.RemoteAddr().(*net.TCPAddr)
+	ipv4 := srcOK && dstOK && src.IP.To4() != nil && dst.IP.To4() != nil
+	known := srcOK && dstOK && (ipv4 || (src.IP.To4() == nil && dst.IP.To4() == nil))
+
+	switch version {
+	case "v1":
+		if !known {
+			return []byte("PROXY UNKNOWN\r\n"), nil
+		}
+		family := "TCP6"
+		if ipv4 {
+			family = "TCP4"
+		}
+		return []byte(fmt.Sprintf("PROXY %s %s %s %d %d\r\n", family, src.IP, dst.IP, src.Port, dst.Port)), nil
+	case "v2":
+		var b bytes.Buffer
+		b.Write(proxyProtocolV2Signature)
+		if !known {
+			// LOCAL command, the receiver uses the real connection addresses
+			b.Write([]byte{0x20, 0x00, 0x00, 0x00})
+			return b.Bytes(), nil
+		}
+		srcIP, dstIP, family := src.IP.To16(), dst.IP.To16(), byte(0x21)
+		if ipv4 {
+			srcIP, dstIP, family = src.IP.To4(), dst.IP.To4(), 0x11
+		}
+		b.Write([]byte{0x21, family})
+		binary.Write(&b, binary.BigEndian, uint16(2*len(srcIP)+4))
+		b.Write(srcIP)
+		b.Write(dstIP)
+		binary.Write(&b, binary.BigEndian, uint16(src.Port))
+		binary.Write(&b, binary.BigEndian, uint16(dst.Port))
+		return b.Bytes(), nil
+	}
+	return nil, fmt.Errorf("invalid value '%s' for proxy_protocol, expected v1 or v2", version)
+}
+
+// sendProxyProtocolHeader writes the header asked for by proxy_protocol to
+// conn, before anything else goes over it.
+func (u *ConnectionURI) sendProxyProtocolHeader(conn net.Conn) error {
+	version := u.Query().Get("proxy_protocol")
+	if version == "" {
+		return nil
+	}
+	header, err := proxyProtocolHeader(version, conn)
+	if err != nil {
+		return err
+	}
+	u.logf("[DEBUG] sending PROXY protocol %s header to %s", version, conn.RemoteAddr())
+	_, err = conn.Write(header)
+	return err
+}
@@ -0,0 +1,106 @@
+package uri
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// readProxyProtocolHeader reads a PROXY protocol v1 or v2 header from r and
+// returns it in the v1 text form, without consuming anything after it.
+func readProxyProtocolHeader(r io.Reader) (string, error) {
+	start := make([]byte, len(proxyProtocolV2Signature))
+	if _, err := io.ReadFull(r, start[:6]); err != nil {
+		return "", err
+	}
+	if string(start[:6]) == "PROXY " {
+		line := []byte("PROXY ")
+		b := make([]byte, 1)
+		for !bytes.HasSuffix(line, []byte("\r\n")) {
+			if _, err := io.ReadFull(r, b); err != nil {
+				return "", err
+			}
+			line = append(line, b[0])
+		}
+		return strings.TrimSuffix(string(line), "\r\n"), nil
+	}
+
+	if _, err := io.ReadFull(r, start[6:]); err != nil {
+		return "", err
+	}
+	if !bytes.Equal(start, proxyProtocolV2Signature) {
+		return "", fmt.Errorf("no PROXY protocol header")
+	}
+	head := make([]byte, 4)
+	if _, err := io.ReadFull(r, head); err != nil {
+		return "", err
+	}
+	addrs := make([]byte, binary.BigEndian.Uint16(head[2:]))
+	if _, err := io.ReadFull(r, addrs); err != nil {
+		return "", err
+	}
+	if head[0] != 0x21 || head[1] != 0x11 || len(addrs) != 12 {
+		return "PROXY UNKNOWN", nil
+	}
+	return fmt.Sprintf("PROXY TCP4 %s %s %d %d", net.IP(addrs[0:4]), net.IP(addrs[4:8]),
+		binary.BigEndian.Uint16(addrs[8:]), binary.BigEndian.Uint16(addrs[10:])), nil
+}
+
+func TestDialSSHProxyProtocol(t *testing.T) {
+	for _, version := range []string{"v1", "v2"} {
+		t.Run(version, func(t *testing.T) {
+			s := newTestSSHServer(t)
+
+			// a load balancer that passes the connection on after the header
+			lb, err := net.Listen("tcp", "127.0.0.1:0")
+			require.NoError(t, err)
+			defer lb.Close()
+			headers := make(chan string, 1)
+			go func() {
+				conn, err := lb.Accept()
+				if err != nil {
+					return
+				}
+				header, err := readProxyProtocolHeader(conn)
+				if err != nil {
+					headers <- err.Error()
+					conn.Close()
+					return
+				}
+				headers <- header
+				s.handleConn(conn)
+			}()
+
+			u := testSSHURI(t, s, "proxy_protocol="+version)
+			u.Host = lb.Addr().String()
+			conn, err := u.Dial()
+			require.NoError(t, err)
+			local := conn.(*Conn).transport.LocalAddr().(*net.TCPAddr)
+			conn.Close()
+
+			_, lbPort, _ := net.SplitHostPort(lb.Addr().String())
+			assert.Equal(t, fmt.Sprintf("PROXY TCP4 127.0.0.1 127.0.0.1 %d %s", local.Port, lbPort), <-headers)
+			assert.Equal(t, []string{defaultUnixSock}, s.DialedSockets())
+		})
+	}
+}
+
+func TestProxyProtocolHeaderInvalid(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	_, err := proxyProtocolHeader("v3", client)
+	assert.ErrorContains(t, err, "invalid value 'v3' for proxy_protocol")
+
+	header, err := proxyProtocolHeader("v1", client)
+	require.NoError(t, err)
+	assert.Equal(t, "PROXY UNKNOWN\r\n", string(header))
+}
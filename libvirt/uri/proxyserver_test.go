@@ -0,0 +1,186 @@
+package uri
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// testSOCKSProxy is a minimal SOCKS5 proxy without authentication that
+// records the addresses it was asked to connect to.
+type testSOCKSProxy struct {
+	listener net.Listener
+
+	mu       sync.Mutex
+	requests []string
+}
+
+func newTestSOCKSProxy(t *testing.T) *testSOCKSProxy {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	p := &testSOCKSProxy{listener: l}
+	t.Cleanup(func() { l.Close() })
+	go p.serve()
+	return p
+}
+
+func (p *testSOCKSProxy) Addr() string {
+	return p.listener.Addr().String()
+}
+
+// Requests returns the addresses the proxy connected to so far.
+func (p *testSOCKSProxy) Requests() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string(nil), p.requests...)
+}
+
+func (p *testSOCKSProxy) serve() {
+	for {
+		conn, err := p.listener.Accept()
+		if err != nil {
+			return
+		}
+		go p.handleConn(conn)
+	}
+}
+
+func (p *testSOCKSProxy) handleConn(conn net.Conn) {
+	defer conn.Close()
+
+	// greeting: version, number of methods, methods
+	hdr := make([]byte, 2)
+	if _, err := io.ReadFull(conn, hdr); err != nil {
+		return
+	}
+	if _, err := io.ReadFull(conn, make([]byte, hdr[1])); err != nil {
+		return
+	}
+	conn.Write([]byte{0x05, 0x00})
+
+	// request: version, command, reserved, address type, address, port
+	req := make([]byte, 4)
+	if _, err := io.ReadFull(conn, req); err != nil {
+		return
+	}
+	var host string
+	switch req[3] {
+	case 0x01:
+		ip := make([]byte, net.IPv4len)
+		if _, err := io.ReadFull(conn, ip); err != nil {
+			return
+		}
+		host = net.IP(ip).String()
+	case 0x03:
+		n := make([]byte, 1)
+		if _, err := io.ReadFull(conn, n); err != nil {
+			return
+		}
+		name := make([]byte, n[0])
+		if _, err := io.ReadFull(conn, name); err != nil {
+			return
+		}
+		host = string(name)
+	default:
+		return
+	}
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(conn, port); err != nil {
+		return
+	}
+	addr := net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port))))
+
+	p.mu.Lock()
+	p.requests = append(p.requests, addr)
+	p.mu.Unlock()
+
+	target, err := net.Dial("tcp", addr)
+	if err != nil {
+		conn.Write([]byte{0x05, 0x05, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	defer target.Close()
+	conn.Write([]byte{0x05, 0x00, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+
+	go io.Copy(target, conn)
+	io.Copy(conn, target)
+}
+
+// testHTTPProxy is a minimal HTTP proxy serving CONNECT requests that
+// records the addresses it was asked to connect to. With auth set, it
+// requires that Proxy-Authorization.
+type testHTTPProxy struct {
+	listener net.Listener
+	auth     string
+
+	mu       sync.Mutex
+	requests []string
+}
+
+func newTestHTTPProxy(t *testing.T, auth string) *testHTTPProxy {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	p := &testHTTPProxy{listener: l, auth: auth}
+	t.Cleanup(func() { l.Close() })
+	go p.serve()
+	return p
+}
+
+func (p *testHTTPProxy) Addr() string {
+	return p.listener.Addr().String()
+}
+
+// Requests returns the addresses the proxy connected to so far.
+func (p *testHTTPProxy) Requests() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string(nil), p.requests...)
+}
+
+func (p *testHTTPProxy) serve() {
+	for {
+		conn, err := p.listener.Accept()
+		if err != nil {
+			return
+		}
+		go p.handleConn(conn)
+	}
+}
+
+func (p *testHTTPProxy) handleConn(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	req, err := http.ReadRequest(r)
+	if err != nil {
+		return
+	}
+	if req.Method != http.MethodConnect {
+		io.WriteString(conn, "HTTP/1.1 405 Method Not Allowed\r\n\r\n")
+		return
+	}
+	if p.auth != "" && req.Header.Get("Proxy-Authorization") != p.auth {
+		io.WriteString(conn, "HTTP/1.1 407 Proxy Authentication Required\r\n\r\n")
+		return
+	}
+	p.mu.Lock()
+	p.requests = append(p.requests, req.Host)
+	p.mu.Unlock()
+
+	target, err := net.Dial("tcp", req.Host)
+	if err != nil {
+		io.WriteString(conn, "HTTP/1.1 502 Bad Gateway\r\n\r\n")
+		return
+	}
+	defer target.Close()
+	io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+
+	go io.Copy(target, r)
+	io.Copy(conn, target)
+}
@@ -0,0 +1,74 @@
+package uri
+
+import (
+	"crypto/rsa"
+	"fmt"
+	"net"
+	"strconv"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// minRSABits returns the minimum size of RSA keys given by min_rsa_bits, or
+// 0 when RSA keys of any size are accepted.
+func (u *ConnectionURI) minRSABits() (int, error) {
+	v := u.Query().Get("min_rsa_bits")
+	if v == "" {
+		return 0, nil
+	}
+	bits, err := strconv.Atoi(v)
+	if err != nil || bits < 0 {
+		return 0, fmt.Errorf("invalid value '%s' for min_rsa_bits", v)
+	}
+	return bits, nil
+}
+
+// rsaKeyBits returns the size of key, or of the key of a certificate, when
+// it is an RSA key, and 0 otherwise.
+func rsaKeyBits(key ssh.PublicKey) int {
+	if cert, ok := key.(*ssh.Certificate); ok {
+		key = cert.Key
+	}
+	cryptoKey, ok := key.(ssh.CryptoPublicKey)
+	if !ok {
+		return 0
+	}
+	rsaKey, ok := cryptoKey.CryptoPublicKey().(*rsa.PublicKey)
+	if !ok {
+		return 0
+	}
+	return rsaKey.N.BitLen()
+}
+
+// withMinRSABits wraps cb so that RSA host keys smaller than minBits are
+// rejected, whatever cb would decide about them.
+func withMinRSABits(cb ssh.HostKeyCallback, minBits int) ssh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		if bits := rsaKeyBits(key); bits > 0 && bits < minBits {
+			return fmt.Errorf("host key %s of %s is a %d-bit RSA key, smaller than min_rsa_bits=%d",
+				ssh.FingerprintSHA256(key), hostname, bits, minBits)
+		}
+		return cb(hostname, remote, key)
+	}
+}
+
+// withoutWeakRSAKeys wraps signers so that RSA keys smaller than minBits are
+// not offered to the server.
+func (a *sshAuth) withoutWeakRSAKeys(signers func() ([]ssh.Signer, error), minBits int) func() ([]ssh.Signer, error) {
+	return func() ([]ssh.Signer, error) {
+		result, err := signers()
+		if err != nil {
+			return nil, err
+		}
+		kept := result[:0]
+		for _, signer := range result {
+			if bits := rsaKeyBits(signer.PublicKey()); bits > 0 && bits < minBits {
+				a.logf("[WARN] not offering %d-bit RSA key %s, smaller than min_rsa_bits=%d",
+					bits, ssh.FingerprintSHA256(signer.PublicKey()), minBits)
+				continue
+			}
+			kept = append(kept, signer)
+		}
+		return kept, nil
+	}
+}
@@ -0,0 +1,85 @@
+package uri
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+func newTestRSASigner(t *testing.T, bits int) (*rsa.PrivateKey, ssh.Signer) {
+	priv, err := rsa.GenerateKey(rand.Reader, bits)
+	require.NoError(t, err)
+	signer, err := ssh.NewSignerFromKey(priv)
+	require.NoError(t, err)
+	return priv, signer
+}
+
+// useRSAHostKey makes s present only an RSA host key of the given size.
+func useRSAHostKey(t *testing.T, s *testSSHServer, bits int) {
+	_, hostKey := newTestRSASigner(t, bits)
+	s.Configure(func(config *ssh.ServerConfig) {
+		*config = ssh.ServerConfig{
+			PasswordCallback:  config.PasswordCallback,
+			PublicKeyCallback: config.PublicKeyCallback,
+		}
+		config.AddHostKey(hostKey)
+	})
+}
+
+func TestDialSSHMinRSABitsHostKey(t *testing.T) {
+	s := newTestSSHServer(t)
+	t.Setenv("HTTP_PROXY", "")
+	t.Setenv("ALL_PROXY", "")
+
+	useRSAHostKey(t, s, 2048)
+	u := testSSHURI(t, s, "min_rsa_bits=2048")
+	conn, err := u.Dial()
+	require.NoError(t, err)
+	conn.Close()
+
+	useRSAHostKey(t, s, 1024)
+	_, err = u.Dial()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "host key verification failed")
+	assert.Contains(t, err.Error(), "is a 1024-bit RSA key, smaller than min_rsa_bits=2048")
+
+	u = testSSHURI(t, s, "min_rsa_bits=many")
+	_, err = u.hostKeyCallback(context.Background(), nil)
+	assert.EqualError(t, err, "invalid value 'many' for min_rsa_bits")
+}
+
+func TestMinRSABitsUserKeys(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("SSH_AUTH_SOCK", "")
+	keyPath := filepath.Join(t.TempDir(), "id_rsa")
+	priv, weak := newTestRSASigner(t, 1024)
+	block, err := ssh.MarshalPrivateKey(priv, "")
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(keyPath, pem.EncodeToMemory(block), 0600))
+
+	u, err := Parse("qemu+ssh://hv1/system?sshauth=privkey&min_rsa_bits=2048&keyfile=" + keyPath)
+	require.NoError(t, err)
+	assert.Empty(t, u.parseAuthMethods(nil).names)
+
+	u, err = Parse("qemu+ssh://hv1/system?sshauth=privkey&min_rsa_bits=1024&keyfile=" + keyPath)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"privkey"}, u.parseAuthMethods(nil).names)
+
+	// agent keys below the minimum are left out
+	_, strong := newTestRSASigner(t, 2048)
+	ed := newTestSigner(t)
+	auth := &sshAuth{logf: u.logf}
+	signers, err := auth.withoutWeakRSAKeys(func() ([]ssh.Signer, error) {
+		return []ssh.Signer{weak, strong, ed}, nil
+	}, 2048)()
+	require.NoError(t, err)
+	assert.Equal(t, []ssh.Signer{strong, ed}, signers)
+}
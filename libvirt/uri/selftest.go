@@ -0,0 +1,181 @@
+package uri
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	libvirt "github.com/digitalocean/go-libvirt"
+	"golang.org/x/crypto/ssh"
+)
+
+// phaseLibvirt is the phase after the dial, when the self test opens the
+// libvirt connection and queries the version.
+const phaseLibvirt ConnectionPhase = "querying libvirt"
+
+// SelfTestReport summarizes a test connection made by SelfTest. It is meant
+// to be serialized to JSON, so that pipelines can assert on it.
+type SelfTestReport struct {
+	// OK is set when the connection was established and libvirt answered
+	OK bool `json:"ok"`
+	// URI is the connection URI, without password
+	URI        string            `json:"uri"`
+	Transport  string            `json:"transport"`
+	RemoteName string            `json:"remote_name"`
+	Params     map[string]string `json:"params,omitempty"`
+
+	Phases []SelfTestPhase `json:"phases"`
+	// FailedPhase is the phase the connection failed in
+	FailedPhase ConnectionPhase `json:"failed_phase,omitempty"`
+	Error       string          `json:"error,omitempty"`
+
+	// HostKey and AuthMethod are reported for the ssh transport
+	HostKey    *SelfTestHostKey `json:"host_key,omitempty"`
+	AuthMethod string           `json:"auth_method,omitempty"`
+
+	LibvirtVersion string `json:"libvirt_version,omitempty"`
+}
+
+// SelfTestPhase is the outcome of one phase of the connection.
+type SelfTestPhase struct {
+	Phase    ConnectionPhase `json:"phase"`
+	Attempt  int             `json:"attempt,omitempty"`
+	Duration time.Duration   `json:"duration"`
+	Error    string          `json:"error,omitempty"`
+}
+
+// SelfTestHostKey describes the host key presented by the SSH server.
+type SelfTestHostKey struct {
+	// Algorithm is the type of the host key
+	Algorithm   string `json:"algorithm"`
+	Fingerprint string `json:"fingerprint"`
+	Verified    bool   `json:"verified"`
+	Error       string `json:"error,omitempty"`
+}
+
+// selfTestKey is the context key holding the report of a running self test.
+type selfTestKey struct{}
+
+func selfTestReport(ctx context.Context) *SelfTestReport {
+	r, _ := ctx.Value(selfTestKey{}).(*SelfTestReport)
+	return r
+}
+
+// recordHostKey adds the outcome of a host key verification to the report
+// of the self test and to the audit record of the attempt running in ctx, if
+// any.
+func recordHostKey(ctx context.Context, key ssh.PublicKey, err error) {
+	if a := auditRecord(ctx); a != nil {
+		a.HostKey = &AuditHostKey{
+			Algorithm:   key.Type(),
+			Fingerprint: ssh.FingerprintSHA256(key),
+			Verified:    err == nil,
+		}
+		if err != nil {
+			a.HostKey.Error = err.Error()
+		}
+	}
+
+	r := selfTestReport(ctx)
+	if r == nil {
+		return
+	}
+	r.HostKey = &SelfTestHostKey{
+		Algorithm:   key.Type(),
+		Fingerprint: ssh.FingerprintSHA256(key),
+		Verified:    err == nil,
+	}
+	if err != nil {
+		r.HostKey.Error = err.Error()
+	}
+}
+
+// selfTestDialer hands the context of the self test to the dial.
+type selfTestDialer struct {
+	u   *ConnectionURI
+	ctx context.Context
+}
+
+func (d selfTestDialer) Dial() (net.Conn, error) {
+	return d.u.dialContext(d.ctx)
+}
+
+// SelfTest connects to libvirt, queries its version and disconnects again,
+// reporting the configuration used and how every phase went. Unlike Dial it
+// does not return an error: a failure is described in the report.
+func (u *ConnectionURI) SelfTest() *SelfTestReport {
+	r := &SelfTestReport{
+		URI:        u.URL.Redacted(),
+		Transport:  u.transport(),
+		RemoteName: u.RemoteName(),
+		Params:     make(map[string]string),
+	}
+	for k := range u.Query() {
+		v := u.Query().Get(k)
+		lower := strings.ToLower(k)
+		if strings.Contains(lower, "pass") || strings.Contains(lower, "secret") || strings.Contains(lower, "token") {
+			v = "xxxxx"
+		}
+		r.Params[k] = v
+	}
+
+	var started time.Time
+	begin := func(phase ConnectionPhase, attempt int, at time.Time) {
+		if n := len(r.Phases); n > 0 {
+			r.Phases[n-1].Duration = at.Sub(started)
+		}
+		started = at
+		r.Phases = append(r.Phases, SelfTestPhase{Phase: phase, Attempt: attempt})
+	}
+	fail := func(err error, at time.Time) {
+		if len(r.Phases) == 0 {
+			begin(PhaseConnecting, 0, at)
+		}
+		n := len(r.Phases)
+		r.Phases[n-1].Duration = at.Sub(started)
+		r.Phases[n-1].Error = err.Error()
+		r.FailedPhase = r.Phases[n-1].Phase
+		r.Error = err.Error()
+	}
+
+	tu := *u
+	tu.OnEvent = func(e ConnectionEvent) {
+		if e.Phase == PhaseFailed {
+			fail(e.Err, e.Time)
+		} else {
+			begin(e.Phase, e.Attempt, e.Time)
+		}
+		if u.OnEvent != nil {
+			u.OnEvent(e)
+		}
+	}
+
+	ctx := context.WithValue(context.Background(), selfTestKey{}, r)
+	l := libvirt.NewWithDialer(selfTestDialer{u: &tu, ctx: ctx})
+	if err := l.ConnectToURI(libvirt.ConnectURI(tu.RemoteName())); err != nil {
+		if r.FailedPhase == "" {
+			// the dial worked, libvirt refused the connection
+			begin(phaseLibvirt, 0, time.Now())
+			fail(u.ExplainOpenError(err), time.Now())
+		}
+		return r
+	}
+	defer func() {
+		if err := l.Disconnect(); err != nil {
+			u.logf("[WARN] cannot close libvirt connection: %v", err)
+		}
+	}()
+
+	begin(phaseLibvirt, 0, time.Now())
+	v, err := l.ConnectGetLibVersion()
+	if err != nil {
+		fail(fmt.Errorf("failed to retrieve libvirt version: %w", err), time.Now())
+		return r
+	}
+	r.Phases[len(r.Phases)-1].Duration = time.Since(started)
+	r.LibvirtVersion = fmt.Sprintf("%d.%d.%d", v/1000000, v/1000%1000, v%1000)
+	r.OK = true
+	return r
+}
@@ -0,0 +1,95 @@
+package uri
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+// forwardTo makes the SSH server forward remote socket dials to the libvirt
+// server.
+func forwardTo(s *testSSHServer, l *testLibvirtServer) {
+	s.streamlocal = func(_ string, ch ssh.Channel) {
+		defer ch.Close()
+		conn, err := net.Dial("unix", l.Socket)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		go io.Copy(conn, ch)
+		io.Copy(ch, conn)
+	}
+}
+
+func TestSelfTest(t *testing.T) {
+	l := newTestLibvirtServer(t)
+	l.Handle(testProcConnectGetLibVersion, func([]byte) ([]byte, error) {
+		version := make([]byte, 8)
+		binary.BigEndian.PutUint64(version, 9001002)
+		return version, nil
+	})
+	s := newTestSSHServer(t)
+	forwardTo(s, l)
+
+	u := testSSHURI(t, s, "conn_tag=ci")
+	r := u.SelfTest()
+
+	require.True(t, r.OK, r.Error)
+	assert.Empty(t, r.Error)
+	assert.Empty(t, r.FailedPhase)
+	assert.NotContains(t, r.URI, testSSHPassword)
+	assert.Equal(t, "ssh", r.Transport)
+	assert.Equal(t, "qemu:///system", r.RemoteName)
+	assert.Equal(t, "ci", r.Params["conn_tag"])
+	assert.Equal(t, "ssh-password", r.AuthMethod)
+	assert.Equal(t, "9.1.2", r.LibvirtVersion)
+
+	require.NotNil(t, r.HostKey)
+	assert.Equal(t, ssh.KeyAlgoED25519, r.HostKey.Algorithm)
+	assert.Equal(t, ssh.FingerprintSHA256(s.hostKey.PublicKey()), r.HostKey.Fingerprint)
+	assert.True(t, r.HostKey.Verified)
+
+	var phases []ConnectionPhase
+	for _, p := range r.Phases {
+		phases = append(phases, p.Phase)
+		assert.Empty(t, p.Error)
+	}
+	assert.Equal(t, []ConnectionPhase{
+		PhaseConnecting,
+		PhaseVerifyingHostKey,
+		PhaseAuthenticating,
+		PhaseOpeningSocket,
+		PhaseConnected,
+		phaseLibvirt,
+	}, phases)
+
+	out, err := json.Marshal(r)
+	require.NoError(t, err)
+	assert.Contains(t, string(out), `"libvirt_version":"9.1.2"`)
+}
+
+func TestSelfTestFailure(t *testing.T) {
+	s := newTestSSHServer(t)
+	s.exec["uname -m"] = "aarch64\n"
+
+	u := testSSHURI(t, s, "require_arch=x86_64")
+	r := u.SelfTest()
+
+	assert.False(t, r.OK)
+	assert.Equal(t, PhaseAuthenticating, r.FailedPhase)
+	assert.Contains(t, r.Error, "does not match required architecture")
+	assert.Equal(t, "ssh-password", r.AuthMethod)
+	require.NotNil(t, r.HostKey)
+	assert.True(t, r.HostKey.Verified)
+	assert.Empty(t, r.LibvirtVersion)
+
+	last := r.Phases[len(r.Phases)-1]
+	assert.Equal(t, PhaseAuthenticating, last.Phase)
+	assert.Equal(t, r.Error, last.Error)
+}
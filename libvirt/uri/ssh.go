@@ -1,31 +1,45 @@
 package uri
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"github.com/trzsz/trzsz-ssh/tssh"
 	"golang.org/x/net/proxy"
-	"log"
 	"net"
 	"net/url"
 	"os"
 	"os/user"
 	"strings"
+	"time"
 
 	"github.com/kevinburke/ssh_config"
 	"golang.org/x/crypto/ssh"
-	"golang.org/x/crypto/ssh/agent"
 	"golang.org/x/crypto/ssh/knownhosts"
 )
 
 const (
 	defaultSSHPort           = "22"
-	defaultSSHKeyPath        = "${HOME}/.ssh/id_rsa"
 	defaultSSHKnownHostsPath = "${HOME}/.ssh/known_hosts"
 	defaultSSHConfigFile     = "${HOME}/.ssh/config"
 	defaultSSHAuthMethods    = "agent,privkey"
 )
 
-func (u *ConnectionURI) parseAuthMethods() []ssh.AuthMethod {
+// defaultSSHKeyPaths are the keys tried by privkey when neither keyfile nor
+// the ssh config name one, in the order OpenSSH tries them.
+var defaultSSHKeyPaths = []string{
+	"${HOME}/.ssh/id_ed25519",
+	"${HOME}/.ssh/id_ecdsa",
+	"${HOME}/.ssh/id_rsa",
+	"${HOME}/.ssh/id_dsa",
+}
+
+// parseAuthMethods builds the SSH authentication methods requested by the
+// sshauth parameter. The privkey method reads the comma separated keys of
+// the keyfile parameter, or else the IdentityFile of the host in sshcfg, or
+// else every one of defaultSSHKeyPaths that exists.
+func (u *ConnectionURI) parseAuthMethods(sshcfg *ssh_config.Config) *sshAuth {
 	q := u.Query()
 
 	authMethods := q.Get("sshauth")
@@ -33,191 +47,791 @@ func (u *ConnectionURI) parseAuthMethods() []ssh.AuthMethod {
 		authMethods = defaultSSHAuthMethods
 	}
 
-	sshKeyPath := q.Get("keyfile")
-	if sshKeyPath == "" {
-		sshKeyPath = defaultSSHKeyPath
+	var sshKeyPaths []string
+	if keyfile := q.Get("keyfile"); keyfile != "" {
+		for _, path := range strings.Split(keyfile, ",") {
+			if path = strings.TrimSpace(path); path != "" {
+				sshKeyPaths = append(sshKeyPaths, strings.Replace(path, "~", "$HOME", 1))
+			}
+		}
+	} else if sshcfg != nil {
+		if identityFile, err := sshcfg.Get(u.Hostname(), "IdentityFile"); err != nil {
+			u.logf("[WARN] Failed to read IdentityFile from ssh config: %v", err)
+		} else if identityFile != "" {
+			u.logf("[DEBUG] IdentityFile for %s: %s", u.Hostname(), identityFile)
+			sshKeyPaths = []string{strings.Replace(identityFile, "~", "$HOME", 1)}
+		}
+	}
+	// the default keys are only tried, missing ones are no error
+	defaultKeys := len(sshKeyPaths) == 0
+	if defaultKeys {
+		sshKeyPaths = defaultSSHKeyPaths
 	}
 
+	// an invalid min_rsa_bits fails the dial in hostKeyCallback
+	minBits, _ := u.minRSABits()
+
 	auths := strings.Split(authMethods, ",")
+	auth := &sshAuth{logf: u.logf}
 	result := make([]ssh.AuthMethod, 0)
+	names := make([]string, 0)
 	for _, v := range auths {
 		switch v {
 		case "agent":
-			socket := os.Getenv("SSH_AUTH_SOCK")
-			if socket == "" {
-				continue
-			}
-			conn, err := net.Dial("unix", socket)
+			agentClient, err := auth.agent()
 			// Ignore error, we just fall back to another auth method
 			if err != nil {
-				log.Printf("[ERROR] Unable to connect to SSH agent: %v", err)
+				u.logf("[ERROR] Unable to connect to SSH agent: %v", err)
 				continue
 			}
-			agentClient := agent.NewClient(conn)
-			result = append(result, ssh.PublicKeysCallback(agentClient.Signers))
-		case "privkey":
-			sshKey, err := os.ReadFile(os.ExpandEnv(sshKeyPath))
-			if err != nil {
-				log.Printf("[ERROR] Failed to read ssh key: %v", err)
+			if agentClient == nil {
 				continue
 			}
-
-			signer, err := ssh.ParsePrivateKey(sshKey)
-			if err != nil {
-				log.Printf("[ERROR] Failed to parse ssh key: %v", err)
+			signers := agentClient.Signers
+			if nonZero(q.Get("no_shared_agent_state")) {
+				signers = auth.withoutSharedKeys(signers)
+			}
+			if minBits > 0 {
+				signers = auth.withoutWeakRSAKeys(signers, minBits)
 			}
-			result = append(result, ssh.PublicKeys(signer))
+			result = append(result, ssh.PublicKeysCallback(auth.recordSigners(signers, true)))
+		case "privkey":
+			var signers []ssh.Signer
+			if len(u.PrivateKey) > 0 {
+				if signer := u.privateKeySigner(auth, u.PrivateKey, "private_key", "", minBits); signer != nil {
+					signers = append(signers, signer)
+				}
+			} else {
+				for _, path := range sshKeyPaths {
+					keyName := os.ExpandEnv(path)
+					sshKey, err := os.ReadFile(keyName)
+					if err != nil {
+						if defaultKeys && os.IsNotExist(err) {
+							u.logf("[DEBUG] no ssh key at %s", keyName)
+						} else {
+							auth.skipKey("Failed to read ssh key", err)
+						}
+						continue
+					}
+					if signer := u.privateKeySigner(auth, sshKey, keyName, keyName, minBits); signer != nil {
+						signers = append(signers, signer)
+					}
+				}
+			}
+			if len(signers) == 0 {
+				continue
+			}
+			result = append(result, ssh.PublicKeysCallback(auth.recordSigners(func() ([]ssh.Signer, error) {
+				return signers, nil
+			}, false)))
 		case "ssh-password":
 			if sshPassword, ok := u.User.Password(); ok {
-				result = append(result, ssh.Password(sshPassword))
+				result = append(result, ssh.PasswordCallback(auth.recordPassword(sshPassword)))
 			} else {
-				log.Printf("[ERROR] Missing password in userinfo of URI authority section")
+				u.logf("[ERROR] Missing password in userinfo of URI authority section")
+				continue
+			}
+		case "keyboard-interactive":
+			answers := u.kbdAnswers()
+			var totpKey []byte
+			if secret := u.totpSecret(); secret != "" {
+				var err error
+				if totpKey, err = decodeTOTPSecret(secret); err != nil {
+					u.logf("[ERROR] %v", err)
+				}
+			}
+			askpass := u.kbdAskpass()
+			if len(answers) == 0 && totpKey == nil && askpass == "" {
+				u.logf("[ERROR] Missing sshauth_kbd_answers, totp_secret or sshauth_askpass for keyboard-interactive authentication")
+				continue
 			}
+			result = append(result, ssh.KeyboardInteractive(auth.answerChallenge(answers, totpKey, askpass)))
 		default:
 			// For future compatibility it's better to just warn and not error
-			log.Printf("[WARN] Unsupported auth method: %s", v)
+			u.logf("[WARN] Unsupported auth method: %s", v)
+			continue
 		}
+		names = append(names, v)
 	}
 
-	return result
+	// Servers with a low MaxAuthTries disconnect after the first rejected
+	// method, so only offer the one most likely to succeed.
+	if nonZero(q.Get("single_attempt")) && len(result) > 1 {
+		pick := 0
+		if want := q.Get("single_attempt_method"); want != "" {
+			pick = -1
+			for i, name := range names {
+				if name == want {
+					pick = i
+					break
+				}
+			}
+			if pick < 0 {
+				u.logf("[WARN] single_attempt_method '%s' has no credentials, offering '%s' instead", want, names[0])
+				pick = 0
+			}
+		}
+		u.logf("[DEBUG] single_attempt: only offering auth method '%s'", names[pick])
+		result = result[pick : pick+1]
+		names = names[pick : pick+1]
+	}
+
+	auth.methods = result
+	auth.names = names
+	return auth
 }
 
-func (u *ConnectionURI) dialSSH() (net.Conn, error) {
-	q := u.Query()
-	sshConfigFilePath := q.Get("ssh_config")
-	if sshConfigFilePath == "" {
-		sshConfigFilePath = defaultSSHConfigFile
+// privateKeySigner returns the signer for the PEM encoded key sshKey, named
+// keyName in messages, along with its certificate when there is one for the
+// key read from certPath ("" for a key not read from a file). It returns nil
+// when the key cannot be used.
+func (u *ConnectionURI) privateKeySigner(auth *sshAuth, sshKey []byte, keyName, certPath string, minBits int) ssh.Signer {
+	signer, err := parsePrivateKey(sshKey, keyName, nil)
+	var passphrase []byte
+	var missing *ssh.PassphraseMissingError
+	if errors.As(err, &missing) {
+		if passphrase, err = u.passphraseProvider().Passphrase(keyName); err != nil {
+			auth.skipKey("Failed to get the passphrase of ssh key "+keyName, err)
+			return nil
+		}
+		signer, err = parsePrivateKey(sshKey, keyName, passphrase)
 	}
-	sshConfigFile, err := os.Open(os.ExpandEnv(sshConfigFilePath))
 	if err != nil {
-		log.Printf("[WARN] Failed to open ssh config file: %v", err)
+		auth.skipKey("Failed to parse ssh key", err)
+		return nil
+	}
+	if bits := rsaKeyBits(signer.PublicKey()); bits > 0 && bits < minBits {
+		auth.skipKey("Unusable ssh key", fmt.Errorf("ssh key %s is a %d-bit RSA key, smaller than min_rsa_bits=%d", keyName, bits, minBits))
+		return nil
+	}
+	if cert, err := u.loadCertificate(certPath); err != nil {
+		u.logf("[ERROR] %v", err)
+	} else if cert != nil {
+		if !bytes.Equal(cert.Key.Marshal(), signer.PublicKey().Marshal()) && u.Query().Get("certfile") != "" {
+			// with several keys, certfile certifies one of them
+			u.logf("[DEBUG] certfile does not certify ssh key %s", keyName)
+		} else if certSigner, err := ssh.NewCertSigner(cert, signer); err != nil {
+			u.logf("[ERROR] Failed to use ssh certificate: %v", err)
+		} else {
+			signer = certSigner
+			// the connection is recycled before the first certificate
+			// to expire
+			if expiry := certExpiry(cert); !expiry.IsZero() && (auth.certExpiry.IsZero() || expiry.Before(auth.certExpiry)) {
+				auth.certExpiry = expiry
+			}
+		}
+	}
+	if nonZero(u.Query().Get("add_keys_to_agent")) {
+		if err := u.addKeyToAgent(auth, sshKey, keyName, passphrase); err != nil {
+			u.logf("[WARN] Failed to add ssh key to the agent: %v", err)
+		}
+	}
+	return signer
+}
+
+// currentUser returns the user running the provider.
+var currentUser = user.Current
+
+// sshUsername returns the user to log in as on the remote host. In order
+// of precedence it comes from the URI, the sshuser parameter, the User
+// directive in the ssh config, the USER or LOGNAME environment variables, and
+// finally the system user database. The environment is consulted before the
+// user database because in minimal containers the running UID often has no
+// passwd entry.
+func (u *ConnectionURI) sshUsername(sshcfg *ssh_config.Config) (string, error) {
+	if username := u.User.Username(); username != "" {
+		return username, nil
+	}
+	if username := u.Query().Get("sshuser"); username != "" {
+		return username, nil
+	}
+
+	if sshcfg != nil {
+		sshu, err := sshcfg.Get(u.Hostname(), "User")
+		if err != nil {
+			u.logf("[WARN] Failed to read User from ssh config: %v", err)
+		} else if sshu != "" {
+			u.logf("[DEBUG] SSH User: %v", sshu)
+			return sshu, nil
+		}
 	}
 
-	sshcfg, err := ssh_config.Decode(sshConfigFile)
+	for _, env := range []string{"USER", "LOGNAME"} {
+		if username := os.Getenv(env); username != "" {
+			u.logf("[DEBUG] ssh user: %s from %s", username, env)
+			return username, nil
+		}
+	}
+
+	u.logf("[DEBUG] ssh user: system username")
+	cu, err := currentUser()
 	if err != nil {
-		log.Printf("[WARN] Failed to parse ssh config file: %v", err)
+		return "", fmt.Errorf("unable to get username: %w", err)
 	}
+	return cu.Username, nil
+}
 
-	authMethods := u.parseAuthMethods()
-	if len(authMethods) < 1 {
-		return nil, fmt.Errorf("could not configure SSH authentication methods")
+// sshHostName returns the host to connect to: the HostName of the host in
+// the ssh config, where %h stands for the host of the URI, or else the host
+// of the URI itself. Everything else read from the ssh config is still
+// looked up by the host of the URI, like OpenSSH does with host aliases.
+func (u *ConnectionURI) sshHostName(sshcfg *ssh_config.Config) string {
+	if sshcfg != nil {
+		hostName, err := sshcfg.Get(u.Hostname(), "HostName")
+		if err != nil {
+			u.logf("[WARN] Failed to read HostName from ssh config: %v", err)
+		} else if hostName != "" {
+			hostName = strings.ReplaceAll(hostName, "%h", u.Hostname())
+			u.logf("[DEBUG] HostName for %s: %s", u.Hostname(), hostName)
+			return hostName
+		}
 	}
+	return u.Hostname()
+}
 
-	knownHostsPath := q.Get("knownhosts")
-	knownHostsVerify := q.Get("known_hosts_verify")
-	doVerify := q.Get("no_verify") == ""
+// sshPort returns the port to connect to: the port of the URI, or else the
+// Port of the host in the ssh config, or else the default SSH port.
+func (u *ConnectionURI) sshPort(sshcfg *ssh_config.Config) string {
+	if port := u.Port(); port != "" {
+		return port
+	}
+	if sshcfg != nil {
+		port, err := sshcfg.Get(u.Hostname(), "Port")
+		if err != nil {
+			u.logf("[WARN] Failed to read Port from ssh config: %v", err)
+		} else if port != "" {
+			return port
+		}
+	}
+	return defaultSSHPort
+}
+
+// hostKeyCallback returns the callback used to verify the host keys presented
+// during the connection, according to the knownhosts, known_hosts_verify and
+// no_verify parameters. The same callback verifies every host the connection
+// goes through, not only the final target.
+//
+// Without known_hosts_verify or no_verify, the StrictHostKeyChecking of the
+// host in the ssh config decides, so that hosts sharing one provider
+// configuration can each have their own policy.
+//
+// With accept-new, the keys of hosts missing from knownhosts are added to
+// it, while a key that does not match the known one is still rejected.
+// Host certificates are verified against the @cert-authority entries of
+// knownhosts and host_ca_file, or else by their plain key.
+//
+// known_host_line pins the host to the key of that line instead, taking
+// precedence over all of the above.
+//
+// With min_rsa_bits, RSA host keys smaller than that are always rejected.
+func (u *ConnectionURI) hostKeyCallback(ctx context.Context, sshcfg *ssh_config.Config) (ssh.HostKeyCallback, error) {
+	minBits, err := u.minRSABits()
+	if err != nil {
+		return nil, err
+	}
+	cb, err := u.knownHostKeyCallback(ctx, sshcfg)
+	if err != nil || minBits == 0 {
+		return cb, err
+	}
+	return withMinRSABits(cb, minBits), nil
+}
 
-	if knownHostsVerify == "ignore" {
-		doVerify = false
+// knownHostKeyCallback returns the callback checking host keys against the
+// known hosts, as described for hostKeyCallback.
+func (u *ConnectionURI) knownHostKeyCallback(ctx context.Context, sshcfg *ssh_config.Config) (ssh.HostKeyCallback, error) {
+	q := u.Query()
+	if line := q.Get("known_host_line"); line != "" {
+		return knownHostLineCallback(line)
 	}
 
+	knownHostsPath := q.Get("knownhosts")
 	if knownHostsPath == "" {
 		knownHostsPath = defaultSSHKnownHostsPath
 	}
 
-	hostKeyCallback := ssh.InsecureIgnoreHostKey()
-	if doVerify {
-		cb, err := knownhosts.New(os.ExpandEnv(knownHostsPath))
+	if !u.verifiesHostKey(sshcfg) {
+		u.warnInsecureHostKey()
+		return ssh.InsecureIgnoreHostKey(), nil
+	}
+	if u.HostKeyStore != nil {
+		return u.hostKeyStoreCallback(ctx)
+	}
+
+	knownHostsPath = os.ExpandEnv(knownHostsPath)
+	acceptNew := u.acceptsNewHostKeys(sshcfg)
+	if acceptNew {
+		if err := createKnownHosts(knownHostsPath); err != nil {
+			return nil, err
+		}
+	}
+	cb, err := knownhosts.New(knownHostsPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ssh known hosts: %w", err)
+	}
+	cb = u.withPlainHostKey(cb)
+	if acceptNew {
+		maxLines, err := u.knownHostsMaxLines()
 		if err != nil {
-			return nil, fmt.Errorf("failed to read ssh known hosts: %w", err)
+			return nil, err
 		}
-		hostKeyCallback = cb
+		cb = u.withAcceptNew(cb, knownHostsPath, maxLines, u.hashesKnownHosts(sshcfg))
 	}
-
-	username := u.User.Username()
-	if username == "" {
-		sshu, err := sshcfg.Get(u.Host, "User")
-		log.Printf("[DEBUG] SSH User: %v", sshu)
+	if hostCAFile := q.Get("host_ca_file"); hostCAFile != "" {
+		cas, err := readHostCAs(os.ExpandEnv(strings.Replace(hostCAFile, "~", "$HOME", 1)))
 		if err != nil {
-			log.Printf("[DEBUG] ssh user: system username")
-			u, err := user.Current()
-			if err != nil {
-				return nil, fmt.Errorf("unable to get username: %w", err)
+			return nil, err
+		}
+		cb = u.withHostCAs(cb, cas)
+	}
+	revoked, err := readRevokedKeys(knownHostsPath)
+	if err != nil {
+		return nil, err
+	}
+	return u.withRevokedKeys(cb, revoked, knownHostsPath), nil
+}
+
+// verifiesHostKey reports whether host keys are verified at all, or are
+// accepted blindly because of known_hosts_verify=ignore, no_verify or the
+// StrictHostKeyChecking of the host. A pinned known_host_line is always
+// verified.
+func (u *ConnectionURI) verifiesHostKey(sshcfg *ssh_config.Config) bool {
+	q := u.Query()
+	if q.Get("known_host_line") != "" {
+		return true
+	}
+	if q.Get("no_verify") != "" {
+		return false
+	}
+	switch q.Get("known_hosts_verify") {
+	case "ignore":
+		return false
+	case "":
+		if sshcfg == nil {
+			return true
+		}
+		if strict, err := sshcfg.Get(u.Hostname(), "StrictHostKeyChecking"); err == nil {
+			u.logf("[DEBUG] StrictHostKeyChecking for %s: %s", u.Hostname(), strict)
+			switch strings.ToLower(strict) {
+			case "no", "off":
+				return false
 			}
-			sshu = u.Username
 		}
-		username = sshu
+	}
+	return true
+}
+
+// acceptsNewHostKeys reports whether the keys of unknown hosts are added to
+// knownhosts, by known_hosts_verify=accept-new or the StrictHostKeyChecking
+// accept-new of the host in the ssh config.
+func (u *ConnectionURI) acceptsNewHostKeys(sshcfg *ssh_config.Config) bool {
+	switch u.Query().Get("known_hosts_verify") {
+	case "accept-new":
+		return true
+	case "":
+		if sshcfg == nil || u.Query().Get("no_verify") != "" {
+			return false
+		}
+		strict, err := sshcfg.Get(u.Hostname(), "StrictHostKeyChecking")
+		return err == nil && strings.EqualFold(strict, "accept-new")
+	}
+	return false
+}
+
+// sshConfig returns the ssh config that applies to the connection: the
+// SSHConfig content when set, or else the file given by ssh_config.
+func (u *ConnectionURI) sshConfig() *ssh_config.Config {
+	if u.SSHConfig == "" {
+		sshConfigFilePath := u.Query().Get("ssh_config")
+		if sshConfigFilePath == "" {
+			sshConfigFilePath = defaultSSHConfigFile
+		}
+		return u.sshConfigFile(os.ExpandEnv(sshConfigFilePath))
+	}
+
+	sshcfg, err := ssh_config.Decode(strings.NewReader(u.SSHConfig))
+	if err != nil {
+		u.logf("[WARN] Failed to parse ssh config file: %v", err)
+	}
+	u.warnUnknownDirectives(sshcfg)
+	return sshcfg
+}
+
+func (u *ConnectionURI) dialSSH(ctx context.Context) (net.Conn, error) {
+	q := u.Query()
+	sshcfg := u.sshConfig()
+
+	auth := u.parseAuthMethods(sshcfg)
+	if len(auth.methods) < 1 {
+		return nil, fmt.Errorf("could not configure SSH authentication methods%s", auth.skippedKeysNote())
+	}
+
+	hostKeyCallback, err := u.hostKeyCallback(ctx, sshcfg)
+	if err != nil {
+		return nil, err
+	}
+	verified := u.verifiesHostKey(sshcfg)
+	if !verified && nonZero(q.Get("require_verified")) {
+		return nil, fmt.Errorf("require_verified is set, but host key verification of %s is disabled by known_hosts_verify=ignore, no_verify or StrictHostKeyChecking in the ssh config", u.Hostname())
+	}
+
+	username, err := u.sshUsername(sshcfg)
+	if err != nil {
+		return nil, err
+	}
+	if r := auditRecord(ctx); r != nil {
+		r.User = username
+	}
+	keepaliveInterval, err := u.keepaliveInterval(sshcfg)
+	if err != nil {
+		return nil, err
+	}
+	address, err := u.libvirtSocket()
+	if err != nil {
+		return nil, err
 	}
 
 	cfg := ssh.ClientConfig{
-		User:            username,
-		HostKeyCallback: hostKeyCallback,
-		Auth:            authMethods,
-		Timeout:         dialTimeout,
+		User: username,
+		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			u.emit(ctx, PhaseVerifyingHostKey, nil)
+			err := hostKeyCallback(hostname, remote, key)
+			recordHostKey(ctx, key, err)
+			if err != nil {
+				return err
+			}
+			u.emit(ctx, PhaseAuthenticating, nil)
+			return nil
+		},
+		Auth:          auth.methods,
+		Timeout:       dialTimeout,
+		ClientVersion: u.sshClientVersion(),
 	}
 
-	sshClient, err := u.sshClient(cfg)
+	if nonZero(q.Get("probe_keys")) {
+		if err := u.probeKeys(ctx, auth, sshcfg, username, hostKeyCallback); err != nil {
+			return nil, err
+		}
+	}
+
+	release, err := u.acquireHandshake(ctx)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
+	}
+	sshClient, transport, err := u.sshClient(ctx, sshcfg, cfg)
+	release()
+	if r := auditRecord(ctx); r != nil {
+		r.AuthMethod = auth.lastMethod()
+	}
+	if err != nil {
+		return nil, u.sshConnectionError(auth.explainError(err, username, u.Hostname()))
+	}
+
+	if r := selfTestReport(ctx); r != nil {
+		r.AuthMethod = auth.lastMethod()
+	}
+	if keepaliveInterval > 0 {
+		u.keepAlive(sshClient, keepaliveInterval)
+	}
+	if nonZero(q.Get("agent_forwarding")) {
+		u.forwardAgent(auth, sshClient)
+	}
+
+	if arch := q.Get("require_arch"); arch != "" {
+		if err := u.checkRemoteArch(sshClient, arch); err != nil {
+			sshClient.Close()
+			return nil, err
+		}
 	}
 
-	address := q.Get("socket")
-	if address == "" {
-		address = defaultUnixSock
+	var expiry time.Time
+	if auth.lastMethod() == "privkey" {
+		expiry = auth.certExpiry
+	}
+
+	if subsystem := q.Get("subsystem"); subsystem != "" {
+		u.emit(ctx, PhaseOpeningSocket, nil)
+		start := time.Now()
+		c, err := dialSubsystem(sshClient, subsystem)
+		profileStep(ctx, profileSocket, start, time.Now(), err)
+		if err != nil {
+			sshClient.Close()
+			return nil, fmt.Errorf("failed to connect to libvirt on the remote host: %w", err)
+		}
+		return u.sshConn(sshClient, c, transport, verified, expiry)
 	}
 
+	// on the wire, abstract socket names start with a NUL byte
+	if name, ok := abstractSocketName(address); ok {
+		address = "\x00" + name
+	}
+
+	u.emit(ctx, PhaseOpeningSocket, nil)
+	start := time.Now()
 	c, err := sshClient.Dial("unix", address)
+	profileStep(ctx, profileSocket, start, time.Now(), err)
 	if err != nil {
+		sshClient.Close()
 		return nil, fmt.Errorf("failed to connect to libvirt on the remote host: %w", err)
 	}
 
-	return c, nil
+	return u.sshConn(sshClient, c, transport, verified, expiry)
 }
 
-func (u *ConnectionURI) sshClient(cfg ssh.ClientConfig) (*ssh.Client, error) {
-	q := u.Query()
-	sshControlPath := q.Get("SSHControlPath")
-	proxyURI := proxyByEnvVar()
-	port := u.Port()
-	if port == "" {
-		port = defaultSSHPort
+// sshConn wraps the channel c to libvirt of client, carried by the SSH
+// connection transport, into the Conn returned by Dial.
+func (u *ConnectionURI) sshConn(client *ssh.Client, c net.Conn, transport net.Conn, verified bool, expiry time.Time) (*Conn, error) {
+	conn := &Conn{Conn: c, transport: transport, hostKeyVerified: verified, expiry: expiry}
+	conn.watchTransport(client, u.Host)
+	if err := u.recycleBeforeExpiry(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// normalizeArch maps common aliases of an architecture name to the name
+// reported by uname -m.
+func normalizeArch(arch string) string {
+	switch arch = strings.ToLower(strings.TrimSpace(arch)); arch {
+	case "amd64", "x64":
+		return "x86_64"
+	case "arm64":
+		return "aarch64"
+	case "i386", "i486", "i586":
+		return "i686"
+	}
+	return arch
+}
+
+// checkRemoteArch runs uname -m on the remote host and fails if the reported
+// architecture does not match the required one.
+func (u *ConnectionURI) checkRemoteArch(client *ssh.Client, required string) error {
+	session, err := client.NewSession()
+	if err != nil {
+		return fmt.Errorf("failed to open session to check remote architecture: %w", err)
+	}
+	defer session.Close()
+
+	out, err := session.Output("uname -m")
+	if err != nil {
+		return fmt.Errorf("failed to check remote architecture: %w", err)
+	}
+
+	remote := strings.TrimSpace(string(out))
+	if normalizeArch(remote) != normalizeArch(required) {
+		return fmt.Errorf("remote host architecture '%s' does not match required architecture '%s'", remote, required)
 	}
-	if sshControlPath == "" && proxyURI == "" {
-		return ssh.Dial("tcp", fmt.Sprintf("%s:%s", u.Hostname(), port), &cfg)
+	u.logf("[DEBUG] remote host architecture: %s", remote)
+	return nil
+}
+
+// sshClient establishes the SSH connection to the remote host. Besides the
+// client it returns the connection SSH runs over, on which the deadlines of
+// the channels opened through the client are set.
+func (u *ConnectionURI) sshClient(ctx context.Context, sshcfg *ssh_config.Config, cfg ssh.ClientConfig) (*ssh.Client, net.Conn, error) {
+	q := u.Query()
+	expectBanner, err := u.expectedBanner()
+	if err != nil {
+		return nil, nil, err
 	}
+	sshControlPath := q.Get("SSHControlPath")
+	proxyURI := u.sshProxy(sshcfg)
+	host := u.sshHostName(sshcfg)
+	port := u.sshPort(sshcfg)
 	var proxyConn net.Conn
-	if sshControlPath != "" {
+	if rendezvous := q.Get("rendezvous"); rendezvous != "" {
+		conn, err := u.acceptRendezvous(ctx, rendezvous)
+		if err != nil {
+			return nil, nil, err
+		}
+		proxyConn = conn
+	} else if sshControlPath != "" {
 		sshControlPath = os.ExpandEnv(strings.Replace(sshControlPath, "~", "$HOME", 1))
-		_, err := os.Stat(sshControlPath)
-		if err != nil || os.IsNotExist(err) {
-			return nil, err
+		conn, err := u.dialControlMaster(ctx, sshControlPath, host, port)
+		if err != nil {
+			return nil, nil, err
 		}
-		controlSocketConn, err := net.Dial("unix", sshControlPath)
+		u.saveControlPath(sshControlPath)
+		proxyConn = conn
+	} else if conn := u.dialSavedControlMaster(ctx, host, port); conn != nil {
+		proxyConn = conn
+	} else if jump := u.sshProxyJump(sshcfg); jump != "" {
+		conn, err := u.dialProxyJump(ctx, jump, sshcfg, cfg, proxyURI, host, port)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
-		controlConn, chans, reqs, err := tssh.NewControlClientConn(controlSocketConn)
+		proxyConn = conn
+	} else if command := u.sshProxyCommand(sshcfg); command != "" {
+		command, err := expandProxyCommand(command, u.Hostname(), host, port, cfg.User)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
-		sshControlClient := ssh.NewClient(controlConn, chans, reqs)
-		sshControlClientConn, err := sshControlClient.Dial("tcp", fmt.Sprintf("%s:%s", u.Hostname(), port))
+		conn, err := u.dialProxyCommand(ctx, command)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
-		proxyConn = sshControlClientConn
+		profileProxyHop(ctx)
+		proxyConn = conn
 	} else {
-		parsedProxyURI, err := url.Parse(proxyURI)
-		if err != nil || os.IsNotExist(err) {
-			return nil, err
-		}
-		dialer, err := proxy.SOCKS5(parsedProxyURI.Scheme, parsedProxyURI.Host, nil, proxy.Direct)
+		conn, err := u.dialSSHHost(ctx, proxyURI, host, port)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
-		socketConn, err := dialer.Dial("tcp", u.Host)
-		if err != nil {
-			return nil, err
+		proxyConn = conn
+	}
+
+	// bound the handshake by the overall connection deadline, if any
+	if deadline, ok := ctx.Deadline(); ok {
+		proxyConn.SetDeadline(deadline)
+	}
+	handshake := newHandshakeConn(proxyConn)
+	if expectBanner != nil {
+		cfg.HostKeyCallback = u.withExpectedBanner(cfg.HostKeyCallback, expectBanner, handshake)
+	}
+	timings := handshakeTimings{start: time.Now()}
+	if connProfile(ctx) != nil {
+		cfg.HostKeyCallback = timings.wrap(cfg.HostKeyCallback)
+	}
+	cfg.HostKeyCallback = withHostKeyError(cfg.HostKeyCallback)
+	ncc, chans, reqs, err := ssh.NewClientConn(handshake, net.JoinHostPort(host, port), &cfg)
+	timings.record(ctx, handshake.bannerTime(), err)
+	if err != nil {
+		ctxErr := ctx.Err()
+		if ctxErr == nil && isTimeout(err) {
+			// the connection deadline may fire just before the context
+			if deadline, ok := ctx.Deadline(); ok && !time.Now().Before(deadline) {
+				ctxErr = context.DeadlineExceeded
+			}
+		}
+		if ctxErr != nil || isTimeout(err) {
+			if hint := handshake.stallHint(); hint != "" {
+				err = fmt.Errorf("%w (%s)", err, hint)
+			}
+		}
+		if ctxErr != nil {
+			return nil, nil, fmt.Errorf("%w: %v", ctxErr, err)
+		}
+		return nil, nil, err
+	}
+	handshake.done()
+	proxyConn.SetDeadline(time.Time{})
+	cli := ssh.NewClient(ncc, u.routeChannels(chans), reqs)
+	return cli, proxyConn, nil
+}
+
+// dialControlMaster reaches the SSH server through the OpenSSH ControlMaster
+// listening on controlPath, reusing its already authenticated connection.
+func (u *ConnectionURI) dialControlMaster(ctx context.Context, controlPath, host, port string) (net.Conn, error) {
+	_, err := os.Stat(controlPath)
+	if err != nil || os.IsNotExist(err) {
+		return nil, err
+	}
+	var d net.Dialer
+	controlSocketConn, err := d.DialContext(ctx, "unix", controlPath)
+	if err != nil {
+		return nil, err
+	}
+	controlConn, chans, reqs, err := tssh.NewControlClientConn(controlSocketConn)
+	if err != nil {
+		controlSocketConn.Close()
+		return nil, err
+	}
+	sshControlClient := ssh.NewClient(controlConn, chans, reqs)
+	sshControlClientConn, err := sshControlClient.Dial("tcp", net.JoinHostPort(host, port))
+	if err != nil {
+		sshControlClient.Close()
+		return nil, err
+	}
+	return sshControlClientConn, nil
+}
+
+// dialSSHHost opens the TCP connection to the SSH server on host, through
+// the proxy at proxyURI when it is set. It provides the first hop of
+// the connection, so that a proxy composes with whatever is layered on top.
+// With proxy_protocol, the PROXY protocol header is sent right away.
+func (u *ConnectionURI) dialSSHHost(ctx context.Context, proxyURI, host, port string) (net.Conn, error) {
+	conn, err := u.dialSSHHostConn(ctx, proxyURI, host, port)
+	if err != nil {
+		return nil, err
+	}
+	if err := u.sendProxyProtocolHeader(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// dialSSHHostConn dials host directly or through the proxy: an HTTP CONNECT
+// tunnel for http:// and https:// proxies, SOCKS5 for any other scheme.
+func (u *ConnectionURI) dialSSHHostConn(ctx context.Context, proxyURI, host, port string) (net.Conn, error) {
+	if proxyURI == "" {
+		if nonZero(u.Query().Get("preflight")) {
+			if err := u.preflight(ctx, host, port); err != nil {
+				return nil, err
+			}
 		}
-		proxyConn = socketConn
+		return u.dialHost(ctx, "tcp", host, port)
 	}
 
-	ncc, chans, reqs, err := ssh.NewClientConn(proxyConn, fmt.Sprintf("%s:%s", u.Hostname(), port), &cfg)
+	parsedProxyURI, err := url.Parse(proxyURI)
+	if err != nil {
+		return nil, err
+	}
+	address := net.JoinHostPort(host, port)
+	if parsedProxyURI.Scheme == "http" || parsedProxyURI.Scheme == "https" {
+		profileProxyHop(ctx)
+		u.logf("[DEBUG] connecting to %s through HTTP proxy %s", address, parsedProxyURI.Host)
+		return u.dialHTTPConnect(ctx, parsedProxyURI, address)
+	}
+	network := parsedProxyURI.Scheme
+	if network == "socks5" || network == "socks5h" {
+		network = "tcp"
+	}
+	dialer, err := proxy.SOCKS5(network, parsedProxyURI.Host, nil, proxy.Direct)
 	if err != nil {
 		return nil, err
 	}
-	cli := ssh.NewClient(ncc, chans, reqs)
-	return cli, nil
+	profileProxyHop(ctx)
+	u.logf("[DEBUG] connecting to %s through proxy %s", address, parsedProxyURI.Host)
+	if contextDialer, ok := dialer.(proxy.ContextDialer); ok {
+		return contextDialer.DialContext(ctx, "tcp", address)
+	}
+	return dialer.Dial("tcp", address)
+}
+
+// sshProxy returns the proxy the SSH connection goes through, or "" to
+// connect directly.
+//
+// The proxy parameter of the URI comes first, so that every host can use its
+// own proxy, and proxy=none connects directly. Otherwise a "ProxyCommand
+// none" for the host in the ssh config, or a match of NO_PROXY, bypasses the
+// proxy environment variables.
+func (u *ConnectionURI) sshProxy(sshcfg *ssh_config.Config) string {
+	if p := u.Query().Get("proxy"); p != "" {
+		// jump hosts are dialed directly, see sshProxyJump
+		if p == "none" || isSSHProxy(p) {
+			return ""
+		}
+		return p
+	}
+	if sshcfg != nil {
+		if cmd, err := sshcfg.Get(u.Hostname(), "ProxyCommand"); err == nil && cmd == "none" {
+			u.logf("[DEBUG] not using a proxy for %s, as configured in the ssh config", u.Hostname())
+			return ""
+		}
+	}
+	p := proxyByEnvVar()
+	if p == "" {
+		return ""
+	}
+	if noProxy := noProxyEnv(); noProxy != "" {
+		port := u.sshPort(sshcfg)
+		if matchNoProxy(noProxy, u.Hostname(), port) || matchNoProxy(noProxy, u.sshHostName(sshcfg), port) {
+			u.logf("[DEBUG] not using a proxy for %s, as it matches NO_PROXY", u.Hostname())
+			return ""
+		}
+	}
+	return p
 }
 
 func proxyByEnvVar() string {
@@ -0,0 +1,391 @@
+package uri
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// sshAuth holds the authentication methods offered to an SSH server, along
+// with what is needed to explain why the server rejected them.
+type sshAuth struct {
+	methods []ssh.AuthMethod
+	// names of the sshauth entries the methods were built from, in the
+	// same order
+	names []string
+	// logf logs on behalf of the connection
+	logf func(format string, v ...interface{})
+
+	mu sync.Mutex
+	// offered are the fingerprints of the public keys offered so far
+	offered []string
+	// agentUsed is set once keys from the ssh agent were offered
+	agentUsed bool
+	// last is the sshauth entry whose credentials were requested last,
+	// which is the one that succeeded once authentication is done
+	last string
+
+	// certExpiry is when the ssh certificate offered by privkey expires,
+	// if there is one that does
+	certExpiry time.Time
+
+	// agentClient is the connection to the ssh agent, shared by everything
+	// that needs the agent during one dial
+	agentClient agent.ExtendedAgent
+
+	// addedKeys are the fingerprints of the keys this dial added to the
+	// ssh agent
+	addedKeys map[string]bool
+
+	// sources are the callbacks listing the keys of the publickey methods
+	sources []func() ([]ssh.Signer, error)
+	// probedKey, when set, is the fingerprint of the key probe_keys found
+	// the server to accept, the only key that is offered then
+	probedKey string
+
+	// skippedKeys are why the keys of privkey that could not be loaded were
+	// left out, e.g. a missing passphrase
+	skippedKeys []string
+}
+
+// skipKey logs and records why a key of privkey is left out, so that an
+// authentication failure points at it instead of the key failing silently.
+func (a *sshAuth) skipKey(msg string, err error) {
+	a.logf("[ERROR] %s: %v", msg, err)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.skippedKeys = append(a.skippedKeys, err.Error())
+}
+
+// skippedKeysNote returns the reasons the keys were left out, to append to
+// an error message, or "" when no key was.
+func (a *sshAuth) skippedKeysNote() string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(a.skippedKeys) == 0 {
+		return ""
+	}
+	return fmt.Sprintf(" (unusable keys: %s)", strings.Join(a.skippedKeys, "; "))
+}
+
+// signerSources returns the callbacks listing the keys of the publickey
+// methods.
+func (a *sshAuth) signerSources() []func() ([]ssh.Signer, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.sources
+}
+
+// preferKey has the publickey methods only offer the key with fingerprint.
+func (a *sshAuth) preferKey(fingerprint string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.probedKey = fingerprint
+}
+
+// agentKeysAdded records the fingerprints of the keys added to the ssh agent
+// with add_keys_to_agent during this run, by any connection.
+var (
+	agentKeysMutex sync.Mutex
+	agentKeysAdded = make(map[string]bool)
+)
+
+// agent returns a client for the ssh agent listening on SSH_AUTH_SOCK,
+// connecting on first use. It returns nil if no agent is configured.
+func (a *sshAuth) agent() (agent.ExtendedAgent, error) {
+	if a.agentClient != nil {
+		return a.agentClient, nil
+	}
+	socket := os.Getenv("SSH_AUTH_SOCK")
+	if socket == "" {
+		return nil, nil
+	}
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		return nil, err
+	}
+	a.agentClient = agent.NewClient(conn)
+	return a.agentClient, nil
+}
+
+// addKeyToAgent adds the private key read from path to the ssh agent, as
+// OpenSSH does with AddKeysToAgent, so that later connections can use it
+// without reading the key again. agent_key_lifetime and agent_key_confirm
+// set the lifetime and confirmation constraints of the added key. An
+// encrypted key is decrypted with passphrase.
+func (u *ConnectionURI) addKeyToAgent(a *sshAuth, pemBytes []byte, path string, passphrase []byte) error {
+	agentClient, err := a.agent()
+	if err != nil {
+		return err
+	}
+	if agentClient == nil {
+		return fmt.Errorf("SSH_AUTH_SOCK is not set")
+	}
+
+	var key interface{}
+	if len(passphrase) > 0 {
+		key, err = ssh.ParseRawPrivateKeyWithPassphrase(pemBytes, passphrase)
+	} else {
+		key, err = ssh.ParseRawPrivateKey(pemBytes)
+	}
+	if err != nil {
+		return err
+	}
+
+	lifetime, err := u.durationParam("agent_key_lifetime")
+	if err != nil {
+		return err
+	}
+
+	added := agent.AddedKey{
+		PrivateKey:       key,
+		Comment:          path,
+		LifetimeSecs:     uint32(lifetime / time.Second),
+		ConfirmBeforeUse: nonZero(u.Query().Get("agent_key_confirm")),
+	}
+	if err := agentClient.Add(added); err != nil {
+		return err
+	}
+	if signer, err := ssh.NewSignerFromKey(key); err == nil {
+		fingerprint := ssh.FingerprintSHA256(signer.PublicKey())
+		agentKeysMutex.Lock()
+		agentKeysAdded[fingerprint] = true
+		agentKeysMutex.Unlock()
+		a.mu.Lock()
+		if a.addedKeys == nil {
+			a.addedKeys = make(map[string]bool)
+		}
+		a.addedKeys[fingerprint] = true
+		a.mu.Unlock()
+	}
+	u.logf("[DEBUG] added ssh key %s to the agent (lifetime: %s, confirm: %v)", path, lifetime, added.ConfirmBeforeUse)
+	return nil
+}
+
+// withoutSharedKeys wraps the signers of the ssh agent so that the keys
+// other connections added with add_keys_to_agent are left out, for
+// no_shared_agent_state. Keys that were in the agent before, and the ones
+// this dial added itself, are still offered.
+func (a *sshAuth) withoutSharedKeys(signers func() ([]ssh.Signer, error)) func() ([]ssh.Signer, error) {
+	return func() ([]ssh.Signer, error) {
+		result, err := signers()
+		if err != nil {
+			return nil, err
+		}
+		agentKeysMutex.Lock()
+		defer agentKeysMutex.Unlock()
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		kept := result[:0]
+		for _, signer := range result {
+			fingerprint := ssh.FingerprintSHA256(signer.PublicKey())
+			if agentKeysAdded[fingerprint] && !a.addedKeys[fingerprint] {
+				a.logf("[DEBUG] no_shared_agent_state: not offering agent key %s added by another connection", fingerprint)
+				continue
+			}
+			kept = append(kept, signer)
+		}
+		return kept, nil
+	}
+}
+
+// recordSigners wraps a signers callback so that the keys it returns are
+// recorded as offered to the server. Once probe_keys found the key the
+// server accepts, the others are left out.
+func (a *sshAuth) recordSigners(signers func() ([]ssh.Signer, error), fromAgent bool) func() ([]ssh.Signer, error) {
+	a.mu.Lock()
+	a.sources = append(a.sources, signers)
+	a.mu.Unlock()
+	return func() ([]ssh.Signer, error) {
+		result, err := signers()
+		if err != nil {
+			return nil, err
+		}
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		if a.probedKey != "" {
+			kept := make([]ssh.Signer, 0, 1)
+			for _, signer := range result {
+				if ssh.FingerprintSHA256(signer.PublicKey()) == a.probedKey {
+					kept = append(kept, signer)
+				}
+			}
+			result = kept
+		}
+		if fromAgent && len(result) > 0 {
+			a.agentUsed = true
+		}
+		a.last = "privkey"
+		if fromAgent {
+			a.last = "agent"
+		}
+	next:
+		for _, signer := range result {
+			fingerprint := ssh.FingerprintSHA256(signer.PublicKey())
+			for _, f := range a.offered {
+				if f == fingerprint {
+					continue next
+				}
+			}
+			a.offered = append(a.offered, fingerprint)
+		}
+		return result, nil
+	}
+}
+
+// recordPassword wraps password so that using it is recorded.
+func (a *sshAuth) recordPassword(password string) func() (string, error) {
+	return func() (string, error) {
+		a.mu.Lock()
+		a.last = "ssh-password"
+		a.mu.Unlock()
+		return password, nil
+	}
+}
+
+// kbdAnswer answers the keyboard-interactive prompts containing prompt.
+type kbdAnswer struct {
+	prompt string
+	answer string
+}
+
+// kbdAnswers returns the answers to keyboard-interactive prompts, from the
+// sshauth_kbd_answers parameter or else the LIBVIRT_SSH_KBD_ANSWERS
+// environment variable, given as comma separated prompt=answer pairs, e.g.
+// "Verification code=123456,Password=secret".
+func (u *ConnectionURI) kbdAnswers() []kbdAnswer {
+	v := u.Query().Get("sshauth_kbd_answers")
+	if v == "" {
+		v = os.Getenv("LIBVIRT_SSH_KBD_ANSWERS")
+	}
+	var answers []kbdAnswer
+	for _, pair := range strings.Split(v, ",") {
+		if pair == "" {
+			continue
+		}
+		prompt, answer, ok := strings.Cut(pair, "=")
+		if !ok {
+			u.logf("[WARN] Ignoring sshauth_kbd_answers entry without '=' (prompt=answer)")
+			continue
+		}
+		answers = append(answers, kbdAnswer{prompt: strings.ToLower(strings.TrimSpace(prompt)), answer: answer})
+	}
+	return answers
+}
+
+// kbdAskpass returns the command asked for the answers to keyboard-interactive
+// prompts that sshauth_kbd_answers and totp_secret have none for, from the
+// sshauth_askpass parameter or else the LIBVIRT_SSH_ASKPASS environment
+// variable.
+func (u *ConnectionURI) kbdAskpass() string {
+	if askpass := u.Query().Get("sshauth_askpass"); askpass != "" {
+		return askpass
+	}
+	return os.Getenv("LIBVIRT_SSH_ASKPASS")
+}
+
+// askpassAnswer runs the askpass command with prompt as its only argument, as
+// OpenSSH does with SSH_ASKPASS, and returns what it prints without the
+// trailing line break.
+func askpassAnswer(askpass string, prompt string) (string, error) {
+	out, err := exec.Command(askpass, prompt).Output()
+	if err != nil {
+		// the output is not part of the error, it may be the secret
+		return "", fmt.Errorf("askpass command %s failed for prompt %q: %w", askpass, prompt, err)
+	}
+	return string(bytes.TrimSuffix(bytes.TrimSuffix(out, []byte("\n")), []byte("\r"))), nil
+}
+
+// answerChallenge returns a keyboard-interactive challenge callback that
+// answers each prompt with the first of answers whose prompt it contains,
+// ignoring case. Otherwise a prompt asking for a one-time code is answered
+// with the current code of totpKey, when set, computed as the server asks,
+// and any other prompt with what the askpass command prints, when set.
+// Prompts without an answer are logged and answered with an empty string,
+// as there is nobody to ask.
+func (a *sshAuth) answerChallenge(answers []kbdAnswer, totpKey []byte, askpass string) ssh.KeyboardInteractiveChallenge {
+	return func(name, instruction string, questions []string, echos []bool) ([]string, error) {
+		a.mu.Lock()
+		a.last = "keyboard-interactive"
+		a.mu.Unlock()
+
+		replies := make([]string, len(questions))
+	next:
+		for i, q := range questions {
+			for _, answer := range answers {
+				if strings.Contains(strings.ToLower(q), answer.prompt) {
+					replies[i] = answer.answer
+					continue next
+				}
+			}
+			if totpKey != nil && isTOTPPrompt(q) {
+				a.logf("[DEBUG] keyboard-interactive: answering prompt %q with the TOTP code of totp_secret", q)
+				replies[i] = totpCode(totpKey, time.Now())
+				continue
+			}
+			if askpass != "" {
+				a.logf("[DEBUG] keyboard-interactive: answering prompt %q with sshauth_askpass", q)
+				reply, err := askpassAnswer(askpass, q)
+				if err != nil {
+					return nil, err
+				}
+				replies[i] = reply
+				continue
+			}
+			a.logf("[WARN] keyboard-interactive: no answer in sshauth_kbd_answers for prompt %q", q)
+		}
+		return replies, nil
+	}
+}
+
+// lastMethod returns the sshauth entry whose credentials were used last.
+func (a *sshAuth) lastMethod() string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.last
+}
+
+// explainError adds guidance to authentication errors returned by the SSH
+// handshake with host as user. Any other error is returned unchanged.
+func (a *sshAuth) explainError(err error, user string, host string) error {
+	if err == nil {
+		return nil
+	}
+
+	if strings.Contains(err.Error(), "too many authentication failures") {
+		a.logf("[ERROR] SSH server disconnected after too many authentication attempts (offered: %s). "+
+			"If the server uses a low MaxAuthTries, set single_attempt=1 and single_attempt_method to the method expected to succeed",
+			strings.Join(a.names, ", "))
+		return fmt.Errorf("SSH server closed the connection after too many authentication failures (offered methods: %s), "+
+			"consider single_attempt=1 with single_attempt_method: %w", strings.Join(a.names, ", "), err)
+	}
+
+	if strings.Contains(err.Error(), "unable to authenticate") && strings.Contains(err.Error(), "publickey") {
+		skipped := a.skippedKeysNote()
+		a.mu.Lock()
+		defer a.mu.Unlock()
+
+		if len(a.offered) == 0 {
+			return fmt.Errorf("permission denied (publickey) for %s@%s: no keys were offered%s, "+
+				"check the keyfile parameter and that the ssh agent holds keys: %w", user, host, skipped, err)
+		}
+
+		source := "from key files"
+		if a.agentUsed {
+			source = "including keys from the ssh agent"
+		}
+		return fmt.Errorf("permission denied (publickey) for %s@%s: offered keys %s (%s)%s, "+
+			"verify that one of these public keys is listed in ~/.ssh/authorized_keys of %s on %s: %w",
+			user, host, strings.Join(a.offered, ", "), source, skipped, user, host, err)
+	}
+
+	return err
+}
@@ -0,0 +1,36 @@
+package uri
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// expectedBanner returns the pattern the server version line has to match,
+// given by the expect_banner parameter, or nil when it is not set.
+func (u *ConnectionURI) expectedBanner() (*regexp.Regexp, error) {
+	expr := u.Query().Get("expect_banner")
+	if expr == "" {
+		return nil, nil
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid value '%s' for expect_banner: %w", expr, err)
+	}
+	return re, nil
+}
+
+// withExpectedBanner wraps cb so that the handshake fails when the version
+// line the server sent on conn does not match re. The host key is checked
+// during the key exchange, before authentication, so no credentials are
+// offered to a server presenting an unexpected sshd.
+func (u *ConnectionURI) withExpectedBanner(cb ssh.HostKeyCallback, re *regexp.Regexp, conn *handshakeConn) ssh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		if version := conn.serverVersion(); !re.MatchString(version) {
+			return fmt.Errorf("server version %q of %s does not match expect_banner %q", version, hostname, re)
+		}
+		return cb(hostname, remote, key)
+	}
+}
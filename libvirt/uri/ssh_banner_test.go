@@ -0,0 +1,56 @@
+package uri
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestDialSSHExpectBanner(t *testing.T) {
+	s := newTestSSHServer(t)
+	var authAttempts int64
+	s.Configure(func(config *ssh.ServerConfig) {
+		config.ServerVersion = "SSH-2.0-OpenSSH_9.6p1 Debian-3"
+		password := config.PasswordCallback
+		config.PasswordCallback = func(c ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+			atomic.AddInt64(&authAttempts, 1)
+			return password(c, pass)
+		}
+	})
+
+	u := testSSHURI(t, s, "expect_banner=^SSH-2.0-OpenSSH_9\\.")
+	conn, err := u.Dial()
+	require.NoError(t, err)
+	conn.Close()
+	assert.Equal(t, int64(1), atomic.LoadInt64(&authAttempts))
+
+	u = testSSHURI(t, s, "expect_banner=^SSH-2.0-dropbear")
+	auth := u.parseAuthMethods(nil)
+	_, _, err = u.sshClient(context.Background(), nil, ssh.ClientConfig{
+		User:            testSSHUser,
+		Auth:            auth.methods,
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `server version "SSH-2.0-OpenSSH_9.6p1 Debian-3" of 127.0.0.1`)
+	assert.Contains(t, err.Error(), `does not match expect_banner "^SSH-2.0-dropbear"`)
+	// the password was never sent to the unexpected server
+	assert.Equal(t, int64(1), atomic.LoadInt64(&authAttempts))
+
+	u = testSSHURI(t, s, "expect_banner=(")
+	_, _, err = u.sshClient(context.Background(), nil, ssh.ClientConfig{User: testSSHUser})
+	assert.ErrorContains(t, err, "invalid value '(' for expect_banner")
+}
+
+func TestHandshakeConnServerVersion(t *testing.T) {
+	c := newHandshakeConn(nil)
+	c.readBanner([]byte("Welcome to\r\nthe bastion\r\nSSH-2.0-Open"))
+	assert.Equal(t, "", c.serverVersion())
+	c.readBanner([]byte("SSH_9.6\r\n\x00\x00"))
+	assert.Equal(t, "SSH-2.0-OpenSSH_9.6", c.serverVersion())
+	assert.Equal(t, 2, c.afterBanner)
+}
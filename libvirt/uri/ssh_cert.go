@@ -0,0 +1,78 @@
+package uri
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// loadCertificate returns the SSH certificate to present along with the
+// private key read from keyPath: the certfile parameter when set, like
+// OpenSSH's CertificateFile, or else the key path with -cert.pub appended,
+// when that file exists. keyPath is "" for a key not read from a file.
+func (u *ConnectionURI) loadCertificate(keyPath string) (*ssh.Certificate, error) {
+	path := u.Query().Get("certfile")
+	if path != "" {
+		path = os.ExpandEnv(strings.Replace(path, "~", "$HOME", 1))
+	} else if keyPath != "" {
+		path = keyPath + "-cert.pub"
+		if _, err := os.Stat(path); err != nil {
+			return nil, nil
+		}
+	} else {
+		return nil, nil
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ssh certificate: %w", err)
+	}
+	key, _, _, _, err := ssh.ParseAuthorizedKey(content)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse ssh certificate %s: %w", path, err)
+	}
+	cert, ok := key.(*ssh.Certificate)
+	if !ok {
+		return nil, fmt.Errorf("%s is a public key, not an ssh certificate", path)
+	}
+	return cert, nil
+}
+
+// certExpiry returns when cert stops being valid, or the zero time when it
+// does not expire.
+func certExpiry(cert *ssh.Certificate) time.Time {
+	if cert.ValidBefore == ssh.CertTimeInfinity || cert.ValidBefore > 1<<63-1 {
+		return time.Time{}
+	}
+	return time.Unix(int64(cert.ValidBefore), 0)
+}
+
+// recycleBeforeExpiry closes conn the cert_renew_before duration before the
+// certificate it authenticated with expires, so that the next connection
+// logs in again with a freshly issued one instead of the server rejecting
+// the old one mid-run.
+func (u *ConnectionURI) recycleBeforeExpiry(conn *Conn) error {
+	renewBefore, err := u.durationParam("cert_renew_before")
+	if err != nil || renewBefore == 0 || conn.expiry.IsZero() {
+		return err
+	}
+
+	wait := time.Until(conn.expiry.Add(-renewBefore))
+	if wait < time.Second {
+		// recycling right away would only get the same certificate again
+		u.logf("[WARN] the ssh certificate used for %s expires at %s, within cert_renew_before", u.Hostname(), conn.expiry.Format(time.RFC3339))
+		return nil
+	}
+	conn.recycled = make(chan struct{})
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	conn.recycleTimer = time.AfterFunc(wait, func() {
+		u.logf("[INFO] closing the connection to %s, its ssh certificate expires at %s", u.Hostname(), conn.expiry.Format(time.RFC3339))
+		close(conn.recycled)
+		conn.Close()
+	})
+	return nil
+}
@@ -0,0 +1,111 @@
+package uri
+
+import (
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+// writeTestUserCert issues a certificate for key, valid until validBefore,
+// and writes it next to keyPath as OpenSSH does.
+func writeTestUserCert(t *testing.T, ca ssh.Signer, key ssh.PublicKey, keyPath string, serial uint64, validBefore time.Time) {
+	cert := &ssh.Certificate{
+		Key:             key,
+		Serial:          serial,
+		CertType:        ssh.UserCert,
+		ValidPrincipals: []string{testSSHUser},
+		ValidAfter:      uint64(time.Now().Add(-time.Minute).Unix()),
+		ValidBefore:     uint64(validBefore.Unix()),
+	}
+	require.NoError(t, cert.SignCert(rand.Reader, ca))
+	require.NoError(t, os.WriteFile(keyPath+"-cert.pub", ssh.MarshalAuthorizedKey(cert), 0600))
+}
+
+func TestDialSSHRecyclesBeforeCertExpiry(t *testing.T) {
+	s := newTestSSHServer(t)
+	ca := newTestSigner(t)
+
+	var mu sync.Mutex
+	var serials []uint64
+	checker := &ssh.CertChecker{
+		IsUserAuthority: func(auth ssh.PublicKey) bool {
+			return string(auth.Marshal()) == string(ca.PublicKey().Marshal())
+		},
+	}
+	s.Configure(func(config *ssh.ServerConfig) {
+		config.PublicKeyCallback = func(c ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			cert, ok := key.(*ssh.Certificate)
+			if !ok {
+				return nil, ssh.ErrNoAuth
+			}
+			perms, err := checker.Authenticate(c, key)
+			if err == nil {
+				mu.Lock()
+				serials = append(serials, cert.Serial)
+				mu.Unlock()
+			}
+			return perms, err
+		}
+	})
+
+	keyPath := filepath.Join(t.TempDir(), "id_ed25519")
+	signer := writeTestKey(t, keyPath)
+	expiry := time.Now().Add(4 * time.Second)
+	writeTestUserCert(t, ca, signer.PublicKey(), keyPath, 1, expiry)
+
+	u := testSSHURI(t, s, "sshauth=privkey&keyfile="+keyPath+"&cert_renew_before=2s")
+	c, err := u.Dial()
+	require.NoError(t, err)
+	conn := c.(*Conn)
+	assert.Equal(t, expiry.Unix(), conn.CredentialExpiry().Unix())
+
+	select {
+	case <-conn.Recycled():
+	case <-time.After(5 * time.Second):
+		t.Fatal("connection was not recycled")
+	}
+	assert.True(t, time.Now().Before(conn.CredentialExpiry()), "recycled after the certificate expired")
+	_, err = conn.Write([]byte{0})
+	assert.Error(t, err)
+
+	// a renewed certificate was issued in the meantime
+	writeTestUserCert(t, ca, signer.PublicKey(), keyPath, 2, time.Now().Add(time.Hour))
+	c, err = u.Dial()
+	require.NoError(t, err)
+	c.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []uint64{1, 2}, serials)
+}
+
+func TestDialSSHCertWithoutRenewal(t *testing.T) {
+	s := newTestSSHServer(t)
+	ca := newTestSigner(t)
+	checker := &ssh.CertChecker{
+		IsUserAuthority: func(auth ssh.PublicKey) bool { return true },
+	}
+	s.Configure(func(config *ssh.ServerConfig) {
+		config.PublicKeyCallback = checker.Authenticate
+	})
+
+	keyPath := filepath.Join(t.TempDir(), "id_ed25519")
+	signer := writeTestKey(t, keyPath)
+	certPath := filepath.Join(t.TempDir(), "user-cert.pub")
+	writeTestUserCert(t, ca, signer.PublicKey(), keyPath, 1, time.Now().Add(time.Hour))
+	require.NoError(t, os.Rename(keyPath+"-cert.pub", certPath))
+
+	u := testSSHURI(t, s, "sshauth=privkey&keyfile="+keyPath+"&certfile="+certPath)
+	c, err := u.Dial()
+	require.NoError(t, err)
+	defer c.Close()
+	assert.False(t, c.(*Conn).CredentialExpiry().IsZero())
+	assert.Nil(t, c.(*Conn).Recycled())
+}
@@ -0,0 +1,73 @@
+package uri
+
+import (
+	"os"
+	"sync"
+	"time"
+
+	"github.com/kevinburke/ssh_config"
+)
+
+// sshConfigCache holds the ssh config files read so far, by path, so that
+// every connection does not parse them again.
+var (
+	sshConfigMutex sync.Mutex
+	sshConfigCache = make(map[string]*cachedSSHConfig)
+)
+
+type cachedSSHConfig struct {
+	config  *ssh_config.Config
+	modTime time.Time
+	size    int64
+}
+
+// ReloadConfig drops the cached ssh config files, so that new connections
+// read them again. Long running embedders call it when the user edited the
+// files, e.g. on SIGHUP; connections already established are not affected.
+func ReloadConfig() {
+	sshConfigMutex.Lock()
+	defer sshConfigMutex.Unlock()
+	sshConfigCache = make(map[string]*cachedSSHConfig)
+}
+
+// sshConfigFile returns the parsed ssh config file at path, from the cache
+// when possible. With ssh_config_watch set, the file is checked for changes
+// on every connection and read again when it was modified.
+func (u *ConnectionURI) sshConfigFile(path string) *ssh_config.Config {
+	watch := nonZero(u.Query().Get("ssh_config_watch"))
+
+	sshConfigMutex.Lock()
+	defer sshConfigMutex.Unlock()
+
+	cached := sshConfigCache[path]
+	if cached != nil && !watch {
+		return cached.config
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		u.logf("[WARN] Failed to open ssh config file: %v", err)
+		delete(sshConfigCache, path)
+		return nil
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		u.logf("[WARN] Failed to open ssh config file: %v", err)
+		return nil
+	}
+	if cached != nil && cached.modTime.Equal(info.ModTime()) && cached.size == info.Size() {
+		return cached.config
+	}
+	if cached != nil {
+		u.logf("[DEBUG] ssh config file %s changed, reading it again", path)
+	}
+
+	sshcfg, err := ssh_config.Decode(f)
+	if err != nil {
+		u.logf("[WARN] Failed to parse ssh config file: %v", err)
+	}
+	u.warnUnknownDirectives(sshcfg)
+	sshConfigCache[path] = &cachedSSHConfig{config: sshcfg, modTime: info.ModTime(), size: info.Size()}
+	return sshcfg
+}
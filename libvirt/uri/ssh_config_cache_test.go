@@ -0,0 +1,38 @@
+package uri
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReloadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config")
+	require.NoError(t, os.WriteFile(path, []byte("Host hv1\n  User alice\n"), 0600))
+	defer ReloadConfig()
+
+	u, err := Parse("qemu+ssh://hv1/system?ssh_config=" + path)
+	require.NoError(t, err)
+	user := func() string {
+		v, err := u.sshConfig().Get("hv1", "User")
+		require.NoError(t, err)
+		return v
+	}
+	assert.Equal(t, "alice", user())
+
+	// edited, but still served from the cache until reloaded
+	require.NoError(t, os.WriteFile(path, []byte("Host hv1\n  User bob\n"), 0600))
+	assert.Equal(t, "alice", user())
+	ReloadConfig()
+	assert.Equal(t, "bob", user())
+
+	// with ssh_config_watch, the next connection sees the change by itself
+	u, err = Parse("qemu+ssh://hv1/system?ssh_config_watch=1&ssh_config=" + path)
+	require.NoError(t, err)
+	assert.Equal(t, "bob", user())
+	require.NoError(t, os.WriteFile(path, []byte("Host hv1\n  User carol\n"), 0600))
+	assert.Equal(t, "carol", user())
+}
@@ -0,0 +1,79 @@
+package uri
+
+import (
+	"path"
+	"strings"
+
+	"github.com/kevinburke/ssh_config"
+)
+
+// sshConfigDirectives are the keywords of the OpenSSH client configuration,
+// in lower case. A directive outside this list is most likely a typo, or
+// meant for another client.
+var sshConfigDirectives = map[string]bool{}
+
+func init() {
+	for _, d := range []string{
+		"AddKeysToAgent", "AddressFamily", "BatchMode", "BindAddress", "BindInterface",
+		"CanonicalDomains", "CanonicalizeFallbackLocal", "CanonicalizeHostname",
+		"CanonicalizeMaxDots", "CanonicalizePermittedCNAMEs", "CASignatureAlgorithms",
+		"CertificateFile", "ChallengeResponseAuthentication", "ChannelTimeout", "CheckHostIP",
+		"Ciphers", "ClearAllForwardings", "Compression", "ConnectionAttempts", "ConnectTimeout",
+		"ControlMaster", "ControlPath", "ControlPersist", "DynamicForward", "EnableEscapeCommandline",
+		"EnableSSHKeysign", "EscapeChar", "ExitOnForwardFailure", "FingerprintHash", "ForkAfterAuthentication",
+		"ForwardAgent", "ForwardX11", "ForwardX11Timeout", "ForwardX11Trusted", "GatewayPorts",
+		"GlobalKnownHostsFile", "GSSAPIAuthentication", "GSSAPIDelegateCredentials", "HashKnownHosts",
+		"Host", "HostbasedAcceptedAlgorithms", "HostbasedAuthentication", "HostbasedKeyTypes",
+		"HostKeyAlgorithms", "HostKeyAlias", "Hostname", "IdentitiesOnly", "IdentityAgent", "IdentityFile",
+		"IgnoreUnknown", "Include", "IPQoS", "KbdInteractiveAuthentication", "KbdInteractiveDevices",
+		"KexAlgorithms", "KnownHostsCommand", "LocalCommand", "LocalForward", "LogLevel", "LogVerbose",
+		"MACs", "Match", "NoHostAuthenticationForLocalhost", "NumberOfPasswordPrompts",
+		"ObscureKeystrokeTiming", "PasswordAuthentication", "PermitLocalCommand", "PermitRemoteOpen",
+		"PKCS11Provider", "Port", "PreferredAuthentications", "ProxyCommand", "ProxyJump", "ProxyUseFdpass",
+		"PubkeyAcceptedAlgorithms", "PubkeyAcceptedKeyTypes", "PubkeyAuthentication", "RekeyLimit",
+		"RemoteCommand", "RemoteForward", "RequestTTY", "RequiredRSASize", "RevokedHostKeys",
+		"SecurityKeyProvider", "SendEnv", "ServerAliveCountMax", "ServerAliveInterval", "SessionType",
+		"SetEnv", "StdinNull", "StreamLocalBindMask", "StreamLocalBindUnlink", "StrictHostKeyChecking",
+		"SyslogFacility", "TCPKeepAlive", "Tag", "Tunnel", "TunnelDevice", "UpdateHostKeys", "User",
+		"UserKnownHostsFile", "VerifyHostKeyDNS", "VisualHostKey", "XAuthLocation",
+	} {
+		sshConfigDirectives[strings.ToLower(d)] = true
+	}
+}
+
+// warnUnknownDirectives logs the directives of the ssh config that are not
+// OpenSSH keywords, except the ones matched by an IgnoreUnknown pattern, so
+// that a config shared with other clients does not produce warnings for the
+// directives it already declares as foreign.
+func (u *ConnectionURI) warnUnknownDirectives(sshcfg *ssh_config.Config) {
+	if sshcfg == nil {
+		return
+	}
+
+	var ignore []string
+	for _, host := range sshcfg.Hosts {
+		for _, node := range host.Nodes {
+			if kv, ok := node.(*ssh_config.KV); ok && strings.EqualFold(kv.Key, "IgnoreUnknown") {
+				for _, p := range strings.Split(kv.Value, ",") {
+					ignore = append(ignore, strings.ToLower(strings.TrimSpace(p)))
+				}
+			}
+		}
+	}
+
+	for _, host := range sshcfg.Hosts {
+	next:
+		for _, node := range host.Nodes {
+			kv, ok := node.(*ssh_config.KV)
+			if !ok || sshConfigDirectives[strings.ToLower(kv.Key)] {
+				continue
+			}
+			for _, p := range ignore {
+				if matched, _ := path.Match(p, strings.ToLower(kv.Key)); matched {
+					continue next
+				}
+			}
+			u.logf("[WARN] unknown directive '%s' in ssh config at line %d, add it to IgnoreUnknown if it is meant for another client", kv.Key, kv.Pos().Line)
+		}
+	}
+}
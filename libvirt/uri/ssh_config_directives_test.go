@@ -0,0 +1,26 @@
+package uri
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWarnUnknownDirectives(t *testing.T) {
+	u, err := Parse("qemu+ssh://libvirt.example.com/system")
+	require.NoError(t, err)
+
+	logs := captureLog(t)
+	u.SSHConfig = "Host *\n  UseKeychain yes\n  User root\n"
+	u.sshConfig()
+	assert.Contains(t, logs.String(), "[WARN] unknown directive 'UseKeychain' in ssh config at line 2")
+
+	logs = captureLog(t)
+	u.SSHConfig = "IgnoreUnknown UseKeychain,AddKeys*\n\nHost *\n  UseKeychain yes\n  AddKeysToKeychain yes\n  user root\n"
+	sshcfg := u.sshConfig()
+	assert.Empty(t, logs.String())
+	user, err := sshcfg.Get("libvirt.example.com", "User")
+	require.NoError(t, err)
+	assert.Equal(t, "root", user)
+}
@@ -0,0 +1,71 @@
+package uri
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"syscall"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// hostKeyError marks an error returned by the host key callback, so that a
+// failed verification can be told apart from other handshake errors once
+// x/crypto has wrapped it.
+type hostKeyError struct {
+	err error
+}
+
+func (e *hostKeyError) Error() string { return e.err.Error() }
+func (e *hostKeyError) Unwrap() error { return e.err }
+
+// withHostKeyError wraps the errors returned by cb in a hostKeyError.
+func withHostKeyError(cb ssh.HostKeyCallback) ssh.HostKeyCallback {
+	if cb == nil {
+		return nil
+	}
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		if err := cb(hostname, remote, key); err != nil {
+			return &hostKeyError{err: err}
+		}
+		return nil
+	}
+}
+
+// sshFailure names the common reasons an SSH connection fails, or returns
+// "" when err is none of them.
+func sshFailure(err error) string {
+	var keyErr *knownhosts.KeyError
+	var hkErr *hostKeyError
+	var sourceErr *hostKeySourceError
+	switch {
+	case errors.As(err, &sourceErr):
+		return "host key source unavailable"
+	case errors.As(err, &keyErr) && len(keyErr.Want) > 0:
+		return "host key mismatch"
+	case errors.As(err, &keyErr):
+		return "unknown host key"
+	case errors.As(err, &hkErr):
+		return "host key verification failed"
+	case errors.Is(err, context.DeadlineExceeded) || isTimeout(err):
+		return "timed out"
+	case strings.Contains(err.Error(), "unable to authenticate"),
+		strings.Contains(err.Error(), "too many authentication failures"):
+		return "authentication failed"
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "connection refused"
+	}
+	return ""
+}
+
+// sshConnectionError wraps an error of establishing the SSH connection,
+// naming the reason of the failure when it is a common one.
+func (u *ConnectionURI) sshConnectionError(err error) error {
+	if reason := sshFailure(err); reason != "" {
+		return fmt.Errorf("failed to establish SSH connection to %s: %s: %w", u.Host, reason, err)
+	}
+	return fmt.Errorf("failed to establish SSH connection to %s: %w", u.Host, err)
+}
@@ -0,0 +1,63 @@
+package uri
+
+import (
+	"net"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func TestDialSSHFailures(t *testing.T) {
+	s := newTestSSHServer(t)
+	t.Setenv("HTTP_PROXY", "")
+	t.Setenv("ALL_PROXY", "")
+
+	u := testSSHURI(t, s, "")
+	u.User = url.UserPassword(testSSHUser, "wrong")
+	_, err := u.Dial()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to establish SSH connection to "+s.Addr()+": authentication failed: ")
+
+	other := newTestSSHServer(t)
+	changed := knownhosts.Line([]string{knownhosts.Normalize(s.Addr())}, other.hostKey.PublicKey())
+	u = testSSHURIWithKnownHosts(t, s, []string{changed}, "")
+	_, err = u.Dial()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to establish SSH connection to "+s.Addr()+": host key mismatch: ")
+	var keyErr *knownhosts.KeyError
+	assert.ErrorAs(t, err, &keyErr)
+
+	unknown := knownhosts.Line([]string{knownhosts.Normalize(other.Addr())}, other.hostKey.PublicKey())
+	u = testSSHURIWithKnownHosts(t, s, []string{unknown}, "")
+	_, err = u.Dial()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), ": unknown host key: ")
+
+	// a server that accepts the connection but never speaks SSH
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer c.Close()
+		}
+	}()
+	u, err = Parse("qemu+ssh://" + testSSHUser + ":" + testSSHPassword + "@" + l.Addr().String() + "/system?sshauth=ssh-password&no_verify=1&total_timeout=1")
+	require.NoError(t, err)
+	_, err = u.Dial()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), ": timed out: ")
+
+	u, err = Parse("qemu+ssh://" + testSSHUser + ":" + testSSHPassword + "@127.0.0.1:" + closedPort(t) + "/system?sshauth=ssh-password&no_verify=1")
+	require.NoError(t, err)
+	_, err = u.Dial()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), ": connection refused: ")
+}
@@ -0,0 +1,67 @@
+package uri
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// supportedKeyBlocks are the PEM block types ssh.ParsePrivateKey understands.
+var supportedKeyBlocks = map[string]bool{
+	"OPENSSH PRIVATE KEY": true,
+	"RSA PRIVATE KEY":     true,
+	"EC PRIVATE KEY":      true,
+	"DSA PRIVATE KEY":     true,
+	"PRIVATE KEY":         true,
+}
+
+// parsePrivateKey parses the private key read from name, decrypting it with
+// passphrase when one is given. Text around the PEM block, like comments, is
+// ignored. Keys in a format that cannot be used get an error saying what is
+// wrong with them and, where possible, how to convert them.
+func parsePrivateKey(pemBytes []byte, name string, passphrase []byte) (ssh.Signer, error) {
+	trimmed := bytes.TrimSpace(pemBytes)
+	if bytes.HasPrefix(trimmed, []byte("PuTTY-User-Key-File-")) {
+		return nil, fmt.Errorf("'%s' is a PuTTY key, which is not supported; convert it to OpenSSH format with 'puttygen %s -O private-openssh -o <new file>'", name, name)
+	}
+	if _, _, _, _, err := ssh.ParseAuthorizedKey(trimmed); err == nil {
+		return nil, fmt.Errorf("'%s' is a public key, keyfile must point to the private key", name)
+	}
+
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, fmt.Errorf("'%s' does not contain a PEM encoded private key", name)
+	}
+	switch {
+	case block.Type == "ENCRYPTED PRIVATE KEY":
+		return nil, fmt.Errorf("'%s' is an encrypted PKCS#8 key, which is not supported; convert it to OpenSSH format with 'ssh-keygen -p -f %s'", name, name)
+	case block.Type == "PUBLIC KEY" || block.Type == "RSA PUBLIC KEY":
+		return nil, fmt.Errorf("'%s' is a public key, keyfile must point to the private key", name)
+	case !supportedKeyBlocks[block.Type]:
+		return nil, fmt.Errorf("'%s' contains an unsupported key type '%s'", name, block.Type)
+	}
+
+	// only hand the key itself over, without the text around it
+	var signer ssh.Signer
+	var err error
+	if len(passphrase) > 0 {
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(pem.EncodeToMemory(block), passphrase)
+	} else {
+		signer, err = ssh.ParsePrivateKey(pem.EncodeToMemory(block))
+	}
+	if err != nil {
+		var missing *ssh.PassphraseMissingError
+		if errors.As(err, &missing) {
+			return nil, fmt.Errorf("'%s' is passphrase protected, set keyfile_passphrase: %w", name, err)
+		}
+		if errors.Is(err, x509.IncorrectPasswordError) {
+			return nil, fmt.Errorf("wrong keyfile_passphrase for '%s'", name)
+		}
+		return nil, fmt.Errorf("failed to parse private key '%s': %w", name, err)
+	}
+	return signer, nil
+}
@@ -0,0 +1,162 @@
+package uri
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+const testPuTTYKey = `PuTTY-User-Key-File-3: ssh-ed25519
+Encryption: none
+Comment: eddsa-key-20240101
+Public-Lines: 2
+AAAAC3NzaC1lZDI1NTE5AAAAIHk8TjWzA5cTiDc3RKbNksQUfPSoqwgqmjSdbMZT
+o2yK
+Private-Lines: 1
+AAAAIN0frXoKq8k1vX1tGhv7WhvLBHGIEmhVAdXptKjF5mRi
+Private-MAC: 4a1e5bfbf97c4aa5e1664fdc2dd41fd3bbe0b4d0b4fc6f2d3af04a4bd7c5b69e
+`
+
+func TestParsePrivateKeyOpenSSH(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "id_ed25519")
+	signer := writeTestKey(t, path)
+	pemBytes, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	parsed, err := parsePrivateKey(pemBytes, path, nil)
+	require.NoError(t, err)
+	assert.Equal(t, signer.PublicKey().Marshal(), parsed.PublicKey().Marshal())
+}
+
+func TestParsePrivateKeySurroundingText(t *testing.T) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.MarshalECPrivateKey(priv)
+	require.NoError(t, err)
+	pemBytes := append([]byte("# deploy key for the hypervisors\n# rotated yearly\n"),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})...)
+	pemBytes = append(pemBytes, []byte("trailing notes\n")...)
+
+	parsed, err := parsePrivateKey(pemBytes, "id_ecdsa", nil)
+	require.NoError(t, err)
+	expected, err := ssh.NewPublicKey(&priv.PublicKey)
+	require.NoError(t, err)
+	assert.Equal(t, expected.Marshal(), parsed.PublicKey().Marshal())
+}
+
+func TestParsePrivateKeyErrors(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "id_ed25519")
+	signer := writeTestKey(t, path)
+
+	for _, tc := range []struct {
+		name     string
+		key      []byte
+		expected string
+	}{
+		{"key.ppk", []byte(testPuTTYKey), "'key.ppk' is a PuTTY key, which is not supported; convert it to OpenSSH format with 'puttygen key.ppk -O private-openssh -o <new file>'"},
+		{"id_ed25519.pub", ssh.MarshalAuthorizedKey(signer.PublicKey()), "'id_ed25519.pub' is a public key"},
+		{"key.p8", pem.EncodeToMemory(&pem.Block{Type: "ENCRYPTED PRIVATE KEY", Bytes: []byte{0x30}}), "is an encrypted PKCS#8 key"},
+		{"key.pem", pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: []byte{0x30}}), "unsupported key type 'CERTIFICATE'"},
+		{"empty", []byte("not a key\n"), "'empty' does not contain a PEM encoded private key"},
+	} {
+		_, err := parsePrivateKey(tc.key, tc.name, nil)
+		require.Error(t, err, tc.name)
+		assert.Contains(t, err.Error(), tc.expected, tc.name)
+	}
+}
+
+func TestParsePrivateKeyPassphrase(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	block, err := ssh.MarshalPrivateKeyWithPassphrase(priv, "", []byte("s3cret"))
+	require.NoError(t, err)
+	pemBytes := pem.EncodeToMemory(block)
+
+	_, err = parsePrivateKey(pemBytes, "id_ed25519", nil)
+	assert.ErrorContains(t, err, "'id_ed25519' is passphrase protected, set keyfile_passphrase")
+
+	_, err = parsePrivateKey(pemBytes, "id_ed25519", []byte("wrong"))
+	assert.EqualError(t, err, "wrong keyfile_passphrase for 'id_ed25519'")
+
+	parsed, err := parsePrivateKey(pemBytes, "id_ed25519", []byte("s3cret"))
+	require.NoError(t, err)
+	expected, err := ssh.NewPublicKey(priv.Public())
+	require.NoError(t, err)
+	assert.Equal(t, expected.Marshal(), parsed.PublicKey().Marshal())
+}
+
+func TestDialSSHEncryptedKey(t *testing.T) {
+	s := newTestSSHServer(t)
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	block, err := ssh.MarshalPrivateKeyWithPassphrase(priv, "", []byte("s3cret"))
+	require.NoError(t, err)
+	keyPath := filepath.Join(t.TempDir(), "id_ed25519")
+	require.NoError(t, os.WriteFile(keyPath, pem.EncodeToMemory(block), 0600))
+	signer, err := ssh.NewSignerFromKey(priv)
+	require.NoError(t, err)
+	s.Authorize(signer.PublicKey())
+
+	// without the passphrase the key is left out instead of offering a
+	// nil signer
+	u := testSSHURI(t, s, "sshauth=privkey,ssh-password&keyfile="+keyPath)
+	assert.Equal(t, []string{"ssh-password"}, u.parseAuthMethods(nil).names)
+
+	// but the dial failure says why
+	u = testSSHURI(t, s, "sshauth=privkey&keyfile="+keyPath)
+	_, err = u.Dial()
+	assert.ErrorContains(t, err, "could not configure SSH authentication methods (unusable keys: '"+keyPath+"' is passphrase protected, set keyfile_passphrase")
+
+	other := filepath.Join(t.TempDir(), "id_ecdsa")
+	writeTestKey(t, other)
+	u = testSSHURI(t, s, "sshauth=privkey&keyfile="+keyPath+","+other)
+	_, err = u.Dial()
+	assert.ErrorContains(t, err, "(unusable keys: '"+keyPath+"' is passphrase protected, set keyfile_passphrase")
+	assert.ErrorContains(t, err, "permission denied (publickey)")
+
+	u = testSSHURI(t, s, "sshauth=privkey&keyfile="+keyPath+"&keyfile_passphrase=s3cret")
+	conn, err := u.Dial()
+	require.NoError(t, err)
+	conn.Close()
+
+	t.Setenv("LIBVIRT_SSH_KEY_PASSPHRASE", "s3cret")
+	u = testSSHURI(t, s, "sshauth=privkey&keyfile="+keyPath)
+	assert.Equal(t, []string{"privkey"}, u.parseAuthMethods(nil).names)
+}
+
+func TestDialSSHDefaultKeys(t *testing.T) {
+	s := newTestSSHServer(t)
+	t.Setenv("HTTP_PROXY", "")
+	t.Setenv("ALL_PROXY", "")
+
+	// only the second of the default keys is authorized, id_rsa is missing
+	u := testSSHURI(t, s, "sshauth=privkey")
+	sshDir := filepath.Join(os.Getenv("HOME"), ".ssh")
+	require.NoError(t, os.Mkdir(sshDir, 0700))
+	writeTestKey(t, filepath.Join(sshDir, "id_ed25519"))
+	s.Authorize(writeTestKey(t, filepath.Join(sshDir, "id_ecdsa")).PublicKey())
+	conn, err := u.Dial()
+	require.NoError(t, err)
+	conn.Close()
+
+	// every key of keyfile is offered, missing ones are skipped
+	dir := t.TempDir()
+	s.Authorize(writeTestKey(t, filepath.Join(dir, "ci_key")).PublicKey())
+	u = testSSHURI(t, s, "sshauth=privkey&keyfile="+filepath.Join(dir, "missing")+","+filepath.Join(dir, "ci_key"))
+	conn, err = u.Dial()
+	require.NoError(t, err)
+	conn.Close()
+
+	u = testSSHURI(t, s, "sshauth=privkey")
+	assert.Empty(t, u.parseAuthMethods(nil).names)
+}
@@ -0,0 +1,88 @@
+package uri
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// transportErrorWait bounds how long a failed read or write waits for the
+// SSH connection to report why it went down. The channels of the
+// connection are closed just before its error is known.
+const transportErrorWait = 100 * time.Millisecond
+
+// sshTransport is the SSH connection carrying a Conn, watched for the
+// error it ends with.
+type sshTransport struct {
+	host string
+	done chan struct{}
+	err  error
+}
+
+// watchTransport records the error client ends with, so that the reads and
+// writes failing because of it can tell a failed key re-exchange from the
+// connection being closed.
+func (c *Conn) watchTransport(client *ssh.Client, host string) {
+	t := &sshTransport{host: host, done: make(chan struct{})}
+	c.ssh = t
+	go func() {
+		t.err = client.Wait()
+		close(t.done)
+	}()
+}
+
+// transportError returns err, the error of a read or write, naming the
+// failed key re-exchange of the SSH connection when that is what brought it
+// down, rather than the bare EOF the channel reports.
+func (c *Conn) transportError(err error) error {
+	if c.ssh == nil {
+		return err
+	}
+	select {
+	case <-c.ssh.done:
+	case <-time.After(transportErrorWait):
+		return err
+	}
+	if reason := rekeyFailure(c.ssh.err); reason != "" {
+		return fmt.Errorf("SSH key re-exchange with %s failed on the established connection: %s: %w", c.ssh.host, reason, c.ssh.err)
+	}
+	return err
+}
+
+// rekeyFailure names the reason a key exchange failed, or returns "" when
+// err, the error an established SSH connection ended with, is not a key
+// exchange failure. Once authenticated, the only key exchanges are the
+// re-exchanges either side starts every gigabyte or so.
+func rekeyFailure(err error) string {
+	if err == nil {
+		return ""
+	}
+	var hkErr *hostKeyError
+	switch {
+	case errors.As(err, &hkErr):
+		return "host key verification failed"
+	case strings.Contains(err.Error(), "no common algorithm"):
+		return "no common algorithm"
+	}
+	for _, marker := range kexErrorMarkers {
+		if strings.Contains(err.Error(), marker) {
+			return "key exchange failed"
+		}
+	}
+	return ""
+}
+
+// kexErrorMarkers are found in the errors of x/crypto failing a key
+// exchange, and in the disconnect of a server failing it (reason 3).
+var kexErrorMarkers = []string{
+	"key exchange",
+	"msgKexInit",
+	"msgNewKeys",
+	"signature did not verify",
+	"DH parameter",
+	"curve25519 public value",
+	"disconnect, reason 3:",
+}
@@ -0,0 +1,56 @@
+package uri
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+)
+
+const (
+	defaultRendezvousTimeout = time.Minute
+)
+
+// listenRendezvous opens the listener on which the remote end of a reverse
+// tunnel terminates.
+var listenRendezvous = net.Listen
+
+// acceptRendezvous waits for the remote host to connect to the rendezvous
+// address and adopts that connection as the transport for SSH. This supports
+// hosts behind NAT that open a tunnel outwards instead of accepting
+// connections.
+func (u *ConnectionURI) acceptRendezvous(ctx context.Context, address string) (net.Conn, error) {
+	l, err := listenRendezvous("tcp", address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on rendezvous address %s: %w", address, err)
+	}
+	defer l.Close()
+
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, defaultRendezvousTimeout)
+		defer cancel()
+	}
+
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+	accepted := make(chan result, 1)
+	go func() {
+		conn, err := l.Accept()
+		accepted <- result{conn, err}
+	}()
+
+	u.logf("[DEBUG] waiting for the remote host to connect to rendezvous address %s", address)
+	select {
+	case r := <-accepted:
+		if r.err != nil {
+			return nil, fmt.Errorf("failed to accept tunnel on rendezvous address %s: %w", address, r.err)
+		}
+		u.logf("[DEBUG] remote host connected from %s", r.conn.RemoteAddr())
+		return r.conn, nil
+	case <-ctx.Done():
+		return nil, fmt.Errorf("no tunnel arrived on rendezvous address %s: %w", address, ctx.Err())
+	}
+}
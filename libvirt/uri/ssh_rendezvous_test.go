@@ -0,0 +1,88 @@
+package uri
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// chanListener is a net.Listener accepting the connections sent to it.
+type chanListener struct {
+	conns chan net.Conn
+	done  chan struct{}
+}
+
+func (l *chanListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *chanListener) Close() error {
+	select {
+	case <-l.done:
+	default:
+		close(l.done)
+	}
+	return nil
+}
+
+func (l *chanListener) Addr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)}
+}
+
+// connPair returns both ends of a connected loopback socket. Unlike
+// net.Pipe it is buffered, so both SSH sides can send their version at once.
+func connPair(t *testing.T) (net.Conn, net.Conn) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		c, _ := l.Accept()
+		accepted <- c
+	}()
+	local, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	remote := <-accepted
+	require.NotNil(t, remote)
+	t.Cleanup(func() {
+		local.Close()
+		remote.Close()
+	})
+	return local, remote
+}
+
+func TestDialSSHRendezvous(t *testing.T) {
+	s := newTestSSHServer(t)
+
+	var listened string
+	oldListenRendezvous := listenRendezvous
+	listenRendezvous = func(network, address string) (net.Listener, error) {
+		listened = address
+		l := &chanListener{conns: make(chan net.Conn, 1), done: make(chan struct{})}
+		local, remote := connPair(t)
+		// the hypervisor dials out to the rendezvous point and serves SSH
+		// over the tunnel
+		go s.handleConn(remote)
+		l.conns <- local
+		return l, nil
+	}
+	defer func() { listenRendezvous = oldListenRendezvous }()
+
+	// the host in the URI is only a name, it is never dialed
+	u := testSSHURI(t, s, "rendezvous=0.0.0.0:2200&socket=/var/run/libvirt/libvirt-sock")
+	u.Host = "edge-node-42"
+
+	conn, err := u.Dial()
+	require.NoError(t, err)
+	conn.Close()
+	assert.Equal(t, "0.0.0.0:2200", listened)
+	assert.Equal(t, []string{"/var/run/libvirt/libvirt-sock"}, s.DialedSockets())
+}
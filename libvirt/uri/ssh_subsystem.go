@@ -0,0 +1,79 @@
+package uri
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// subsystemConn adapts the stdin/stdout of an SSH session running a
+// subsystem to a net.Conn, so that libvirt RPC can be spoken over it.
+type subsystemConn struct {
+	io.Reader
+	io.WriteCloser
+	session *ssh.Session
+	client  *ssh.Client
+}
+
+func (c *subsystemConn) Close() error {
+	c.WriteCloser.Close()
+	return c.session.Close()
+}
+
+func (c *subsystemConn) LocalAddr() net.Addr {
+	return c.client.LocalAddr()
+}
+
+func (c *subsystemConn) RemoteAddr() net.Addr {
+	return c.client.RemoteAddr()
+}
+
+// SetDeadline exists to satisfy the net.Conn interface but is not
+// implemented by this type, the same as for ssh forwarded channels.
+func (c *subsystemConn) SetDeadline(t time.Time) error {
+	return errors.New("ssh: subsystem: deadline not supported")
+}
+
+func (c *subsystemConn) SetReadDeadline(t time.Time) error {
+	return c.SetDeadline(t)
+}
+
+func (c *subsystemConn) SetWriteDeadline(t time.Time) error {
+	return c.SetDeadline(t)
+}
+
+// dialSubsystem opens a session on the client and requests the given
+// subsystem, returning the session streams as a connection.
+func dialSubsystem(client *ssh.Client, name string) (net.Conn, error) {
+	session, err := client.NewSession()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open session for subsystem '%s': %w", name, err)
+	}
+
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		session.Close()
+		return nil, err
+	}
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		session.Close()
+		return nil, err
+	}
+
+	if err := session.RequestSubsystem(name); err != nil {
+		session.Close()
+		return nil, fmt.Errorf("failed to request subsystem '%s': %w", name, err)
+	}
+
+	return &subsystemConn{
+		Reader:      stdout,
+		WriteCloser: stdin,
+		session:     session,
+		client:      client,
+	}, nil
+}
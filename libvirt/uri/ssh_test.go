@@ -0,0 +1,727 @@
+package uri
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kevinburke/ssh_config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// testSSHURI builds a qemu+ssh URI pointing at the given test server, using
+// password authentication and no host key verification unless params
+// override them.
+func testSSHURI(t *testing.T, s *testSSHServer, params string) *ConnectionURI {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("SSH_AUTH_SOCK", "")
+
+	u, err := Parse(fmt.Sprintf("qemu+ssh://%s:%s@%s/system?sshauth=ssh-password&no_verify=1",
+		testSSHUser, testSSHPassword, s.Addr()))
+	require.NoError(t, err)
+
+	overrides, err := url.ParseQuery(params)
+	require.NoError(t, err)
+	q := u.Query()
+	for k, v := range overrides {
+		q[k] = v
+	}
+	u.RawQuery = q.Encode()
+	return u
+}
+
+func TestDialSSHSocketFailureClosesConnection(t *testing.T) {
+	s := newTestSSHServer(t)
+	s.streamlocal = nil
+	closed := make(chan struct{}, 1)
+	s.connected = func(conn *ssh.ServerConn) {
+		conn.Wait()
+		closed <- struct{}{}
+	}
+
+	for _, params := range []string{"keepalive_interval=20ms", "keepalive_interval=20ms&subsystem=libvirt"} {
+		_, err := testSSHURI(t, s, params).Dial()
+		require.Error(t, err, params)
+		assert.Contains(t, err.Error(), "failed to connect to libvirt on the remote host")
+
+		// the SSH connection is closed along with its keepalives
+		select {
+		case <-closed:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("the SSH connection is still open after the socket dial failed with %s", params)
+		}
+	}
+}
+
+func TestDialSSHRequireArch(t *testing.T) {
+	s := newTestSSHServer(t)
+	s.exec["uname -m"] = "aarch64\n"
+
+	u := testSSHURI(t, s, "require_arch=x86_64")
+	_, err := u.Dial()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "remote host architecture 'aarch64' does not match required architecture 'x86_64'")
+	assert.Empty(t, s.DialedSockets())
+
+	u = testSSHURI(t, s, "require_arch=arm64&socket=/run/libvirt/libvirt-sock")
+	conn, err := u.Dial()
+	require.NoError(t, err)
+	conn.Close()
+	assert.Equal(t, []string{"/run/libvirt/libvirt-sock"}, s.DialedSockets())
+}
+
+func TestNormalizeArch(t *testing.T) {
+	assert.Equal(t, "x86_64", normalizeArch("amd64"))
+	assert.Equal(t, "x86_64", normalizeArch("x86_64\n"))
+	assert.Equal(t, "aarch64", normalizeArch("ARM64"))
+	assert.Equal(t, "ppc64le", normalizeArch("ppc64le"))
+}
+
+func TestDialSSHSubsystem(t *testing.T) {
+	s := newTestSSHServer(t)
+	s.subsystems["libvirt"] = func(ch ssh.Channel) {
+		io.Copy(ch, ch)
+	}
+
+	u := testSSHURI(t, s, "subsystem=libvirt")
+	conn, err := u.Dial()
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// the first bytes of a libvirt RPC packet: length, program and version
+	handshake := []byte{0x00, 0x00, 0x00, 0x1c, 0x20, 0x00, 0x80, 0x86, 0x00, 0x00, 0x00, 0x01}
+	_, err = conn.Write(handshake)
+	require.NoError(t, err)
+
+	reply := make([]byte, len(handshake))
+	_, err = io.ReadFull(conn, reply)
+	require.NoError(t, err)
+	assert.Equal(t, handshake, reply)
+	assert.Empty(t, s.DialedSockets())
+
+	u = testSSHURI(t, s, "subsystem=unknown")
+	_, err = u.Dial()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to request subsystem 'unknown'")
+}
+
+// testSSHURIWithKnownHosts builds a qemu+ssh URI pointing at the given test
+// server that verifies host keys against a known_hosts file with the given
+// lines.
+func testSSHURIWithKnownHosts(t *testing.T, s *testSSHServer, lines []string, params string) *ConnectionURI {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("SSH_AUTH_SOCK", "")
+
+	knownHostsPath := filepath.Join(t.TempDir(), "known_hosts")
+	require.NoError(t, os.WriteFile(knownHostsPath, []byte(strings.Join(lines, "\n")+"\n"), 0600))
+
+	u, err := Parse(fmt.Sprintf("qemu+ssh://%s:%s@%s/system?sshauth=ssh-password&knownhosts=%s&%s",
+		testSSHUser, testSSHPassword, s.Addr(), knownHostsPath, params))
+	require.NoError(t, err)
+	return u
+}
+
+func TestDialSSHStrictHostKeyVerification(t *testing.T) {
+	s := newTestSSHServer(t)
+	other := newTestSSHServer(t)
+	addr, err := net.ResolveTCPAddr("tcp", s.Addr())
+	require.NoError(t, err)
+
+	known := knownhosts.Line([]string{knownhosts.Normalize(s.Addr())}, s.hostKey.PublicKey())
+	u := testSSHURIWithKnownHosts(t, s, []string{known}, "")
+	conn, err := u.Dial()
+	require.NoError(t, err)
+	conn.Close()
+
+	// same address, but a different key than the one on record
+	changed := knownhosts.Line([]string{knownhosts.Normalize(s.Addr())}, other.hostKey.PublicKey())
+	u = testSSHURIWithKnownHosts(t, s, []string{changed}, "")
+	cb, err := u.hostKeyCallback(context.Background(), nil)
+	require.NoError(t, err)
+	err = cb(s.Addr(), addr, s.hostKey.PublicKey())
+	var keyErr *knownhosts.KeyError
+	require.ErrorAs(t, err, &keyErr)
+	assert.NotEmpty(t, keyErr.Want)
+
+	// no entry at all for the address
+	unknown := knownhosts.Line([]string{knownhosts.Normalize(other.Addr())}, other.hostKey.PublicKey())
+	u = testSSHURIWithKnownHosts(t, s, []string{unknown}, "")
+	cb, err = u.hostKeyCallback(context.Background(), nil)
+	require.NoError(t, err)
+	err = cb(s.Addr(), addr, s.hostKey.PublicKey())
+	require.ErrorAs(t, err, &keyErr)
+	assert.Empty(t, keyErr.Want)
+}
+
+func TestDialSSHSingleAttempt(t *testing.T) {
+	s := newTestSSHServer(t)
+	s.Configure(func(config *ssh.ServerConfig) {
+		config.MaxAuthTries = 1
+	})
+
+	// a key the server does not accept, offered before the password
+	keyPath := filepath.Join(t.TempDir(), "id_ed25519")
+	writeTestKey(t, keyPath)
+
+	u := testSSHURI(t, s, "sshauth=privkey,ssh-password&keyfile="+keyPath)
+	auth := u.parseAuthMethods(nil)
+	assert.Equal(t, []string{"privkey", "ssh-password"}, auth.names)
+	_, _, err := u.sshClient(context.Background(), nil, ssh.ClientConfig{
+		User:            testSSHUser,
+		Auth:            auth.methods,
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	require.Error(t, err)
+	err = auth.explainError(err, testSSHUser, "127.0.0.1")
+	assert.Contains(t, err.Error(), "too many authentication failures (offered methods: privkey, ssh-password)")
+	assert.Contains(t, err.Error(), "single_attempt=1")
+
+	u = testSSHURI(t, s, "sshauth=privkey,ssh-password&keyfile="+keyPath+"&single_attempt=1&single_attempt_method=ssh-password")
+	auth = u.parseAuthMethods(nil)
+	assert.Equal(t, []string{"ssh-password"}, auth.names)
+	assert.Len(t, auth.methods, 1)
+
+	conn, err := u.Dial()
+	require.NoError(t, err)
+	conn.Close()
+}
+
+func TestDialSSHPublicKeyDenied(t *testing.T) {
+	s := newTestSSHServer(t)
+	dir := t.TempDir()
+	authorized := writeTestKey(t, filepath.Join(dir, "authorized"))
+	s.Authorize(authorized.PublicKey())
+
+	keyPath := filepath.Join(dir, "id_ed25519")
+	offered := writeTestKey(t, keyPath)
+
+	u := testSSHURI(t, s, "sshauth=privkey&keyfile="+keyPath)
+	auth := u.parseAuthMethods(nil)
+	_, _, err := u.sshClient(context.Background(), nil, ssh.ClientConfig{
+		User:            testSSHUser,
+		Auth:            auth.methods,
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	require.Error(t, err)
+	err = auth.explainError(err, testSSHUser, "127.0.0.1")
+	assert.Contains(t, err.Error(), "permission denied (publickey) for testuser@127.0.0.1")
+	assert.Contains(t, err.Error(), "offered keys "+ssh.FingerprintSHA256(offered.PublicKey())+" (from key files)")
+	assert.Contains(t, err.Error(), "authorized_keys")
+	assert.NotContains(t, err.Error(), ssh.FingerprintSHA256(authorized.PublicKey()))
+
+	// the right key gets in
+	u = testSSHURI(t, s, "sshauth=privkey&keyfile="+filepath.Join(dir, "authorized"))
+	conn, err := u.Dial()
+	require.NoError(t, err)
+	conn.Close()
+}
+
+func TestDialSSHAbstractSocket(t *testing.T) {
+	s := newTestSSHServer(t)
+
+	for _, socket := range []string{"@libvirt-sock", "%00libvirt-sock"} {
+		u := testSSHURI(t, s, "socket="+socket)
+		conn, err := u.Dial()
+		require.NoError(t, err)
+		conn.Close()
+	}
+	assert.Equal(t, []string{"\x00libvirt-sock", "\x00libvirt-sock"}, s.DialedSockets())
+}
+
+func TestSSHUsername(t *testing.T) {
+	oldCurrentUser := currentUser
+	currentUser = func() (*user.User, error) {
+		return nil, errors.New("user: unknown userid 1000")
+	}
+	defer func() { currentUser = oldCurrentUser }()
+
+	sshcfg, err := ssh_config.Decode(strings.NewReader("Host configured\n  User fromconfig\n"))
+	require.NoError(t, err)
+
+	username := func(uri string) (string, error) {
+		u, err := Parse(uri)
+		require.NoError(t, err)
+		return u.sshUsername(sshcfg)
+	}
+
+	t.Setenv("USER", "ci")
+	t.Setenv("LOGNAME", "")
+	name, err := username("qemu+ssh://somehost/system")
+	require.NoError(t, err)
+	assert.Equal(t, "ci", name)
+
+	t.Setenv("USER", "")
+	t.Setenv("LOGNAME", "runner")
+	name, err = username("qemu+ssh://somehost/system")
+	require.NoError(t, err)
+	assert.Equal(t, "runner", name)
+
+	name, err = username("qemu+ssh://configured/system")
+	require.NoError(t, err)
+	assert.Equal(t, "fromconfig", name)
+
+	name, err = username("qemu+ssh://configured/system?sshuser=override")
+	require.NoError(t, err)
+	assert.Equal(t, "override", name)
+
+	name, err = username("qemu+ssh://root@configured/system?sshuser=override")
+	require.NoError(t, err)
+	assert.Equal(t, "root", name)
+
+	t.Setenv("LOGNAME", "")
+	_, err = username("qemu+ssh://somehost/system")
+	assert.ErrorContains(t, err, "unable to get username")
+}
+
+func TestDialSSHAddKeysToAgent(t *testing.T) {
+	s := newTestSSHServer(t)
+	keyPath := filepath.Join(t.TempDir(), "id_ed25519")
+	signer := writeTestKey(t, keyPath)
+	s.Authorize(signer.PublicKey())
+
+	u := testSSHURI(t, s, "sshauth=privkey&keyfile="+keyPath+"&add_keys_to_agent=1&agent_key_lifetime=1h&agent_key_confirm=1")
+	a := newTestAgent(t)
+	t.Setenv("SSH_AUTH_SOCK", a.Socket)
+
+	conn, err := u.Dial()
+	require.NoError(t, err)
+	conn.Close()
+
+	added := a.Added()
+	require.Len(t, added, 1)
+	assert.Equal(t, uint32(3600), added[0].LifetimeSecs)
+	assert.True(t, added[0].ConfirmBeforeUse)
+	assert.Equal(t, keyPath, added[0].Comment)
+
+	keys, err := a.List()
+	require.NoError(t, err)
+	require.Len(t, keys, 1)
+	assert.Equal(t, signer.PublicKey().Marshal(), keys[0].Marshal())
+}
+
+func TestDialSSHNoSharedAgentState(t *testing.T) {
+	s := newTestSSHServer(t)
+	dir := t.TempDir()
+	keyPath := filepath.Join(dir, "id_ed25519")
+	signer := writeTestKey(t, keyPath)
+	s.Authorize(signer.PublicKey())
+	// a key that was in the agent all along
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	preloaded, err := ssh.NewSignerFromKey(priv)
+	require.NoError(t, err)
+	a := newTestAgent(t)
+	require.NoError(t, a.Agent.Add(agent.AddedKey{PrivateKey: priv}))
+
+	// a first connection adds its key to the agent
+	u := testSSHURI(t, s, "sshauth=privkey&keyfile="+keyPath+"&add_keys_to_agent=1")
+	t.Setenv("SSH_AUTH_SOCK", a.Socket)
+	conn, err := u.Dial()
+	require.NoError(t, err)
+	conn.Close()
+
+	// another one that is isolated does not pick it up
+	u = testSSHURI(t, s, "sshauth=agent&no_shared_agent_state=1")
+	t.Setenv("SSH_AUTH_SOCK", a.Socket)
+	auth := u.parseAuthMethods(nil)
+	_, _, err = u.sshClient(context.Background(), nil, ssh.ClientConfig{
+		User:            testSSHUser,
+		Auth:            auth.methods,
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	require.Error(t, err)
+	auth.mu.Lock()
+	assert.Equal(t, []string{ssh.FingerprintSHA256(preloaded.PublicKey())}, auth.offered)
+	auth.mu.Unlock()
+
+	// while one that is not does
+	u = testSSHURI(t, s, "sshauth=agent")
+	t.Setenv("SSH_AUTH_SOCK", a.Socket)
+	conn, err = u.Dial()
+	require.NoError(t, err)
+	conn.Close()
+}
+
+func TestDialSSHKeyboardInteractive(t *testing.T) {
+	s := newTestSSHServer(t)
+	s.Configure(func(config *ssh.ServerConfig) {
+		config.KeyboardInteractiveCallback = func(c ssh.ConnMetadata, client ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
+			answers, err := client("", "MFA required", []string{"Password: ", "Verification code: "}, []bool{false, true})
+			if err != nil {
+				return nil, err
+			}
+			if c.User() == testSSHUser && len(answers) == 2 && answers[0] == testSSHPassword && answers[1] == "123456" {
+				return nil, nil
+			}
+			return nil, ssh.ErrNoAuth
+		}
+	})
+
+	u := testSSHURI(t, s, "sshauth=keyboard-interactive&sshauth_kbd_answers=password="+testSSHPassword+",verification code=123456")
+	auth := u.parseAuthMethods(nil)
+	assert.Equal(t, []string{"keyboard-interactive"}, auth.names)
+	conn, err := u.Dial()
+	require.NoError(t, err)
+	conn.Close()
+
+	// the environment variable is used when the parameter is not set, and
+	// prompts it has no answer for are answered with nothing
+	t.Setenv("LIBVIRT_SSH_KBD_ANSWERS", "Password="+testSSHPassword)
+	u = testSSHURI(t, s, "sshauth=keyboard-interactive")
+	auth = u.parseAuthMethods(nil)
+	_, _, err = u.sshClient(context.Background(), nil, ssh.ClientConfig{
+		User:            testSSHUser,
+		Auth:            auth.methods,
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	assert.ErrorContains(t, err, "keyboard-interactive")
+	assert.Equal(t, "keyboard-interactive", auth.lastMethod())
+
+	t.Setenv("LIBVIRT_SSH_KBD_ANSWERS", "")
+	u = testSSHURI(t, s, "sshauth=keyboard-interactive,ssh-password")
+	assert.Equal(t, []string{"ssh-password"}, u.parseAuthMethods(nil).names)
+}
+
+func TestDialSSHKeyboardInteractiveAskpass(t *testing.T) {
+	s := newTestSSHServer(t)
+	s.Configure(func(config *ssh.ServerConfig) {
+		config.KeyboardInteractiveCallback = func(c ssh.ConnMetadata, client ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
+			answers, err := client("", "MFA required", []string{"Password: ", "Duo passcode: "}, []bool{false, true})
+			if err != nil {
+				return nil, err
+			}
+			if c.User() == testSSHUser && len(answers) == 2 && answers[0] == testSSHPassword && answers[1] == "passcode for Duo passcode: " {
+				return nil, nil
+			}
+			return nil, ssh.ErrNoAuth
+		}
+	})
+
+	// the prompts sshauth_kbd_answers has no answer for go to the command
+	askpass := filepath.Join(t.TempDir(), "askpass")
+	require.NoError(t, os.WriteFile(askpass, []byte("#!/bin/sh\necho \"passcode for $1\"\n"), 0o700))
+	u := testSSHURI(t, s, "sshauth=keyboard-interactive&sshauth_kbd_answers=password="+testSSHPassword+"&sshauth_askpass="+url.QueryEscape(askpass))
+	conn, err := u.Dial()
+	require.NoError(t, err)
+	conn.Close()
+
+	// the environment variable is used when the parameter is not set, and
+	// is enough for the method to be offered
+	t.Setenv("LIBVIRT_SSH_ASKPASS", askpass)
+	u = testSSHURI(t, s, "sshauth=keyboard-interactive")
+	assert.Equal(t, []string{"keyboard-interactive"}, u.parseAuthMethods(nil).names)
+
+	// a failing command fails the method, without its output in the error
+	failing := filepath.Join(t.TempDir(), "askpass")
+	require.NoError(t, os.WriteFile(failing, []byte("#!/bin/sh\necho s3cret\nexit 1\n"), 0o700))
+	_, err = askpassAnswer(failing, "Password: ")
+	assert.ErrorContains(t, err, "askpass command")
+	assert.NotContains(t, err.Error(), "s3cret")
+}
+
+func TestDialSSHProxyPerHost(t *testing.T) {
+	proxied := newTestSSHServer(t)
+	direct := newTestSSHServer(t)
+	p := newTestSOCKSProxy(t)
+	t.Setenv("HTTP_PROXY", "")
+	t.Setenv("ALL_PROXY", "")
+
+	u := testSSHURI(t, proxied, "proxy=socks5://"+p.Addr())
+	conn, err := u.Dial()
+	require.NoError(t, err)
+	conn.Close()
+
+	u = testSSHURI(t, direct, "")
+	conn, err = u.Dial()
+	require.NoError(t, err)
+	conn.Close()
+
+	assert.Equal(t, []string{proxied.Addr()}, p.Requests())
+	assert.Equal(t, []string{defaultUnixSock}, proxied.DialedSockets())
+	assert.Equal(t, []string{defaultUnixSock}, direct.DialedSockets())
+}
+
+func TestSSHProxy(t *testing.T) {
+	t.Setenv("HTTP_PROXY", "")
+	t.Setenv("ALL_PROXY", "tcp://proxy.example.com:1080")
+
+	sshcfg, err := ssh_config.Decode(strings.NewReader("Host direct.example.com\n  ProxyCommand none\n"))
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		uri      string
+		expected string
+	}{
+		{"qemu+ssh://libvirt.example.com/system", "tcp://proxy.example.com:1080"},
+		{"qemu+ssh://direct.example.com/system", ""},
+		{"qemu+ssh://direct.example.com:2222/system", ""},
+		{"qemu+ssh://direct.example.com/system?proxy=socks5://other.example.com:1080", "socks5://other.example.com:1080"},
+		{"qemu+ssh://libvirt.example.com/system?proxy=none", ""},
+	} {
+		u, err := Parse(tc.uri)
+		require.NoError(t, err)
+		assert.Equal(t, tc.expected, u.sshProxy(sshcfg), tc.uri)
+	}
+}
+
+func TestDialSSHPrivateKey(t *testing.T) {
+	s := newTestSSHServer(t)
+	keyPath := filepath.Join(t.TempDir(), "id_ed25519")
+	signer := writeTestKey(t, keyPath)
+	s.Authorize(signer.PublicKey())
+	pemBytes, err := os.ReadFile(keyPath)
+	require.NoError(t, err)
+	require.NoError(t, os.Remove(keyPath))
+
+	u := testSSHURI(t, s, "sshauth=privkey&keyfile="+keyPath)
+	u.PrivateKey = pemBytes
+	conn, err := u.Dial()
+	require.NoError(t, err)
+	conn.Close()
+	assert.NotContains(t, u.String(), "PRIVATE KEY")
+}
+
+func TestSSHConfigInline(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config")
+	require.NoError(t, os.WriteFile(path, []byte("Host *\n  User fromfile\n"), 0600))
+
+	u, err := Parse("qemu+ssh://libvirt.example.com/system?ssh_config=" + path)
+	require.NoError(t, err)
+	username, err := u.sshUsername(u.sshConfig())
+	require.NoError(t, err)
+	assert.Equal(t, "fromfile", username)
+
+	u.SSHConfig = "Host libvirt.example.com\n  User inline\n"
+	username, err = u.sshUsername(u.sshConfig())
+	require.NoError(t, err)
+	assert.Equal(t, "inline", username)
+}
+
+func TestDialSSHConfigHostAlias(t *testing.T) {
+	s := newTestSSHServer(t)
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("SSH_AUTH_SOCK", "")
+	t.Setenv("HTTP_PROXY", "")
+	t.Setenv("ALL_PROXY", "")
+	host, port, err := net.SplitHostPort(s.Addr())
+	require.NoError(t, err)
+	keyPath := filepath.Join(t.TempDir(), "prod_key")
+	signer := writeTestKey(t, keyPath)
+	s.Authorize(signer.PublicKey())
+
+	u, err := Parse("qemu+ssh://libvirt-prod/system?sshauth=privkey&no_verify=1")
+	require.NoError(t, err)
+	u.SSHConfig = fmt.Sprintf("Host libvirt-prod\n  HostName %s\n  Port %s\n  User %s\n  IdentityFile %s\n",
+		host, port, testSSHUser, keyPath)
+	conn, err := u.Dial()
+	require.NoError(t, err)
+	conn.Close()
+
+	// the URI wins over the ssh config, which still applies to the host
+	// when the URI has a port
+	u, err = Parse("qemu+ssh://libvirt-prod:" + closedPort(t) + "/system?sshauth=privkey&keyfile=/nonexistent")
+	require.NoError(t, err)
+	u.SSHConfig = "Host libvirt-prod\n  HostName 192.0.2.1\n  Port 2222\n  User admin\n  IdentityFile " + keyPath + "\n"
+	sshcfg := u.sshConfig()
+	assert.Equal(t, "192.0.2.1", u.sshHostName(sshcfg))
+	assert.NotEqual(t, "2222", u.sshPort(sshcfg))
+	username, err := u.sshUsername(sshcfg)
+	require.NoError(t, err)
+	assert.Equal(t, "admin", username)
+	assert.Empty(t, u.parseAuthMethods(sshcfg).names)
+
+	u.SSHConfig = "Host libvirt-prod\n  HostName %h.example.com\n"
+	assert.Equal(t, "libvirt-prod.example.com", u.sshHostName(u.sshConfig()))
+}
+
+func TestSSHClientIPv6Address(t *testing.T) {
+	s := newTestSSHServer(t)
+	t.Setenv("HTTP_PROXY", "")
+	t.Setenv("ALL_PROXY", "")
+	_, port, err := net.SplitHostPort(s.Addr())
+	require.NoError(t, err)
+
+	// the IPv6 literal is dialed as 127.0.0.1, the host key is still
+	// verified for the bracketed address
+	u, err := Parse("qemu+ssh://" + testSSHUser + "@[::1]:" + port + "/system?resolved_ip=127.0.0.1")
+	require.NoError(t, err)
+	var hostname string
+	client, _, err := u.sshClient(context.Background(), nil, ssh.ClientConfig{
+		User: testSSHUser,
+		Auth: []ssh.AuthMethod{ssh.Password(testSSHPassword)},
+		HostKeyCallback: func(h string, _ net.Addr, _ ssh.PublicKey) error {
+			hostname = h
+			return nil
+		},
+	})
+	require.NoError(t, err)
+	client.Close()
+	assert.Equal(t, "[::1]:"+port, hostname)
+
+	u, err = Parse("qemu+ssh://[::1]/system")
+	require.NoError(t, err)
+	assert.Equal(t, "[::1]:22", net.JoinHostPort(u.sshHostName(nil), u.sshPort(nil)))
+}
+
+func TestDialSSHConfigInline(t *testing.T) {
+	s := newTestSSHServer(t)
+	t.Setenv("HTTP_PROXY", "")
+	t.Setenv("ALL_PROXY", "tcp://"+net.JoinHostPort("127.0.0.1", closedPort(t)))
+
+	u := testSSHURI(t, s, "")
+	u.SSHConfig = "Host 127.0.0.1\n  ProxyCommand none\n"
+	conn, err := u.Dial()
+	require.NoError(t, err)
+	conn.Close()
+	assert.Equal(t, []string{defaultUnixSock}, s.DialedSockets())
+}
+
+func TestDialSSHReadOnly(t *testing.T) {
+	s := newTestSSHServer(t)
+
+	u := testSSHURI(t, s, "readonly=1")
+	conn, err := u.Dial()
+	require.NoError(t, err)
+	conn.Close()
+
+	u = testSSHURI(t, s, "readonly=1&socket=/run/libvirt/libvirt-sock")
+	conn, err = u.Dial()
+	require.NoError(t, err)
+	conn.Close()
+
+	assert.Equal(t, []string{defaultUnixSockRO, "/run/libvirt/libvirt-sock"}, s.DialedSockets())
+}
+
+func TestHostKeyCallbackPerHost(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	knownHostsPath := filepath.Join(t.TempDir(), "known_hosts")
+	require.NoError(t, os.WriteFile(knownHostsPath, nil, 0600))
+
+	sshcfg, err := ssh_config.Decode(strings.NewReader(
+		"Host lab.example.com\n  StrictHostKeyChecking no\n\nHost prod.example.com\n  StrictHostKeyChecking yes\n"))
+	require.NoError(t, err)
+
+	s := newTestSSHServer(t)
+	addr, err := net.ResolveTCPAddr("tcp", s.Addr())
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		host   string
+		params string
+		strict bool
+	}{
+		{"lab.example.com", "", false},
+		{"prod.example.com", "", true},
+		{"other.example.com", "", true},
+		// the URI parameter wins over the ssh config
+		{"lab.example.com", "&known_hosts_verify=normal", true},
+		{"prod.example.com", "&known_hosts_verify=ignore", false},
+	} {
+		u, err := Parse("qemu+ssh://" + tc.host + "/system?knownhosts=" + knownHostsPath + tc.params)
+		require.NoError(t, err)
+		cb, err := u.hostKeyCallback(context.Background(), sshcfg)
+		require.NoError(t, err)
+		err = cb(tc.host+":22", addr, s.hostKey.PublicKey())
+		if tc.strict {
+			var keyErr *knownhosts.KeyError
+			assert.ErrorAs(t, err, &keyErr, tc.host+tc.params)
+		} else {
+			assert.NoError(t, err, tc.host+tc.params)
+		}
+	}
+}
+
+func TestDialSSHHostThroughProxy(t *testing.T) {
+	bastion := newTestSSHServer(t)
+	p := newTestSOCKSProxy(t)
+	host, port, err := net.SplitHostPort(bastion.Addr())
+	require.NoError(t, err)
+
+	// the first hop can be any host, not only the target of the URI
+	u, err := Parse("qemu+ssh://target.example.com/system")
+	require.NoError(t, err)
+	conn, err := u.dialSSHHost(context.Background(), "socks5://"+p.Addr(), host, port)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	c, chans, reqs, err := ssh.NewClientConn(conn, bastion.Addr(), &ssh.ClientConfig{
+		User:            testSSHUser,
+		Auth:            []ssh.AuthMethod{ssh.Password(testSSHPassword)},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	require.NoError(t, err)
+	ssh.NewClient(c, chans, reqs).Close()
+	assert.Equal(t, []string{bastion.Addr()}, p.Requests())
+}
+
+func TestDialSSHResolvedIP(t *testing.T) {
+	s := newTestSSHServer(t)
+	_, port, err := net.SplitHostPort(s.Addr())
+	require.NoError(t, err)
+
+	oldLookupHost := lookupHost
+	lookupHost = func(ctx context.Context, host string) ([]string, error) {
+		return nil, &net.DNSError{Err: "server misbehaving", Name: host}
+	}
+	defer func() { lookupHost = oldLookupHost }()
+
+	// the host key is on record for the name only, not for the address
+	known := knownhosts.Line([]string{knownhosts.Normalize("hv1.example.com:" + port)}, s.hostKey.PublicKey())
+	u := testSSHURIWithKnownHosts(t, s, []string{known}, "")
+	u.Host = "hv1.example.com:" + port
+
+	_, err = u.dialHost(context.Background(), "tcp", u.Hostname(), port)
+	require.Error(t, err)
+
+	q := u.Query()
+	q.Set("resolved_ip", "127.0.0.1")
+	u.RawQuery = q.Encode()
+	conn, err := u.Dial()
+	require.NoError(t, err)
+	conn.Close()
+
+	q.Set("resolved_ip", "hv1")
+	u.RawQuery = q.Encode()
+	_, err = u.dialHost(context.Background(), "tcp", u.Hostname(), port)
+	assert.ErrorContains(t, err, "invalid value 'hv1' for resolved_ip")
+}
+
+func TestDialSSHRequireVerified(t *testing.T) {
+	s := newTestSSHServer(t)
+
+	// no_verify leaked into the configuration
+	u := testSSHURI(t, s, "require_verified=true")
+	_, err := u.Dial()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "require_verified is set, but host key verification of 127.0.0.1 is disabled")
+
+	conn, err := testSSHURI(t, s, "").Dial()
+	require.NoError(t, err)
+	assert.False(t, conn.(*Conn).HostKeyVerified())
+	conn.Close()
+
+	u = testSSHURIWithKnownHosts(t, s, []string{knownhosts.Line([]string{knownhosts.Normalize(s.Addr())}, s.hostKey.PublicKey())}, "require_verified=1")
+	conn, err = u.Dial()
+	require.NoError(t, err)
+	assert.True(t, conn.(*Conn).HostKeyVerified())
+	conn.Close()
+
+	u.SSHConfig = "Host 127.0.0.1\n  StrictHostKeyChecking no\n"
+	_, err = u.Dial()
+	assert.ErrorContains(t, err, "require_verified is set")
+}
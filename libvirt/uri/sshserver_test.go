@@ -0,0 +1,392 @@
+package uri
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/pem"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// testSSHServer is a minimal in-process SSH server used to exercise the
+// ssh transport. It accepts password authentication for testSSHUser and
+// testSSHPassword, answers exec requests from a fixed table, hands
+// direct-streamlocal channels (remote unix socket dials) to a handler and
+// forwards direct-tcpip channels, acting as a jump host.
+type testSSHServer struct {
+	t        *testing.T
+	listener net.Listener
+	config   *ssh.ServerConfig
+	hostKey  ssh.Signer
+
+	mu sync.Mutex
+	// exec maps a command line to the output it produces
+	exec map[string]string
+	// subsystems maps a subsystem name to the handler serving it
+	subsystems map[string]func(ch ssh.Channel)
+	// streamlocal is called for every remote unix socket dial, which is
+	// rejected when it is nil
+	streamlocal func(socketPath string, ch ssh.Channel)
+	// dialedSockets records the remote socket paths that were dialed
+	dialedSockets []string
+	// dialedAddrs records the host:port addresses that were dialed
+	dialedAddrs []string
+	// globalRequests records the types of the global requests received
+	globalRequests []string
+	// sessionRequests records the types of the session requests received
+	sessionRequests []string
+	// authorizedKeys are the public keys accepted for testSSHUser
+	authorizedKeys []ssh.PublicKey
+	// connected, when set, is called for every authenticated connection
+	connected func(conn *ssh.ServerConn)
+}
+
+const (
+	testSSHUser     = "testuser"
+	testSSHPassword = "testpassword"
+)
+
+func newTestSSHServer(t *testing.T) *testSSHServer {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	hostKey, err := ssh.NewSignerFromKey(priv)
+	require.NoError(t, err)
+
+	s := &testSSHServer{
+		t:          t,
+		hostKey:    hostKey,
+		exec:       make(map[string]string),
+		subsystems: make(map[string]func(ch ssh.Channel)),
+		streamlocal: func(_ string, ch ssh.Channel) {
+			ch.Close()
+		},
+	}
+	s.config = &ssh.ServerConfig{
+		PasswordCallback: func(c ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+			if c.User() == testSSHUser && string(pass) == testSSHPassword {
+				return nil, nil
+			}
+			return nil, ssh.ErrNoAuth
+		},
+		PublicKeyCallback: func(c ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			for _, k := range s.authorizedKeys {
+				if c.User() == testSSHUser && bytes.Equal(k.Marshal(), key.Marshal()) {
+					return nil, nil
+				}
+			}
+			return nil, ssh.ErrNoAuth
+		},
+	}
+	s.config.AddHostKey(hostKey)
+
+	s.listener, err = net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { s.listener.Close() })
+
+	go s.serve()
+	return s
+}
+
+// Configure changes the server configuration used by new connections.
+func (s *testSSHServer) Configure(fn func(config *ssh.ServerConfig)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fn(s.config)
+}
+
+// Authorize adds a public key accepted for testSSHUser.
+func (s *testSSHServer) Authorize(key ssh.PublicKey) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.authorizedKeys = append(s.authorizedKeys, key)
+}
+
+// writeTestKey generates an ed25519 private key, writes it in OpenSSH format
+// to path and returns its signer.
+func writeTestKey(t *testing.T, path string) ssh.Signer {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	block, err := ssh.MarshalPrivateKey(priv, "")
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(block), 0600))
+	signer, err := ssh.NewSignerFromKey(priv)
+	require.NoError(t, err)
+	return signer
+}
+
+// Addr returns the host:port the server listens on.
+func (s *testSSHServer) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// DialedSockets returns the remote socket paths dialed so far.
+func (s *testSSHServer) DialedSockets() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.dialedSockets...)
+}
+
+// DialedAddrs returns the host:port addresses dialed through the server so
+// far.
+func (s *testSSHServer) DialedAddrs() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.dialedAddrs...)
+}
+
+// GlobalRequests returns the types of the global requests received so far.
+func (s *testSSHServer) GlobalRequests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.globalRequests...)
+}
+
+// SessionRequests returns the types of the session requests received so
+// far.
+func (s *testSSHServer) SessionRequests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.sessionRequests...)
+}
+
+func (s *testSSHServer) handleGlobalRequests(reqs <-chan *ssh.Request) {
+	for req := range reqs {
+		s.mu.Lock()
+		s.globalRequests = append(s.globalRequests, req.Type)
+		s.mu.Unlock()
+		if req.WantReply {
+			req.Reply(false, nil)
+		}
+	}
+}
+
+func (s *testSSHServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handleConn(conn)
+	}
+}
+
+func (s *testSSHServer) handleConn(conn net.Conn) {
+	defer conn.Close()
+	s.mu.Lock()
+	config := *s.config
+	s.mu.Unlock()
+	serverConn, chans, reqs, err := ssh.NewServerConn(conn, &config)
+	if err != nil {
+		return
+	}
+	go s.handleGlobalRequests(reqs)
+	s.mu.Lock()
+	connected := s.connected
+	s.mu.Unlock()
+	if connected != nil {
+		go connected(serverConn)
+	}
+
+	for newChannel := range chans {
+		switch newChannel.ChannelType() {
+		case "session":
+			go s.handleSession(newChannel)
+		case "direct-streamlocal@openssh.com":
+			go s.handleStreamLocal(newChannel)
+		case "direct-tcpip":
+			go s.handleDirectTCPIP(newChannel)
+		default:
+			newChannel.Reject(ssh.UnknownChannelType, "unsupported channel type")
+		}
+	}
+}
+
+func (s *testSSHServer) handleSession(newChannel ssh.NewChannel) {
+	ch, reqs, err := newChannel.Accept()
+	if err != nil {
+		return
+	}
+	defer ch.Close()
+
+	for req := range reqs {
+		s.mu.Lock()
+		s.sessionRequests = append(s.sessionRequests, req.Type)
+		s.mu.Unlock()
+		if req.Type == "auth-agent-req@openssh.com" {
+			req.Reply(true, nil)
+			continue
+		}
+		if req.Type == "subsystem" {
+			var payload struct{ Name string }
+			if err := ssh.Unmarshal(req.Payload, &payload); err != nil {
+				req.Reply(false, nil)
+				continue
+			}
+			s.mu.Lock()
+			handler, ok := s.subsystems[payload.Name]
+			s.mu.Unlock()
+			req.Reply(ok, nil)
+			if ok {
+				go ssh.DiscardRequests(reqs)
+				handler(ch)
+				return
+			}
+			continue
+		}
+		if req.Type != "exec" {
+			req.Reply(false, nil)
+			continue
+		}
+		var payload struct{ Command string }
+		if err := ssh.Unmarshal(req.Payload, &payload); err != nil {
+			req.Reply(false, nil)
+			continue
+		}
+		s.mu.Lock()
+		out, ok := s.exec[payload.Command]
+		s.mu.Unlock()
+		req.Reply(true, nil)
+
+		status := uint32(0)
+		if ok {
+			ch.Write([]byte(out))
+		} else {
+			status = 127
+		}
+		exitStatus := make([]byte, 4)
+		binary.BigEndian.PutUint32(exitStatus, status)
+		ch.SendRequest("exit-status", false, exitStatus)
+		return
+	}
+}
+
+func (s *testSSHServer) handleStreamLocal(newChannel ssh.NewChannel) {
+	var payload struct {
+		SocketPath string
+		Reserved0  string
+		Reserved1  uint32
+	}
+	if err := ssh.Unmarshal(newChannel.ExtraData(), &payload); err != nil {
+		newChannel.Reject(ssh.ConnectionFailed, "invalid payload")
+		return
+	}
+	s.mu.Lock()
+	s.dialedSockets = append(s.dialedSockets, payload.SocketPath)
+	handler := s.streamlocal
+	s.mu.Unlock()
+	if handler == nil {
+		newChannel.Reject(ssh.ConnectionFailed, "no such socket")
+		return
+	}
+
+	ch, reqs, err := newChannel.Accept()
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	handler(payload.SocketPath, ch)
+}
+
+func (s *testSSHServer) handleDirectTCPIP(newChannel ssh.NewChannel) {
+	var payload struct {
+		Host       string
+		Port       uint32
+		OriginHost string
+		OriginPort uint32
+	}
+	if err := ssh.Unmarshal(newChannel.ExtraData(), &payload); err != nil {
+		newChannel.Reject(ssh.ConnectionFailed, "invalid payload")
+		return
+	}
+	addr := net.JoinHostPort(payload.Host, strconv.Itoa(int(payload.Port)))
+	s.mu.Lock()
+	s.dialedAddrs = append(s.dialedAddrs, addr)
+	s.mu.Unlock()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		newChannel.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	defer conn.Close()
+	ch, reqs, err := newChannel.Accept()
+	if err != nil {
+		return
+	}
+	defer ch.Close()
+	go ssh.DiscardRequests(reqs)
+
+	done := make(chan struct{})
+	go func() {
+		io.Copy(ch, conn)
+		ch.CloseWrite()
+		close(done)
+	}()
+	io.Copy(conn, ch)
+	conn.(*net.TCPConn).CloseWrite()
+	<-done
+}
+
+// testAgent is an in-memory ssh agent that records the keys added to it.
+type testAgent struct {
+	agent.Agent
+	Socket string
+
+	mu    sync.Mutex
+	added []agent.AddedKey
+}
+
+// newTestAgent serves an ssh agent on a unix socket. The caller points
+// SSH_AUTH_SOCK to its Socket.
+func newTestAgent(t *testing.T) *testAgent {
+	a := &testAgent{
+		Agent:  agent.NewKeyring(),
+		Socket: filepath.Join(t.TempDir(), "agent.sock"),
+	}
+	l, err := net.Listen("unix", a.Socket)
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				agent.ServeAgent(a, conn)
+			}()
+		}
+	}()
+	return a
+}
+
+func (a *testAgent) Add(key agent.AddedKey) error {
+	a.mu.Lock()
+	a.added = append(a.added, key)
+	a.mu.Unlock()
+	// the in-memory keyring cannot ask for confirmation
+	key.ConfirmBeforeUse = false
+	return a.Agent.Add(key)
+}
+
+// Added returns the keys added to the agent so far.
+func (a *testAgent) Added() []agent.AddedKey {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]agent.AddedKey(nil), a.added...)
+}
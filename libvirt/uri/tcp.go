@@ -1,7 +1,7 @@
 package uri
 
 import (
-	"fmt"
+	"context"
 	"net"
 )
 
@@ -9,11 +9,11 @@ const (
 	defaultTCPPort = "16509"
 )
 
-func (u *ConnectionURI) dialTCP() (net.Conn, error) {
+func (u *ConnectionURI) dialTCP(ctx context.Context) (net.Conn, error) {
 	port := u.Port()
 	if port == "" {
 		port = defaultTCPPort
 	}
 
-	return net.DialTimeout("tcp", fmt.Sprintf("%s:%s", u.Hostname(), port), dialTimeout)
+	return u.dialHost(ctx, "tcp", u.Hostname(), port)
 }
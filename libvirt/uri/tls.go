@@ -1,6 +1,7 @@
 package uri
 
 import (
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"fmt"
@@ -97,7 +98,7 @@ func (u *ConnectionURI) tlsConfig() (*tls.Config, error) {
 
 	caCert, err := os.ReadFile(caCertPath)
 	if err != nil {
-		return nil, fmt.Errorf("can't read certificate '%s': %w", caCert, err)
+		return nil, fmt.Errorf("can't read certificate '%s': %w", caCertPath, err)
 	}
 
 	roots := x509.NewCertPool()
@@ -118,8 +119,12 @@ func (u *ConnectionURI) tlsConfig() (*tls.Config, error) {
 	}, nil
 }
 
-// TODO handle no_verify and pkipath URI options.
-func (u *ConnectionURI) dialTLS() (net.Conn, error) {
+// dialTLS connects to the TLS listener of libvirtd, authenticating with the
+// client certificate of the libvirt PKI layout (see tlsConfig). The server
+// certificate is verified against the CA certificate and the host name,
+// unless no_verify is set. The TCP connection is made like for the tcp
+// transport, so resolved_ip, bind_interface and address_family apply.
+func (u *ConnectionURI) dialTLS(ctx context.Context) (net.Conn, error) {
 	port := u.Port()
 	if port == "" {
 		port = defaultTLSPort
@@ -129,6 +134,18 @@ func (u *ConnectionURI) dialTLS() (net.Conn, error) {
 	if err != nil {
 		return nil, err
 	}
+	tlsConfig.ServerName = u.Hostname()
 
-	return tls.Dial("tcp", fmt.Sprintf("%s:%s", u.Hostname(), port), tlsConfig)
+	c, err := u.dialHost(ctx, "tcp", u.Hostname(), port)
+	if err != nil {
+		return nil, err
+	}
+	handshakeCtx, cancel := context.WithTimeout(ctx, dialTimeout)
+	defer cancel()
+	conn := tls.Client(c, tlsConfig)
+	if err := conn.HandshakeContext(handshakeCtx); err != nil {
+		c.Close()
+		return nil, fmt.Errorf("TLS handshake with %s failed: %w", u.Host, err)
+	}
+	return conn, nil
 }
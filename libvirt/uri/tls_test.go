@@ -9,6 +9,7 @@ import (
 	"encoding/pem"
 	"fmt"
 	"math/big"
+	"net"
 	"os"
 	"path/filepath"
 	"testing"
@@ -85,10 +86,11 @@ func createCACerts(pkipath string) error {
 		Subject: pkix.Name{
 			Organization: []string{"Avocado"},
 		},
-		NotBefore: time.Now(),
-		NotAfter:  time.Now().Add(365 * 24 * time.Hour),
-		IsCA:      true,
-		KeyUsage:  x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(365 * 24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
 	}
 
 	priv, _ := rsa.GenerateKey(rand.Reader, 2048)
@@ -165,3 +167,88 @@ func TestTLSConfig(t *testing.T) {
 	assert.False(t, tlsConfig.InsecureSkipVerify)
 
 }
+
+// newTestTLSServer starts a TLS listener like the one of libvirtd, with a
+// certificate for localhost signed by the CA in pkipath, that requires a
+// client certificate from that CA. It returns the port and a channel
+// receiving the organization of every authenticated client.
+func newTestTLSServer(t *testing.T, pkipath string) (string, <-chan string) {
+	ca, err := tls.LoadX509KeyPair(filepath.Join(pkipath, "cacert.pem"), filepath.Join(pkipath, "cakey.pem"))
+	require.NoError(t, err)
+	caCert, err := x509.ParseCertificate(ca.Certificate[0])
+	require.NoError(t, err)
+
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	der, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(43),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}, caCert, &priv.PublicKey, ca.PrivateKey)
+	require.NoError(t, err)
+
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(caCert)
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: priv}},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientCAs,
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+
+	clients := make(chan string, 10)
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conn := c.(*tls.Conn)
+			if err := conn.Handshake(); err == nil {
+				clients <- conn.ConnectionState().PeerCertificates[0].Subject.Organization[0]
+			}
+			conn.Close()
+		}
+	}()
+	_, port, err := net.SplitHostPort(l.Addr().String())
+	require.NoError(t, err)
+	return port, clients
+}
+
+func TestDialTLS(t *testing.T) {
+	pkipath := t.TempDir()
+	require.NoError(t, createCACerts(pkipath))
+	port, clients := newTestTLSServer(t, pkipath)
+
+	u, err := Parse(fmt.Sprintf("qemu+tls://localhost:%s/system?pkipath=%s", port, pkipath))
+	require.NoError(t, err)
+	conn, err := u.Dial()
+	require.NoError(t, err)
+	conn.Close()
+	assert.Equal(t, "Avocado", <-clients)
+
+	// the certificate of the server is for localhost only
+	u, err = Parse(fmt.Sprintf("qemu+tls://127.0.0.1:%s/system?pkipath=%s", port, pkipath))
+	require.NoError(t, err)
+	_, err = u.Dial()
+	assert.ErrorContains(t, err, "TLS handshake with 127.0.0.1:"+port+" failed: ")
+
+	u, err = Parse(fmt.Sprintf("qemu+tls://127.0.0.1:%s/system?pkipath=%s&no_verify=1", port, pkipath))
+	require.NoError(t, err)
+	conn, err = u.Dial()
+	require.NoError(t, err)
+	conn.Close()
+	assert.Equal(t, "Avocado", <-clients)
+
+	// without a client certificate there is nothing to connect with
+	require.NoError(t, os.Remove(filepath.Join(pkipath, "clientcert.pem")))
+	u, err = Parse(fmt.Sprintf("qemu+tls://localhost:%s/system?pkipath=%s", port, pkipath))
+	require.NoError(t, err)
+	_, err = u.Dial()
+	assert.ErrorContains(t, err, "can't locate resource 'clientcert.pem'")
+}
@@ -0,0 +1,65 @@
+package uri
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+const totpStep = 30 * time.Second
+
+// totpPromptWords identify the keyboard-interactive prompts asking for a
+// one-time code, e.g. "Verification code:" of google-authenticator.
+var totpPromptWords = []string{"code", "token", "verification"}
+
+// totpSecret returns the base32 TOTP secret from the totp_secret parameter,
+// or else the LIBVIRT_SSH_TOTP_SECRET environment variable.
+func (u *ConnectionURI) totpSecret() string {
+	if secret := u.Query().Get("totp_secret"); secret != "" {
+		return secret
+	}
+	return os.Getenv("LIBVIRT_SSH_TOTP_SECRET")
+}
+
+// decodeTOTPSecret decodes a base32 secret as shown by authenticator apps,
+// in any case, with or without spaces and padding.
+func decodeTOTPSecret(secret string) ([]byte, error) {
+	secret = strings.ToUpper(strings.ReplaceAll(secret, " ", ""))
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.TrimRight(secret, "="))
+	if err != nil || len(key) == 0 {
+		return nil, fmt.Errorf("invalid totp_secret, expected a base32 encoded secret")
+	}
+	return key, nil
+}
+
+// totpCode returns the RFC 6238 one-time code of key at t, with the usual
+// 30 second step, 6 digits and HMAC-SHA1.
+func totpCode(key []byte, t time.Time) string {
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(t.Unix()/int64(totpStep/time.Second)))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+
+	// dynamic truncation of RFC 4226
+	offset := sum[len(sum)-1] & 0x0f
+	code := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%06d", code%1000000)
+}
+
+// isTOTPPrompt reports whether a keyboard-interactive prompt asks for a
+// one-time code.
+func isTOTPPrompt(prompt string) bool {
+	prompt = strings.ToLower(prompt)
+	for _, word := range totpPromptWords {
+		if strings.Contains(prompt, word) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,76 @@
+package uri
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+// testTOTPSecret is the SHA1 secret of the RFC 6238 test vectors,
+// "12345678901234567890", in base32.
+const testTOTPSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCode(t *testing.T) {
+	key, err := decodeTOTPSecret(testTOTPSecret)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("12345678901234567890"), key)
+
+	// the last 6 digits of the 8 digit RFC 6238 test vectors
+	for unix, code := range map[int64]string{
+		59:          "287082",
+		1111111109:  "081804",
+		1111111111:  "050471",
+		1234567890:  "005924",
+		2000000000:  "279037",
+		20000000000: "353130",
+	} {
+		assert.Equal(t, code, totpCode(key, time.Unix(unix, 0)), unix)
+	}
+
+	key, err = decodeTOTPSecret("gezd gnbv gy3t qojq gezd gnbv gy3t qojq")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("12345678901234567890"), key)
+	_, err = decodeTOTPSecret("not base32!")
+	assert.EqualError(t, err, "invalid totp_secret, expected a base32 encoded secret")
+}
+
+func TestDialSSHTOTP(t *testing.T) {
+	s := newTestSSHServer(t)
+	t.Setenv("HTTP_PROXY", "")
+	t.Setenv("ALL_PROXY", "")
+	key, err := decodeTOTPSecret(testTOTPSecret)
+	require.NoError(t, err)
+	s.Configure(func(config *ssh.ServerConfig) {
+		config.KeyboardInteractiveCallback = func(c ssh.ConnMetadata, client ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
+			answers, err := client("", "MFA required", []string{"Password: ", "Verification code: "}, []bool{false, true})
+			if err != nil {
+				return nil, err
+			}
+			// the code of the previous step is accepted too, like
+			// servers allow for clock skew
+			now := time.Now()
+			valid := answers[1] == totpCode(key, now) || answers[1] == totpCode(key, now.Add(-totpStep))
+			if c.User() == testSSHUser && answers[0] == testSSHPassword && valid {
+				return nil, nil
+			}
+			return nil, ssh.ErrNoAuth
+		}
+	})
+
+	// the explicit answer takes the password prompt, the code is computed
+	u := testSSHURI(t, s, "sshauth=keyboard-interactive&sshauth_kbd_answers=password="+testSSHPassword+"&totp_secret="+testTOTPSecret)
+	assert.Equal(t, []string{"keyboard-interactive"}, u.parseAuthMethods(nil).names)
+	conn, err := u.Dial()
+	require.NoError(t, err)
+	conn.Close()
+
+	// a secret alone is enough to offer the method
+	t.Setenv("LIBVIRT_SSH_TOTP_SECRET", testTOTPSecret)
+	u = testSSHURI(t, s, "sshauth=keyboard-interactive")
+	assert.Equal(t, []string{"keyboard-interactive"}, u.parseAuthMethods(nil).names)
+	u = testSSHURI(t, s, "sshauth=keyboard-interactive&totp_secret=not+base32!")
+	assert.Empty(t, u.parseAuthMethods(nil).names)
+}
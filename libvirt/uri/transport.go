@@ -0,0 +1,47 @@
+package uri
+
+import (
+	"context"
+	"net"
+	"sync"
+)
+
+// Transport establishes the connection libvirt RPC runs over.
+type Transport interface {
+	Dial(ctx context.Context) (net.Conn, error)
+}
+
+// TransportFunc adapts a function to the Transport interface.
+type TransportFunc func(ctx context.Context) (net.Conn, error)
+
+func (f TransportFunc) Dial(ctx context.Context) (net.Conn, error) {
+	return f(ctx)
+}
+
+// NewTransportFunc returns the transport for a connection URI.
+type NewTransportFunc func(u *ConnectionURI) Transport
+
+var (
+	transportsMutex sync.RWMutex
+	transports      = map[string]NewTransportFunc{
+		"tcp":  func(u *ConnectionURI) Transport { return TransportFunc(u.dialTCP) },
+		"tls":  func(u *ConnectionURI) Transport { return TransportFunc(u.dialTLS) },
+		"unix": func(u *ConnectionURI) Transport { return TransportFunc(u.dialUNIX) },
+		"ssh":  func(u *ConnectionURI) Transport { return TransportFunc(u.dialSSH) },
+	}
+)
+
+// RegisterTransport makes a transport available under the given name, which
+// is selected by the part of the URI scheme after the '+' (e.g. "grpc" for
+// qemu+grpc://). Registering an existing name replaces that transport.
+func RegisterTransport(name string, newTransport NewTransportFunc) {
+	transportsMutex.Lock()
+	defer transportsMutex.Unlock()
+	transports[name] = newTransport
+}
+
+func transportFor(name string) NewTransportFunc {
+	transportsMutex.RLock()
+	defer transportsMutex.RUnlock()
+	return transports[name]
+}
@@ -0,0 +1,47 @@
+package uri
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegisterTransport(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+
+	var dialed *ConnectionURI
+	RegisterTransport("test", func(u *ConnectionURI) Transport {
+		return TransportFunc(func(ctx context.Context) (net.Conn, error) {
+			dialed = u
+			var d net.Dialer
+			return d.DialContext(ctx, "tcp", l.Addr().String())
+		})
+	})
+	defer func() {
+		transportsMutex.Lock()
+		delete(transports, "test")
+		transportsMutex.Unlock()
+	}()
+
+	u, err := Parse("qemu+test://libvirt.example.com/system")
+	require.NoError(t, err)
+	conn, err := u.Dial()
+	require.NoError(t, err)
+	defer conn.Close()
+
+	assert.Same(t, u, dialed)
+	assert.Equal(t, l.Addr().String(), conn.RemoteAddr().String())
+	assert.Equal(t, "qemu:///system", u.RemoteName())
+}
+
+func TestUnknownTransport(t *testing.T) {
+	u, err := Parse("qemu+quic://libvirt.example.com/system")
+	require.NoError(t, err)
+	_, err = u.Dial()
+	assert.ErrorContains(t, err, "transport 'quic' not implemented")
+}
@@ -1,20 +1,57 @@
 package uri
 
 import (
+	"context"
 	"net"
+	"strings"
 )
 
 const (
-	defaultUnixSock = "/var/run/libvirt/libvirt-sock"
+	defaultUnixSock   = "/var/run/libvirt/libvirt-sock"
+	defaultUnixSockRO = "/var/run/libvirt/libvirt-sock-ro"
 )
 
-func (u *ConnectionURI) dialUNIX() (net.Conn, error) {
+// abstractSocketName reports whether address names a Linux abstract unix
+// socket, written with a leading '@' or NUL byte, and returns the name
+// without that prefix.
+func abstractSocketName(address string) (string, bool) {
+	if strings.HasPrefix(address, "@") || strings.HasPrefix(address, "\x00") {
+		return address[1:], true
+	}
+	return "", false
+}
 
+// libvirtSocket returns the path of the libvirt socket to connect to: the
+// socket parameter, or else the socket of the daemon selected with daemon,
+// or else the default read-write or, with readonly, the read-only socket.
+func (u *ConnectionURI) libvirtSocket() (string, error) {
 	q := u.Query()
-	address := q.Get("socket")
-	if address == "" {
-		address = defaultUnixSock
+	if address := q.Get("socket"); address != "" {
+		return address, nil
+	}
+	readonly := nonZero(q.Get("readonly"))
+	if socket, err := u.daemonSocket(readonly); err != nil || socket != "" {
+		return socket, err
+	}
+	if readonly {
+		return defaultUnixSockRO, nil
+	}
+	return defaultUnixSock, nil
+}
+
+func (u *ConnectionURI) dialUNIX(ctx context.Context) (net.Conn, error) {
+	address, err := u.libvirtSocket()
+	if err != nil {
+		return nil, err
+	}
+
+	// the net package spells abstract socket names with a leading '@'
+	if name, ok := abstractSocketName(address); ok {
+		address = "@" + name
 	}
 
-	return net.DialTimeout("unix", address, dialTimeout)
+	d := net.Dialer{Timeout: dialTimeout}
+	return u.dialWithFDBackpressure(ctx, func() (net.Conn, error) {
+		return d.DialContext(ctx, "unix", address)
+	})
 }
@@ -0,0 +1,53 @@
+package uri
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAbstractSocketName(t *testing.T) {
+	name, ok := abstractSocketName("@libvirt")
+	assert.True(t, ok)
+	assert.Equal(t, "libvirt", name)
+
+	name, ok = abstractSocketName("\x00libvirt")
+	assert.True(t, ok)
+	assert.Equal(t, "libvirt", name)
+
+	_, ok = abstractSocketName("/var/run/libvirt/libvirt-sock")
+	assert.False(t, ok)
+}
+
+func TestDialUNIXAbstractSocket(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("abstract unix sockets are specific to Linux")
+	}
+
+	name := fmt.Sprintf("terraform-provider-libvirt-test-%d", os.Getpid())
+	l, err := net.Listen("unix", "@"+name)
+	require.NoError(t, err)
+	defer l.Close()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			c.Close()
+		}
+	}()
+
+	for _, socket := range []string{"@" + name, "%00" + name} {
+		u, err := Parse("qemu:///system?socket=" + socket)
+		require.NoError(t, err)
+		conn, err := u.Dial()
+		require.NoError(t, err)
+		conn.Close()
+	}
+}
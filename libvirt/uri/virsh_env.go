@@ -0,0 +1,106 @@
+package uri
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+)
+
+// redacted replaces secret values in a VirshEnvironment, like
+// url.URL.Redacted does for passwords.
+const redacted = "xxxxx"
+
+// secretParams are the query parameters whose values are redacted.
+var secretParams = []string{"keyfile_passphrase", "sshauth_kbd_answers", "totp_secret"}
+
+// VirshEnvironment is what it takes to reproduce a connection with virsh,
+// for support cases: the environment to set and the notes on what has to be
+// filled in by hand.
+type VirshEnvironment struct {
+	// Env are the environment variables to set, LIBVIRT_DEFAULT_URI among
+	// them. Secret values are redacted.
+	Env map[string]string
+	// Required are the variables of Env whose redacted value has to be set
+	// by hand.
+	Required []string
+	// Notes explain what else is needed to reproduce the connection.
+	Notes []string
+}
+
+// VirshEnvironment returns the environment reproducing the connection with
+// virsh. LIBVIRT_DEFAULT_URI is the URI with the parameters read from
+// config_file applied, and with passwords and other secrets redacted.
+func (u *ConnectionURI) VirshEnvironment() *VirshEnvironment {
+	e := &VirshEnvironment{Env: make(map[string]string)}
+
+	repro := *u.URL
+	q := u.Query()
+	q.Del("config_file")
+	if password, ok := u.User.Password(); ok && password != "" {
+		repro.User = url.UserPassword(u.User.Username(), redacted)
+		e.Notes = append(e.Notes, fmt.Sprintf("the password of %s is redacted in LIBVIRT_DEFAULT_URI", u.User.Username()))
+	}
+	for _, param := range secretParams {
+		if q.Get(param) != "" {
+			q.Set(param, redacted)
+			e.Notes = append(e.Notes, fmt.Sprintf("%s is redacted in LIBVIRT_DEFAULT_URI", param))
+		}
+	}
+	repro.RawQuery = q.Encode()
+	e.Env["LIBVIRT_DEFAULT_URI"] = repro.String()
+
+	for _, name := range []string{"LIBVIRT_SSH_KEY_PASSPHRASE", "LIBVIRT_SSH_KBD_ANSWERS", "LIBVIRT_SSH_TOTP_SECRET"} {
+		if os.Getenv(name) != "" {
+			e.Env[name] = redacted
+			e.Required = append(e.Required, name)
+		}
+	}
+
+	if u.transport() == "ssh" {
+		authMethods := q.Get("sshauth")
+		if authMethods == "" {
+			authMethods = defaultSSHAuthMethods
+		}
+		if strings.Contains(authMethods, "agent") {
+			if socket := os.Getenv("SSH_AUTH_SOCK"); socket != "" {
+				e.Env["SSH_AUTH_SOCK"] = socket
+			}
+		}
+		if len(u.PrivateKey) > 0 {
+			e.Notes = append(e.Notes, "the private_key of the provider has to be written to a file given in the keyfile parameter")
+		}
+	}
+	return e
+}
+
+// Script returns the environment as shell commands to paste into a shell,
+// followed by a virsh command checking the connection.
+func (e *VirshEnvironment) Script() string {
+	var b strings.Builder
+	for _, note := range e.Notes {
+		fmt.Fprintf(&b, "# %s\n", note)
+	}
+	names := make([]string, 0, len(e.Env))
+	for name := range e.Env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(&b, "export %s=%s", name, shellQuote(e.Env[name]))
+		for _, required := range e.Required {
+			if required == name {
+				b.WriteString(" # set the real value")
+			}
+		}
+		b.WriteString("\n")
+	}
+	b.WriteString("virsh uri\n")
+	return b.String()
+}
+
+// shellQuote quotes s for a POSIX shell.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
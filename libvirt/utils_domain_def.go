@@ -123,7 +123,7 @@ func getHostArchitecture(virConn *libvirt.Libvirt) (string, error) {
 		}
 	}
 
-	info, err := virConn.Capabilities()
+	info, err := hostCapabilitiesXML(virConn)
 	if err != nil {
 		return "", err
 	}
@@ -138,10 +138,8 @@ func getHostArchitecture(virConn *libvirt.Libvirt) (string, error) {
 }
 
 func getHostCapabilities(virConn *libvirt.Libvirt) (libvirtxml.Caps, error) {
-	// We should perhaps think of storing this on the connect object
-	// on first call to avoid the back and forth
 	caps := libvirtxml.Caps{}
-	capsXML, err := virConn.Capabilities()
+	capsXML, err := hostCapabilitiesXML(virConn)
 	if err != nil {
 		return caps, err
 	}
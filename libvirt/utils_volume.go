@@ -1,25 +1,62 @@
 package libvirt
 
 import (
-	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"log"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	libvirt "github.com/digitalocean/go-libvirt"
 )
 
 const (
-	copierBufferSize = 4 * 1024 * 1024
+	// uploadChunkSize is the size of the chunks the volumes are uploaded by,
+	// each chunk being held in memory by an upload stream
+	uploadChunkSize = 16 * 1024 * 1024
+	// number of attempts to upload a chunk
+	uploadChunkAttempts = 3
+	// wait time between the attempts
+	uploadChunkRetryWait = 2 * time.Second
+	// defaultUploadParallelism is the number of upload streams of a volume
+	// that is not given any
+	defaultUploadParallelism = 4
 )
 
-func newCopier(virConn *libvirt.Libvirt, volume *libvirt.StorageVol, size uint64) func(src io.Reader) error {
+// volumeUploadOptions configures the upload of a volume.
+type volumeUploadOptions struct {
+	// parallelism is the number of libvirt streams uploading chunks at
+	// the same time
+	parallelism int
+	// sha256 is the checksum the source is verified against, if any
+	sha256 string
+	// sparse skips uploading the chunks only made of zeros, which is only
+	// right for volumes reading as zeros where never written
+	sparse bool
+}
+
+// newCopier returns a copier uploading the source to volume sequentially.
+func newCopier(ctx context.Context, virConn *libvirt.Libvirt, volume *libvirt.StorageVol, size uint64) func(src io.Reader) error {
+	return newVolumeUploader(ctx, virConn, volume, size, volumeUploadOptions{parallelism: 1})
+}
+
+// newVolumeUploader returns a copier uploading the source to volume by
+// chunks, as configured by options.
+func newVolumeUploader(ctx context.Context, virConn *libvirt.Libvirt, volume *libvirt.StorageVol, size uint64, options volumeUploadOptions) func(src io.Reader) error {
 	copier := func(src io.Reader) error {
 		start := time.Now()
-		if err := virConn.StorageVolUpload(*volume, bufio.NewReaderSize(src, copierBufferSize), 0, size, 0); err != nil {
+		err := uploadChunks(src, size, volume.Name, options, func(offset uint64, data []byte) error {
+			return retryLibvirt(ctx, virConn, func() error {
+				return virConn.StorageVolUpload(*volume, bytes.NewReader(data), offset, uint64(len(data)), 0)
+			})
+		})
+		if err != nil {
 			return fmt.Errorf("error while uploading volume %w", err)
 		}
 		log.Printf("[DEBUG] upload took %d ms", time.Since(start).Milliseconds())
@@ -29,6 +66,160 @@ func newCopier(virConn *libvirt.Libvirt, volume *libvirt.StorageVol, size uint64
 	return copier
 }
 
+type uploadChunk struct {
+	offset uint64
+	data   []byte
+}
+
+// uploadChunks reads src by chunks, handing them to options.parallelism
+// workers calling upload, which is retried on error with the same chunk,
+// so that a failure does not restart the whole upload. The source is
+// verified against options.sha256 once read entirely.
+func uploadChunks(src io.Reader, size uint64, name string, options volumeUploadOptions, upload func(offset uint64, data []byte) error) error {
+	parallelism := options.parallelism
+	if parallelism < 1 {
+		parallelism = 1
+	}
+
+	// the buffers are recycled once uploaded, bounding the memory used
+	buffers := make(chan []byte, parallelism)
+	for i := 0; i < parallelism; i++ {
+		buffers <- make([]byte, uploadChunkSize)
+	}
+	chunks := make(chan uploadChunk)
+	done := make(chan struct{})
+	var stop sync.Once
+	errs := make(chan error, parallelism)
+
+	progress := newUploadProgress(name, size)
+	var wg sync.WaitGroup
+	for i := 0; i < parallelism; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for chunk := range chunks {
+				if err := uploadChunkWithRetries(chunk, upload); err != nil {
+					errs <- err
+					stop.Do(func() { close(done) })
+					return
+				}
+				progress.add(uint64(len(chunk.data)))
+				buffers <- chunk.data[:cap(chunk.data)]
+			}
+		}()
+	}
+
+	hash := sha256.New()
+	readErr := func() error {
+		defer close(chunks)
+		var offset uint64
+		for {
+			var buf []byte
+			select {
+			case buf = <-buffers:
+			case <-done:
+				return nil
+			}
+
+			n, err := io.ReadFull(src, buf)
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil && err != io.ErrUnexpectedEOF {
+				return fmt.Errorf("error reading the source at offset %d: %w", offset, err)
+			}
+			hash.Write(buf[:n])
+			last := err == io.ErrUnexpectedEOF || (size > 0 && offset+uint64(n) >= size)
+
+			// the last chunk is always written, as it sets the size of
+			// the volumes growing with their content
+			if options.sparse && !last && isZeroChunk(buf[:n]) {
+				progress.add(uint64(n))
+				buffers <- buf
+			} else {
+				select {
+				case chunks <- uploadChunk{offset: offset, data: buf[:n]}:
+				case <-done:
+					return nil
+				}
+			}
+			offset += uint64(n)
+			if last {
+				return nil
+			}
+		}
+	}()
+	wg.Wait()
+
+	select {
+	case err := <-errs:
+		return err
+	default:
+	}
+	if readErr != nil {
+		return readErr
+	}
+
+	if options.sha256 != "" {
+		if sum := hex.EncodeToString(hash.Sum(nil)); !strings.EqualFold(sum, options.sha256) {
+			return fmt.Errorf("the SHA-256 checksum of the source is %s, expected %s", sum, options.sha256)
+		}
+	}
+	return nil
+}
+
+func uploadChunkWithRetries(chunk uploadChunk, upload func(offset uint64, data []byte) error) error {
+	var err error
+	for attempt := 1; attempt <= uploadChunkAttempts; attempt++ {
+		if err = upload(chunk.offset, chunk.data); err == nil {
+			return nil
+		}
+		log.Printf("[WARN] uploading %d bytes at offset %d failed (attempt #%d): %s", len(chunk.data), chunk.offset, attempt, err)
+		if attempt < uploadChunkAttempts {
+			time.Sleep(uploadChunkRetryWait)
+		}
+	}
+	return fmt.Errorf("uploading %d bytes at offset %d failed after %d attempts: %w", len(chunk.data), chunk.offset, uploadChunkAttempts, err)
+}
+
+func isZeroChunk(data []byte) bool {
+	for _, b := range data {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// uploadProgress logs the progress of an upload every 10 percents.
+type uploadProgress struct {
+	mu       sync.Mutex
+	name     string
+	size     uint64
+	uploaded uint64
+	logged   uint64
+}
+
+func newUploadProgress(name string, size uint64) *uploadProgress {
+	return &uploadProgress{name: name, size: size}
+}
+
+func (p *uploadProgress) add(n uint64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.uploaded += n
+	if p.size == 0 {
+		return
+	}
+	//nolint:gomnd
+	percent := p.uploaded * 100 / p.size
+	if percent >= p.logged+10 || p.uploaded >= p.size {
+		p.logged = percent - percent%10
+		log.Printf("[INFO] Uploaded %d of %d bytes (%d%%) to volume %s", p.uploaded, p.size, percent, p.name)
+	}
+}
+
 //nolint:gomnd
 func timeFromEpoch(str string) time.Time {
 	var s, ns int
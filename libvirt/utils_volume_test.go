@@ -1,8 +1,15 @@
 package libvirt
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"math/rand"
 	"os"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -29,6 +36,95 @@ func TestTimeFromEpoch(t *testing.T) {
 	}
 }
 
+// uploadRecorder records the chunks uploaded, failing the first uploads at
+// the offsets in failures.
+type uploadRecorder struct {
+	mu       sync.Mutex
+	volume   []byte
+	offsets  []uint64
+	failures map[uint64]int
+}
+
+func (r *uploadRecorder) upload(offset uint64, data []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.failures[offset] > 0 {
+		r.failures[offset]--
+		return errors.New("connection reset")
+	}
+	if end := offset + uint64(len(data)); end > uint64(len(r.volume)) {
+		r.volume = append(r.volume, make([]byte, end-uint64(len(r.volume)))...)
+	}
+	copy(r.volume[offset:], data)
+	r.offsets = append(r.offsets, offset)
+	return nil
+}
+
+func TestUploadChunks(t *testing.T) {
+	// three and a half chunks, the second one being zeros
+	source := make([]byte, uploadChunkSize*7/2)
+	rand.New(rand.NewSource(1)).Read(source)
+	copy(source[uploadChunkSize:], make([]byte, uploadChunkSize))
+	sum := sha256.Sum256(source)
+
+	for _, parallelism := range []int{1, 4} {
+		for _, sparse := range []bool{false, true} {
+			t.Run(fmt.Sprintf("parallelism %d sparse %t", parallelism, sparse), func(t *testing.T) {
+				recorder := &uploadRecorder{}
+				options := volumeUploadOptions{parallelism: parallelism, sha256: hex.EncodeToString(sum[:]), sparse: sparse}
+				if err := uploadChunks(bytes.NewReader(source), uint64(len(source)), "volume", options, recorder.upload); err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+				if !bytes.Equal(recorder.volume, source) {
+					t.Errorf("the volume uploaded differs from the source")
+				}
+				expectedChunks := 4
+				if sparse {
+					expectedChunks = 3
+				}
+				if len(recorder.offsets) != expectedChunks {
+					t.Errorf("expected %d chunks uploaded, got offsets %v", expectedChunks, recorder.offsets)
+				}
+			})
+		}
+	}
+}
+
+func TestUploadChunksRetries(t *testing.T) {
+	source := bytes.Repeat([]byte("libvirt"), uploadChunkSize/2)
+
+	// the second chunk fails once, and is the only one uploaded again
+	recorder := &uploadRecorder{failures: map[uint64]int{uploadChunkSize: 1}}
+	if err := uploadChunks(bytes.NewReader(source), uint64(len(source)), "volume", volumeUploadOptions{parallelism: 2}, recorder.upload); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !bytes.Equal(recorder.volume, source) {
+		t.Errorf("the volume uploaded differs from the source")
+	}
+	if len(recorder.offsets) != 4 {
+		t.Errorf("expected 4 chunks uploaded, got offsets %v", recorder.offsets)
+	}
+
+	recorder = &uploadRecorder{failures: map[uint64]int{0: uploadChunkAttempts}}
+	err := uploadChunks(bytes.NewReader(source), uint64(len(source)), "volume", volumeUploadOptions{parallelism: 2}, recorder.upload)
+	if err == nil || !strings.Contains(err.Error(), fmt.Sprintf("failed after %d attempts", uploadChunkAttempts)) {
+		t.Errorf("expected the upload to fail after %d attempts, got %v", uploadChunkAttempts, err)
+	}
+}
+
+func TestUploadChunksChecksumMismatch(t *testing.T) {
+	source := []byte("this is a qcow image... well, it is not")
+	sum := sha256.Sum256([]byte("another image"))
+
+	recorder := &uploadRecorder{}
+	options := volumeUploadOptions{sha256: hex.EncodeToString(sum[:])}
+	err := uploadChunks(bytes.NewReader(source), uint64(len(source)), "volume", options, recorder.upload)
+	if err == nil || !strings.Contains(err.Error(), "the SHA-256 checksum of the source is") {
+		t.Errorf("expected a checksum mismatch, got %v", err)
+	}
+}
+
 func TestAccUtilsVolume_UploadVolumeCopier(t *testing.T) {
 
 	var volume libvirt.StorageVol
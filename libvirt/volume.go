@@ -2,11 +2,13 @@ package libvirt
 
 import (
 	"context"
+	"encoding/xml"
 	"fmt"
 	"log"
 
 	libvirt "github.com/digitalocean/go-libvirt"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
+	"libvirt.org/go/libvirtxml"
 )
 
 const (
@@ -108,7 +110,9 @@ func volumeDelete(ctx context.Context, client *Client, key string) error {
 		// Volume is probably gone already, getting its XML description is pointless
 	}
 
-	err = virConn.StorageVolDelete(volume, 0)
+	err = retryLibvirt(ctx, virConn, func() error {
+		return virConn.StorageVolDelete(volume, 0)
+	})
 	if err != nil {
 		if !isError(err, libvirt.ErrNoStorageVol) {
 			return fmt.Errorf("can't delete volume %s: %w", key, err)
@@ -119,3 +123,114 @@ func volumeDelete(ctx context.Context, client *Client, key string) error {
 
 	return volumeWaitDeleted(ctx, client.libvirt, key)
 }
+
+// diskUsesVolume reports whether disk is backed by the volume named volName
+// in the pool poolName, which lives at path.
+func diskUsesVolume(disk libvirtxml.DomainDisk, poolName string, volName string, path string) bool {
+	if disk.Source == nil {
+		return false
+	}
+	switch {
+	case disk.Source.Volume != nil:
+		return disk.Source.Volume.Pool == poolName && disk.Source.Volume.Volume == volName
+	case disk.Source.File != nil:
+		return disk.Source.File.File == path
+	case disk.Source.Block != nil:
+		return disk.Source.Block.Dev == path
+	}
+	return false
+}
+
+// volumeResize changes the capacity of the volume identified by `key` to
+// size. When a running domain uses the volume, its hypervisor resizes the
+// disk, so that the guest sees the new size right away, unless
+// resizeDomains is false; otherwise the volume is resized by its pool.
+func volumeResize(ctx context.Context, client *Client, key string, size uint64, shrink bool, resizeDomains bool) error {
+	virConn := client.libvirt
+	if virConn == nil {
+		return fmt.Errorf(LibVirtConIsNil)
+	}
+	volume, err := virConn.StorageVolLookupByKey(key)
+	if err != nil {
+		return fmt.Errorf("can't retrieve volume %s: %w", key, err)
+	}
+
+	volPool, err := virConn.StoragePoolLookupByVolume(volume)
+	if err != nil {
+		return fmt.Errorf("error retrieving pool for volume: %w", err)
+	}
+
+	client.poolMutexKV.Lock(volPool.Name)
+	defer client.poolMutexKV.Unlock(volPool.Name)
+
+	if resizeDomains {
+		path, err := virConn.StorageVolGetPath(volume)
+		if err != nil {
+			return fmt.Errorf("error retrieving path of volume %s: %w", key, err)
+		}
+
+		domains, _, err := virConn.ConnectListAllDomains(1, libvirt.ConnectListDomainsActive)
+		if err != nil {
+			return fmt.Errorf("error listing the running domains: %w", err)
+		}
+		for _, domain := range domains {
+			domainDef, err := getXMLDomainDefFromLibvirt(virConn, domain)
+			if err != nil {
+				return err
+			}
+			if domainDef.Devices == nil {
+				continue
+			}
+			for _, disk := range domainDef.Devices.Disks {
+				if disk.Target == nil || !diskUsesVolume(disk, volPool.Name, volume.Name, path) {
+					continue
+				}
+				log.Printf("[INFO] Resizing disk %s of domain %s to %d bytes", disk.Target.Dev, domain.Name, size)
+				if err := virConn.DomainBlockResize(domain, disk.Target.Dev, size, libvirt.DomainBlockResizeBytes); err != nil {
+					return fmt.Errorf("error resizing disk %s of domain %s: %w", disk.Target.Dev, domain.Name, err)
+				}
+				return refreshPoolOfVolume(virConn, volPool)
+			}
+		}
+	}
+
+	var flags libvirt.StorageVolResizeFlags
+	if shrink {
+		flags |= libvirt.StorageVolResizeShrink
+	}
+	log.Printf("[INFO] Resizing volume %s to %d bytes", volume.Name, size)
+	if err := retryLibvirt(ctx, virConn, func() error {
+		return virConn.StorageVolResize(volume, size, flags)
+	}); err != nil {
+		return fmt.Errorf("can't resize volume %s: %w", key, err)
+	}
+	return nil
+}
+
+// refreshPoolOfVolume refreshes volPool after one of its volumes was changed
+// behind its back, by a domain.
+func refreshPoolOfVolume(virConn *libvirt.Libvirt, volPool libvirt.StoragePool) error {
+	return waitForSuccess("error refreshing pool for volume", func() error {
+		return virConn.StoragePoolRefresh(volPool, 0)
+	})
+}
+
+// poolVolumesReadZeros reports whether the volumes created in pool read as
+// zeros until written, being sparse files.
+func poolVolumesReadZeros(virConn *libvirt.Libvirt, pool libvirt.StoragePool) bool {
+	poolXML, err := virConn.StoragePoolGetXMLDesc(pool, 0)
+	if err != nil {
+		log.Printf("[WARN] could not retrieve the XML description of pool %s: %s", pool.Name, err)
+		return false
+	}
+	var poolDef libvirtxml.StoragePool
+	if err := xml.Unmarshal([]byte(poolXML), &poolDef); err != nil {
+		log.Printf("[WARN] could not read the XML description of pool %s: %s", pool.Name, err)
+		return false
+	}
+	switch poolDef.Type {
+	case "dir", "fs", "netfs":
+		return true
+	}
+	return false
+}
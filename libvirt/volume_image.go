@@ -188,7 +188,9 @@ func (i *httpImage) Import(copier func(io.Reader) error, vol libvirtxml.StorageV
 		if response.StatusCode == http.StatusNotModified {
 			return nil
 		} else if response.StatusCode == http.StatusOK {
-			return copier(response.Body)
+			body := &resumableHTTPBody{client: client, url: i.url.String(), body: response.Body}
+			defer body.Close()
+			return copier(body)
 		} else if response.StatusCode < http.StatusInternalServerError {
 			break
 		} else if retryCount < maxHTTPRetries {
@@ -201,6 +203,55 @@ func (i *httpImage) Import(copier func(io.Reader) error, vol libvirtxml.StorageV
 	return fmt.Errorf("error while downloading %s: %v", i.url.String(), response)
 }
 
+// resumableHTTPBody reads the body of a download, downloading the rest of
+// the resource from where it stopped when reading it fails.
+type resumableHTTPBody struct {
+	client  *http.Client
+	url     string
+	body    io.ReadCloser
+	offset  uint64
+	resumes int
+}
+
+func (r *resumableHTTPBody) Read(p []byte) (int, error) {
+	// number of times a download is resumed
+	const maxHTTPResumes int = 3
+	// wait time before resuming
+	const resumeWait time.Duration = 2 * time.Second
+
+	n, err := r.body.Read(p)
+	r.offset += uint64(n)
+	if err == nil || err == io.EOF || r.resumes >= maxHTTPResumes {
+		return n, err
+	}
+
+	r.resumes++
+	log.Printf("[WARN] Download of %s failed at offset %d, resuming (#%d): %s", r.url, r.offset, r.resumes, err)
+	r.body.Close()
+	time.Sleep(resumeWait)
+
+	req, reqErr := http.NewRequest("GET", r.url, nil)
+	if reqErr != nil {
+		return n, fmt.Errorf("error while resuming download: %w", reqErr)
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-", r.offset))
+	response, respErr := r.client.Do(req)
+	if respErr != nil {
+		return n, fmt.Errorf("error while resuming download after %s: %w", err, respErr)
+	}
+	if response.StatusCode != http.StatusPartialContent {
+		response.Body.Close()
+		return n, fmt.Errorf("can't resume download after %s: %s", err, response.Status)
+	}
+	r.body = response.Body
+
+	return n, nil
+}
+
+func (r *resumableHTTPBody) Close() error {
+	return r.body.Close()
+}
+
 func newImage(source string) (image, error) {
 	url, err := url.Parse(source)
 	if err != nil {
@@ -231,4 +231,40 @@ func TestRemoteImageDownload(t *testing.T) {
 	t.Log("File not copied because modification time was the same")
 }
 
+func TestRemoteImageDownloadResume(t *testing.T) {
+	content := bytes.Repeat([]byte("this is a qcow image... well, it is not\n"), 1024)
+
+	// the first response is cut in the middle, the next ones serve the
+	// range requested
+	requests := 0
+	server := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				requests++
+				if requests == 1 {
+					w.Header().Set("Content-Length", fmt.Sprintf("%d", len(content)))
+					w.WriteHeader(http.StatusOK)
+					w.Write(content[:len(content)/2])
+					return
+				}
+				http.ServeContent(w, r, "content", time.Now(), bytes.NewReader(content))
+			}))
+	defer server.Close()
+
+	var downloaded []byte
+	copier := func(r io.Reader) error {
+		var err error
+		downloaded, err = io.ReadAll(r)
+		return err
+	}
 
+	image, err := newImage(server.URL)
+	if err != nil {
+		t.Fatalf("Could not create image object: %v", err)
+	}
+	if err = image.Import(copier, newDefVolume()); err != nil {
+		t.Fatalf("Expected the download to resume: %v", err)
+	}
+	assert.Equal(t, content, downloaded)
+	assert.Equal(t, 2, requests)
+}
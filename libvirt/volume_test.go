@@ -0,0 +1,30 @@
+package libvirt
+
+import (
+	"testing"
+
+	"libvirt.org/go/libvirtxml"
+)
+
+func TestDiskUsesVolume(t *testing.T) {
+	const path = "/var/lib/libvirt/images/data.qcow2"
+
+	tests := []struct {
+		source *libvirtxml.DomainDiskSource
+		uses   bool
+	}{
+		{&libvirtxml.DomainDiskSource{Volume: &libvirtxml.DomainDiskSourceVolume{Pool: "default", Volume: "data.qcow2"}}, true},
+		{&libvirtxml.DomainDiskSource{Volume: &libvirtxml.DomainDiskSourceVolume{Pool: "other", Volume: "data.qcow2"}}, false},
+		{&libvirtxml.DomainDiskSource{File: &libvirtxml.DomainDiskSourceFile{File: path}}, true},
+		{&libvirtxml.DomainDiskSource{File: &libvirtxml.DomainDiskSourceFile{File: "/tmp/data.qcow2"}}, false},
+		{&libvirtxml.DomainDiskSource{Block: &libvirtxml.DomainDiskSourceBlock{Dev: path}}, true},
+		{nil, false},
+	}
+
+	for _, test := range tests {
+		disk := libvirtxml.DomainDisk{Source: test.source}
+		if uses := diskUsesVolume(disk, "default", "data.qcow2", path); uses != test.uses {
+			t.Errorf("diskUsesVolume(%+v) = %t, expected %t", test.source, uses, test.uses)
+		}
+	}
+}